@@ -0,0 +1,169 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1_test
+
+import (
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestConvertingEgressIPToV1beta1AndBack(t *testing.T) {
+	src := &v1alpha1.EgressIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant"},
+		Spec: v1alpha1.EgressIPSpec{
+			IPs: []v1alpha1.FailureDomainEgressIPSpec{
+				{FailureDomain: "zone-a", IP: "10.0.1.10"},
+				{FailureDomain: "zone-b", NodeAffinity: &corev1.NodeAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{{
+						Weight: 10,
+						Preference: corev1.NodeSelectorTerm{
+							MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "node-role.kubernetes.io/infra", Operator: corev1.NodeSelectorOpExists}},
+						},
+					}},
+				}},
+			},
+			AllFailureDomains: true,
+		},
+		Status: v1alpha1.EgressIPStatus{
+			Phase:    "provisioned",
+			IP:       v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "zone-a", IP: "10.0.1.10"},
+			HostName: "node-a",
+			IPs: []v1alpha1.AssignedEgressIP{
+				{FailureDomain: "zone-a", IP: "10.0.1.10", HostName: "node-a"},
+				{FailureDomain: "zone-b", IP: "10.0.2.10", HostName: "node-b"},
+			},
+			History: []v1alpha1.EgressIPHistoryEntry{
+				{Action: v1alpha1.HistoryActionAssigned, FailureDomain: "zone-a", IP: "10.0.1.10", NewHostName: "node-a", Reason: "Specified", Actor: "admin"},
+			},
+		},
+	}
+
+	hub := &v1beta1.EgressIP{}
+	err := src.ConvertTo(hub)
+	if err != nil {
+		t.Fatalf("EgressIP could not be converted to v1beta1: %v", err)
+	}
+
+	if hub.Spec.IPs[0].FailureDomainRef.Name != "zone-a" || hub.Status.IPs[1].FailureDomainRef.Name != "zone-b" || hub.Status.History[0].FailureDomainRef.Name != "zone-a" {
+		t.Errorf("Failure domains should be referenced by name! current=%v", hub)
+	}
+	if _, found := hub.Annotations[v1alpha1.StatusIPAnnotation]; found {
+		t.Errorf("Single ip of the status matching the first ip should not be kept! current=%v", hub.Annotations)
+	}
+
+	dst := &v1alpha1.EgressIP{}
+	err = dst.ConvertFrom(hub)
+	if err != nil {
+		t.Fatalf("EgressIP could not be converted from v1beta1: %v", err)
+	}
+
+	if !equality.Semantic.DeepEqual(src, dst) {
+		t.Errorf("EgressIP should survive the round trip! expected=%v, current=%v", src, dst)
+	}
+}
+
+func TestConvertingSingleStatusIPToV1beta1AndBack(t *testing.T) {
+	tests := []struct {
+		name   string
+		status v1alpha1.EgressIPStatus
+	}{
+		{"status without ips", v1alpha1.EgressIPStatus{
+			Phase:    "provisioned",
+			IP:       v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "zone-a", IP: "10.0.1.10"},
+			HostName: "node-a",
+		}},
+		{"status ip not first of ips", v1alpha1.EgressIPStatus{
+			Phase:    "provisioned",
+			IP:       v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "zone-b", IP: "10.0.2.10"},
+			HostName: "node-b",
+			IPs:      []v1alpha1.AssignedEgressIP{{FailureDomain: "zone-a", IP: "10.0.1.10", HostName: "node-a"}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := &v1alpha1.EgressIP{
+				ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant", Annotations: map[string]string{"owner": "team-a"}},
+				Status:     test.status,
+			}
+
+			hub := &v1beta1.EgressIP{}
+			err := src.ConvertTo(hub)
+			if err != nil {
+				t.Fatalf("EgressIP could not be converted to v1beta1: %v", err)
+			}
+
+			if hub.Annotations[v1alpha1.StatusIPAnnotation] == "" {
+				t.Errorf("Single ip of the status should be kept as annotation! current=%v", hub.Annotations)
+			}
+			if src.Annotations[v1alpha1.StatusIPAnnotation] != "" {
+				t.Errorf("Annotations of the source have been changed! current=%v", src.Annotations)
+			}
+
+			dst := &v1alpha1.EgressIP{}
+			err = dst.ConvertFrom(hub)
+			if err != nil {
+				t.Fatalf("EgressIP could not be converted from v1beta1: %v", err)
+			}
+
+			if !equality.Semantic.DeepEqual(src, dst) {
+				t.Errorf("EgressIP should survive the round trip! expected=%v, current=%v", src, dst)
+			}
+		})
+	}
+}
+
+func TestConvertingFailureDomainToCluster(t *testing.T) {
+	src := &v1alpha1.EgressIPFailureDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: "zone-a", Namespace: "egress"},
+		Spec: v1alpha1.EgressIPFailureDomainSpec{
+			Cidr:            "10.0.1.0/24",
+			NodeSelector:    corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}}}}}},
+			AllocationRange: &v1alpha1.IPRange{Start: "10.0.1.10", End: "10.0.1.99"},
+			ExcludeRanges:   []v1alpha1.IPRange{{Start: "10.0.1.20", End: "10.0.1.29"}},
+			Reserved:        []string{"10.0.1.5"},
+		},
+		Status: v1alpha1.EgressIPFailureDomainStatus{
+			Phase:      "provisioned",
+			Conditions: []v1alpha1.FailureDomainCondition{{Type: v1alpha1.FailureDomainHostsConfigured, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	dst := &v1beta1.ClusterEgressIPFailureDomain{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"owner": "network"}}}
+	src.ConvertToCluster(dst)
+
+	expected := v1beta1.ClusterEgressIPFailureDomainSpec{
+		Cidr:            src.Spec.Cidr,
+		NodeSelector:    src.Spec.NodeSelector,
+		AllocationRange: &v1beta1.IPRange{Start: "10.0.1.10", End: "10.0.1.99"},
+		ExcludeRanges:   []v1beta1.IPRange{{Start: "10.0.1.20", End: "10.0.1.29"}},
+		Reserved:        []string{"10.0.1.5"},
+	}
+	if !equality.Semantic.DeepEqual(expected, dst.Spec) {
+		t.Errorf("Spec should be copied! expected=%v, current=%v", expected, dst.Spec)
+	}
+	if dst.Name != "zone-a" || dst.Namespace != "" {
+		t.Errorf("Cluster failure domain should be named alike! expected=zone-a, current=%v/%v", dst.Namespace, dst.Name)
+	}
+	if dst.Annotations[v1alpha1.MigratedFromAnnotation] != "egressipfailuredomain/egress/zone-a" || dst.Annotations["owner"] != "network" {
+		t.Errorf("Source should be recorded as annotation! expected=egressipfailuredomain/egress/zone-a, current=%v", dst.Annotations)
+	}
+}
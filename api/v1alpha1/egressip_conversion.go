@@ -0,0 +1,177 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// StatusIPAnnotation keeps the single IP and host of the status of a v1alpha1 EgressIP in v1beta1 if they differ from
+// the first assigned IP, e.g. for EgressIPs provisioned before the status listed all IPs.
+const StatusIPAnnotation = "egressip.kaiserpfalz-edv.de/v1alpha1-status-ip"
+
+// statusIP is the single IP and host of the status of a v1alpha1 EgressIP.
+type statusIP struct {
+	IP       FailureDomainEgressIPSpec `json:"ip"`
+	HostName string                    `json:"hostname,omitempty"`
+}
+
+// ConvertTo converts this EgressIP to the hub version v1beta1. The single IP of the status is kept as annotation if it
+// is not the first assigned IP.
+func (src *EgressIP) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.EgressIP)
+
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Annotations = withoutStatusIP(src.Annotations)
+	if kept := (statusIP{IP: src.Status.IP, HostName: src.Status.HostName}); kept != firstStatusIP(src.Status.IPs) {
+		annotation, err := json.Marshal(kept)
+		if err != nil {
+			return err
+		}
+
+		if dst.Annotations == nil {
+			dst.Annotations = make(map[string]string)
+		}
+		dst.Annotations[StatusIPAnnotation] = string(annotation)
+	}
+
+	dst.Spec.AllFailureDomains = src.Spec.AllFailureDomains
+	dst.Spec.ReclaimPolicy = src.Spec.ReclaimPolicy
+	dst.Spec.IPs = nil
+	for _, ip := range src.Spec.IPs {
+		dst.Spec.IPs = append(dst.Spec.IPs, v1beta1.EgressIPAddress{
+			FailureDomainRef: v1beta1.FailureDomainReference{Name: ip.FailureDomain},
+			IP:               ip.IP,
+			NodeAffinity:     ip.NodeAffinity,
+		})
+	}
+
+	dst.Status.Phase = src.Status.Phase
+	dst.Status.Message = src.Status.Message
+	dst.Status.IPs = nil
+	for _, ip := range src.Status.IPs {
+		dst.Status.IPs = append(dst.Status.IPs, v1beta1.AssignedIP{
+			FailureDomainRef: v1beta1.FailureDomainReference{Name: ip.FailureDomain},
+			IP:               ip.IP,
+			HostName:         ip.HostName,
+		})
+	}
+	dst.Status.History = nil
+	for _, entry := range src.Status.History {
+		dst.Status.History = append(dst.Status.History, v1beta1.HistoryEntry{
+			Timestamp:        entry.Timestamp,
+			Action:           entry.Action,
+			FailureDomainRef: v1beta1.FailureDomainReference{Name: entry.FailureDomain},
+			IP:               entry.IP,
+			OldHostName:      entry.OldHostName,
+			NewHostName:      entry.NewHostName,
+			Reason:           entry.Reason,
+			Actor:            entry.Actor,
+		})
+	}
+
+	return nil
+}
+
+// ConvertFrom converts the hub version v1beta1 to this EgressIP. The single IP of the status is the first assigned IP
+// unless another one has been kept as annotation.
+func (dst *EgressIP) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.EgressIP)
+
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Annotations = withoutStatusIP(src.Annotations)
+
+	dst.Spec.AllFailureDomains = src.Spec.AllFailureDomains
+	dst.Spec.ReclaimPolicy = src.Spec.ReclaimPolicy
+	dst.Spec.IPs = nil
+	for _, ip := range src.Spec.IPs {
+		dst.Spec.IPs = append(dst.Spec.IPs, FailureDomainEgressIPSpec{
+			FailureDomain: ip.FailureDomainRef.Name,
+			IP:            ip.IP,
+			NodeAffinity:  ip.NodeAffinity,
+		})
+	}
+
+	dst.Status.Phase = src.Status.Phase
+	dst.Status.Message = src.Status.Message
+	dst.Status.IP = FailureDomainEgressIPSpec{}
+	dst.Status.HostName = ""
+	dst.Status.IPs = nil
+	for _, ip := range src.Status.IPs {
+		dst.Status.IPs = append(dst.Status.IPs, AssignedEgressIP{
+			FailureDomain: ip.FailureDomainRef.Name,
+			IP:            ip.IP,
+			HostName:      ip.HostName,
+		})
+	}
+	kept := firstStatusIP(dst.Status.IPs)
+	if annotation, found := src.Annotations[StatusIPAnnotation]; found {
+		err := json.Unmarshal([]byte(annotation), &kept)
+		if err != nil {
+			return err
+		}
+	}
+	dst.Status.IP = kept.IP
+	dst.Status.HostName = kept.HostName
+	dst.Status.History = nil
+	for _, entry := range src.Status.History {
+		dst.Status.History = append(dst.Status.History, EgressIPHistoryEntry{
+			Timestamp:     entry.Timestamp,
+			Action:        entry.Action,
+			FailureDomain: entry.FailureDomainRef.Name,
+			IP:            entry.IP,
+			OldHostName:   entry.OldHostName,
+			NewHostName:   entry.NewHostName,
+			Reason:        entry.Reason,
+			Actor:         entry.Actor,
+		})
+	}
+
+	return nil
+}
+
+// firstStatusIP returns the single IP of the status derived from the first assigned IP.
+func firstStatusIP(ips []AssignedEgressIP) statusIP {
+	if len(ips) == 0 {
+		return statusIP{}
+	}
+
+	return statusIP{
+		IP:       FailureDomainEgressIPSpec{FailureDomain: ips[0].FailureDomain, IP: ips[0].IP},
+		HostName: ips[0].HostName,
+	}
+}
+
+// withoutStatusIP returns a copy of the annotations without the kept single IP of the status. Nil is returned if no
+// other annotation is left.
+func withoutStatusIP(annotations map[string]string) map[string]string {
+	var result map[string]string
+	for key, value := range annotations {
+		if key == StatusIPAnnotation {
+			continue
+		}
+
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[key] = value
+	}
+
+	return result
+}
@@ -17,9 +17,16 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Reclaim policies of the EgressIPs.
+const (
+	ReclaimPolicyDelete = "Delete"
+	ReclaimPolicyRetain = "Retain"
+)
+
 // FailureDomainEgressIPSpec defines a single IP within a failureDomain
 type FailureDomainEgressIPSpec struct {
 	// FailureDomain is the defined failuredomain for this EgressIP. Needs to be defined prior to using it.
@@ -27,14 +34,24 @@ type FailureDomainEgressIPSpec struct {
 	// +kubebuilder:validation:Pattern=\d+.\d+.\d+.\d+
 	// IP is the IP that should be used for this EgressIP.
 	IP string `json:"ip,omitempty"`
+	// NodeAffinity restricts the hosts of the IP within the node selector of the failure domain. Required terms have to
+	// match, the IP moves back to the host preferred most once it has been ready for the hold-down time.
+	NodeAffinity *corev1.NodeAffinity `json:"nodeAffinity,omitempty"`
 }
 
 // EgressIPSpec defines the desired state of EgressIP
 type EgressIPSpec struct {
-	// IPs is an array of defined EgressIPs. You may list all defined failure domains. At least one needs to be listed.
-	// +kubebuilder:validation:MinItems=1
+	// IPs is an array of defined EgressIPs. You may list all defined failure domains. An empty list gets a random IP
+	// in every failure domain.
 	// +kubebuilder:validation:UniqueItems=true
-	IPs []FailureDomainEgressIPSpec `json:"ips"`
+	IPs []FailureDomainEgressIPSpec `json:"ips,omitempty"`
+	// AllFailureDomains adds a random IP for every failure domain not listed in IPs, including failure domains created
+	// later.
+	AllFailureDomains bool `json:"allFailureDomains,omitempty"`
+	// +kubebuilder:validation:Enum={"Delete","Retain"}
+	// ReclaimPolicy decides what happens to the IPs released by the EgressIP. Delete returns them to the failure
+	// domain immediately, Retain quarantines them for the namespace. Defaults to Delete.
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
 }
 
 // EgressIPStatus defines the observed state of EgressIP
@@ -48,6 +65,20 @@ type EgressIPStatus struct {
 	HostName string `json:"hostname,omitempty"`
 	// Message is a human readable message for this state.
 	Message string `json:"message,omitempty"`
+	// IPs are the IPs currently assigned per failure domain.
+	IPs []AssignedEgressIP `json:"ips,omitempty"`
+	// History are the latest assignments, moves and releases of the IPs, the oldest first.
+	History []EgressIPHistoryEntry `json:"history,omitempty"`
+}
+
+// AssignedEgressIP is a single IP of the EgressIP assigned to a host within a failure domain.
+type AssignedEgressIP struct {
+	// FailureDomain is the failure domain the IP belongs to.
+	FailureDomain string `json:"failure-domain"`
+	// IP is the assigned IP.
+	IP string `json:"ip"`
+	// HostName is the host the IP is assigned to.
+	HostName string `json:"hostname,omitempty"`
 }
 
 // +kubebuilder:object:root=true
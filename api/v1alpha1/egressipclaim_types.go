@@ -0,0 +1,84 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phases of the EgressIPClaims.
+const (
+	ClaimPhasePending = "pending"
+	ClaimPhaseBound   = "bound"
+	ClaimPhaseFailed  = "failed"
+)
+
+// EgressIPClaimSpec defines the egress IPs a tenant asks for.
+type EgressIPClaimSpec struct {
+	// ClassName is the name of the EgressIPPool satisfying the claim. Without it the default pool is used.
+	ClassName string `json:"className,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// Count is the number of IPs, each in another failure domain of the pool. Defaults to one IP.
+	Count int `json:"count,omitempty"`
+	// OnePerFailureDomain asks for an IP in every failure domain of the pool instead of a count.
+	OnePerFailureDomain bool `json:"onePerFailureDomain,omitempty"`
+	// +kubebuilder:validation:Enum={"Delete","Retain"}
+	// ReclaimPolicy is the reclaim policy of the EgressIP bound to the claim. Defaults to Delete.
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+}
+
+// EgressIPClaimStatus defines the IPs the claim is bound to.
+type EgressIPClaimStatus struct {
+	// +kubebuilder:validation:Enum={"pending","bound","failed"}
+	// Phase is the state of the claim. May be pending, bound or failed.
+	Phase string `json:"phase,omitempty"`
+	// Message is a human readable message for this state.
+	Message string `json:"message,omitempty"`
+	// ClassName is the name of the pool the claim is bound with.
+	ClassName string `json:"className,omitempty"`
+	// IPs are the egress IPs of the namespace bound to the claim.
+	IPs []string `json:"ips,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Class",type=string,JSONPath=`.status.className`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="IPs",type=string,JSONPath=`.status.ips`
+
+// EgressIPClaim is the request of a tenant for egress IPs of its namespace. The operator binds it to IPs of the
+// failure domains of an EgressIPPool, so tenants neither see nor choose failure domains or IPs.
+type EgressIPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressIPClaimSpec   `json:"spec,omitempty"`
+	Status EgressIPClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EgressIPClaimList contains a list of EgressIPClaim
+type EgressIPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressIPClaim `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIPClaim{}, &EgressIPClaimList{})
+}
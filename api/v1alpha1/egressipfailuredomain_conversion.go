@@ -0,0 +1,52 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+)
+
+// MigratedFromAnnotation names the object a migrated object has been created from as '<kind>/<name>'. Namespaced
+// EgressIPFailureDomains are named as 'egressipfailuredomain/<namespace>/<name>'.
+const MigratedFromAnnotation = "egressip.kaiserpfalz-edv.de/migrated-from"
+
+// MigratedFrom returns the value of the MigratedFromAnnotation for this failure domain.
+func (src *EgressIPFailureDomain) MigratedFrom() string {
+	return "egressipfailuredomain/" + src.Namespace + "/" + src.Name
+}
+
+// ConvertToCluster copies the spec of this namespaced failure domain to the ClusterEgressIPFailureDomain of the same
+// name and marks it as migrated from this one. The status is left to the operator.
+func (src *EgressIPFailureDomain) ConvertToCluster(dst *v1beta1.ClusterEgressIPFailureDomain) {
+	dst.Name = src.Name
+	if dst.Annotations == nil {
+		dst.Annotations = make(map[string]string)
+	}
+	dst.Annotations[MigratedFromAnnotation] = src.MigratedFrom()
+
+	dst.Spec.Cidr = src.Spec.Cidr
+	dst.Spec.NodeSelector = src.Spec.NodeSelector
+	dst.Spec.AllocationRange = nil
+	if src.Spec.AllocationRange != nil {
+		dst.Spec.AllocationRange = &v1beta1.IPRange{Start: src.Spec.AllocationRange.Start, End: src.Spec.AllocationRange.End}
+	}
+	dst.Spec.ExcludeRanges = nil
+	for _, excluded := range src.Spec.ExcludeRanges {
+		dst.Spec.ExcludeRanges = append(dst.Spec.ExcludeRanges, v1beta1.IPRange{Start: excluded.Start, End: excluded.End})
+	}
+	dst.Spec.Reserved = append([]string(nil), src.Spec.Reserved...)
+}
@@ -28,6 +28,24 @@ type EgressIPFailureDomainSpec struct {
 	Cidr         string              `json:"cidr,omitempty"`
 	// NodeSelector is the nodeselector of all nodes eligible to get egress ips assigned to.
 	NodeSelector corev1.NodeSelector `json:"nodeSelector,omitempty"`
+	// AllocationRange limits the random IPs to a range within the CIDR. Specified IPs may be outside of it.
+	AllocationRange *IPRange `json:"allocationRange,omitempty"`
+	// ExcludeRanges are ranges of the CIDR already in use, e.g. by load balancers or network appliances. They are never
+	// used for random IPs and specified IPs within them are rejected.
+	ExcludeRanges []IPRange `json:"excludeRanges,omitempty"`
+	// Reserved are single IPs of the CIDR already in use, e.g. by NAT gateways. They are never used for random IPs and
+	// specifying them is rejected.
+	Reserved []string `json:"reserved,omitempty"`
+}
+
+// IPRange is a range of IPs including the first and the last one.
+type IPRange struct {
+	// +kubebuilder:validation:Pattern=\d+.\d+.\d+.\d+
+	// Start is the first IP of the range.
+	Start string `json:"start"`
+	// +kubebuilder:validation:Pattern=\d+.\d+.\d+.\d+
+	// End is the last IP of the range.
+	End string `json:"end"`
 }
 
 // FailureDomainStatus defines the observed state of FailureDomain
@@ -42,6 +60,28 @@ type EgressIPFailureDomainStatus struct {
 	IP string `json:"ip,omitempty"`
 	// Namespace is the namespace this IP belongs to.
 	Namespace string `json:"namespace,omitempty"`
+	// Conditions are the observations of the state of this failure domain.
+	Conditions []FailureDomainCondition `json:"conditions,omitempty"`
+}
+
+const (
+	// FailureDomainHostsConfigured signals whether all eligible hosts are configured to serve egress IPs.
+	FailureDomainHostsConfigured = "HostsConfigured"
+)
+
+// FailureDomainCondition is a single observation of the state of a failure domain.
+type FailureDomainCondition struct {
+	// Type is the type of the condition.
+	Type string `json:"type"`
+	// +kubebuilder:validation:Enum={"True","False","Unknown"}
+	// Status is the status of the condition. May be True, False or Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition changed its status.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a machine readable reason for the last transition.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message for this condition.
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Actions of the history entries.
+const (
+	HistoryActionAssigned = "assigned"
+	HistoryActionMoved    = "moved"
+	HistoryActionReleased = "released"
+)
+
+// EgressIPHistoryEntry records a single assignment, move or release of an IP of an EgressIP.
+type EgressIPHistoryEntry struct {
+	// Timestamp is the time the IP has been changed.
+	Timestamp metav1.Time `json:"timestamp"`
+	// +kubebuilder:validation:Enum={"assigned","moved","released"}
+	// Action is the change of the IP. May be assigned, moved or released.
+	Action string `json:"action"`
+	// FailureDomain is the failure domain the IP belongs to.
+	FailureDomain string `json:"failure-domain"`
+	// IP is the changed IP.
+	IP string `json:"ip"`
+	// OldHostName is the host serving the IP before the change. Empty for assigned IPs.
+	OldHostName string `json:"old-hostname,omitempty"`
+	// NewHostName is the host serving the IP after the change. Empty for released IPs.
+	NewHostName string `json:"new-hostname,omitempty"`
+	// Reason is a machine readable reason for the change.
+	Reason string `json:"reason"`
+	// Actor is the user or component that caused the change.
+	Actor string `json:"actor"`
+}
+
+// EgressIPHistorySpec defines a recorded change of an IP of an EgressIP.
+type EgressIPHistorySpec struct {
+	// EgressIP is the name of the EgressIP the IP belongs to.
+	EgressIP             string `json:"egressip"`
+	EgressIPHistoryEntry `json:",inline"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="EgressIP",type=string,JSONPath=`.spec.egressip`
+// +kubebuilder:printcolumn:name="Action",type=string,JSONPath=`.spec.action`
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.spec.ip`
+// +kubebuilder:printcolumn:name="Old Host",type=string,JSONPath=`.spec.old-hostname`
+// +kubebuilder:printcolumn:name="New Host",type=string,JSONPath=`.spec.new-hostname`
+// +kubebuilder:printcolumn:name="Timestamp",type=date,JSONPath=`.spec.timestamp`
+
+// EgressIPHistory is a single recorded change of an IP of an EgressIP. The entries are never changed and outlive the
+// EgressIP.
+type EgressIPHistory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EgressIPHistorySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EgressIPHistoryList contains a list of EgressIPHistory
+type EgressIPHistoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressIPHistory `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIPHistory{}, &EgressIPHistoryList{})
+}
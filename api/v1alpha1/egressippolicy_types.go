@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressIPPolicySpec defines the quotas and restrictions of the selected namespaces. A namespace selected by several
+// policies has to satisfy all of them.
+type EgressIPPolicySpec struct {
+	// Namespaces are the names of the selected namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces by their labels. A policy without namespaces and selector selects all
+	// namespaces.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// MaxIPs is the maximum number of egress IPs of every selected namespace.
+	MaxIPs *int `json:"maxIPs,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// MaxIPsPerFailureDomain is the maximum number of egress IPs of every selected namespace within a single failure
+	// domain.
+	MaxIPsPerFailureDomain *int `json:"maxIPsPerFailureDomain,omitempty"`
+	// AllowedFailureDomains are the only failure domains the selected namespaces may use. All failure domains are
+	// allowed without them.
+	AllowedFailureDomains []string `json:"allowedFailureDomains,omitempty"`
+	// AllowedPools are the only EgressIPPools the claims of the selected namespaces may use. All pools are allowed
+	// without them.
+	AllowedPools []string `json:"allowedPools,omitempty"`
+}
+
+// FailureDomainUsage are the egress IPs of a namespace within a failure domain.
+type FailureDomainUsage struct {
+	// Name is the name of the failure domain.
+	Name string `json:"name"`
+	// IPs is the number of assigned egress IPs.
+	IPs int `json:"ips"`
+}
+
+// NamespaceUsage are the egress IPs of a namespace selected by the policy.
+type NamespaceUsage struct {
+	// Namespace is the name of the namespace.
+	Namespace string `json:"namespace"`
+	// IPs is the number of assigned egress IPs.
+	IPs int `json:"ips"`
+	// FailureDomains are the assigned egress IPs per failure domain.
+	FailureDomains []FailureDomainUsage `json:"failureDomains,omitempty"`
+	// Violations are the reasons the namespace exceeds the policy.
+	Violations []string `json:"violations,omitempty"`
+}
+
+// EgressIPPolicyStatus defines the usage of the selected namespaces against the quotas.
+type EgressIPPolicyStatus struct {
+	// Namespaces are the usages of the selected namespaces with egress IPs.
+	Namespaces []NamespaceUsage `json:"namespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Max IPs",type=integer,JSONPath=`.spec.maxIPs`
+// +kubebuilder:printcolumn:name="Max IPs per Failure Domain",type=integer,JSONPath=`.spec.maxIPsPerFailureDomain`
+
+// EgressIPPolicy caps the egress IPs of namespaces and restricts the failure domains and pools they may use.
+type EgressIPPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressIPPolicySpec   `json:"spec,omitempty"`
+	Status EgressIPPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EgressIPPolicyList contains a list of EgressIPPolicy
+type EgressIPPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressIPPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIPPolicy{}, &EgressIPPolicyList{})
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultPoolAnnotation marks the pool used by claims without class name when set to "true".
+const DefaultPoolAnnotation = "egressip.kaiserpfalz-edv.de/is-default-class"
+
+// EgressIPPoolSpec defines which failure domains and CIDRs satisfy the claims of the pool.
+type EgressIPPoolSpec struct {
+	// FailureDomains are the names of the failure domains the IPs are taken from. An empty list uses all failure
+	// domains.
+	FailureDomains []string `json:"failureDomains,omitempty"`
+	// Cidrs limit the IPs to these networks within the CIDRs of the failure domains. Without them random IPs of the
+	// failure domains are used.
+	Cidrs []string `json:"cidrs,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// EgressIPPool is a class of egress IPs owned by the cluster admins. EgressIPClaims reference it by name like a
+// StorageClass.
+type EgressIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EgressIPPoolSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EgressIPPoolList contains a list of EgressIPPool
+type EgressIPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressIPPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIPPool{}, &EgressIPPoolList{})
+}
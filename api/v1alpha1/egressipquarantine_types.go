@@ -0,0 +1,64 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressIPQuarantineSpec defines the IP kept back and for whom.
+type EgressIPQuarantineSpec struct {
+	// FailureDomain is the failure domain of the IP.
+	FailureDomain string `json:"failureDomain"`
+	// IP is the quarantined IP.
+	IP string `json:"ip"`
+	// Namespace is the namespace the IP is kept for. Only EgressIPs of this namespace may specify it.
+	Namespace string `json:"namespace"`
+	// EgressIP is the name of the EgressIP that released the IP.
+	EgressIP string `json:"egressIP"`
+	// ReleaseAfter is the end of the cool-down. The IP is kept until the quarantine is deleted without it.
+	ReleaseAfter *metav1.Time `json:"releaseAfter,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.spec.ip`
+// +kubebuilder:printcolumn:name="Failure Domain",type=string,JSONPath=`.spec.failureDomain`
+// +kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.spec.namespace`
+// +kubebuilder:printcolumn:name="Release After",type=string,format=date-time,JSONPath=`.spec.releaseAfter`
+
+// EgressIPQuarantine keeps an IP released by an EgressIP with the reclaim policy Retain from being allocated to other
+// namespaces. Deleting it releases the IP.
+type EgressIPQuarantine struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EgressIPQuarantineSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EgressIPQuarantineList contains a list of EgressIPQuarantine
+type EgressIPQuarantineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressIPQuarantine `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIPQuarantine{}, &EgressIPQuarantineList{})
+}
@@ -1,3 +1,4 @@
+//go:build !ignore_autogenerated
 // +build !ignore_autogenerated
 
 /*
@@ -21,16 +22,33 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignedEgressIP) DeepCopyInto(out *AssignedEgressIP) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignedEgressIP.
+func (in *AssignedEgressIP) DeepCopy() *AssignedEgressIP {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignedEgressIP)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressIP) DeepCopyInto(out *EgressIP) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIP.
@@ -52,12 +70,106 @@ func (in *EgressIP) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressIPFailureDomain) DeepCopyInto(out *EgressIPFailureDomain) {
+func (in *EgressIPClaim) DeepCopyInto(out *EgressIPClaim) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaim.
+func (in *EgressIPClaim) DeepCopy() *EgressIPClaim {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPClaim)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPClaim) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPClaimList) DeepCopyInto(out *EgressIPClaimList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressIPClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaimList.
+func (in *EgressIPClaimList) DeepCopy() *EgressIPClaimList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPClaimList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPClaimList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPClaimSpec) DeepCopyInto(out *EgressIPClaimSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaimSpec.
+func (in *EgressIPClaimSpec) DeepCopy() *EgressIPClaimSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPClaimSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPClaimStatus) DeepCopyInto(out *EgressIPClaimStatus) {
+	*out = *in
+	if in.IPs != nil {
+		in, out := &in.IPs, &out.IPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaimStatus.
+func (in *EgressIPClaimStatus) DeepCopy() *EgressIPClaimStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPClaimStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPFailureDomain) DeepCopyInto(out *EgressIPFailureDomain) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPFailureDomain.
@@ -113,6 +225,22 @@ func (in *EgressIPFailureDomainList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressIPFailureDomainSpec) DeepCopyInto(out *EgressIPFailureDomainSpec) {
 	*out = *in
+	in.NodeSelector.DeepCopyInto(&out.NodeSelector)
+	if in.AllocationRange != nil {
+		in, out := &in.AllocationRange, &out.AllocationRange
+		*out = new(IPRange)
+		**out = **in
+	}
+	if in.ExcludeRanges != nil {
+		in, out := &in.ExcludeRanges, &out.ExcludeRanges
+		*out = make([]IPRange, len(*in))
+		copy(*out, *in)
+	}
+	if in.Reserved != nil {
+		in, out := &in.Reserved, &out.Reserved
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPFailureDomainSpec.
@@ -128,6 +256,13 @@ func (in *EgressIPFailureDomainSpec) DeepCopy() *EgressIPFailureDomainSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressIPFailureDomainStatus) DeepCopyInto(out *EgressIPFailureDomainStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]FailureDomainCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPFailureDomainStatus.
@@ -140,6 +275,96 @@ func (in *EgressIPFailureDomainStatus) DeepCopy() *EgressIPFailureDomainStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPHistory) DeepCopyInto(out *EgressIPHistory) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPHistory.
+func (in *EgressIPHistory) DeepCopy() *EgressIPHistory {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPHistory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPHistory) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPHistoryEntry) DeepCopyInto(out *EgressIPHistoryEntry) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPHistoryEntry.
+func (in *EgressIPHistoryEntry) DeepCopy() *EgressIPHistoryEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPHistoryEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPHistoryList) DeepCopyInto(out *EgressIPHistoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressIPHistory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPHistoryList.
+func (in *EgressIPHistoryList) DeepCopy() *EgressIPHistoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPHistoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPHistoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPHistorySpec) DeepCopyInto(out *EgressIPHistorySpec) {
+	*out = *in
+	in.EgressIPHistoryEntry.DeepCopyInto(&out.EgressIPHistoryEntry)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPHistorySpec.
+func (in *EgressIPHistorySpec) DeepCopy() *EgressIPHistorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPHistorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressIPList) DeepCopyInto(out *EgressIPList) {
 	*out = *in
@@ -172,13 +397,301 @@ func (in *EgressIPList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPPolicy) DeepCopyInto(out *EgressIPPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPPolicy.
+func (in *EgressIPPolicy) DeepCopy() *EgressIPPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPPolicyList) DeepCopyInto(out *EgressIPPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressIPPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPPolicyList.
+func (in *EgressIPPolicyList) DeepCopy() *EgressIPPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPPolicySpec) DeepCopyInto(out *EgressIPPolicySpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxIPs != nil {
+		in, out := &in.MaxIPs, &out.MaxIPs
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxIPsPerFailureDomain != nil {
+		in, out := &in.MaxIPsPerFailureDomain, &out.MaxIPsPerFailureDomain
+		*out = new(int)
+		**out = **in
+	}
+	if in.AllowedFailureDomains != nil {
+		in, out := &in.AllowedFailureDomains, &out.AllowedFailureDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedPools != nil {
+		in, out := &in.AllowedPools, &out.AllowedPools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPPolicySpec.
+func (in *EgressIPPolicySpec) DeepCopy() *EgressIPPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPPolicyStatus) DeepCopyInto(out *EgressIPPolicyStatus) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]NamespaceUsage, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPPolicyStatus.
+func (in *EgressIPPolicyStatus) DeepCopy() *EgressIPPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPPool) DeepCopyInto(out *EgressIPPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPPool.
+func (in *EgressIPPool) DeepCopy() *EgressIPPool {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPPoolList) DeepCopyInto(out *EgressIPPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressIPPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPPoolList.
+func (in *EgressIPPoolList) DeepCopy() *EgressIPPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPPoolSpec) DeepCopyInto(out *EgressIPPoolSpec) {
+	*out = *in
+	if in.FailureDomains != nil {
+		in, out := &in.FailureDomains, &out.FailureDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Cidrs != nil {
+		in, out := &in.Cidrs, &out.Cidrs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPPoolSpec.
+func (in *EgressIPPoolSpec) DeepCopy() *EgressIPPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPQuarantine) DeepCopyInto(out *EgressIPQuarantine) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPQuarantine.
+func (in *EgressIPQuarantine) DeepCopy() *EgressIPQuarantine {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPQuarantine)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPQuarantine) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPQuarantineList) DeepCopyInto(out *EgressIPQuarantineList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressIPQuarantine, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPQuarantineList.
+func (in *EgressIPQuarantineList) DeepCopy() *EgressIPQuarantineList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPQuarantineList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPQuarantineList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPQuarantineSpec) DeepCopyInto(out *EgressIPQuarantineSpec) {
+	*out = *in
+	if in.ReleaseAfter != nil {
+		in, out := &in.ReleaseAfter, &out.ReleaseAfter
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPQuarantineSpec.
+func (in *EgressIPQuarantineSpec) DeepCopy() *EgressIPQuarantineSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPQuarantineSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressIPSpec) DeepCopyInto(out *EgressIPSpec) {
 	*out = *in
 	if in.IPs != nil {
 		in, out := &in.IPs, &out.IPs
 		*out = make([]FailureDomainEgressIPSpec, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -195,7 +708,19 @@ func (in *EgressIPSpec) DeepCopy() *EgressIPSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressIPStatus) DeepCopyInto(out *EgressIPStatus) {
 	*out = *in
-	out.IP = in.IP
+	in.IP.DeepCopyInto(&out.IP)
+	if in.IPs != nil {
+		in, out := &in.IPs, &out.IPs
+		*out = make([]AssignedEgressIP, len(*in))
+		copy(*out, *in)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]EgressIPHistoryEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPStatus.
@@ -208,9 +733,30 @@ func (in *EgressIPStatus) DeepCopy() *EgressIPStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureDomainCondition) DeepCopyInto(out *FailureDomainCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureDomainCondition.
+func (in *FailureDomainCondition) DeepCopy() *FailureDomainCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureDomainCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FailureDomainEgressIPSpec) DeepCopyInto(out *FailureDomainEgressIPSpec) {
 	*out = *in
+	if in.NodeAffinity != nil {
+		in, out := &in.NodeAffinity, &out.NodeAffinity
+		*out = new(v1.NodeAffinity)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureDomainEgressIPSpec.
@@ -222,3 +768,58 @@ func (in *FailureDomainEgressIPSpec) DeepCopy() *FailureDomainEgressIPSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureDomainUsage) DeepCopyInto(out *FailureDomainUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureDomainUsage.
+func (in *FailureDomainUsage) DeepCopy() *FailureDomainUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureDomainUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPRange) DeepCopyInto(out *IPRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRange.
+func (in *IPRange) DeepCopy() *IPRange {
+	if in == nil {
+		return nil
+	}
+	out := new(IPRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceUsage) DeepCopyInto(out *NamespaceUsage) {
+	*out = *in
+	if in.FailureDomains != nil {
+		in, out := &in.FailureDomains, &out.FailureDomains
+		*out = make([]FailureDomainUsage, len(*in))
+		copy(*out, *in)
+	}
+	if in.Violations != nil {
+		in, out := &in.Violations, &out.Violations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceUsage.
+func (in *NamespaceUsage) DeepCopy() *NamespaceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterEgressIPFailureDomainSpec defines the desired state of ClusterEgressIPFailureDomain
+type ClusterEgressIPFailureDomainSpec struct {
+	// +kubebuilder:validation:Pattern=\d+.\d+.\d+.\d+/\d+
+	// Cidr is the network of the failure domain. Only needed for the provisioners 'ocp-static' and 'ocp-dynamic'.
+	Cidr string `json:"cidr,omitempty"`
+	// NodeSelector is the nodeselector of all nodes eligible to get egress ips assigned to.
+	NodeSelector corev1.NodeSelector `json:"nodeSelector,omitempty"`
+	// AllocationRange limits the random IPs to a range within the CIDR. Specified IPs may be outside of it.
+	AllocationRange *IPRange `json:"allocationRange,omitempty"`
+	// ExcludeRanges are ranges of the CIDR already in use, e.g. by load balancers or network appliances. They are never
+	// used for random IPs and specified IPs within them are rejected.
+	ExcludeRanges []IPRange `json:"excludeRanges,omitempty"`
+	// Reserved are single IPs of the CIDR already in use, e.g. by NAT gateways. They are never used for random IPs and
+	// specifying them is rejected.
+	Reserved []string `json:"reserved,omitempty"`
+}
+
+// IPRange is a range of IPs including the first and the last one.
+type IPRange struct {
+	// +kubebuilder:validation:Pattern=\d+.\d+.\d+.\d+
+	// Start is the first IP of the range.
+	Start string `json:"start"`
+	// +kubebuilder:validation:Pattern=\d+.\d+.\d+.\d+
+	// End is the last IP of the range.
+	End string `json:"end"`
+}
+
+// ClusterEgressIPFailureDomainStatus defines the observed state of ClusterEgressIPFailureDomain
+type ClusterEgressIPFailureDomainStatus struct {
+	// +kubebuilder:validation:Enum={"pending","initializing","failed","provisioned","deprovisioned"}
+	// Phase is the state of this failure domain. May be pending, initializing, failed, provisioned or deprovisioned
+	Phase string `json:"phase,omitempty"`
+	// Message is a human readable message for this state.
+	Message string `json:"message,omitempty"`
+	// Conditions are the observations of the state of this failure domain.
+	Conditions []FailureDomainCondition `json:"conditions,omitempty"`
+}
+
+const (
+	// FailureDomainHostsConfigured signals whether all eligible hosts are configured to serve egress IPs.
+	FailureDomainHostsConfigured = "HostsConfigured"
+)
+
+// FailureDomainCondition is a single observation of the state of a failure domain.
+type FailureDomainCondition struct {
+	// Type is the type of the condition.
+	Type string `json:"type"`
+	// +kubebuilder:validation:Enum={"True","False","Unknown"}
+	// Status is the status of the condition. May be True, False or Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition changed its status.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a machine readable reason for the last transition.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message for this condition.
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cidr",type=string,JSONPath=`.spec.cidr`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// ClusterEgressIPFailureDomain is the Schema for the clusteregressipfailuredomains API. It replaces the namespaced
+// v1alpha1 EgressIPFailureDomain, the operator migrates those to the ClusterEgressIPFailureDomain of the same name.
+type ClusterEgressIPFailureDomain struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterEgressIPFailureDomainSpec   `json:"spec,omitempty"`
+	Status ClusterEgressIPFailureDomainStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterEgressIPFailureDomainList contains a list of ClusterEgressIPFailureDomain
+type ClusterEgressIPFailureDomainList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterEgressIPFailureDomain `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterEgressIPFailureDomain{}, &ClusterEgressIPFailureDomainList{})
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FailureDomainReference references a cluster-scoped EgressIPFailureDomain.
+type FailureDomainReference struct {
+	// Name is the name of the failure domain.
+	Name string `json:"name"`
+}
+
+// EgressIPAddress defines a single IP within a failure domain.
+type EgressIPAddress struct {
+	// FailureDomainRef references the failure domain of this IP. Needs to be defined prior to using it.
+	FailureDomainRef FailureDomainReference `json:"failureDomainRef"`
+	// +kubebuilder:validation:Pattern=\d+.\d+.\d+.\d+
+	// IP is the IP that should be used. Without it a random IP of the failure domain is used.
+	IP string `json:"ip,omitempty"`
+	// NodeAffinity restricts the hosts of the IP within the node selector of the failure domain. Required terms have to
+	// match, the IP moves back to the host preferred most once it has been ready for the hold-down time.
+	NodeAffinity *corev1.NodeAffinity `json:"nodeAffinity,omitempty"`
+}
+
+// EgressIPSpec defines the desired state of EgressIP
+type EgressIPSpec struct {
+	// IPs are the IPs of the EgressIP, at most one per failure domain. An empty list gets a random IP in every failure
+	// domain.
+	// +kubebuilder:validation:UniqueItems=true
+	IPs []EgressIPAddress `json:"ips,omitempty"`
+	// AllFailureDomains adds a random IP for every failure domain not listed in IPs, including failure domains created
+	// later.
+	AllFailureDomains bool `json:"allFailureDomains,omitempty"`
+	// +kubebuilder:validation:Enum={"Delete","Retain"}
+	// ReclaimPolicy decides what happens to the IPs released by the EgressIP. Delete returns them to the failure
+	// domain immediately, Retain quarantines them for the namespace. Defaults to Delete.
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+}
+
+// AssignedIP is a single IP of the EgressIP assigned to a host within a failure domain.
+type AssignedIP struct {
+	// FailureDomainRef references the failure domain the IP belongs to.
+	FailureDomainRef FailureDomainReference `json:"failureDomainRef"`
+	// IP is the assigned IP.
+	IP string `json:"ip"`
+	// HostName is the host the IP is assigned to.
+	HostName string `json:"hostName,omitempty"`
+}
+
+// HistoryEntry records a single assignment, move or release of an IP of an EgressIP.
+type HistoryEntry struct {
+	// Timestamp is the time the IP has been changed.
+	Timestamp metav1.Time `json:"timestamp"`
+	// +kubebuilder:validation:Enum={"assigned","moved","released"}
+	// Action is the change of the IP. May be assigned, moved or released.
+	Action string `json:"action"`
+	// FailureDomainRef references the failure domain the IP belongs to.
+	FailureDomainRef FailureDomainReference `json:"failureDomainRef"`
+	// IP is the changed IP.
+	IP string `json:"ip"`
+	// OldHostName is the host serving the IP before the change. Empty for assigned IPs.
+	OldHostName string `json:"oldHostName,omitempty"`
+	// NewHostName is the host serving the IP after the change. Empty for released IPs.
+	NewHostName string `json:"newHostName,omitempty"`
+	// Reason is a machine readable reason for the change.
+	Reason string `json:"reason"`
+	// Actor is the user or component that caused the change.
+	Actor string `json:"actor"`
+}
+
+// EgressIPStatus defines the observed state of EgressIP
+type EgressIPStatus struct {
+	// +kubebuilder:validation:Enum={"pending","initializing","failed","provisioned","deprovisioned"}
+	// Phase is the state of this EgressIP. May be pending, initializing, failed, provisioned or deprovisioned
+	Phase string `json:"phase,omitempty"`
+	// Message is a human readable message for this state.
+	Message string `json:"message,omitempty"`
+	// IPs are the IPs currently assigned per failure domain.
+	IPs []AssignedIP `json:"ips,omitempty"`
+	// History are the latest assignments, moves and releases of the IPs, the oldest first.
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.message`
+
+// EgressIP is the Schema for the egressips API
+type EgressIP struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressIPSpec   `json:"spec,omitempty"`
+	Status EgressIPStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EgressIPList contains a list of EgressIP
+type EgressIPList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressIP `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIP{}, &EgressIPList{})
+}
@@ -0,0 +1,347 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"k8s.io/api/core/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignedIP) DeepCopyInto(out *AssignedIP) {
+	*out = *in
+	out.FailureDomainRef = in.FailureDomainRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignedIP.
+func (in *AssignedIP) DeepCopy() *AssignedIP {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignedIP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterEgressIPFailureDomain) DeepCopyInto(out *ClusterEgressIPFailureDomain) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterEgressIPFailureDomain.
+func (in *ClusterEgressIPFailureDomain) DeepCopy() *ClusterEgressIPFailureDomain {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterEgressIPFailureDomain)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterEgressIPFailureDomain) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterEgressIPFailureDomainList) DeepCopyInto(out *ClusterEgressIPFailureDomainList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterEgressIPFailureDomain, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterEgressIPFailureDomainList.
+func (in *ClusterEgressIPFailureDomainList) DeepCopy() *ClusterEgressIPFailureDomainList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterEgressIPFailureDomainList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterEgressIPFailureDomainList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterEgressIPFailureDomainSpec) DeepCopyInto(out *ClusterEgressIPFailureDomainSpec) {
+	*out = *in
+	in.NodeSelector.DeepCopyInto(&out.NodeSelector)
+	if in.AllocationRange != nil {
+		in, out := &in.AllocationRange, &out.AllocationRange
+		*out = new(IPRange)
+		**out = **in
+	}
+	if in.ExcludeRanges != nil {
+		in, out := &in.ExcludeRanges, &out.ExcludeRanges
+		*out = make([]IPRange, len(*in))
+		copy(*out, *in)
+	}
+	if in.Reserved != nil {
+		in, out := &in.Reserved, &out.Reserved
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterEgressIPFailureDomainSpec.
+func (in *ClusterEgressIPFailureDomainSpec) DeepCopy() *ClusterEgressIPFailureDomainSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterEgressIPFailureDomainSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterEgressIPFailureDomainStatus) DeepCopyInto(out *ClusterEgressIPFailureDomainStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]FailureDomainCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterEgressIPFailureDomainStatus.
+func (in *ClusterEgressIPFailureDomainStatus) DeepCopy() *ClusterEgressIPFailureDomainStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterEgressIPFailureDomainStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIP) DeepCopyInto(out *EgressIP) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIP.
+func (in *EgressIP) DeepCopy() *EgressIP {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIP) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPAddress) DeepCopyInto(out *EgressIPAddress) {
+	*out = *in
+	out.FailureDomainRef = in.FailureDomainRef
+	if in.NodeAffinity != nil {
+		in, out := &in.NodeAffinity, &out.NodeAffinity
+		*out = new(v1.NodeAffinity)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPAddress.
+func (in *EgressIPAddress) DeepCopy() *EgressIPAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPList) DeepCopyInto(out *EgressIPList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressIP, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPList.
+func (in *EgressIPList) DeepCopy() *EgressIPList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressIPList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPSpec) DeepCopyInto(out *EgressIPSpec) {
+	*out = *in
+	if in.IPs != nil {
+		in, out := &in.IPs, &out.IPs
+		*out = make([]EgressIPAddress, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPSpec.
+func (in *EgressIPSpec) DeepCopy() *EgressIPSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPStatus) DeepCopyInto(out *EgressIPStatus) {
+	*out = *in
+	if in.IPs != nil {
+		in, out := &in.IPs, &out.IPs
+		*out = make([]AssignedIP, len(*in))
+		copy(*out, *in)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]HistoryEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPStatus.
+func (in *EgressIPStatus) DeepCopy() *EgressIPStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureDomainCondition) DeepCopyInto(out *FailureDomainCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureDomainCondition.
+func (in *FailureDomainCondition) DeepCopy() *FailureDomainCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureDomainCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureDomainReference) DeepCopyInto(out *FailureDomainReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureDomainReference.
+func (in *FailureDomainReference) DeepCopy() *FailureDomainReference {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureDomainReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HistoryEntry) DeepCopyInto(out *HistoryEntry) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+	out.FailureDomainRef = in.FailureDomainRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HistoryEntry.
+func (in *HistoryEntry) DeepCopy() *HistoryEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(HistoryEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPRange) DeepCopyInto(out *IPRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRange.
+func (in *IPRange) DeepCopy() *IPRange {
+	if in == nil {
+		return nil
+	}
+	out := new(IPRange)
+	in.DeepCopyInto(out)
+	return out
+}
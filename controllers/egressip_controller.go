@@ -20,13 +20,22 @@ import (
 	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
 	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
 
+	"context"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	egressipv1beta1 "github.com/klenkes74/egress-ip-operator/api/v1beta1"
 )
 
 // EgressIPReconciler reconciles a EgressIP object
@@ -36,19 +45,106 @@ type EgressIPReconciler struct {
 	Scheme      *runtime.Scheme
 	Provisioner *provisioner.EgressIPProvisioner
 	Alarm       *metrics.AlarmStore
+	Recorder    record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressips,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressips/status,verbs=get;update;patch;create;delete
-// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipfailuredomains/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressiphistories,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains,verbs=get;list;watch
+// +kubebuilder:rbac:groups=network.openshift.io,resources=hostsubnets,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=network.openshift.io,resources=hostsubnets/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=network.openshift.io,resources=netnamespaces,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *EgressIPReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	return openshift.ManageEgressIP(req, r.Client, r.Log)
+	ctx, span := tracing.Start(context.Background(), "EgressIPReconciler.Reconcile",
+		tracing.NamespaceKey.String(req.Namespace),
+		tracing.NameKey.String(req.Name),
+	)
+	result, err := openshift.ManageEgressIP(ctx, req, r.Client, *r.Provisioner, *r.Alarm, r.Recorder, r.Log)
+	tracing.End(span, err)
+
+	return result, err
 }
 
 func (r *EgressIPReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&egressipv1alpha1.EgressIP{}).
+		Watches(
+			&source.Kind{Type: &corev1.Node{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.egressIPsOfNode)},
+		).
+		Watches(
+			&source.Kind{Type: &egressipv1beta1.ClusterEgressIPFailureDomain{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.egressIPsUsingAllFailureDomains)},
+		).
 		Complete(r)
 }
+
+// egressIPsUsingAllFailureDomains maps a changed failure domain to all EgressIPs using all failure domains, so they get
+// an IP in new failure domains.
+func (r *EgressIPReconciler) egressIPsUsingAllFailureDomains(_ handler.MapObject) []reconcile.Request {
+	egressIPs := &egressipv1alpha1.EgressIPList{}
+	err := r.Client.List(context.Background(), egressIPs)
+	if err != nil {
+		r.Log.Error(err, "can not list egress ips")
+		return []reconcile.Request{}
+	}
+
+	result := make([]reconcile.Request, 0)
+	for _, egressIP := range egressIPs.Items {
+		if egressIP.Spec.AllFailureDomains {
+			result = append(result, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: egressIP.Namespace, Name: egressIP.Name},
+			})
+		}
+	}
+
+	return result
+}
+
+// egressIPsOfNode maps a changed node to all EgressIPs with an IP assigned to it, so IPs of failed nodes get moved.
+// EgressIPs with a node affinity are mapped to every node, so their IPs move back to recovered preferred nodes.
+func (r *EgressIPReconciler) egressIPsOfNode(node handler.MapObject) []reconcile.Request {
+	egressIPs := &egressipv1alpha1.EgressIPList{}
+	err := r.Client.List(context.Background(), egressIPs)
+	if err != nil {
+		r.Log.Error(err, "can not list egress ips")
+		return []reconcile.Request{}
+	}
+
+	result := make([]reconcile.Request, 0)
+	for _, egressIP := range egressIPs.Items {
+		if hasNodeAffinity(&egressIP) || isAssignedTo(&egressIP, node.Meta.GetName()) {
+			result = append(result, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: egressIP.Namespace, Name: egressIP.Name},
+			})
+		}
+	}
+
+	return result
+}
+
+func isAssignedTo(egressIP *egressipv1alpha1.EgressIP, hostName string) bool {
+	for _, ip := range egressIP.Status.IPs {
+		if ip.HostName == hostName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasNodeAffinity(egressIP *egressipv1alpha1.EgressIP) bool {
+	for _, spec := range egressIP.Spec.IPs {
+		if spec.NodeAffinity != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+
+	"context"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	egressipv1beta1 "github.com/klenkes74/egress-ip-operator/api/v1beta1"
+)
+
+// EgressIPClaimReconciler reconciles a EgressIPClaim object
+type EgressIPClaimReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipclaims,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipclaims/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressippools,verbs=get;list;watch
+
+func (r *EgressIPClaimReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracing.Start(context.Background(), "EgressIPClaimReconciler.Reconcile",
+		tracing.NamespaceKey.String(req.Namespace),
+		tracing.NameKey.String(req.Name),
+	)
+	result, err := openshift.ManageEgressIPClaim(ctx, req, r.Client, r.Recorder, r.Log)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (r *EgressIPClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&egressipv1alpha1.EgressIPClaim{}).
+		Owns(&egressipv1alpha1.EgressIP{}).
+		Watches(
+			&source.Kind{Type: &egressipv1alpha1.EgressIPPool{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.allClaims)},
+		).
+		Watches(
+			&source.Kind{Type: &egressipv1beta1.ClusterEgressIPFailureDomain{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.allClaims)},
+		).
+		Complete(r)
+}
+
+// allClaims maps a changed pool or failure domain to all claims since any of them may be bound with it.
+func (r *EgressIPClaimReconciler) allClaims(_ handler.MapObject) []reconcile.Request {
+	claims := &egressipv1alpha1.EgressIPClaimList{}
+	err := r.Client.List(context.Background(), claims)
+	if err != nil {
+		r.Log.Error(err, "can not list egress ip claims")
+		return []reconcile.Request{}
+	}
+
+	result := make([]reconcile.Request, len(claims.Items))
+	for i, claim := range claims.Items {
+		result[i] = reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: claim.Namespace, Name: claim.Name},
+		}
+	}
+
+	return result
+}
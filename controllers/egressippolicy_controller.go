@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+
+	"context"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+)
+
+// EgressIPPolicyReconciler reconciles a EgressIPPolicy object
+type EgressIPPolicyReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressippolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressippolicies/status,verbs=get;update;patch
+
+func (r *EgressIPPolicyReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracing.Start(context.Background(), "EgressIPPolicyReconciler.Reconcile",
+		tracing.NameKey.String(req.Name),
+	)
+	result, err := openshift.ManageEgressIPPolicy(ctx, req, r.Client, r.Recorder, r.Log)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (r *EgressIPPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&egressipv1alpha1.EgressIPPolicy{}).
+		Watches(
+			&source.Kind{Type: &egressipv1alpha1.EgressIP{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.allPolicies)},
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Namespace{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.allPolicies)},
+		).
+		Complete(r)
+}
+
+// allPolicies maps a changed EgressIP or namespace to all policies since any of them may select the namespace.
+func (r *EgressIPPolicyReconciler) allPolicies(_ handler.MapObject) []reconcile.Request {
+	policies := &egressipv1alpha1.EgressIPPolicyList{}
+	err := r.Client.List(context.Background(), policies)
+	if err != nil {
+		r.Log.Error(err, "can not list egress ip policies")
+		return []reconcile.Request{}
+	}
+
+	result := make([]reconcile.Request, len(policies.Items))
+	for i, policy := range policies.Items {
+		result[i] = reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: policy.Name},
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+
+	"context"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+)
+
+// EgressIPQuarantineReconciler reconciles a EgressIPQuarantine object
+type EgressIPQuarantineReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipquarantines,verbs=get;list;watch;create;update;patch;delete
+
+func (r *EgressIPQuarantineReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracing.Start(context.Background(), "EgressIPQuarantineReconciler.Reconcile",
+		tracing.NameKey.String(req.Name),
+	)
+	result, err := openshift.ManageEgressIPQuarantine(ctx, req, r.Client, r.Recorder, r.Log)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (r *EgressIPQuarantineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&egressipv1alpha1.EgressIPQuarantine{}).
+		Complete(r)
+}
@@ -17,16 +17,30 @@
 package controllers
 
 import (
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
 	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
 	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
 
+	"context"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	egressipv1beta1 "github.com/klenkes74/egress-ip-operator/api/v1beta1"
 )
 
 // EgressIPFailureDomainReconciler reconciles a FailureDomain object
@@ -36,19 +50,137 @@ type EgressIPFailureDomainReconciler struct {
 	Scheme      *runtime.Scheme
 	Provisioner *provisioner.EgressIPProvisioner
 	Alarm       *metrics.AlarmStore
+	Recorder    record.EventRecorder
 }
 
-// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipfailuredomains,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipfailuredomains/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressips,verbs=get;list;watch
 // +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressips/status,verbs=get;update;patch;create;delete
 // +kubebuilder:rbac:groups=network.openshift.io,resources=hostsubnets/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *EgressIPFailureDomainReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	return openshift.ManageEgressIPFailureDomain(req, r.Client, r.Log)
+	ctx, span := tracing.Start(context.Background(), "EgressIPFailureDomainReconciler.Reconcile",
+		tracing.NamespaceKey.String(req.Namespace),
+		tracing.NameKey.String(req.Name),
+	)
+	result, err := openshift.ManageEgressIPFailureDomain(ctx, req, r.Client, *r.Provisioner, r.Recorder, r.Log)
+	tracing.End(span, err)
+
+	return result, err
 }
 
 func (r *EgressIPFailureDomainReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&egressipv1alpha1.EgressIPFailureDomain{}).
+		For(&egressipv1beta1.ClusterEgressIPFailureDomain{}).
+		Watches(
+			&source.Kind{Type: &corev1.Node{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.failureDomainsOfNode)},
+			builder.WithPredicates(eligibilityChanged),
+		).
+		Watches(
+			&source.Kind{Type: &egressipv1alpha1.EgressIP{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.referencedFailureDomains)},
+		).
 		Complete(r)
 }
+
+// allFailureDomains returns a request for every failure domain.
+func (r *EgressIPFailureDomainReconciler) allFailureDomains(_ handler.MapObject) []reconcile.Request {
+	failureDomains := &egressipv1beta1.ClusterEgressIPFailureDomainList{}
+	err := r.Client.List(context.Background(), failureDomains)
+	if err != nil {
+		r.Log.Error(err, "can not list failure domains")
+		return []reconcile.Request{}
+	}
+
+	result := make([]reconcile.Request, len(failureDomains.Items))
+	for i, failureDomain := range failureDomains.Items {
+		result[i] = reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: failureDomain.Name},
+		}
+	}
+
+	return result
+}
+
+// failureDomainsOfNode maps a changed node to the failure domains it is eligible for and the failure domains it has been
+// checked for, since it may have left them.
+func (r *EgressIPFailureDomainReconciler) failureDomainsOfNode(object handler.MapObject) []reconcile.Request {
+	node, ok := object.Object.(*corev1.Node)
+	if !ok {
+		return []reconcile.Request{}
+	}
+
+	failureDomains := &egressipv1beta1.ClusterEgressIPFailureDomainList{}
+	err := r.Client.List(context.Background(), failureDomains)
+	if err != nil {
+		r.Log.Error(err, "can not list failure domains")
+		return []reconcile.Request{}
+	}
+
+	checked := openshift.FailureDomainsCheckingHost(node.Name)
+
+	result := make([]reconcile.Request, 0)
+	for _, failureDomain := range failureDomains.Items {
+		if failuredomain.NodeMatchesSelector(node, &failureDomain.Spec.NodeSelector) || containsName(checked, failureDomain.Name) {
+			result = append(result, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: failureDomain.Name},
+			})
+		}
+	}
+
+	return result
+}
+
+// eligibilityChanged passes the node events changing the labels, addresses, readiness or the instance of the node.
+// Updates of the heartbeats only are dropped.
+var eligibilityChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNode, ok := e.ObjectOld.(*corev1.Node)
+		if !ok {
+			return true
+		}
+		newNode, ok := e.ObjectNew.(*corev1.Node)
+		if !ok {
+			return true
+		}
+
+		return !reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+			!reflect.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses) ||
+			oldNode.Spec.ProviderID != newNode.Spec.ProviderID ||
+			failuredomain.IsNodeReady(oldNode) != failuredomain.IsNodeReady(newNode)
+	},
+}
+
+func containsName(names []string, name string) bool {
+	for _, candidate := range names {
+		if candidate == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// referencedFailureDomains maps a changed EgressIP to the failure domains it references to update their capacity.
+func (r *EgressIPFailureDomainReconciler) referencedFailureDomains(object handler.MapObject) []reconcile.Request {
+	egressIP, ok := object.Object.(*egressipv1alpha1.EgressIP)
+	if !ok {
+		return []reconcile.Request{}
+	}
+
+	result := make([]reconcile.Request, 0)
+	for _, request := range r.allFailureDomains(object) {
+		for _, ip := range egressIP.Spec.IPs {
+			if ip.FailureDomain == request.Name {
+				result = append(result, request)
+				break
+			}
+		}
+	}
+
+	return result
+}
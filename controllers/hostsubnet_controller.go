@@ -20,10 +20,13 @@ import (
 	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
 	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
 
+	"context"
 	"github.com/go-logr/logr"
 	netv1 "github.com/openshift/api/network/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -35,15 +38,29 @@ type HostSubnetReconciler struct {
 	Scheme      *runtime.Scheme
 	Provisioner *provisioner.EgressIPProvisioner
 	Alarm       *metrics.AlarmStore
+	Recorder    record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=network.openshift.io,resources=hostsubnets,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=network.openshift.io,resources=hostsubnets/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipfailuredomains/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressips,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressips/status,verbs=get;update;patch;create;delete
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains,verbs=get;list;watch
+// +kubebuilder:rbac:groups=network.openshift.io,resources=netnamespaces,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *HostSubnetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	return openshift.ManageHostSubnet(req, r.Client, r.Log)
+	ctx, span := tracing.Start(context.Background(), "HostSubnetReconciler.Reconcile",
+		tracing.NamespaceKey.String(req.Namespace),
+		tracing.NameKey.String(req.Name),
+	)
+	result, err := openshift.ManageHostSubnet(ctx, req, r.Client, *r.Provisioner, *r.Alarm, r.Recorder, r.Log)
+	tracing.End(span, err)
+
+	return result, err
 }
 
 func (r *HostSubnetReconciler) SetupWithManager(mgr ctrl.Manager) error {
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+
+	"context"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+
+	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	egressipv1beta1 "github.com/klenkes74/egress-ip-operator/api/v1beta1"
+)
+
+// LegacyFailureDomainReconciler migrates the namespaced v1alpha1 EgressIPFailureDomains to ClusterEgressIPFailureDomains
+type LegacyFailureDomainReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipfailuredomains,verbs=get;list;watch
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=egressipfailuredomains/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains,verbs=get;list;watch;create;update;patch
+
+func (r *LegacyFailureDomainReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracing.Start(context.Background(), "LegacyFailureDomainReconciler.Reconcile",
+		tracing.NamespaceKey.String(req.Namespace),
+		tracing.NameKey.String(req.Name),
+	)
+	result, err := openshift.ManageLegacyFailureDomain(ctx, req, r.Client, r.Log)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (r *LegacyFailureDomainReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&egressipv1alpha1.EgressIPFailureDomain{}).
+		Watches(
+			&source.Kind{Type: &egressipv1beta1.ClusterEgressIPFailureDomain{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(migratedFrom)},
+		).
+		Complete(r)
+}
+
+// migratedFrom maps a changed cluster failure domain to the namespaced failure domain it has been migrated from, so
+// its status is mirrored and the cluster failure domain is handed over when the namespaced one is gone.
+func migratedFrom(object handler.MapObject) []reconcile.Request {
+	from := strings.Split(object.Meta.GetAnnotations()[egressipv1alpha1.MigratedFromAnnotation], "/")
+	if len(from) != 3 || from[0] != "egressipfailuredomain" {
+		return []reconcile.Request{}
+	}
+
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: from[1], Name: from[2]}}}
+}
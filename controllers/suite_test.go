@@ -31,6 +31,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	egressipv1beta1 "github.com/klenkes74/egress-ip-operator/api/v1beta1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -65,6 +66,9 @@ var _ = BeforeSuite(func(done Done) {
 	err = egressipv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = egressipv1beta1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	// +kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
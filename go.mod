@@ -10,6 +10,10 @@ require (
 	github.com/onsi/gomega v1.10.2
 	github.com/openshift/api v3.9.0+incompatible
 	github.com/prometheus/client_golang v1.0.0
+	go.opentelemetry.io/otel v0.15.0
+	go.opentelemetry.io/otel/exporters/otlp v0.15.0
+	go.opentelemetry.io/otel/sdk v0.15.0
+	k8s.io/api v0.18.6
 	k8s.io/apimachinery v0.18.6
 	k8s.io/client-go v0.18.6
 	sigs.k8s.io/controller-runtime v0.6.2
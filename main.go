@@ -17,9 +17,17 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"github.com/klenkes74/egress-ip-operator/pkg/alerting"
+	"github.com/klenkes74/egress-ip-operator/pkg/garbagecollector"
+	"github.com/klenkes74/egress-ip-operator/pkg/health"
 	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/migration"
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/statusapi"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+	"github.com/klenkes74/egress-ip-operator/pkg/webhooks"
 	"os"
 
 	netv1 "github.com/openshift/api/network/v1"
@@ -29,8 +37,10 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	egressipv1alpha1 "github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	egressipv1beta1 "github.com/klenkes74/egress-ip-operator/api/v1beta1"
 	"github.com/klenkes74/egress-ip-operator/controllers"
 	// +kubebuilder:scaffold:imports
 )
@@ -46,13 +56,16 @@ func init() {
 	utilruntime.Must(netv1.AddToScheme(scheme))
 
 	utilruntime.Must(egressipv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(egressipv1beta1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
+	var healthProbeAddr string
 	var enableLeaderElection bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&healthProbeAddr, "health-probe-addr", ":8081", "The address the health probe endpoints bind to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -61,31 +74,99 @@ func main() {
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "416133d7.kaiserpfalz-edv.de",
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: healthProbeAddr,
+		Port:                   9443,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "416133d7.kaiserpfalz-edv.de",
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), ctrl.Log.WithName("tracing"))
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
 	alarm := metrics.NewAlarmStore(ctrl.Log.WithName("metrics-based-alarmstore"))
 
-	egressIPProvisioner, err := provisioner.NewEgressIPProvisioner(ctrl.Log)
-	if err != nil {
-		setupLog.Error(err, "unable to create egress ip provisioner")
+	egressIPProvisioner, provisionerErr := provisioner.NewEgressIPProvisioner(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log)
+	checker := health.NewProvisionerChecker(egressIPProvisioner, provisionerErr, ctrl.Log.WithName("health"))
+	if err = mgr.AddHealthzCheck("provisioner", checker.Alive); err != nil {
+		setupLog.Error(err, "unable to create liveness check")
+		os.Exit(1)
+	}
+	if err = mgr.AddReadyzCheck("provisioner", checker.Ready); err != nil {
+		setupLog.Error(err, "unable to create readiness check")
+		os.Exit(1)
+	}
+
+	if provisionerErr != nil {
+		setupLog.Error(provisionerErr, "unable to create egress ip provisioner - the manager won't get ready")
+	} else {
+		setupControllers(mgr, egressIPProvisioner, alarm)
+	}
+
+	if webhooks.Enabled {
+		webhooks.Register(mgr, ctrl.Log.WithName("webhooks"))
+	}
+
+	if err = mgr.Add(alerting.NewPrometheusRuleMaintainer(
+		mgr.GetClient(),
+		ctrl.Log.WithName("prometheus-rule"),
+	)); err != nil {
+		setupLog.Error(err, "unable to create maintainer of the prometheus rule")
 		os.Exit(1)
 	}
 
+	if statusapi.Enabled {
+		statusHandler := statusapi.NewHandler(mgr.GetClient(), *alarm, ctrl.Log.WithName("status-api"))
+		for _, path := range []string{statusapi.Path, statusapi.HistoryPath} {
+			if err = mgr.AddMetricsExtraHandler(path, statusHandler); err != nil {
+				setupLog.Error(err, "unable to create status api", "path", path)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if migration.Mode != migration.ModeDisabled || migration.AdoptionMode != migration.ModeDisabled {
+		if err = mgr.Add(migration.NewMigrator(
+			mgr.GetAPIReader(),
+			mgr.GetClient(),
+			ctrl.Log.WithName("migration"),
+		)); err != nil {
+			setupLog.Error(err, "unable to create migration")
+			os.Exit(1)
+		}
+	}
+
+	setupLog.Info("starting manager")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running manager")
+		_ = shutdownTracing(context.Background())
+		os.Exit(1)
+	}
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "problem flushing the traces")
+	}
+}
+
+// setupControllers adds the reconcilers and the garbage collector working with the provisioner to the manager.
+func setupControllers(mgr manager.Manager, egressIPProvisioner *provisioner.EgressIPProvisioner, alarm *metrics.AlarmStore) {
+	var err error
+
 	if err = (&controllers.EgressIPReconciler{
 		Client:      mgr.GetClient(),
 		Log:         ctrl.Log.WithName("controllers").WithName("egressip-controller"),
 		Scheme:      mgr.GetScheme(),
 		Provisioner: egressIPProvisioner,
 		Alarm:       alarm,
+		Recorder:    mgr.GetEventRecorderFor("egressip-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "egressip-controller")
 		os.Exit(1)
@@ -96,25 +177,65 @@ func main() {
 		Scheme:      mgr.GetScheme(),
 		Provisioner: egressIPProvisioner,
 		Alarm:       alarm,
+		Recorder:    mgr.GetEventRecorderFor("egressip-failuredomain-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "egressip-failuredomain-controller")
 		os.Exit(1)
 	}
+	if err = (&controllers.LegacyFailureDomainReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("legacy-failuredomain-controller"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "legacy-failuredomain-controller")
+		os.Exit(1)
+	}
 	if err = (&controllers.HostSubnetReconciler{
 		Client:      mgr.GetClient(),
 		Log:         ctrl.Log.WithName("controllers").WithName("HostSubnet"),
 		Scheme:      mgr.GetScheme(),
 		Provisioner: egressIPProvisioner,
 		Alarm:       alarm,
+		Recorder:    mgr.GetEventRecorderFor("hostsubnet-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HostSubnet")
 		os.Exit(1)
 	}
+	if err = (&controllers.EgressIPClaimReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("egressip-claim-controller"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("egressip-claim-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "egressip-claim-controller")
+		os.Exit(1)
+	}
+	if err = (&controllers.EgressIPPolicyReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("egressip-policy-controller"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("egressip-policy-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "egressip-policy-controller")
+		os.Exit(1)
+	}
+	if err = (&controllers.EgressIPQuarantineReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("egressip-quarantine-controller"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("egressip-quarantine-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "egressip-quarantine-controller")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
-	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		setupLog.Error(err, "problem running manager")
+	if err = mgr.Add(garbagecollector.NewOrphanedIPCollector(
+		mgr.GetClient(),
+		*egressIPProvisioner,
+		ctrl.Log.WithName("garbage-collector"),
+	)); err != nil {
+		setupLog.Error(err, "unable to create garbage collector for orphaned ips")
 		os.Exit(1)
 	}
 }
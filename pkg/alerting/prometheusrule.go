@@ -0,0 +1,309 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// alerting maintains the PrometheusRule with the alerts of the operator. The alerts are derived from the metrics of the
+// alarm store, the capacity of the failure domains, the failovers and the calls to the cloud API.
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"strconv"
+	"time"
+)
+
+// +kubebuilder:rbac:groups=monitoring.coreos.com,resources=prometheusrules,verbs=get;list;watch;create;update;patch
+
+const (
+	DefaultRuleName              = "egress-ip-operator"
+	DefaultInterval              = 10 * time.Minute
+	DefaultEgressIPFailureFor    = 10 * time.Minute
+	DefaultFreeAddressRatio      = 0.1
+	DefaultFlappingFailovers     = 3
+	DefaultFlappingWindow        = 1 * time.Hour
+	DefaultCloudAPIErrorRatio    = 0.05
+	DefaultCloudAPIErrorRatioFor = 15 * time.Minute
+	DefaultFailureDomainShortFor = 15 * time.Minute
+	prometheusRuleAPIVersion     = "monitoring.coreos.com/v1"
+	prometheusRuleKind           = "PrometheusRule"
+)
+
+// Thresholds are the configurable thresholds of the alerts.
+type Thresholds struct {
+	// EgressIPFailureFor is the time an EgressIP has to fail before the alert fires.
+	EgressIPFailureFor time.Duration
+	// FreeAddressRatio is the ratio of free addresses of a failure domain below which the alert fires.
+	FreeAddressRatio float64
+	// FailureDomainShortFor is the time a failure domain has to be short of addresses before the alert fires.
+	FailureDomainShortFor time.Duration
+	// FlappingFailovers is the number of moves within the FlappingWindow that count as flapping.
+	FlappingFailovers int
+	// FlappingWindow is the window the moves are counted in.
+	FlappingWindow time.Duration
+	// CloudAPIErrorRatio is the ratio of failed calls to the cloud API above which the alert fires.
+	CloudAPIErrorRatio float64
+	// CloudAPIErrorRatioFor is the time the error ratio has to be exceeded before the alert fires.
+	CloudAPIErrorRatioFor time.Duration
+}
+
+var (
+	// Enabled enables the maintenance of the PrometheusRule.
+	Enabled bool
+	// Namespace is the namespace the PrometheusRule is created in. Normally the namespace of the operator.
+	Namespace string
+	// DefaultThresholds are the thresholds configured via the environment.
+	DefaultThresholds Thresholds
+)
+
+func init() {
+	Enabled = true
+	enabled, found := os.LookupEnv("PROMETHEUS_RULE_ENABLED")
+	if found {
+		Enabled, _ = strconv.ParseBool(enabled)
+	}
+
+	Namespace, _ = os.LookupEnv("OPERATOR_NAMESPACE")
+
+	DefaultThresholds = Thresholds{
+		EgressIPFailureFor:    durationFromEnv("ALERT_EGRESSIP_FAILURE_FOR", DefaultEgressIPFailureFor),
+		FreeAddressRatio:      floatFromEnv("ALERT_FREE_ADDRESS_RATIO", DefaultFreeAddressRatio),
+		FailureDomainShortFor: durationFromEnv("ALERT_FAILURE_DOMAIN_SHORT_FOR", DefaultFailureDomainShortFor),
+		FlappingFailovers:     int(floatFromEnv("ALERT_FLAPPING_FAILOVERS", DefaultFlappingFailovers)),
+		FlappingWindow:        durationFromEnv("ALERT_FLAPPING_WINDOW", DefaultFlappingWindow),
+		CloudAPIErrorRatio:    floatFromEnv("ALERT_CLOUD_API_ERROR_RATIO", DefaultCloudAPIErrorRatio),
+		CloudAPIErrorRatioFor: durationFromEnv("ALERT_CLOUD_API_ERROR_RATIO_FOR", DefaultCloudAPIErrorRatioFor),
+	}
+}
+
+func durationFromEnv(name string, defaultValue time.Duration) time.Duration {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue
+	}
+
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return result
+}
+
+func floatFromEnv(name string, defaultValue float64) float64 {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue
+	}
+
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue
+	}
+
+	return result
+}
+
+var _ manager.Runnable = &PrometheusRuleMaintainer{}
+var _ manager.LeaderElectionRunnable = &PrometheusRuleMaintainer{}
+
+// PrometheusRuleMaintainer creates the PrometheusRule at startup and restores it periodically.
+type PrometheusRuleMaintainer struct {
+	Client     client.Client
+	Name       string
+	Namespace  string
+	Thresholds Thresholds
+	Interval   time.Duration
+
+	Log logr.Logger
+}
+
+// NewPrometheusRuleMaintainer creates the maintainer configured via the environment.
+func NewPrometheusRuleMaintainer(c client.Client, logger logr.Logger) *PrometheusRuleMaintainer {
+	return &PrometheusRuleMaintainer{
+		Client:     c,
+		Name:       DefaultRuleName,
+		Namespace:  Namespace,
+		Thresholds: DefaultThresholds,
+		Interval:   DefaultInterval,
+		Log:        logger,
+	}
+}
+
+// NeedLeaderElection makes sure only the leading manager writes the PrometheusRule.
+func (p *PrometheusRuleMaintainer) NeedLeaderElection() bool {
+	return true
+}
+
+// Start maintains the PrometheusRule until the stop channel is closed.
+func (p *PrometheusRuleMaintainer) Start(stop <-chan struct{}) error {
+	if !Enabled || p.Namespace == "" {
+		p.Log.Info("maintenance of the prometheus rule is disabled", "namespace", p.Namespace)
+		return nil
+	}
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := p.Ensure(context.Background())
+		if err != nil {
+			p.Log.Error(err, "prometheus rule could not be maintained")
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ensure creates the PrometheusRule or updates its spec. A cluster without the PrometheusRule CRD is skipped.
+func (p *PrometheusRuleMaintainer) Ensure(ctx context.Context) error {
+	desired := NewPrometheusRule(p.Name, p.Namespace, p.Thresholds)
+
+	current := &unstructured.Unstructured{}
+	current.SetAPIVersion(prometheusRuleAPIVersion)
+	current.SetKind(prometheusRuleKind)
+	err := p.Client.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Name}, current)
+	if meta.IsNoMatchError(err) {
+		p.Log.Info("prometheus rules are not supported by the cluster")
+		return nil
+	}
+	if errors.IsNotFound(err) {
+		p.Log.Info("creating prometheus rule", "namespace", p.Namespace, "name", p.Name)
+		return p.Client.Create(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	current.Object["spec"] = desired.Object["spec"]
+	return p.Client.Update(ctx, current)
+}
+
+// NewPrometheusRule renders the PrometheusRule with the alerts of the operator.
+func NewPrometheusRule(name string, namespace string, thresholds Thresholds) *unstructured.Unstructured {
+	result := &unstructured.Unstructured{}
+	result.SetAPIVersion(prometheusRuleAPIVersion)
+	result.SetKind(prometheusRuleKind)
+	result.SetName(name)
+	result.SetNamespace(namespace)
+	result.SetLabels(map[string]string{"control-plane": "controller-manager"})
+
+	result.Object["spec"] = map[string]interface{}{
+		"groups": []interface{}{
+			map[string]interface{}{
+				"name": "egress-ip-operator",
+				"rules": []interface{}{
+					alert(
+						"EgressIPFailing",
+						"max by (namespace, egressip, failure_domain, ip) (egress_ip_handling_failures{state=\"active\"}) > 0",
+						thresholds.EgressIPFailureFor,
+						"critical",
+						"EgressIP {{ $labels.namespace }}/{{ $labels.egressip }} fails to provision ip '{{ $labels.ip }}' in failure domain {{ $labels.failure_domain }}.",
+					),
+					alert(
+						"EgressIPAlarmFlapping",
+						"egress_ip_alarm_flapping > 0",
+						0,
+						"warning",
+						"The alarm of EgressIP {{ $labels.namespace }}/{{ $labels.egressip }} is raised and cleared repeatedly.",
+					),
+					alert(
+						"EgressIPFailureDomainNearlyExhausted",
+						fmt.Sprintf(
+							"egress_ip_failure_domain_free_addresses / egress_ip_failure_domain_addresses < %v",
+							thresholds.FreeAddressRatio,
+						),
+						thresholds.FailureDomainShortFor,
+						"warning",
+						"Failure domain {{ $labels.failure_domain }} has only {{ $value | humanizePercentage }} of its addresses left.",
+					),
+					alert(
+						"EgressIPFailureDomainExhausted",
+						"egress_ip_failure_domain_free_addresses == 0",
+						thresholds.FailureDomainShortFor,
+						"critical",
+						"Failure domain {{ $labels.failure_domain }} has no free address left.",
+					),
+					alert(
+						"EgressIPFailoverFlapping",
+						fmt.Sprintf(
+							"increase(egress_ip_moves_total[%v]) >= %v",
+							promDuration(thresholds.FlappingWindow),
+							thresholds.FlappingFailovers,
+						),
+						0,
+						"warning",
+						"IPs of failure domain {{ $labels.failure_domain }} have been moved {{ $value }} times within "+promDuration(thresholds.FlappingWindow)+".",
+					),
+					alert(
+						"EgressIPCloudAPIErrors",
+						fmt.Sprintf(
+							"sum by (provider, region) (rate(egress_ip_cloud_api_requests_total{code!=\"success\"}[5m])) / sum by (provider, region) (rate(egress_ip_cloud_api_requests_total[5m])) > %v",
+							thresholds.CloudAPIErrorRatio,
+						),
+						thresholds.CloudAPIErrorRatioFor,
+						"warning",
+						"{{ $value | humanizePercentage }} of the calls to the {{ $labels.provider }} api in region {{ $labels.region }} fail.",
+					),
+				},
+			},
+		},
+	}
+
+	return result
+}
+
+func alert(name string, expression string, duration time.Duration, severity string, message string) map[string]interface{} {
+	result := map[string]interface{}{
+		"alert": name,
+		"expr":  expression,
+		"labels": map[string]interface{}{
+			"severity": severity,
+		},
+		"annotations": map[string]interface{}{
+			"message": message,
+		},
+	}
+
+	if duration > 0 {
+		result["for"] = promDuration(duration)
+	}
+
+	return result
+}
+
+// promDuration formats the duration in the notation of prometheus, e.g. '1h' or '90s'.
+func promDuration(duration time.Duration) string {
+	switch {
+	case duration%time.Hour == 0:
+		return fmt.Sprintf("%vh", int64(duration/time.Hour))
+	case duration%time.Minute == 0:
+		return fmt.Sprintf("%vm", int64(duration/time.Minute))
+	default:
+		return fmt.Sprintf("%vs", int64(duration/time.Second))
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alerting_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/pkg/alerting"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+var log = zap.New(zap.UseDevMode(true)).WithName("alerting_test")
+
+func expressions(t *testing.T, rule *unstructured.Unstructured) map[string]string {
+	groups, _, _ := unstructured.NestedSlice(rule.Object, "spec", "groups")
+	if len(groups) != 1 {
+		t.Fatalf("Wrong number of rule groups! expected=1, current=%v", len(groups))
+	}
+
+	rules, _, _ := unstructured.NestedSlice(groups[0].(map[string]interface{}), "rules")
+	result := make(map[string]string)
+	for _, rule := range rules {
+		alert := rule.(map[string]interface{})
+		result[alert["alert"].(string)] = alert["expr"].(string)
+	}
+
+	return result
+}
+
+func TestRenderingThresholds(t *testing.T) {
+	thresholds := alerting.DefaultThresholds
+	thresholds.FreeAddressRatio = 0.25
+	thresholds.FlappingFailovers = 5
+	thresholds.FlappingWindow = 30 * time.Minute
+
+	rule := alerting.NewPrometheusRule("rule", "operator", thresholds)
+	current := expressions(t, rule)
+
+	for name, expected := range map[string]string{
+		"EgressIPFailing":                      "egress_ip_handling_failures{state=\"active\"}",
+		"EgressIPAlarmFlapping":                "egress_ip_alarm_flapping",
+		"EgressIPFailureDomainNearlyExhausted": "< 0.25",
+		"EgressIPFailureDomainExhausted":       "egress_ip_failure_domain_free_addresses == 0",
+		"EgressIPFailoverFlapping":             "increase(egress_ip_moves_total[30m]) >= 5",
+		"EgressIPCloudAPIErrors":               "egress_ip_cloud_api_requests_total",
+	} {
+		if !strings.Contains(current[name], expected) {
+			t.Errorf("Wrong expression of alert '%v'! expected to contain='%v', current='%v'", name, expected, current[name])
+		}
+	}
+}
+
+func TestEnsuringPrometheusRule(t *testing.T) {
+	c := fake.NewFakeClientWithScheme(runtime.NewScheme())
+	sut := &alerting.PrometheusRuleMaintainer{
+		Client:     c,
+		Name:       "rule",
+		Namespace:  "operator",
+		Thresholds: alerting.DefaultThresholds,
+		Log:        log,
+	}
+
+	err := sut.Ensure(context.Background())
+	if err != nil {
+		t.Fatalf("PrometheusRule could not be created: %v", err)
+	}
+
+	sut.Thresholds.FreeAddressRatio = 0.5
+	err = sut.Ensure(context.Background())
+	if err != nil {
+		t.Fatalf("PrometheusRule could not be updated: %v", err)
+	}
+
+	current := &unstructured.Unstructured{}
+	current.SetAPIVersion("monitoring.coreos.com/v1")
+	current.SetKind("PrometheusRule")
+	err = c.Get(context.Background(), types.NamespacedName{Namespace: "operator", Name: "rule"}, current)
+	if err != nil {
+		t.Fatalf("PrometheusRule could not be read: %v", err)
+	}
+
+	if !strings.Contains(expressions(t, current)["EgressIPFailureDomainNearlyExhausted"], "< 0.5") {
+		t.Errorf("PrometheusRule has not been updated! current=%v", expressions(t, current))
+	}
+}
@@ -17,27 +17,38 @@
 package aws_provider
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"net"
 	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"strings"
 )
 
 type AwsCloudProvider struct {
 	FailureRegion     string
 	MaxIPsPerInstance int
+	// SourceDestCheck is the expected source/destination check of the instances serving egress IPs.
+	SourceDestCheck bool
+	// FixSourceDestCheck enables changing the source/destination check of misconfigured instances.
+	FixSourceDestCheck bool
 
 	Client AwsDirectCalls
+	// Nodes is used to map the kubernetes nodes to the EC2 instances via spec.providerID. If it is nil, the instances
+	// are searched by their private DNS name.
+	Nodes client.Reader
 
 	Log logr.Logger
 }
 
-func (a AwsCloudProvider) AddRandomIP(hostName string) (*net.IP, error) {
-	instance, err := a.instanceByHostname(hostName)
+func (a AwsCloudProvider) AddRandomIP(ctx context.Context, hostName string) (*net.IP, error) {
+	instance, err := a.instanceByHostname(ctx, hostName)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +69,7 @@ func (a AwsCloudProvider) AddRandomIP(hostName string) (*net.IP, error) {
 		NetworkInterfaceId:             interfaceID,
 		SecondaryPrivateIpAddressCount: aws.Int64(int64(1)),
 	}
-	addressResponse, err := a.Client.AssignPrivateIpAddresses(&addressRequest)
+	addressResponse, err := a.Client.AssignPrivateIpAddresses(ctx, &addressRequest)
 	if err != nil {
 		return nil, err
 	}
@@ -80,12 +91,12 @@ func (a AwsCloudProvider) AddRandomIP(hostName string) (*net.IP, error) {
 	return &ip, nil
 }
 
-func (a AwsCloudProvider) AddSpecifiedIP(ip *net.IP, hostName string) error {
-	return a.moveSpecifiedIP(ip, hostName, false)
+func (a AwsCloudProvider) AddSpecifiedIP(ctx context.Context, ip *net.IP, hostName string) error {
+	return a.moveSpecifiedIP(ctx, ip, hostName, false)
 }
 
-func (a AwsCloudProvider) moveSpecifiedIP(ip *net.IP, hostName string, allowReassignement bool) error {
-	instance, err := a.instanceByHostname(hostName)
+func (a AwsCloudProvider) moveSpecifiedIP(ctx context.Context, ip *net.IP, hostName string, allowReassignement bool) error {
+	instance, err := a.instanceByHostname(ctx, hostName)
 	if err != nil {
 		return err
 	}
@@ -112,7 +123,7 @@ func (a AwsCloudProvider) moveSpecifiedIP(ip *net.IP, hostName string, allowReas
 		NetworkInterfaceId: aws.String(*interfaceID),
 		PrivateIpAddresses: aws.StringSlice([]string{ip.String()}),
 	}
-	addressResponse, err := a.Client.AssignPrivateIpAddresses(&addressRequest)
+	addressResponse, err := a.Client.AssignPrivateIpAddresses(ctx, &addressRequest)
 	if err != nil {
 		return err
 	}
@@ -171,8 +182,8 @@ func (a AwsCloudProvider) checkIP(instance *ec2.Instance, ip *net.IP) error {
 	return nil
 }
 
-func (a AwsCloudProvider) CheckIP(ip *net.IP, hostName string) error {
-	instance, err := a.instanceByHostname(hostName)
+func (a AwsCloudProvider) CheckIP(ctx context.Context, ip *net.IP, hostName string) error {
+	instance, err := a.instanceByHostname(ctx, hostName)
 	if err != nil {
 		return err
 	}
@@ -193,17 +204,186 @@ func (a AwsCloudProvider) checkIPOfInstance(ip *net.IP, instance *ec2.Instance)
 	)
 }
 
-func (a AwsCloudProvider) MoveIP(ip *net.IP, oldHostName string, newHostName string) error {
-	err := a.CheckIP(ip, oldHostName)
+func (a AwsCloudProvider) CheckHost(ctx context.Context, hostName string) error {
+	instance, err := a.instanceByHostname(ctx, hostName)
 	if err != nil {
 		return err
 	}
 
-	return a.moveSpecifiedIP(ip, newHostName, true)
+	err = a.checkValidInstance(instance)
+	if err != nil {
+		return err
+	}
+
+	err = a.checkSourceDestCheck(instance)
+	if err == nil {
+		return nil
+	}
+
+	if !a.FixSourceDestCheck {
+		return err
+	}
+
+	a.Log.Info("fixing source/destination check of instance",
+		"host", hostName,
+		"instance-id", *instance.InstanceId,
+		"source-dest-check", a.SourceDestCheck,
+	)
+	modify := ec2.ModifyInstanceAttributeInput{
+		InstanceId:      instance.InstanceId,
+		SourceDestCheck: &ec2.AttributeBooleanValue{Value: aws.Bool(a.SourceDestCheck)},
+	}
+	_, err = a.Client.ModifyInstanceAttribute(ctx, &modify)
+	if err != nil {
+		return err
+	}
+
+	instance, err = a.instanceByHostname(ctx, hostName)
+	if err != nil {
+		return err
+	}
+
+	return a.checkSourceDestCheck(instance)
+}
+
+// CheckBackend describes a few instances. It fails if EC2 can't be reached or the credentials are not valid.
+func (a AwsCloudProvider) CheckBackend(ctx context.Context) error {
+	_, err := a.Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{MaxResults: aws.Int64(5)})
+
+	return err
+}
+
+// checkSourceDestCheck compares the source/destination check of the instance and its primary network interface with
+// the expected configuration.
+func (a AwsCloudProvider) checkSourceDestCheck(instance *ec2.Instance) error {
+	if instance.SourceDestCheck != nil && *instance.SourceDestCheck != a.SourceDestCheck {
+		return fmt.Errorf(
+			"instance '%v' has source/destination check '%v' - expected '%v'",
+			*instance.InstanceId, *instance.SourceDestCheck, a.SourceDestCheck,
+		)
+	}
+
+	eni := instance.NetworkInterfaces[0]
+	if eni.SourceDestCheck != nil && *eni.SourceDestCheck != a.SourceDestCheck {
+		return fmt.Errorf(
+			"network interface '%v' of instance '%v' has source/destination check '%v' - expected '%v'",
+			aws.StringValue(eni.NetworkInterfaceId), *instance.InstanceId, *eni.SourceDestCheck, a.SourceDestCheck,
+		)
+	}
+
+	return nil
+}
+
+func (a AwsCloudProvider) MoveIP(ctx context.Context, ip *net.IP, oldHostName string, newHostName string) error {
+	err := a.CheckIP(ctx, ip, oldHostName)
+	if err != nil {
+		currentHostName, findErr := a.FindIP(ctx, ip)
+		if findErr != nil || currentHostName == "" {
+			return err
+		}
+
+		if currentHostName == newHostName {
+			a.Log.Info("ip is already assigned to the new host",
+				"ip", ip.String(),
+				"host", newHostName,
+			)
+
+			return nil
+		}
+
+		a.Log.Info("ip has drifted to another host - moving it from there",
+			"ip", ip.String(),
+			"expected-host", oldHostName,
+			"current-host", currentHostName,
+			"new-host", newHostName,
+		)
+	}
+
+	return a.moveSpecifiedIP(ctx, ip, newHostName, true)
+}
+
+func (a AwsCloudProvider) FindIP(ctx context.Context, ip *net.IP) (string, error) {
+	filter := ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("addresses.private-ip-address"),
+				Values: aws.StringSlice([]string{ip.String()}),
+			},
+		},
+	}
+
+	interfaces, err := a.Client.DescribeNetworkInterfaces(ctx, &filter)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(interfaces.NetworkInterfaces) {
+	case 0:
+		a.Log.Info("ip is not assigned to any network interface", "ip", ip.String())
+
+		return "", nil
+	case 1:
+		// exactly one eni holds the ip, as expected.
+	default:
+		return "", fmt.Errorf("ip '%v' is assigned to %v network interfaces", ip.String(), len(interfaces.NetworkInterfaces))
+	}
+
+	eni := interfaces.NetworkInterfaces[0]
+	if eni.Attachment == nil || eni.Attachment.InstanceId == nil {
+		return "", fmt.Errorf(
+			"ip '%v' is assigned to network interface '%v' which is not attached to an instance",
+			ip.String(), aws.StringValue(eni.NetworkInterfaceId),
+		)
+	}
+
+	hostName, err := a.hostnameByInstanceID(ctx, *eni.Attachment.InstanceId)
+	if err != nil {
+		return "", err
+	}
+
+	a.Log.Info("found ip",
+		"ip", ip.String(),
+		"host", hostName,
+		"instance-id", *eni.Attachment.InstanceId,
+		"network-interface-id", aws.StringValue(eni.NetworkInterfaceId),
+	)
+
+	return hostName, nil
+}
+
+// IPLimit returns the number of secondary IPs an instance may have. The primary IP of the instance counts against the
+// maximum IPs per instance.
+func (a AwsCloudProvider) IPLimit(_ context.Context, _ string) (int, error) {
+	return a.MaxIPsPerInstance - 1, nil
 }
 
-func (a AwsCloudProvider) RemoveIP(ip *net.IP, hostName string) error {
-	instance, err := a.instanceByHostname(hostName)
+func (a AwsCloudProvider) ListIPs(ctx context.Context, hostName string) ([]*net.IP, error) {
+	instance, err := a.instanceByHostname(ctx, hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*net.IP, 0)
+	for _, eni := range instance.NetworkInterfaces {
+		for _, address := range eni.PrivateIpAddresses {
+			if aws.BoolValue(address.Primary) {
+				continue
+			}
+
+			ip := net.ParseIP(aws.StringValue(address.PrivateIpAddress))
+			if ip == nil {
+				continue
+			}
+
+			result = append(result, &ip)
+		}
+	}
+
+	return result, nil
+}
+
+func (a AwsCloudProvider) RemoveIP(ctx context.Context, ip *net.IP, hostName string) error {
+	instance, err := a.instanceByHostname(ctx, hostName)
 	if err != nil {
 		return err
 	}
@@ -241,7 +421,7 @@ func (a AwsCloudProvider) RemoveIP(ip *net.IP, hostName string) error {
 		PrivateIpAddresses: aws.StringSlice([]string{ip.String()}),
 	}
 
-	_, err = a.Client.UnassignPrivateIpAddresses(&unAssign)
+	_, err = a.Client.UnassignPrivateIpAddresses(ctx, &unAssign)
 	if err != nil {
 		return err
 	}
@@ -249,28 +429,139 @@ func (a AwsCloudProvider) RemoveIP(ip *net.IP, hostName string) error {
 	return nil
 }
 
-func (a AwsCloudProvider) instanceByHostname(hostName string) (*ec2.Instance, error) {
-	filter := ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("private-dns-name"),
-				Values: aws.StringSlice([]string{hostName}),
+// instanceByHostname resolves the kubernetes node to the running EC2 instance backing it. The node is mapped via its
+// spec.providerID. If the node can not be read or has no AWS provider id, the private DNS name is used instead.
+func (a AwsCloudProvider) instanceByHostname(ctx context.Context, hostName string) (*ec2.Instance, error) {
+	var filter ec2.DescribeInstancesInput
+
+	instanceID := a.instanceIDByHostname(ctx, hostName)
+	if instanceID != "" {
+		filter = ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice([]string{instanceID}),
+		}
+	} else {
+		filter = ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("private-dns-name"),
+					Values: aws.StringSlice([]string{hostName}),
+				},
 			},
-		},
+		}
 	}
 
-	reservations, err := a.Client.DescribeInstances(&filter)
+	reservations, err := a.Client.DescribeInstances(ctx, &filter)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(reservations.Reservations) > 0 {
+	instances := make([]*ec2.Instance, 0)
+	for _, reservation := range reservations.Reservations {
+		for _, instance := range reservation.Instances {
+			if instance.State == nil || aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
+				a.Log.Info("ignoring instance not running",
+					"host", hostName,
+					"instance-id", aws.StringValue(instance.InstanceId),
+				)
+				continue
+			}
+
+			instances = append(instances, instance)
+		}
+	}
+
+	switch len(instances) {
+	case 0:
+		return nil, errors.New("no instance found")
+	case 1:
 		a.Log.Info("found instance",
-			"instance-id", reservations.Reservations[0].Instances[0].InstanceId,
+			"host", hostName,
+			"instance-id", aws.StringValue(instances[0].InstanceId),
 		)
-	} else {
-		return nil, errors.New("no instance found")
+
+		return instances[0], nil
+	default:
+		ids := make([]string, len(instances))
+		for i, instance := range instances {
+			ids[i] = aws.StringValue(instance.InstanceId)
+		}
+
+		return nil, fmt.Errorf("host '%v' matches more than one running instance: [%s]",
+			hostName, strings.Join(ids, ","))
+	}
+}
+
+// instanceIDByHostname reads the instance id from the spec.providerID of the node. It returns an empty string if no
+// node lookup is configured, the node can not be read or the provider id is no AWS provider id.
+func (a AwsCloudProvider) instanceIDByHostname(ctx context.Context, hostName string) string {
+	if a.Nodes == nil {
+		return ""
+	}
+
+	node := &corev1.Node{}
+	err := a.Nodes.Get(ctx, types.NamespacedName{Name: hostName}, node)
+	if err != nil {
+		a.Log.Info("can not read node - falling back to private dns name",
+			"host", hostName,
+			"error", err.Error(),
+		)
+
+		return ""
+	}
+
+	return InstanceIDFromProviderID(node.Spec.ProviderID)
+}
+
+// hostnameByInstanceID resolves the instance to the name of the kubernetes node backed by it. If no node matches the
+// instance, the private DNS name of the instance is used.
+func (a AwsCloudProvider) hostnameByInstanceID(ctx context.Context, instanceID string) (string, error) {
+	if a.Nodes != nil {
+		nodes := &corev1.NodeList{}
+		err := a.Nodes.List(ctx, nodes)
+		if err != nil {
+			a.Log.Info("can not list nodes - falling back to private dns name",
+				"instance-id", instanceID,
+				"error", err.Error(),
+			)
+		} else {
+			for _, node := range nodes.Items {
+				if InstanceIDFromProviderID(node.Spec.ProviderID) == instanceID {
+					return node.Name, nil
+				}
+			}
+		}
+	}
+
+	filter := ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice([]string{instanceID}),
+	}
+	reservations, err := a.Client.DescribeInstances(ctx, &filter)
+	if err != nil {
+		return "", err
+	}
+
+	for _, reservation := range reservations.Reservations {
+		for _, instance := range reservation.Instances {
+			if aws.StringValue(instance.InstanceId) == instanceID && aws.StringValue(instance.PrivateDnsName) != "" {
+				return *instance.PrivateDnsName, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no host found for instance '%v'", instanceID)
+}
+
+// InstanceIDFromProviderID extracts the instance id from an AWS provider id like 'aws:///eu-central-1a/i-0123456789'.
+// It returns an empty string if the provider id is no AWS provider id.
+func InstanceIDFromProviderID(providerID string) string {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return ""
+	}
+
+	instanceID := providerID[strings.LastIndex(providerID, "/")+1:]
+	if !strings.HasPrefix(instanceID, "i-") {
+		return ""
 	}
 
-	return reservations.Reservations[0].Instances[0], nil
+	return instanceID
 }
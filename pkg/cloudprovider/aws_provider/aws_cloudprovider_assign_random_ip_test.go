@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"net"
@@ -38,14 +39,14 @@ var _ = Describe("AssignRandomIP", func() {
 
 	It("should add a random ip", func() {
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
 			)
 
 		awsDirect.
-			EXPECT().AssignPrivateIpAddresses(&ec2.AssignPrivateIpAddressesInput{
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
 			NetworkInterfaceId:             aws.String(networkInterfaceId),
 			SecondaryPrivateIpAddressCount: aws.Int64(int64(1)),
 		}).
@@ -61,7 +62,7 @@ var _ = Describe("AssignRandomIP", func() {
 				nil,
 			)
 
-		ip, err := sut.AddRandomIP(hostName)
+		ip, err := sut.AddRandomIP(ctx, hostName)
 
 		Expect(ip).ToNot(BeNil())
 		Expect(ip.String()).To(Equal(ip.String()))
@@ -90,14 +91,14 @@ var _ = Describe("AssignRandomIP", func() {
 		)
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
 			)
 
 		awsDirect.
-			EXPECT().AssignPrivateIpAddresses(&ec2.AssignPrivateIpAddressesInput{
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
 			NetworkInterfaceId:             aws.String(networkInterfaceId),
 			SecondaryPrivateIpAddressCount: aws.Int64(int64(1)),
 		}).
@@ -109,7 +110,7 @@ var _ = Describe("AssignRandomIP", func() {
 				nil,
 			)
 
-		ip, err := sut.AddRandomIP(hostName)
+		ip, err := sut.AddRandomIP(ctx, hostName)
 
 		Expect(ip).To(BeNil())
 		Expect(err).To(MatchError(expectedErr))
@@ -120,14 +121,14 @@ var _ = Describe("AssignRandomIP", func() {
 		expectedErr := fmt.Errorf("no ips available to instance '%v'", hostId)
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
 			)
 
 		awsDirect.
-			EXPECT().AssignPrivateIpAddresses(&ec2.AssignPrivateIpAddressesInput{
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
 			NetworkInterfaceId:             aws.String(networkInterfaceId),
 			SecondaryPrivateIpAddressCount: aws.Int64(int64(1)),
 		}).
@@ -136,7 +137,7 @@ var _ = Describe("AssignRandomIP", func() {
 				expectedErr,
 			)
 
-		ip, err := sut.AddRandomIP(hostName)
+		ip, err := sut.AddRandomIP(ctx, hostName)
 
 		Expect(ip).To(BeNil())
 		Expect(err).To(MatchError(expectedErr))
@@ -146,13 +147,13 @@ var _ = Describe("AssignRandomIP", func() {
 		expectedErr := fmt.Errorf("host '%v' not found", hostName)
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				nil,
 				expectedErr,
 			)
 
-		ip, err := sut.AddRandomIP(hostName)
+		ip, err := sut.AddRandomIP(ctx, hostName)
 
 		Expect(ip).To(BeNil())
 		Expect(err).To(MatchError(expectedErr))
@@ -169,13 +170,13 @@ var _ = Describe("AssignRandomIP", func() {
 		}
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
 			)
 
-		ip, err := sut.AddRandomIP(hostName)
+		ip, err := sut.AddRandomIP(ctx, hostName)
 
 		Expect(ip).To(BeNil())
 		Expect(err).To(MatchError(expectedErr))
@@ -184,13 +185,13 @@ var _ = Describe("AssignRandomIP", func() {
 	It("should return an error when cloud instance has no interface", func() {
 		expectedErr := fmt.Errorf("instance '%v' has no network interface", hostId)
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, "", mainIP, []*net.IP{}),
 				nil,
 			)
 
-		ip, err := sut.AddRandomIP(hostName)
+		ip, err := sut.AddRandomIP(ctx, hostName)
 
 		Expect(ip).To(BeNil())
 		Expect(err).To(MatchError(expectedErr))
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"net"
@@ -37,7 +38,7 @@ var _ = Describe("AssignSpecifiedIP", func() {
 
 	It("should add a specified ip when ip is unused", func() {
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
@@ -45,7 +46,7 @@ var _ = Describe("AssignSpecifiedIP", func() {
 
 		allowReassignement := false
 		awsDirect.
-			EXPECT().AssignPrivateIpAddresses(&ec2.AssignPrivateIpAddressesInput{
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
 			AllowReassignment:  &allowReassignement,
 			NetworkInterfaceId: aws.String(networkInterfaceId),
 			PrivateIpAddresses: aws.StringSlice([]string{ip.String()}),
@@ -62,7 +63,7 @@ var _ = Describe("AssignSpecifiedIP", func() {
 				nil,
 			)
 
-		err := sut.AddSpecifiedIP(ip, hostName)
+		err := sut.AddSpecifiedIP(ctx, ip, hostName)
 
 		Expect(err).To(BeNil())
 	})
@@ -74,13 +75,13 @@ var _ = Describe("AssignSpecifiedIP", func() {
 		secondaryIPs[0] = ip
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
 			)
 
-		err := sut.AddSpecifiedIP(ip, hostName)
+		err := sut.AddSpecifiedIP(ctx, ip, hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
@@ -89,20 +90,20 @@ var _ = Describe("AssignSpecifiedIP", func() {
 		expectedErr := fmt.Errorf("host '%v' not found", hostName)
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				nil,
 				expectedErr,
 			)
 
-		err := sut.AddSpecifiedIP(ip, hostName)
+		err := sut.AddSpecifiedIP(ctx, ip, hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
 
 	It("should return failure when specified ip is already used", func() {
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
@@ -112,7 +113,7 @@ var _ = Describe("AssignSpecifiedIP", func() {
 
 		allowReassignement := false
 		awsDirect.
-			EXPECT().AssignPrivateIpAddresses(&ec2.AssignPrivateIpAddressesInput{
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
 			AllowReassignment:  &allowReassignement,
 			NetworkInterfaceId: aws.String(networkInterfaceId),
 			PrivateIpAddresses: aws.StringSlice([]string{ip.String()}),
@@ -122,7 +123,7 @@ var _ = Describe("AssignSpecifiedIP", func() {
 				expectedErr,
 			)
 
-		err := sut.AddSpecifiedIP(ip, hostName)
+		err := sut.AddSpecifiedIP(ctx, ip, hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
@@ -138,13 +139,13 @@ var _ = Describe("AssignSpecifiedIP", func() {
 		}
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
 			)
 
-		err := sut.AddSpecifiedIP(ip, hostName)
+		err := sut.AddSpecifiedIP(ctx, ip, hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
@@ -152,13 +153,13 @@ var _ = Describe("AssignSpecifiedIP", func() {
 	It("should return an error when cloud instance has no interface", func() {
 		expectedErr := fmt.Errorf("instance '%v' has no network interface", hostId)
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, "", mainIP, []*net.IP{}),
 				nil,
 			)
 
-		err := sut.AddSpecifiedIP(ip, hostName)
+		err := sut.AddSpecifiedIP(ctx, ip, hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
@@ -0,0 +1,291 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"net"
+	"strings"
+)
+
+func (a AwsCloudProvider) AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error) {
+	instance, err := a.instanceByHostname(ctx, hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.checkValidInstance(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.freeIPs(instance) < count {
+		return nil, fmt.Errorf(
+			"instance '%v' has already %v IP addresses - can not add %v IPs with a maximum of %v",
+			*instance.InstanceId,
+			len(instance.NetworkInterfaces[0].PrivateIpAddresses),
+			count,
+			a.MaxIPsPerInstance,
+		)
+	}
+
+	interfaceID := instance.NetworkInterfaces[0].NetworkInterfaceId
+
+	addressRequest := ec2.AssignPrivateIpAddressesInput{
+		NetworkInterfaceId:             interfaceID,
+		SecondaryPrivateIpAddressCount: aws.Int64(int64(count)),
+	}
+	addressResponse, err := a.Client.AssignPrivateIpAddresses(ctx, &addressRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*net.IP, len(addressResponse.AssignedPrivateIpAddresses))
+	ips := make([]string, len(addressResponse.AssignedPrivateIpAddresses))
+	for i, address := range addressResponse.AssignedPrivateIpAddresses {
+		ip := net.ParseIP(aws.StringValue(address.PrivateIpAddress))
+		result[i] = &ip
+		ips[i] = ip.String()
+	}
+
+	if len(result) != count {
+		return result, fmt.Errorf("there have been %v instead of %v IP addresses assigned to the eni '%v': [%s]",
+			len(result), count, *interfaceID, strings.Join(ips, ","))
+	}
+
+	a.Log.Info("Assigned IPs to eni",
+		"eni", interfaceID,
+		"ip-addresses", ips)
+
+	return result, nil
+}
+
+func (a AwsCloudProvider) AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	return a.assignSpecifiedIPs(ctx, ips, hostName, false)
+}
+
+// MoveIPs moves the IPs from the old to the new host with a single call. The old host is usually the failed one, so
+// its instance may be stopped or terminated. Then every IP is located in the cloud like in MoveIP and reassigned from
+// wherever it is.
+func (a AwsCloudProvider) MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error {
+	failures := make(map[string]error)
+
+	instance, instanceErr := a.instanceByHostname(ctx, oldHostName)
+	if instanceErr != nil {
+		a.Log.Info("old host can not be resolved - locating the ips in the cloud",
+			"host", oldHostName,
+			"error", instanceErr.Error(),
+		)
+	}
+
+	movable := make([]*net.IP, 0)
+	for _, ip := range ips {
+		err := instanceErr
+		if instance != nil {
+			err = a.checkIPOfInstance(ip, instance)
+		}
+		if err != nil {
+			currentHostName, findErr := a.FindIP(ctx, ip)
+			if findErr != nil || currentHostName == "" {
+				failures[ip.String()] = err
+				continue
+			}
+
+			if currentHostName == newHostName {
+				a.Log.Info("ip is already assigned to the new host",
+					"ip", ip.String(),
+					"host", newHostName,
+				)
+				continue
+			}
+
+			a.Log.Info("ip has drifted to another host - moving it from there",
+				"ip", ip.String(),
+				"expected-host", oldHostName,
+				"current-host", currentHostName,
+				"new-host", newHostName,
+			)
+		}
+
+		movable = append(movable, ip)
+	}
+
+	for ip, err := range a.assignSpecifiedIPs(ctx, movable, newHostName, true) {
+		failures[ip] = err
+	}
+
+	return failures
+}
+
+func (a AwsCloudProvider) RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	instance, err := a.instanceByHostname(ctx, hostName)
+	if err != nil {
+		return failAll(ips, err)
+	}
+
+	err = a.checkValidInstance(instance)
+	if err != nil {
+		a.Log.Info(
+			"host has no network interface or no IPs attached",
+			"instance-id", *instance.InstanceId,
+		)
+
+		return map[string]error{} // no network interface means that the ips are not on this host.
+	}
+
+	assigned := make([]*net.IP, 0)
+	for _, ip := range ips {
+		if a.checkIPOfInstance(ip, instance) != nil {
+			a.Log.Info(
+				"ip is not assigned on instance",
+				"instance-id", *instance.InstanceId,
+				"network-interface-id", *instance.NetworkInterfaces[0].NetworkInterfaceId,
+				"ip", ip.String(),
+			)
+			continue // since it has not this ip we are fine :-)
+		}
+
+		assigned = append(assigned, ip)
+	}
+
+	if len(assigned) == 0 {
+		return map[string]error{}
+	}
+
+	a.Log.Info("removing ips from instance",
+		"instance-id", *instance.InstanceId,
+		"network-interface-id", *instance.NetworkInterfaces[0].NetworkInterfaceId,
+		"ips", ipStrings(assigned),
+	)
+	unAssign := ec2.UnassignPrivateIpAddressesInput{
+		NetworkInterfaceId: instance.NetworkInterfaces[0].NetworkInterfaceId,
+		PrivateIpAddresses: aws.StringSlice(ipStrings(assigned)),
+	}
+
+	_, err = a.Client.UnassignPrivateIpAddresses(ctx, &unAssign)
+	if err != nil {
+		return failAll(assigned, err)
+	}
+
+	return map[string]error{}
+}
+
+// assignSpecifiedIPs assigns all IPs to the primary network interface of the host with a single call. IPs already
+// assigned to the instance or exceeding the maximum number of IPs are reported as failed and not requested.
+func (a AwsCloudProvider) assignSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string, allowReassignment bool) map[string]error {
+	failures := make(map[string]error)
+	if len(ips) == 0 {
+		return failures
+	}
+
+	instance, err := a.instanceByHostname(ctx, hostName)
+	if err != nil {
+		return failAll(ips, err)
+	}
+
+	err = a.checkValidInstance(instance)
+	if err != nil {
+		return failAll(ips, err)
+	}
+
+	free := a.freeIPs(instance)
+	requested := make([]*net.IP, 0)
+	for _, ip := range ips {
+		err = a.checkIP(instance, ip)
+		if err != nil {
+			failures[ip.String()] = err
+			continue
+		}
+
+		if len(requested) >= free {
+			failures[ip.String()] = fmt.Errorf(
+				"instance '%v' has already %v IP addresses - maximum of %v reached",
+				*instance.InstanceId,
+				len(instance.NetworkInterfaces[0].PrivateIpAddresses)+len(requested),
+				a.MaxIPsPerInstance,
+			)
+			continue
+		}
+
+		requested = append(requested, ip)
+	}
+
+	if len(requested) == 0 {
+		return failures
+	}
+
+	interfaceID := instance.NetworkInterfaces[0].NetworkInterfaceId
+
+	addressRequest := ec2.AssignPrivateIpAddressesInput{
+		AllowReassignment:  &allowReassignment,
+		NetworkInterfaceId: aws.String(*interfaceID),
+		PrivateIpAddresses: aws.StringSlice(ipStrings(requested)),
+	}
+	addressResponse, err := a.Client.AssignPrivateIpAddresses(ctx, &addressRequest)
+	if err != nil {
+		for ip, err := range failAll(requested, err) {
+			failures[ip] = err
+		}
+
+		return failures
+	}
+
+	assigned := make(map[string]bool)
+	for _, address := range addressResponse.AssignedPrivateIpAddresses {
+		assigned[net.ParseIP(aws.StringValue(address.PrivateIpAddress)).String()] = true
+	}
+
+	for _, ip := range requested {
+		if !assigned[ip.String()] {
+			failures[ip.String()] = fmt.Errorf("ip '%v' has not been assigned to the eni '%v'", ip.String(), *interfaceID)
+		}
+	}
+
+	a.Log.Info("Assigned IPs to eni",
+		"eni", interfaceID,
+		"ip-addresses", ipStrings(requested),
+		"failures", len(failures),
+	)
+
+	return failures
+}
+
+// freeIPs returns the number of IPs that may still be added to the instance.
+func (a AwsCloudProvider) freeIPs(instance *ec2.Instance) int {
+	return a.MaxIPsPerInstance - len(instance.NetworkInterfaces[0].PrivateIpAddresses)
+}
+
+func failAll(ips []*net.IP, err error) map[string]error {
+	result := make(map[string]error)
+	for _, ip := range ips {
+		result[ip.String()] = err
+	}
+
+	return result
+}
+
+func ipStrings(ips []*net.IP) []string {
+	result := make([]string, len(ips))
+	for i, ip := range ips {
+		result[i] = ip.String()
+	}
+
+	return result
+}
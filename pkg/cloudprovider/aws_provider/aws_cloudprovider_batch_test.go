@@ -0,0 +1,249 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net"
+)
+
+func createAssignPrivateIpAddressesOutput(networkInterfaceId string, ips ...string) *ec2.AssignPrivateIpAddressesOutput {
+	addresses := make([]*ec2.AssignedPrivateIpAddress, len(ips))
+	for i, ip := range ips {
+		addresses[i] = &ec2.AssignedPrivateIpAddress{PrivateIpAddress: aws.String(ip)}
+	}
+
+	return &ec2.AssignPrivateIpAddressesOutput{
+		AssignedPrivateIpAddresses: addresses,
+		NetworkInterfaceId:         aws.String(networkInterfaceId),
+	}
+}
+
+var _ = Describe("Batch operations", func() {
+	var (
+		ip1 net.IP
+		ip2 net.IP
+		ip3 net.IP
+	)
+
+	BeforeEach(func() {
+		initMock()
+
+		ip1 = net.ParseIP("10.0.1.51")
+		ip2 = net.ParseIP("10.0.1.52")
+		ip3 = net.ParseIP("10.0.1.53")
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should add all random IPs with a single call", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
+			NetworkInterfaceId:             aws.String(networkInterfaceId),
+			SecondaryPrivateIpAddressCount: aws.Int64(int64(2)),
+		}).
+			Return(createAssignPrivateIpAddressesOutput(networkInterfaceId, ip1.String(), ip2.String()), nil)
+
+		ips, err := sut.AddRandomIPs(ctx, 2, hostName)
+
+		Expect(err).To(BeNil())
+		Expect(ips).To(HaveLen(2))
+	})
+
+	It("should throw an error when the random IPs exceed the maximum", func() {
+		expectedErr := fmt.Errorf(
+			"instance '%v' has already %v IP addresses - can not add %v IPs with a maximum of %v",
+			hostId, 1, maxIPsPerInstance, maxIPsPerInstance,
+		)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}), nil)
+
+		_, err := sut.AddRandomIPs(ctx, maxIPsPerInstance, hostName)
+
+		Expect(err).To(MatchError(expectedErr))
+	})
+
+	It("should add all specified IPs with a single call", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
+			AllowReassignment:  aws.Bool(false),
+			NetworkInterfaceId: aws.String(networkInterfaceId),
+			PrivateIpAddresses: aws.StringSlice([]string{ip1.String(), ip2.String()}),
+		}).
+			Return(createAssignPrivateIpAddressesOutput(networkInterfaceId, ip1.String(), ip2.String()), nil)
+
+		failures := sut.AddSpecifiedIPs(ctx, []*net.IP{&ip1, &ip2}, hostName)
+
+		Expect(failures).To(BeEmpty())
+	})
+
+	It("should report the IPs exceeding the maximum and add the others", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{ip}), nil)
+		awsDirect.
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
+			AllowReassignment:  aws.Bool(false),
+			NetworkInterfaceId: aws.String(networkInterfaceId),
+			PrivateIpAddresses: aws.StringSlice([]string{ip1.String(), ip2.String()}),
+		}).
+			Return(createAssignPrivateIpAddressesOutput(networkInterfaceId, ip1.String(), ip2.String()), nil)
+
+		failures := sut.AddSpecifiedIPs(ctx, []*net.IP{&ip1, &ip2, &ip3}, hostName)
+
+		Expect(failures).To(HaveLen(1))
+		Expect(failures).To(HaveKeyWithValue(ip3.String(), MatchError(fmt.Errorf(
+			"instance '%v' has already %v IP addresses - maximum of %v reached",
+			hostId, maxIPsPerInstance, maxIPsPerInstance,
+		))))
+	})
+
+	It("should report IPs missing in the response as failed", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), gomock.Any()).
+			Return(createAssignPrivateIpAddressesOutput(networkInterfaceId, ip1.String()), nil)
+
+		failures := sut.AddSpecifiedIPs(ctx, []*net.IP{&ip1, &ip2}, hostName)
+
+		Expect(failures).To(HaveLen(1))
+		Expect(failures).To(HaveKey(ip2.String()))
+	})
+
+	It("should report all IPs as failed when the cloud call fails", func() {
+		expectedErr := errors.New("throttled")
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), gomock.Any()).
+			Return(nil, expectedErr)
+
+		failures := sut.AddSpecifiedIPs(ctx, []*net.IP{&ip1, &ip2}, hostName)
+
+		Expect(failures).To(HaveLen(2))
+		Expect(failures).To(HaveKeyWithValue(ip1.String(), MatchError(expectedErr)))
+		Expect(failures).To(HaveKeyWithValue(ip2.String(), MatchError(expectedErr)))
+	})
+
+	It("should move all IPs of a host with a single call", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{&ip1, &ip2}), nil)
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput("target")).
+			Return(createDescribeInstancesOutput("target", "vm-2", "eni-2", mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
+			AllowReassignment:  aws.Bool(true),
+			NetworkInterfaceId: aws.String("eni-2"),
+			PrivateIpAddresses: aws.StringSlice([]string{ip1.String(), ip2.String()}),
+		}).
+			Return(createAssignPrivateIpAddressesOutput("eni-2", ip1.String(), ip2.String()), nil)
+
+		failures := sut.MoveIPs(ctx, []*net.IP{&ip1, &ip2}, hostName, "target")
+
+		Expect(failures).To(BeEmpty())
+	})
+
+	It("should move the IPs of a stopped host from the instance still holding them", func() {
+		stopped := createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{&ip1, &ip2})
+		stopped.Reservations[0].Instances[0].State.Name = aws.String(ec2.InstanceStateNameStopped)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(stopped, nil)
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(&ip1)).
+			Return(createDescribeNetworkInterfacesOutput(hostId, networkInterfaceId), nil)
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(&ip2)).
+			Return(createDescribeNetworkInterfacesOutput(hostId, networkInterfaceId), nil)
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice([]string{hostId}),
+		}).
+			Return(stopped, nil).
+			Times(2)
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput("target")).
+			Return(createDescribeInstancesOutput("target", "vm-2", "eni-2", mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
+			AllowReassignment:  aws.Bool(true),
+			NetworkInterfaceId: aws.String("eni-2"),
+			PrivateIpAddresses: aws.StringSlice([]string{ip1.String(), ip2.String()}),
+		}).
+			Return(createAssignPrivateIpAddressesOutput("eni-2", ip1.String(), ip2.String()), nil)
+
+		failures := sut.MoveIPs(ctx, []*net.IP{&ip1, &ip2}, hostName, "target")
+
+		Expect(failures).To(BeEmpty())
+	})
+
+	It("should report the IPs of a terminated host not found anywhere in the cloud", func() {
+		terminated := createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{})
+		terminated.Reservations[0].Instances[0].State.Name = aws.String(ec2.InstanceStateNameTerminated)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(terminated, nil)
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(&ip1)).
+			Return(&ec2.DescribeNetworkInterfacesOutput{}, nil)
+
+		failures := sut.MoveIPs(ctx, []*net.IP{&ip1}, hostName, "target")
+
+		Expect(failures).To(HaveLen(1))
+		Expect(failures).To(HaveKeyWithValue(ip1.String(), MatchError("no instance found")))
+	})
+
+	It("should remove all IPs with a single call and ignore IPs not on the host", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{&ip1, &ip2}), nil)
+		awsDirect.
+			EXPECT().UnassignPrivateIpAddresses(gomock.Any(), &ec2.UnassignPrivateIpAddressesInput{
+			NetworkInterfaceId: aws.String(networkInterfaceId),
+			PrivateIpAddresses: aws.StringSlice([]string{ip1.String(), ip2.String()}),
+		}).
+			Return(&ec2.UnassignPrivateIpAddressesOutput{}, nil)
+
+		failures := sut.RemoveIPs(ctx, []*net.IP{&ip1, &ip2, &ip3}, hostName)
+
+		Expect(failures).To(BeEmpty())
+	})
+})
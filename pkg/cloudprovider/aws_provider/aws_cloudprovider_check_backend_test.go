@@ -0,0 +1,58 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckBackend", func() {
+	BeforeEach(func() {
+		initMock()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should be fine when EC2 answers", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), &ec2.DescribeInstancesInput{MaxResults: aws.Int64(5)}).
+			Return(&ec2.DescribeInstancesOutput{}, nil)
+
+		err := sut.CheckBackend(ctx)
+
+		Expect(err).To(BeNil())
+	})
+
+	It("should pass the error when the credentials are not valid", func() {
+		expectedErr := awserr.New("AuthFailure", "AWS was not able to validate the provided access credentials", nil)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), &ec2.DescribeInstancesInput{MaxResults: aws.Int64(5)}).
+			Return(nil, expectedErr)
+
+		err := sut.CheckBackend(ctx)
+
+		Expect(err).To(Equal(expectedErr))
+	})
+})
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net"
+)
+
+func createDescribeInstancesOutputWithSourceDestCheck(sourceDestCheck bool) *ec2.DescribeInstancesOutput {
+	output := createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{})
+	output.Reservations[0].Instances[0].SourceDestCheck = aws.Bool(sourceDestCheck)
+	output.Reservations[0].Instances[0].NetworkInterfaces[0].SourceDestCheck = aws.Bool(sourceDestCheck)
+
+	return output
+}
+
+var _ = Describe("CheckHost", func() {
+	BeforeEach(func() {
+		initMock()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should be fine when the source/destination check matches", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutputWithSourceDestCheck(false), nil)
+
+		err := sut.CheckHost(ctx, hostName)
+
+		Expect(err).To(BeNil())
+	})
+
+	It("should throw an error when the source/destination check does not match", func() {
+		expectedErr := fmt.Errorf(
+			"instance '%v' has source/destination check '%v' - expected '%v'",
+			hostId, true, false,
+		)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutputWithSourceDestCheck(true), nil)
+
+		err := sut.CheckHost(ctx, hostName)
+
+		Expect(err).To(MatchError(expectedErr))
+	})
+
+	It("should fix the source/destination check when enabled", func() {
+		sut.FixSourceDestCheck = true
+
+		gomock.InOrder(
+			awsDirect.
+				EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+				Return(createDescribeInstancesOutputWithSourceDestCheck(true), nil),
+			awsDirect.
+				EXPECT().ModifyInstanceAttribute(gomock.Any(), &ec2.ModifyInstanceAttributeInput{
+				InstanceId:      aws.String(hostId),
+				SourceDestCheck: &ec2.AttributeBooleanValue{Value: aws.Bool(false)},
+			}).
+				Return(&ec2.ModifyInstanceAttributeOutput{}, nil),
+			awsDirect.
+				EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+				Return(createDescribeInstancesOutputWithSourceDestCheck(false), nil),
+		)
+
+		err := sut.CheckHost(ctx, hostName)
+
+		Expect(err).To(BeNil())
+	})
+
+	It("should throw an error when the fixed source/destination check still does not match", func() {
+		sut.FixSourceDestCheck = true
+		expectedErr := fmt.Errorf(
+			"instance '%v' has source/destination check '%v' - expected '%v'",
+			hostId, true, false,
+		)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutputWithSourceDestCheck(true), nil).
+			Times(2)
+		awsDirect.
+			EXPECT().ModifyInstanceAttribute(gomock.Any(), gomock.Any()).
+			Return(&ec2.ModifyInstanceAttributeOutput{}, nil)
+
+		err := sut.CheckHost(ctx, hostName)
+
+		Expect(err).To(MatchError(expectedErr))
+	})
+
+	It("should throw an error when fixing the source/destination check fails", func() {
+		sut.FixSourceDestCheck = true
+		expectedErr := errors.New("unauthorized")
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutputWithSourceDestCheck(true), nil)
+		awsDirect.
+			EXPECT().ModifyInstanceAttribute(gomock.Any(), &ec2.ModifyInstanceAttributeInput{
+			InstanceId:      aws.String(hostId),
+			SourceDestCheck: &ec2.AttributeBooleanValue{Value: aws.Bool(false)},
+		}).
+			Return(nil, expectedErr)
+
+		err := sut.CheckHost(ctx, hostName)
+
+		Expect(err).To(MatchError(expectedErr))
+	})
+})
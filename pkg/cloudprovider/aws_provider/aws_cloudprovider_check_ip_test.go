@@ -18,6 +18,7 @@ package aws_provider_test
 
 import (
 	"fmt"
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"net"
@@ -42,13 +43,13 @@ var _ = Describe("CheckIP", func() {
 		}
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
 			)
 
-		err := sut.CheckIP(secondaryIPs[1], hostName)
+		err := sut.CheckIP(ctx, secondaryIPs[1], hostName)
 
 		Expect(err).To(BeNil())
 	})
@@ -61,13 +62,13 @@ var _ = Describe("CheckIP", func() {
 		)
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
 			)
 
-		err := sut.CheckIP(&unassignedIP, hostName)
+		err := sut.CheckIP(ctx, &unassignedIP, hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
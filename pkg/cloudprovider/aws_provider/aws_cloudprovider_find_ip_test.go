@@ -0,0 +1,193 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider_test
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func createDescribeNetworkInterfacesInput(ip *net.IP) *ec2.DescribeNetworkInterfacesInput {
+	return &ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("addresses.private-ip-address"),
+				Values: aws.StringSlice([]string{ip.String()}),
+			},
+		},
+	}
+}
+
+func createDescribeNetworkInterfacesOutput(instanceId, networkInterfaceId string) *ec2.DescribeNetworkInterfacesOutput {
+	return &ec2.DescribeNetworkInterfacesOutput{
+		NetworkInterfaces: []*ec2.NetworkInterface{
+			{
+				Attachment: &ec2.NetworkInterfaceAttachment{
+					InstanceId: aws.String(instanceId),
+				},
+				NetworkInterfaceId: aws.String(networkInterfaceId),
+			},
+		},
+	}
+}
+
+var _ = Describe("FindIP", func() {
+	BeforeEach(func() {
+		initMock()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should return the node name of the instance holding the IP", func() {
+		instanceID := "i-0123456789"
+		sut.Nodes = fake.NewFakeClientWithScheme(scheme.Scheme, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "custom-node-name"},
+			Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-central-1a/" + instanceID},
+		})
+
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(ip)).
+			Return(createDescribeNetworkInterfacesOutput(instanceID, networkInterfaceId), nil)
+
+		result, err := sut.FindIP(ctx, ip)
+
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal("custom-node-name"))
+	})
+
+	It("should return the private dns name when no node matches the instance", func() {
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(ip)).
+			Return(createDescribeNetworkInterfacesOutput(hostId, networkInterfaceId), nil)
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice([]string{hostId}),
+		}).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{ip}), nil)
+
+		result, err := sut.FindIP(ctx, ip)
+
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal(hostName))
+	})
+
+	It("should return an empty host name when the IP is not assigned", func() {
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(ip)).
+			Return(&ec2.DescribeNetworkInterfacesOutput{}, nil)
+
+		result, err := sut.FindIP(ctx, ip)
+
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal(""))
+	})
+
+	It("should throw an error when the network interface is not attached", func() {
+		output := createDescribeNetworkInterfacesOutput(hostId, networkInterfaceId)
+		output.NetworkInterfaces[0].Attachment = nil
+
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(ip)).
+			Return(output, nil)
+
+		_, err := sut.FindIP(ctx, ip)
+
+		Expect(err).To(MatchError(fmt.Errorf(
+			"ip '%v' is assigned to network interface '%v' which is not attached to an instance",
+			ip.String(), networkInterfaceId,
+		)))
+	})
+})
+
+var _ = Describe("ListIPs", func() {
+	BeforeEach(func() {
+		initMock()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should list only the secondary IPs of the host", func() {
+		otherIP := net.ParseIP("10.0.1.43")
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{ip, &otherIP}), nil)
+
+		result, err := sut.ListIPs(ctx, hostName)
+
+		Expect(err).To(BeNil())
+		Expect(result).To(HaveLen(2))
+		Expect(result[0].String()).To(Equal(ip.String()))
+		Expect(result[1].String()).To(Equal(otherIP.String()))
+	})
+})
+
+var _ = Describe("MoveIP with drifted IP", func() {
+	BeforeEach(func() {
+		initMock()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should succeed when the IP has already drifted to the new host", func() {
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(ip)).
+			Return(createDescribeNetworkInterfacesOutput("vm-2", "eni-2"), nil)
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice([]string{"vm-2"}),
+		}).
+			Return(createDescribeInstancesOutput("target", "vm-2", "eni-2", mainIP, []*net.IP{ip}), nil)
+
+		err := sut.MoveIP(ctx, ip, hostName, "target")
+
+		Expect(err).To(BeNil())
+	})
+
+	It("should return the original error when the IP is not assigned anywhere", func() {
+		expectedErr := fmt.Errorf("ip '%v' is not assigned to instance '%v'", ip.String(), hostId)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}), nil)
+		awsDirect.
+			EXPECT().DescribeNetworkInterfaces(gomock.Any(), createDescribeNetworkInterfacesInput(ip)).
+			Return(&ec2.DescribeNetworkInterfacesOutput{}, nil)
+
+		err := sut.MoveIP(ctx, ip, hostName, "target")
+
+		Expect(err).To(MatchError(expectedErr))
+	})
+})
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/klenkes74/egress-ip-operator/pkg/cloudprovider/aws_provider"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Instance lookup", func() {
+	BeforeEach(func() {
+		initMock()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should use the instance id of the node provider id", func() {
+		instanceID := "i-0123456789"
+		sut.Nodes = fake.NewFakeClientWithScheme(scheme.Scheme, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: hostName},
+			Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-central-1a/" + instanceID},
+		})
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice([]string{instanceID}),
+		}).
+			Return(
+				createDescribeInstancesOutput("custom-node-name", instanceID, networkInterfaceId, mainIP, []*net.IP{ip}),
+				nil,
+			)
+
+		err := sut.CheckIP(ctx, ip, hostName)
+
+		Expect(err).To(BeNil())
+	})
+
+	It("should fall back to the private dns name when the node is unknown", func() {
+		sut.Nodes = fake.NewFakeClientWithScheme(scheme.Scheme)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(
+				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{ip}),
+				nil,
+			)
+
+		err := sut.CheckIP(ctx, ip, hostName)
+
+		Expect(err).To(BeNil())
+	})
+
+	It("should ignore instances that are not running", func() {
+		output := createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{ip})
+		terminated := createDescribeInstancesOutput(hostName, "vm-old", "eni-old", mainIP, []*net.IP{})
+		terminated.Reservations[0].Instances[0].State.Name = aws.String(ec2.InstanceStateNameTerminated)
+		output.Reservations = append(terminated.Reservations, output.Reservations...)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(output, nil)
+
+		err := sut.CheckIP(ctx, ip, hostName)
+
+		Expect(err).To(BeNil())
+	})
+
+	It("should throw an error when no running instance is found", func() {
+		output := createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{ip})
+		output.Reservations[0].Instances[0].State.Name = aws.String(ec2.InstanceStateNameStopped)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(output, nil)
+
+		err := sut.CheckIP(ctx, ip, hostName)
+
+		Expect(err).To(MatchError(errors.New("no instance found")))
+	})
+
+	It("should throw an error when the host matches more than one running instance", func() {
+		output := createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{ip})
+		other := createDescribeInstancesOutput(hostName, "vm-2", "eni-2", mainIP, []*net.IP{})
+		output.Reservations = append(output.Reservations, other.Reservations...)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(output, nil)
+
+		err := sut.CheckIP(ctx, ip, hostName)
+
+		Expect(err).To(MatchError(fmt.Errorf(
+			"host '%v' matches more than one running instance: [%s]",
+			hostName, hostId+",vm-2",
+		)))
+	})
+
+	It("should extract the instance id from the provider id", func() {
+		Expect(aws_provider.InstanceIDFromProviderID("aws:///eu-central-1a/i-0123456789")).To(Equal("i-0123456789"))
+		Expect(aws_provider.InstanceIDFromProviderID("aws://eu-central-1a/i-0123456789")).To(Equal("i-0123456789"))
+		Expect(aws_provider.InstanceIDFromProviderID("gce://project/zone/instance")).To(Equal(""))
+		Expect(aws_provider.InstanceIDFromProviderID("")).To(Equal(""))
+	})
+})
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"net"
@@ -45,7 +46,7 @@ var _ = Describe("MoveIP", func() {
 		}
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
@@ -60,7 +61,7 @@ var _ = Describe("MoveIP", func() {
 			targetSecondaryIPs[i] = &ip
 		}
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(targetHostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(targetHostName)).
 			Return(
 				createDescribeInstancesOutput(targetHostName, "vm-2", "eni-2", &targetMainIP, targetSecondaryIPs),
 				nil,
@@ -68,7 +69,7 @@ var _ = Describe("MoveIP", func() {
 
 		allowReassignement := true
 		awsDirect.
-			EXPECT().AssignPrivateIpAddresses(&ec2.AssignPrivateIpAddressesInput{
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
 			AllowReassignment:  &allowReassignement,
 			NetworkInterfaceId: aws.String("eni-2"),
 			PrivateIpAddresses: aws.StringSlice([]string{secondaryIPs[1].String()}),
@@ -85,7 +86,7 @@ var _ = Describe("MoveIP", func() {
 				nil,
 			)
 
-		err := sut.MoveIP(secondaryIPs[1], hostName, targetHostName)
+		err := sut.MoveIP(ctx, secondaryIPs[1], hostName, targetHostName)
 
 		Expect(err).To(BeNil())
 	})
@@ -101,7 +102,7 @@ var _ = Describe("MoveIP", func() {
 		}
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
@@ -116,7 +117,7 @@ var _ = Describe("MoveIP", func() {
 			targetSecondaryIPs[i] = &ip
 		}
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(targetHostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(targetHostName)).
 			Return(
 				createDescribeInstancesOutput(targetHostName, "vm-2", "eni-2", &targetMainIP, targetSecondaryIPs),
 				nil,
@@ -124,7 +125,7 @@ var _ = Describe("MoveIP", func() {
 
 		allowReassignement := true
 		awsDirect.
-			EXPECT().AssignPrivateIpAddresses(&ec2.AssignPrivateIpAddressesInput{
+			EXPECT().AssignPrivateIpAddresses(gomock.Any(), &ec2.AssignPrivateIpAddressesInput{
 			AllowReassignment:  &allowReassignement,
 			NetworkInterfaceId: aws.String("eni-2"),
 			PrivateIpAddresses: aws.StringSlice([]string{secondaryIPs[1].String()}),
@@ -134,7 +135,7 @@ var _ = Describe("MoveIP", func() {
 				errors.New("can not move IP to target host"),
 			)
 
-		err := sut.MoveIP(secondaryIPs[1], hostName, targetHostName)
+		err := sut.MoveIP(ctx, secondaryIPs[1], hostName, targetHostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
@@ -147,13 +148,13 @@ var _ = Describe("MoveIP", func() {
 		)
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
 			)
 
-		err := sut.CheckIP(&unassignedIP, hostName)
+		err := sut.CheckIP(ctx, &unassignedIP, hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"net"
@@ -44,14 +45,14 @@ var _ = Describe("RemoveIP", func() {
 		}
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
 			)
 
 		awsDirect.
-			EXPECT().UnassignPrivateIpAddresses(&ec2.UnassignPrivateIpAddressesInput{
+			EXPECT().UnassignPrivateIpAddresses(gomock.Any(), &ec2.UnassignPrivateIpAddressesInput{
 			NetworkInterfaceId: aws.String(networkInterfaceId),
 			PrivateIpAddresses: aws.StringSlice([]string{secondaryIPs[1].String()}),
 		}).
@@ -60,7 +61,7 @@ var _ = Describe("RemoveIP", func() {
 				nil,
 			)
 
-		err := sut.RemoveIP(secondaryIPs[1], hostName)
+		err := sut.RemoveIP(ctx, secondaryIPs[1], hostName)
 
 		Expect(err).To(BeNil())
 	})
@@ -76,14 +77,14 @@ var _ = Describe("RemoveIP", func() {
 		}
 
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, secondaryIPs),
 				nil,
 			)
 
 		awsDirect.
-			EXPECT().UnassignPrivateIpAddresses(&ec2.UnassignPrivateIpAddressesInput{
+			EXPECT().UnassignPrivateIpAddresses(gomock.Any(), &ec2.UnassignPrivateIpAddressesInput{
 			NetworkInterfaceId: aws.String(networkInterfaceId),
 			PrivateIpAddresses: aws.StringSlice([]string{secondaryIPs[1].String()}),
 		}).
@@ -92,35 +93,35 @@ var _ = Describe("RemoveIP", func() {
 				expectedErr,
 			)
 
-		err := sut.RemoveIP(secondaryIPs[1], hostName)
+		err := sut.RemoveIP(ctx, secondaryIPs[1], hostName)
 
 		Expect(err).To(MatchError(expectedErr))
 	})
 
 	It("should ignore missing IPs when removing IP from an instance", func() {
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, networkInterfaceId, mainIP, []*net.IP{}),
 				nil,
 			)
 
 		ip := net.ParseIP("9.9.9.9")
-		err := sut.RemoveIP(&ip, hostName)
+		err := sut.RemoveIP(ctx, &ip, hostName)
 
 		Expect(err).To(BeNil())
 	})
 
 	It("should ignore missing network interface when removing IP from an instance", func() {
 		awsDirect.
-			EXPECT().DescribeInstances(createDescribeInstancesInput(hostName)).
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
 			Return(
 				createDescribeInstancesOutput(hostName, hostId, "", mainIP, []*net.IP{}),
 				nil,
 			)
 
 		ip := net.ParseIP("9.9.9.9")
-		err := sut.RemoveIP(&ip, hostName)
+		err := sut.RemoveIP(ctx, &ip, hostName)
 
 		Expect(err).To(BeNil())
 	})
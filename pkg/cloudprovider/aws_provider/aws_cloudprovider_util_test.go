@@ -19,6 +19,7 @@
 package aws_provider_test
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/mock/gomock"
@@ -31,6 +32,8 @@ import (
 var log = zap.New(zap.UseDevMode(true)).WithName("cloudprovider_test")
 
 var (
+	ctx = context.Background()
+
 	mockCtrl  *gomock.Controller
 	awsDirect *MockAwsDirectCalls
 	sut       *aws_provider.AwsCloudProvider
@@ -127,6 +130,7 @@ func createDescribeInstancesOutput(hostName, hostId, networkInterfaceId string,
 				Instances: []*ec2.Instance{
 					{
 						InstanceId:        aws.String(hostId),
+						State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
 						PrivateIpAddress:  aws.String(mainIP.String()),
 						PrivateDnsName:    aws.String(hostName),
 						NetworkInterfaces: networkInterfaces,
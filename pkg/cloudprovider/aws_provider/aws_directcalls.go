@@ -17,6 +17,7 @@
 package aws_provider
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
@@ -24,9 +25,11 @@ import (
 // AwsDirectCalls is the interface for accessing AWS services. It is the final interface to be able to mock the AWS
 // calls during testing.
 type AwsDirectCalls interface {
-	AssignPrivateIpAddresses(filter *ec2.AssignPrivateIpAddressesInput) (*ec2.AssignPrivateIpAddressesOutput, error)
-	DescribeInstances(filter *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
-	UnassignPrivateIpAddresses(filter *ec2.UnassignPrivateIpAddressesInput) (*ec2.UnassignPrivateIpAddressesOutput, error)
+	AssignPrivateIpAddresses(ctx context.Context, filter *ec2.AssignPrivateIpAddressesInput) (*ec2.AssignPrivateIpAddressesOutput, error)
+	DescribeInstances(ctx context.Context, filter *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	DescribeNetworkInterfaces(ctx context.Context, filter *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error)
+	ModifyInstanceAttribute(ctx context.Context, filter *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error)
+	UnassignPrivateIpAddresses(ctx context.Context, filter *ec2.UnassignPrivateIpAddressesInput) (*ec2.UnassignPrivateIpAddressesOutput, error)
 }
 
 var _ AwsDirectCalls = &AwsDirectCallsProd{}
@@ -38,16 +41,26 @@ type AwsDirectCallsProd struct {
 }
 
 // AssignPrivateIpAddresses calls assign-private-ip-addresses and returns either the output or an error.
-func (a *AwsDirectCallsProd) AssignPrivateIpAddresses(filter *ec2.AssignPrivateIpAddressesInput) (*ec2.AssignPrivateIpAddressesOutput, error) {
-	return a.Client.AssignPrivateIpAddresses(filter)
+func (a *AwsDirectCallsProd) AssignPrivateIpAddresses(ctx context.Context, filter *ec2.AssignPrivateIpAddressesInput) (*ec2.AssignPrivateIpAddressesOutput, error) {
+	return a.Client.AssignPrivateIpAddressesWithContext(ctx, filter)
 }
 
 // DescribeInstances calls describe-instances at AWS and returns either the output or an error.
-func (a *AwsDirectCallsProd) DescribeInstances(filter *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-	return a.Client.DescribeInstances(filter)
+func (a *AwsDirectCallsProd) DescribeInstances(ctx context.Context, filter *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return a.Client.DescribeInstancesWithContext(ctx, filter)
+}
+
+// DescribeNetworkInterfaces calls describe-network-interfaces at AWS and returns either the output or an error.
+func (a *AwsDirectCallsProd) DescribeNetworkInterfaces(ctx context.Context, filter *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	return a.Client.DescribeNetworkInterfacesWithContext(ctx, filter)
+}
+
+// ModifyInstanceAttribute calls modify-instance-attribute at AWS and returns either the output or an error.
+func (a *AwsDirectCallsProd) ModifyInstanceAttribute(ctx context.Context, filter *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	return a.Client.ModifyInstanceAttributeWithContext(ctx, filter)
 }
 
 // UnassignPrivateIpAddresses calls unassign-private-ip-addresses and returns either the output or an error.
-func (a *AwsDirectCallsProd) UnassignPrivateIpAddresses(filter *ec2.UnassignPrivateIpAddressesInput) (*ec2.UnassignPrivateIpAddressesOutput, error) {
-	return a.Client.UnassignPrivateIpAddresses(filter)
+func (a *AwsDirectCallsProd) UnassignPrivateIpAddresses(ctx context.Context, filter *ec2.UnassignPrivateIpAddressesInput) (*ec2.UnassignPrivateIpAddressesOutput, error) {
+	return a.Client.UnassignPrivateIpAddressesWithContext(ctx, filter)
 }
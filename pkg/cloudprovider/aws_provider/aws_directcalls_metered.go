@@ -0,0 +1,112 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"strings"
+	"time"
+)
+
+var _ AwsDirectCalls = &MeteredAwsDirectCalls{}
+
+// MeteredAwsDirectCalls records the latency and the result of every call to AWS in the cloud API metrics and delegates
+// the call to the wrapped AwsDirectCalls.
+type MeteredAwsDirectCalls struct {
+	Calls  AwsDirectCalls
+	Region string
+}
+
+func (m *MeteredAwsDirectCalls) AssignPrivateIpAddresses(ctx context.Context, filter *ec2.AssignPrivateIpAddressesInput) (*ec2.AssignPrivateIpAddressesOutput, error) {
+	start := time.Now()
+	result, err := m.Calls.AssignPrivateIpAddresses(ctx, filter)
+	m.observe("AssignPrivateIpAddresses", start, err)
+
+	return result, err
+}
+
+func (m *MeteredAwsDirectCalls) DescribeInstances(ctx context.Context, filter *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	start := time.Now()
+	result, err := m.Calls.DescribeInstances(ctx, filter)
+	m.observe("DescribeInstances", start, err)
+
+	return result, err
+}
+
+func (m *MeteredAwsDirectCalls) DescribeNetworkInterfaces(ctx context.Context, filter *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	start := time.Now()
+	result, err := m.Calls.DescribeNetworkInterfaces(ctx, filter)
+	m.observe("DescribeNetworkInterfaces", start, err)
+
+	return result, err
+}
+
+func (m *MeteredAwsDirectCalls) ModifyInstanceAttribute(ctx context.Context, filter *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	start := time.Now()
+	result, err := m.Calls.ModifyInstanceAttribute(ctx, filter)
+	m.observe("ModifyInstanceAttribute", start, err)
+
+	return result, err
+}
+
+func (m *MeteredAwsDirectCalls) UnassignPrivateIpAddresses(ctx context.Context, filter *ec2.UnassignPrivateIpAddressesInput) (*ec2.UnassignPrivateIpAddressesOutput, error) {
+	start := time.Now()
+	result, err := m.Calls.UnassignPrivateIpAddresses(ctx, filter)
+	m.observe("UnassignPrivateIpAddresses", start, err)
+
+	return result, err
+}
+
+func (m *MeteredAwsDirectCalls) observe(operation string, start time.Time, err error) {
+	metrics.ObserveCloudAPICall("aws", operation, m.Region, start, ErrorCode(err))
+}
+
+// ErrorCode maps the error of an AWS call to the result codes of the cloud API metrics.
+func ErrorCode(err error) string {
+	if err == nil {
+		return metrics.CloudAPISuccess
+	}
+
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return metrics.CloudAPIOther
+	}
+
+	code := awsErr.Code()
+	switch {
+	case request.IsErrorThrottle(err) || code == "RequestLimitExceeded":
+		return metrics.CloudAPIThrottled
+	case code == "UnauthorizedOperation" || code == "AuthFailure" || code == "OptInRequired" ||
+		strings.HasPrefix(code, "AccessDenied") || request.IsErrorExpiredCreds(err):
+		return metrics.CloudAPIUnauthorized
+	case strings.HasSuffix(code, "NotFound"):
+		return metrics.CloudAPINotFound
+	case strings.HasSuffix(code, "LimitExceeded") || code == "InsufficientFreeAddressesInSubnet":
+		return metrics.CloudAPILimitExceeded
+	case strings.HasPrefix(code, "Invalid") || code == "MissingParameter" || code == "UnknownParameter":
+		return metrics.CloudAPIInvalidParameter
+	case code == "InternalError" || code == "Unavailable" || code == "ServiceUnavailable" ||
+		code == request.ErrCodeRequestError:
+		return metrics.CloudAPIUnavailable
+	}
+
+	return metrics.CloudAPIOther
+}
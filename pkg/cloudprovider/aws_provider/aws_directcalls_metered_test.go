@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider_test
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/klenkes74/egress-ip-operator/pkg/cloudprovider/aws_provider"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var _ = Describe("Metered AWS calls", func() {
+	var metered *aws_provider.MeteredAwsDirectCalls
+
+	BeforeEach(func() {
+		initMock()
+
+		metered = &aws_provider.MeteredAwsDirectCalls{Calls: awsDirect, Region: "metered-region"}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should count successful calls", func() {
+		counter := metrics.CloudAPIRequests.WithLabelValues("aws", "DescribeInstances", "metered-region", metrics.CloudAPISuccess)
+		before := testutil.ToFloat64(counter)
+
+		awsDirect.
+			EXPECT().DescribeInstances(gomock.Any(), createDescribeInstancesInput(hostName)).
+			Return(&ec2.DescribeInstancesOutput{}, nil)
+
+		_, err := metered.DescribeInstances(ctx, createDescribeInstancesInput(hostName))
+
+		Expect(err).To(BeNil())
+		Expect(testutil.ToFloat64(counter)).To(Equal(before + 1))
+		Expect(durationSamples("DescribeInstances", metrics.CloudAPISuccess)).To(BeNumerically(">", 0))
+	})
+
+	It("should count failed calls by error code and pass the error", func() {
+		expectedErr := awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+		counter := metrics.CloudAPIRequests.WithLabelValues("aws", "UnassignPrivateIpAddresses", "metered-region", metrics.CloudAPIThrottled)
+		before := testutil.ToFloat64(counter)
+
+		awsDirect.
+			EXPECT().UnassignPrivateIpAddresses(gomock.Any(), &ec2.UnassignPrivateIpAddressesInput{}).
+			Return(nil, expectedErr)
+
+		_, err := metered.UnassignPrivateIpAddresses(ctx, &ec2.UnassignPrivateIpAddressesInput{})
+
+		Expect(err).To(Equal(expectedErr))
+		Expect(testutil.ToFloat64(counter)).To(Equal(before + 1))
+		Expect(durationSamples("UnassignPrivateIpAddresses", metrics.CloudAPIThrottled)).To(BeNumerically(">", 0))
+	})
+
+	DescribeTable("should map the AWS error codes",
+		func(err error, expected string) {
+			Expect(aws_provider.ErrorCode(err)).To(Equal(expected))
+		},
+		Entry("no error", nil, metrics.CloudAPISuccess),
+		Entry("Throttling", awserr.New("Throttling", "", nil), metrics.CloudAPIThrottled),
+		Entry("RequestLimitExceeded", awserr.New("RequestLimitExceeded", "", nil), metrics.CloudAPIThrottled),
+		Entry("UnauthorizedOperation", awserr.New("UnauthorizedOperation", "", nil), metrics.CloudAPIUnauthorized),
+		Entry("ExpiredToken", awserr.New("ExpiredToken", "", nil), metrics.CloudAPIUnauthorized),
+		Entry("InvalidInstanceID.NotFound", awserr.New("InvalidInstanceID.NotFound", "", nil), metrics.CloudAPINotFound),
+		Entry("PrivateIpAddressLimitExceeded", awserr.New("PrivateIpAddressLimitExceeded", "", nil), metrics.CloudAPILimitExceeded),
+		Entry("InvalidParameterValue", awserr.New("InvalidParameterValue", "", nil), metrics.CloudAPIInvalidParameter),
+		Entry("Unavailable", awserr.New("Unavailable", "", nil), metrics.CloudAPIUnavailable),
+		Entry("RequestError", awserr.New("RequestError", "", errors.New("connection refused")), metrics.CloudAPIUnavailable),
+		Entry("unknown aws code", awserr.New("DryRunOperation", "", nil), metrics.CloudAPIOther),
+		Entry("non aws error", errors.New("failed"), metrics.CloudAPIOther),
+	)
+})
+
+// durationSamples returns the number of observed latencies of the operation in the region of the test with the code.
+func durationSamples(operation string, code string) uint64 {
+	families, err := crmetrics.Registry.Gather()
+	Expect(err).To(BeNil())
+
+	for _, family := range families {
+		if family.GetName() != "egress_ip_cloud_api_duration_seconds" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["operation"] == operation && labels["region"] == "metered-region" && labels["code"] == code {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ AwsDirectCalls = &TracedAwsDirectCalls{}
+
+const (
+	OperationKey         = label.Key("aws.operation")
+	RegionKey            = label.Key("aws.region")
+	InstanceIDKey        = label.Key("aws.instance_id")
+	NetworkInterfaceKey  = label.Key("aws.network_interface_id")
+	ErrorCodeKey         = label.Key("aws.error_code")
+	allowReassignmentKey = label.Key("aws.allow_reassignment")
+)
+
+// TracedAwsDirectCalls creates a span for every call to AWS and delegates the call to the wrapped AwsDirectCalls.
+type TracedAwsDirectCalls struct {
+	Calls  AwsDirectCalls
+	Region string
+}
+
+func (t *TracedAwsDirectCalls) AssignPrivateIpAddresses(ctx context.Context, filter *ec2.AssignPrivateIpAddressesInput) (*ec2.AssignPrivateIpAddressesOutput, error) {
+	ctx, span := t.start(ctx, "AssignPrivateIpAddresses",
+		NetworkInterfaceKey.String(aws.StringValue(filter.NetworkInterfaceId)),
+		tracing.IPKey.Array(aws.StringValueSlice(filter.PrivateIpAddresses)),
+		allowReassignmentKey.Bool(aws.BoolValue(filter.AllowReassignment)),
+	)
+	result, err := t.Calls.AssignPrivateIpAddresses(ctx, filter)
+	t.end(span, err)
+
+	return result, err
+}
+
+func (t *TracedAwsDirectCalls) DescribeInstances(ctx context.Context, filter *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	ctx, span := t.start(ctx, "DescribeInstances", InstanceIDKey.Array(aws.StringValueSlice(filter.InstanceIds)))
+	result, err := t.Calls.DescribeInstances(ctx, filter)
+	t.end(span, err)
+
+	return result, err
+}
+
+func (t *TracedAwsDirectCalls) DescribeNetworkInterfaces(ctx context.Context, filter *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	ctx, span := t.start(ctx, "DescribeNetworkInterfaces")
+	result, err := t.Calls.DescribeNetworkInterfaces(ctx, filter)
+	t.end(span, err)
+
+	return result, err
+}
+
+func (t *TracedAwsDirectCalls) ModifyInstanceAttribute(ctx context.Context, filter *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	ctx, span := t.start(ctx, "ModifyInstanceAttribute", InstanceIDKey.String(aws.StringValue(filter.InstanceId)))
+	result, err := t.Calls.ModifyInstanceAttribute(ctx, filter)
+	t.end(span, err)
+
+	return result, err
+}
+
+func (t *TracedAwsDirectCalls) UnassignPrivateIpAddresses(ctx context.Context, filter *ec2.UnassignPrivateIpAddressesInput) (*ec2.UnassignPrivateIpAddressesOutput, error) {
+	ctx, span := t.start(ctx, "UnassignPrivateIpAddresses",
+		NetworkInterfaceKey.String(aws.StringValue(filter.NetworkInterfaceId)),
+		tracing.IPKey.Array(aws.StringValueSlice(filter.PrivateIpAddresses)),
+	)
+	result, err := t.Calls.UnassignPrivateIpAddresses(ctx, filter)
+	t.end(span, err)
+
+	return result, err
+}
+
+func (t *TracedAwsDirectCalls) start(ctx context.Context, operation string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes, OperationKey.String(operation), RegionKey.String(t.Region))
+
+	return tracing.Start(ctx, "AWS."+operation, attributes...)
+}
+
+// end records the error code of the call as used by the cloud API metrics and ends the span.
+func (t *TracedAwsDirectCalls) end(span trace.Span, err error) {
+	span.SetAttributes(ErrorCodeKey.String(ErrorCode(err)))
+	tracing.End(span, err)
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aws_provider_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/klenkes74/egress-ip-operator/pkg/cloudprovider/aws_provider"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var _ = Describe("Traced AWS calls", func() {
+	var traced *aws_provider.TracedAwsDirectCalls
+	var exporter *tracetest.InMemoryExporter
+
+	BeforeEach(func() {
+		initMock()
+
+		exporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+		traced = &aws_provider.TracedAwsDirectCalls{Calls: awsDirect, Region: "traced-region"}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should trace successful calls with operation, region and instance", func() {
+		input := &ec2.ModifyInstanceAttributeInput{InstanceId: aws.String(hostId)}
+		awsDirect.
+			EXPECT().ModifyInstanceAttribute(gomock.Any(), input).
+			Return(&ec2.ModifyInstanceAttributeOutput{}, nil)
+
+		_, err := traced.ModifyInstanceAttribute(ctx, input)
+
+		Expect(err).To(BeNil())
+		Expect(exporter.GetSpans()).To(HaveLen(1))
+
+		span := exporter.GetSpans()[0]
+		Expect(span.Name).To(Equal("AWS.ModifyInstanceAttribute"))
+		Expect(span.StatusCode).To(Equal(codes.Unset))
+		Expect(spanAttributes(span)).To(And(
+			HaveKeyWithValue(string(aws_provider.OperationKey), "ModifyInstanceAttribute"),
+			HaveKeyWithValue(string(aws_provider.RegionKey), "traced-region"),
+			HaveKeyWithValue(string(aws_provider.InstanceIDKey), hostId),
+			HaveKeyWithValue(string(aws_provider.ErrorCodeKey), metrics.CloudAPISuccess),
+		))
+	})
+
+	It("should mark failed calls with the error code and pass the error", func() {
+		expectedErr := awserr.New("UnauthorizedOperation", "You are not authorized.", nil)
+		awsDirect.
+			EXPECT().UnassignPrivateIpAddresses(gomock.Any(), &ec2.UnassignPrivateIpAddressesInput{}).
+			Return(nil, expectedErr)
+
+		_, err := traced.UnassignPrivateIpAddresses(ctx, &ec2.UnassignPrivateIpAddressesInput{})
+
+		Expect(err).To(Equal(expectedErr))
+		Expect(exporter.GetSpans()).To(HaveLen(1))
+
+		span := exporter.GetSpans()[0]
+		Expect(span.Name).To(Equal("AWS.UnassignPrivateIpAddresses"))
+		Expect(span.StatusCode).To(Equal(codes.Error))
+		Expect(spanAttributes(span)).To(HaveKeyWithValue(string(aws_provider.ErrorCodeKey), metrics.CloudAPIUnauthorized))
+	})
+})
+
+func spanAttributes(span *exporttrace.SpanData) map[string]string {
+	result := make(map[string]string)
+	for _, attribute := range span.Attributes {
+		result[string(attribute.Key)] = attribute.Value.Emit()
+	}
+	return result
+}
@@ -18,6 +18,7 @@
 package cloudprovider
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -26,25 +27,54 @@ import (
 	"github.com/klenkes74/egress-ip-operator/pkg/cloudprovider/aws_provider"
 	"net"
 	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"strconv"
 )
 
 type CloudProvider interface {
 	// AddRandomIP adds a random IP to the specified host.
 	// It will return the IP or the error.
-	AddRandomIP(hostName string) (*net.IP, error)
+	AddRandomIP(ctx context.Context, hostName string) (*net.IP, error)
+	// AddRandomIPs adds the given number of random IPs to the specified host with a single call.
+	// It will return the IPs or the error.
+	AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error)
 	// AddSpecifiedIP adds a predefined IP to the specified host.
 	// It will return an error or nil.
-	AddSpecifiedIP(ip *net.IP, hostName string) error
+	AddSpecifiedIP(ctx context.Context, ip *net.IP, hostName string) error
+	// AddSpecifiedIPs adds the predefined IPs to the specified host with a single call.
+	// It will return the errors of the failed IPs keyed by the IP. An empty map means all IPs have been added.
+	AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error
 	// CheckIP will check if the specified IP is assigned on the specified host.
 	// it will return an error or nil.
-	CheckIP(ip *net.IP, hostName string) error
+	CheckIP(ctx context.Context, ip *net.IP, hostName string) error
+	// CheckHost will check if the specified host is configured to serve egress IPs. Depending on the configuration of
+	// the cloudprovider a misconfiguration is fixed.
+	// It will return an error or nil.
+	CheckHost(ctx context.Context, hostName string) error
+	// CheckBackend will check if the cloud api can be reached with the configured credentials.
+	// It will return an error or nil.
+	CheckBackend(ctx context.Context) error
+	// FindIP searches the whole cloud for the host the specified IP is currently assigned to.
+	// It will return the hostname, an empty hostname if the IP is not assigned at all or the error.
+	FindIP(ctx context.Context, ip *net.IP) (string, error)
+	// ListIPs lists all secondary IPs assigned to the specified host.
+	// It will return the IPs or the error.
+	ListIPs(ctx context.Context, hostName string) ([]*net.IP, error)
+	// IPLimit returns the maximum number of secondary IPs the specified host can serve.
+	// It will return the limit or the error.
+	IPLimit(ctx context.Context, hostName string) (int, error)
 	// MoveIP will move the specified IP from oldHost to newHost.
 	// It will return an error or nil.
-	MoveIP(ip *net.IP, oldHostName string, newHostName string) error
+	MoveIP(ctx context.Context, ip *net.IP, oldHostName string, newHostName string) error
+	// MoveIPs will move the specified IPs from oldHost to newHost with a single call.
+	// It will return the errors of the failed IPs keyed by the IP. An empty map means all IPs have been moved.
+	MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error
 	// RemoveIP will remove the given IP from the specified host.
 	// It will return an error or nil.
-	RemoveIP(ip *net.IP, hostName string) error
+	RemoveIP(ctx context.Context, ip *net.IP, hostName string) error
+	// RemoveIPs will remove the given IPs from the specified host with a single call.
+	// It will return the errors of the failed IPs keyed by the IP. An empty map means all IPs have been removed.
+	RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error
 }
 
 var _ CloudProvider = &aws_provider.AwsCloudProvider{}
@@ -52,11 +82,16 @@ var _ CloudProvider = &aws_provider.AwsCloudProvider{}
 const (
 	DefaultFailureRegion     = "Kunchom"
 	DefaultMaxIPsPerInstance = 8
+	DefaultSourceDestCheck   = false
 )
 
 var (
 	FailureRegion     string
 	MaxIPsPerInstance int
+	// SourceDestCheck is the expected source/destination check of the egress hosts.
+	SourceDestCheck bool
+	// FixSourceDestCheck enables the correction of the source/destination check on misconfigured egress hosts.
+	FixSourceDestCheck bool
 )
 
 func init() {
@@ -75,10 +110,25 @@ func init() {
 	if !found || err != nil {
 		MaxIPsPerInstance = DefaultMaxIPsPerInstance
 	}
+
+	sourceDestCheck, found := os.LookupEnv("CLOUD_SOURCE_DEST_CHECK")
+	if found {
+		SourceDestCheck, err = strconv.ParseBool(sourceDestCheck)
+	}
+
+	if !found || err != nil {
+		SourceDestCheck = DefaultSourceDestCheck
+	}
+
+	fixSourceDestCheck, found := os.LookupEnv("CLOUD_FIX_SOURCE_DEST_CHECK")
+	if found {
+		FixSourceDestCheck, _ = strconv.ParseBool(fixSourceDestCheck)
+	}
 }
 
-// NewCloudProvider initializes the cloudprovider configured for this system.
-func NewCloudProvider(cloudProviderType string, logger logr.Logger) (*CloudProvider, error) {
+// NewCloudProvider initializes the cloudprovider configured for this system. The nodes are read via the given reader to
+// map them to the cloud instances.
+func NewCloudProvider(cloudProviderType string, nodes client.Reader, logger logr.Logger) (*CloudProvider, error) {
 	var result CloudProvider
 
 	switch cloudProviderType {
@@ -91,13 +141,18 @@ func NewCloudProvider(cloudProviderType string, logger logr.Logger) (*CloudProvi
 			Client:  client,
 		}
 
+		tracedCalls := &aws_provider.TracedAwsDirectCalls{Calls: &awsProvider, Region: FailureRegion}
+
 		provider := &aws_provider.AwsCloudProvider{
-			FailureRegion:     FailureRegion,
-			MaxIPsPerInstance: MaxIPsPerInstance,
-			Client:            &awsProvider,
-			Log:               logger.WithName("aws"),
+			FailureRegion:      FailureRegion,
+			MaxIPsPerInstance:  MaxIPsPerInstance,
+			SourceDestCheck:    SourceDestCheck,
+			FixSourceDestCheck: FixSourceDestCheck,
+			Client:             &aws_provider.MeteredAwsDirectCalls{Calls: tracedCalls, Region: FailureRegion},
+			Nodes:              nodes,
+			Log:                logger.WithName("aws"),
 		}
-		result = CloudProvider(provider)
+		result = CloudProvider(&TracedCloudProvider{Provider: provider, Type: cloudProviderType})
 	default:
 		return nil, fmt.Errorf("cloudprovider type '%v' is not defined - please use one of: 'aws'", cloudProviderType)
 	}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cloudprovider
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+	"go.opentelemetry.io/otel/label"
+	"net"
+)
+
+var _ CloudProvider = &TracedCloudProvider{}
+
+// ProviderKey is the span attribute of the cloudprovider type.
+const ProviderKey = label.Key("cloud.provider")
+
+// TracedCloudProvider creates a span for every call and delegates it to the wrapped CloudProvider.
+type TracedCloudProvider struct {
+	Provider CloudProvider
+	Type     string
+}
+
+func (t TracedCloudProvider) AddRandomIP(ctx context.Context, hostName string) (*net.IP, error) {
+	ctx, span := tracing.Start(ctx, "CloudProvider.AddRandomIP", tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	result, err := t.Provider.AddRandomIP(ctx, hostName)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedCloudProvider) AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error) {
+	ctx, span := tracing.Start(ctx, "CloudProvider.AddRandomIPs", tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	result, err := t.Provider.AddRandomIPs(ctx, count, hostName)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedCloudProvider) AddSpecifiedIP(ctx context.Context, ip *net.IP, hostName string) error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.AddSpecifiedIP", tracing.IP(ip), tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	err := t.Provider.AddSpecifiedIP(ctx, ip, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedCloudProvider) AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.AddSpecifiedIPs",
+		tracing.IPs(ips), tracing.HostKey.String(hostName), ProviderKey.String(t.Type),
+	)
+	result := t.Provider.AddSpecifiedIPs(ctx, ips, hostName)
+	tracing.EndWithFailures(span, result)
+
+	return result
+}
+
+func (t TracedCloudProvider) CheckIP(ctx context.Context, ip *net.IP, hostName string) error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.CheckIP", tracing.IP(ip), tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	err := t.Provider.CheckIP(ctx, ip, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedCloudProvider) CheckHost(ctx context.Context, hostName string) error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.CheckHost", tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	err := t.Provider.CheckHost(ctx, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+// CheckBackend is not traced since the health probes would flood the traces.
+func (t TracedCloudProvider) CheckBackend(ctx context.Context) error {
+	return t.Provider.CheckBackend(ctx)
+}
+
+func (t TracedCloudProvider) FindIP(ctx context.Context, ip *net.IP) (string, error) {
+	ctx, span := tracing.Start(ctx, "CloudProvider.FindIP", tracing.IP(ip), ProviderKey.String(t.Type))
+	result, err := t.Provider.FindIP(ctx, ip)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedCloudProvider) ListIPs(ctx context.Context, hostName string) ([]*net.IP, error) {
+	ctx, span := tracing.Start(ctx, "CloudProvider.ListIPs", tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	result, err := t.Provider.ListIPs(ctx, hostName)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedCloudProvider) IPLimit(ctx context.Context, hostName string) (int, error) {
+	ctx, span := tracing.Start(ctx, "CloudProvider.IPLimit", tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	result, err := t.Provider.IPLimit(ctx, hostName)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedCloudProvider) MoveIP(ctx context.Context, ip *net.IP, oldHostName string, newHostName string) error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.MoveIP",
+		tracing.IP(ip), tracing.HostKey.String(oldHostName), tracing.TargetHostKey.String(newHostName),
+		ProviderKey.String(t.Type),
+	)
+	err := t.Provider.MoveIP(ctx, ip, oldHostName, newHostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedCloudProvider) MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.MoveIPs",
+		tracing.IPs(ips), tracing.HostKey.String(oldHostName), tracing.TargetHostKey.String(newHostName),
+		ProviderKey.String(t.Type),
+	)
+	result := t.Provider.MoveIPs(ctx, ips, oldHostName, newHostName)
+	tracing.EndWithFailures(span, result)
+
+	return result
+}
+
+func (t TracedCloudProvider) RemoveIP(ctx context.Context, ip *net.IP, hostName string) error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.RemoveIP", tracing.IP(ip), tracing.HostKey.String(hostName), ProviderKey.String(t.Type))
+	err := t.Provider.RemoveIP(ctx, ip, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedCloudProvider) RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	ctx, span := tracing.Start(ctx, "CloudProvider.RemoveIPs",
+		tracing.IPs(ips), tracing.HostKey.String(hostName), ProviderKey.String(t.Type),
+	)
+	result := t.Provider.RemoveIPs(ctx, ips, hostName)
+	tracing.EndWithFailures(span, result)
+
+	return result
+}
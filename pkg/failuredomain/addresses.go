@@ -0,0 +1,53 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain
+
+import (
+	"math"
+	"math/big"
+	"net"
+)
+
+// UsableAddresses returns the number of host addresses within the CIDR. The network and broadcast address are not
+// counted for networks with more than two addresses.
+func UsableAddresses(cidr *net.IPNet) int64 {
+	ones, bits := cidr.Mask.Size()
+	if bits-ones >= 63 {
+		return math.MaxInt64
+	}
+
+	size := int64(1) << uint(bits-ones)
+	if size > 2 {
+		size -= 2
+	}
+
+	return size
+}
+
+// NextFreeIP returns the first host address of the CIDR that is not contained in used. The keys of used are the
+// string representations of the IPs.
+func NextFreeIP(cidr *net.IPNet, used map[string]bool) (*net.IP, error) {
+	return newAddressSpace(cidr).NextFreeIP(used)
+}
+
+func toIP(value *big.Int, length int) net.IP {
+	raw := value.Bytes()
+	result := make(net.IP, length)
+	copy(result[length-len(raw):], raw)
+
+	return result
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain_test
+
+import (
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"net"
+	"testing"
+)
+
+func TestUsableAddresses(t *testing.T) {
+	for cidr, expected := range map[string]int64{
+		"10.0.1.0/24": 254,
+		"10.0.0.0/22": 1022,
+		"10.0.1.0/31": 2,
+		"10.0.1.1/32": 1,
+		"fd00::/120":  254,
+		"fd00::/64":   1<<63 - 1,
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+
+		current := failuredomain.UsableAddresses(network)
+		if current != expected {
+			t.Errorf("Wrong number of addresses for '%v'! expected=%v, current=%v", cidr, expected, current)
+		}
+	}
+}
+
+func TestNextFreeIPSkipsUsedAddresses(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.1.0/29")
+
+	ip, err := failuredomain.NextFreeIP(network, map[string]bool{"10.0.1.1": true, "10.0.1.2": true})
+	if err != nil {
+		t.Fatalf("No free ip found: %v", err)
+	}
+
+	if ip.String() != "10.0.1.3" {
+		t.Errorf("Wrong ip returned! expected='10.0.1.3', current='%v'", ip.String())
+	}
+}
+
+func TestNextFreeIPFailsOnExhaustedCidr(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.1.0/30")
+
+	ip, err := failuredomain.NextFreeIP(network, map[string]bool{"10.0.1.1": true, "10.0.1.2": true})
+	if err == nil {
+		t.Errorf("Exhausted cidr should return an error! current ip='%v'", ip.String())
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+)
+
+// AddressSpace are the addresses of a failure domain random IPs are taken from: the host addresses of the CIDR within
+// the allocation range without the excluded ranges and reserved IPs.
+type AddressSpace struct {
+	cidr    *net.IPNet
+	first   *big.Int
+	last    *big.Int
+	blocked []addressRange
+}
+
+// addressRange is a range of addresses including first and last.
+type addressRange struct {
+	first  *big.Int
+	last   *big.Int
+	reason string
+}
+
+// NewAddressSpace returns the address space of the failure domain. Invalid ranges and reserved IPs are returned as
+// error.
+func NewAddressSpace(instance *v1beta1.ClusterEgressIPFailureDomain) (*AddressSpace, error) {
+	_, cidr, err := net.ParseCIDR(instance.Spec.Cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newAddressSpace(cidr)
+
+	if instance.Spec.AllocationRange != nil {
+		allocation, err := parseRange(cidr, *instance.Spec.AllocationRange)
+		if err != nil {
+			return nil, fmt.Errorf("allocationRange: %v", err)
+		}
+
+		result.first = maxOf(result.first, allocation.first)
+		result.last = minOf(result.last, allocation.last)
+	}
+
+	for _, excluded := range instance.Spec.ExcludeRanges {
+		blocked, err := parseRange(cidr, excluded)
+		if err != nil {
+			return nil, fmt.Errorf("excludeRanges: %v", err)
+		}
+
+		blocked.reason = fmt.Sprintf("within the excluded range '%v-%v' of failure domain '%v'", excluded.Start, excluded.End, instance.Name)
+		result.blocked = append(result.blocked, blocked)
+	}
+
+	for _, reserved := range instance.Spec.Reserved {
+		blocked, err := parseRange(cidr, v1beta1.IPRange{Start: reserved, End: reserved})
+		if err != nil {
+			return nil, fmt.Errorf("reserved: %v", err)
+		}
+
+		blocked.reason = fmt.Sprintf("reserved in failure domain '%v'", instance.Name)
+		result.blocked = append(result.blocked, blocked)
+	}
+
+	sort.Slice(result.blocked, func(i, j int) bool {
+		return result.blocked[i].first.Cmp(result.blocked[j].first) < 0
+	})
+
+	return result, nil
+}
+
+// newAddressSpace returns all host addresses of the CIDR without the network and broadcast address for networks with
+// more than two addresses.
+func newAddressSpace(cidr *net.IPNet) *AddressSpace {
+	ones, bits := cidr.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+
+	base := new(big.Int).SetBytes(cidr.IP.Mask(cidr.Mask))
+	first := new(big.Int).Set(base)
+	last := new(big.Int).Add(base, size)
+	last.Sub(last, big.NewInt(1))
+	if size.Cmp(big.NewInt(2)) > 0 {
+		first.Add(first, big.NewInt(1))
+		last.Sub(last, big.NewInt(1))
+	}
+
+	return &AddressSpace{
+		cidr:  &net.IPNet{IP: cidr.IP.Mask(cidr.Mask), Mask: cidr.Mask},
+		first: first,
+		last:  last,
+	}
+}
+
+// Within returns the part of the address space within the CIDR. The result is empty if the CIDR does not overlap the
+// address space.
+func (s *AddressSpace) Within(cidr *net.IPNet) *AddressSpace {
+	limit := newAddressSpace(cidr)
+	if len(cidr.IP) != len(s.cidr.IP) {
+		limit.first, limit.last = big.NewInt(1), big.NewInt(0)
+	}
+
+	return &AddressSpace{
+		cidr:    s.cidr,
+		first:   maxOf(s.first, limit.first),
+		last:    minOf(s.last, limit.last),
+		blocked: s.blocked,
+	}
+}
+
+// Size returns the number of addresses available for random IPs.
+func (s *AddressSpace) Size() int64 {
+	result := s.length(s.first, s.last)
+
+	for _, blocked := range s.merged() {
+		result.Sub(result, s.length(maxOf(s.first, blocked.first), minOf(s.last, blocked.last)))
+	}
+
+	if !result.IsInt64() {
+		return math.MaxInt64
+	}
+
+	return result.Int64()
+}
+
+// Contains checks if the IP is available for random IPs.
+func (s *AddressSpace) Contains(ip net.IP) bool {
+	if !s.cidr.Contains(ip) {
+		return false
+	}
+
+	value := s.toInt(ip)
+	return value.Cmp(s.first) >= 0 && value.Cmp(s.last) <= 0 && s.Excluded(ip) == ""
+}
+
+// Excluded returns why the IP must not be used, or an empty string if it is neither within an excluded range nor
+// reserved.
+func (s *AddressSpace) Excluded(ip net.IP) string {
+	if !s.cidr.Contains(ip) {
+		return ""
+	}
+
+	value := s.toInt(ip)
+	for _, blocked := range s.blocked {
+		if value.Cmp(blocked.first) >= 0 && value.Cmp(blocked.last) <= 0 {
+			return blocked.reason
+		}
+	}
+
+	return ""
+}
+
+// NextFreeIP returns the first address of the address space that is not contained in used. The keys of used are the
+// string representations of the IPs.
+func (s *AddressSpace) NextFreeIP(used map[string]bool) (*net.IP, error) {
+	blocked := s.merged()
+
+	next := 0
+	for value := new(big.Int).Set(s.first); value.Cmp(s.last) <= 0; value.Add(value, big.NewInt(1)) {
+		for next < len(blocked) && blocked[next].last.Cmp(value) < 0 {
+			next++
+		}
+		if next < len(blocked) && blocked[next].first.Cmp(value) <= 0 {
+			value.Set(blocked[next].last)
+			continue
+		}
+
+		ip := s.toIP(value)
+		if !used[ip.String()] {
+			return &ip, nil
+		}
+	}
+
+	return nil, errors.New("no free ip left in cidr " + s.cidr.String())
+}
+
+// merged returns the blocked ranges without overlaps.
+func (s *AddressSpace) merged() []addressRange {
+	result := make([]addressRange, 0, len(s.blocked))
+
+	for _, blocked := range s.blocked {
+		if len(result) > 0 {
+			last := &result[len(result)-1]
+			if blocked.first.Cmp(new(big.Int).Add(last.last, big.NewInt(1))) <= 0 {
+				last.last = maxOf(last.last, blocked.last)
+				continue
+			}
+		}
+
+		result = append(result, addressRange{first: blocked.first, last: blocked.last})
+	}
+
+	return result
+}
+
+func (s *AddressSpace) length(first *big.Int, last *big.Int) *big.Int {
+	if first.Cmp(last) > 0 {
+		return big.NewInt(0)
+	}
+
+	result := new(big.Int).Sub(last, first)
+	return result.Add(result, big.NewInt(1))
+}
+
+func (s *AddressSpace) toInt(ip net.IP) *big.Int {
+	if len(s.cidr.IP) == net.IPv4len {
+		ip = ip.To4()
+	}
+
+	return new(big.Int).SetBytes(ip)
+}
+
+func (s *AddressSpace) toIP(value *big.Int) net.IP {
+	return toIP(value, len(s.cidr.IP))
+}
+
+// ValidateRange checks the range is within the CIDR and does not end before its start.
+func ValidateRange(cidr *net.IPNet, ipRange v1beta1.IPRange) error {
+	_, err := parseRange(cidr, ipRange)
+	return err
+}
+
+// parseRange parses the range and checks it is within the CIDR.
+func parseRange(cidr *net.IPNet, ipRange v1beta1.IPRange) (addressRange, error) {
+	start := net.ParseIP(ipRange.Start)
+	if start == nil || !cidr.Contains(start) {
+		return addressRange{}, fmt.Errorf("'%v' is not an ip within cidr '%v'", ipRange.Start, cidr.String())
+	}
+
+	end := net.ParseIP(ipRange.End)
+	if end == nil || !cidr.Contains(end) {
+		return addressRange{}, fmt.Errorf("'%v' is not an ip within cidr '%v'", ipRange.End, cidr.String())
+	}
+
+	space := &AddressSpace{cidr: cidr}
+	result := addressRange{first: space.toInt(start), last: space.toInt(end)}
+	if result.first.Cmp(result.last) > 0 {
+		return addressRange{}, fmt.Errorf("start '%v' is after end '%v'", ipRange.Start, ipRange.End)
+	}
+
+	return result, nil
+}
+
+func maxOf(a *big.Int, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+
+	return b
+}
+
+func minOf(a *big.Int, b *big.Int) *big.Int {
+	if a.Cmp(b) <= 0 {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain_test
+
+import (
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"strings"
+	"testing"
+)
+
+func restrictedFailureDomain() *v1beta1.ClusterEgressIPFailureDomain {
+	return &v1beta1.ClusterEgressIPFailureDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: "zone-a"},
+		Spec: v1beta1.ClusterEgressIPFailureDomainSpec{
+			Cidr:            "10.0.1.0/24",
+			AllocationRange: &v1beta1.IPRange{Start: "10.0.1.10", End: "10.0.1.29"},
+			ExcludeRanges:   []v1beta1.IPRange{{Start: "10.0.1.12", End: "10.0.1.15"}, {Start: "10.0.1.14", End: "10.0.1.16"}},
+			Reserved:        []string{"10.0.1.10", "10.0.1.200"},
+		},
+	}
+}
+
+func TestSizeOfAddressSpace(t *testing.T) {
+	space, err := failuredomain.NewAddressSpace(restrictedFailureDomain())
+	if err != nil {
+		t.Fatalf("Address space could not be created: %v", err)
+	}
+
+	// 20 addresses in the allocation range without 10.0.1.10 and 10.0.1.12 to 10.0.1.16
+	if space.Size() != 14 {
+		t.Errorf("Wrong size of the address space! expected=%v, current=%v", 14, space.Size())
+	}
+}
+
+func TestNextFreeIPOfAddressSpace(t *testing.T) {
+	space, err := failuredomain.NewAddressSpace(restrictedFailureDomain())
+	if err != nil {
+		t.Fatalf("Address space could not be created: %v", err)
+	}
+
+	ip, err := space.NextFreeIP(map[string]bool{"10.0.1.11": true})
+	if err != nil {
+		t.Fatalf("No free ip found: %v", err)
+	}
+
+	if ip.String() != "10.0.1.17" {
+		t.Errorf("Wrong ip returned! expected='10.0.1.17', current='%v'", ip.String())
+	}
+}
+
+func TestExcludedIPsOfAddressSpace(t *testing.T) {
+	space, err := failuredomain.NewAddressSpace(restrictedFailureDomain())
+	if err != nil {
+		t.Fatalf("Address space could not be created: %v", err)
+	}
+
+	for ip, expected := range map[string]string{
+		"10.0.1.200": "reserved",
+		"10.0.1.13":  "excluded range '10.0.1.12-10.0.1.15'",
+		"10.0.1.100": "",
+	} {
+		current := space.Excluded(net.ParseIP(ip))
+		if (expected == "") != (current == "") || !strings.Contains(current, expected) {
+			t.Errorf("Wrong reason for ip '%v'! expected=%v, current=%v", ip, expected, current)
+		}
+	}
+
+	if space.Contains(net.ParseIP("10.0.1.100")) {
+		t.Errorf("IP outside of the allocation range should not be used for random ips!")
+	}
+}
+
+func TestInvalidRangesOfAddressSpace(t *testing.T) {
+	for name, ipRange := range map[string]v1beta1.IPRange{
+		"start after end": {Start: "10.0.1.20", End: "10.0.1.10"},
+		"outside of cidr": {Start: "10.0.1.10", End: "10.0.2.10"},
+		"not a valid ip":  {Start: "10.0.1", End: "10.0.1.10"},
+	} {
+		instance := restrictedFailureDomain()
+		instance.Spec.ExcludeRanges = []v1beta1.IPRange{ipRange}
+
+		_, err := failuredomain.NewAddressSpace(instance)
+		if err == nil {
+			t.Errorf("Range '%v' should be rejected!", name)
+		}
+	}
+}
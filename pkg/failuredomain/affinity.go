@@ -0,0 +1,53 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MatchesRequiredAffinity checks the node against the required terms of the affinity. Without required terms every node
+// matches.
+func MatchesRequiredAffinity(node *corev1.Node, affinity *corev1.NodeAffinity) bool {
+	if affinity == nil || affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+
+	return NodeMatchesSelector(node, affinity.RequiredDuringSchedulingIgnoredDuringExecution)
+}
+
+// PreferenceOf sums up the weights of the preferred terms of the affinity matching the node. Nodes matching no preferred
+// term get 0.
+func PreferenceOf(node *corev1.Node, affinity *corev1.NodeAffinity) int32 {
+	if affinity == nil {
+		return 0
+	}
+
+	var result int32
+	for _, term := range affinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if nodeMatchesTerm(node, &term.Preference) {
+			result += term.Weight
+		}
+	}
+
+	return result
+}
+
+// HasPreferences checks if the affinity prefers any nodes.
+func HasPreferences(affinity *corev1.NodeAffinity) bool {
+	return affinity != nil && len(affinity.PreferredDuringSchedulingIgnoredDuringExecution) > 0
+}
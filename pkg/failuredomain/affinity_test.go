@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain_test
+
+import (
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func infraAffinity() *corev1.NodeAffinity {
+	return &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "node-role.kubernetes.io/infra", Operator: corev1.NodeSelectorOpExists}},
+			}},
+		},
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
+			{
+				Weight: 10,
+				Preference: corev1.NodeSelectorTerm{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "bandwidth", Operator: corev1.NodeSelectorOpIn, Values: []string{"high"}}},
+				},
+			},
+			{
+				Weight: 1,
+				Preference: corev1.NodeSelectorTerm{
+					MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"infra-a"}}},
+				},
+			},
+		},
+	}
+}
+
+func TestMatchingNodeAffinity(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		affinity   *corev1.NodeAffinity
+		matches    bool
+		preference int32
+	}{
+		{"infra-a", map[string]string{"node-role.kubernetes.io/infra": "", "bandwidth": "high"}, infraAffinity(), true, 11},
+		{"infra-b", map[string]string{"node-role.kubernetes.io/infra": ""}, infraAffinity(), true, 0},
+		{"worker-a", map[string]string{"bandwidth": "high"}, infraAffinity(), false, 10},
+		{"worker-b", map[string]string{}, nil, true, 0},
+	}
+
+	for _, test := range tests {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: test.name, Labels: test.labels}}
+
+		if matches := failuredomain.MatchesRequiredAffinity(node, test.affinity); matches != test.matches {
+			t.Errorf("Wrong match of required affinity for '%v'! expected=%v, current=%v", test.name, test.matches, matches)
+		}
+		if preference := failuredomain.PreferenceOf(node, test.affinity); preference != test.preference {
+			t.Errorf("Wrong preference of '%v'! expected=%v, current=%v", test.name, test.preference, preference)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+// ExpandToAllFailureDomains adds a random IP for every failure domain missing in the spec of an EgressIP using all
+// failure domains. An EgressIP without any IPs uses all failure domains. It returns true if the spec has been changed.
+func ExpandToAllFailureDomains(ctx context.Context, c client.Reader, instance *v1alpha1.EgressIP) (bool, error) {
+	changed := false
+	if len(instance.Spec.IPs) == 0 && !instance.Spec.AllFailureDomains {
+		instance.Spec.AllFailureDomains = true
+		changed = true
+	}
+
+	if !instance.Spec.AllFailureDomains {
+		return changed, nil
+	}
+
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err := c.List(ctx, failureDomains)
+	if err != nil {
+		return false, err
+	}
+
+	listed := make(map[string]bool)
+	for _, spec := range instance.Spec.IPs {
+		listed[spec.FailureDomain] = true
+	}
+
+	missing := make([]string, 0)
+	for _, failureDomain := range failureDomains.Items {
+		if !listed[failureDomain.Name] && failureDomain.DeletionTimestamp.IsZero() {
+			listed[failureDomain.Name] = true
+			missing = append(missing, failureDomain.Name)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, name := range missing {
+		instance.Spec.IPs = append(instance.Spec.IPs, v1alpha1.FailureDomainEgressIPSpec{FailureDomain: name})
+	}
+
+	return changed || len(missing) > 0, nil
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func prepareFailureDomains(names ...string) client.Client {
+	scheme := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(scheme)
+	_ = v1beta1.AddToScheme(scheme)
+
+	objects := make([]runtime.Object, len(names))
+	for i, name := range names {
+		objects[i] = &v1beta1.ClusterEgressIPFailureDomain{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+
+	return fake.NewFakeClientWithScheme(scheme, objects...)
+}
+
+func TestExpandingEmptyEgressIPToAllFailureDomains(t *testing.T) {
+	c := prepareFailureDomains("zone-b", "zone-a")
+	instance := &v1alpha1.EgressIP{}
+
+	changed, err := failuredomain.ExpandToAllFailureDomains(context.Background(), c, instance)
+	if err != nil {
+		t.Fatalf("EgressIP could not be expanded: %v", err)
+	}
+
+	if !changed || !instance.Spec.AllFailureDomains {
+		t.Errorf("EgressIP without ips should use all failure domains! changed=%v, spec=%v", changed, instance.Spec)
+	}
+	if len(instance.Spec.IPs) != 2 || instance.Spec.IPs[0].FailureDomain != "zone-a" || instance.Spec.IPs[1].FailureDomain != "zone-b" ||
+		instance.Spec.IPs[0].IP != "" || instance.Spec.IPs[1].IP != "" {
+		t.Errorf("Wrong ips! expected random ips in 'zone-a' and 'zone-b', current=%v", instance.Spec.IPs)
+	}
+}
+
+func TestExpandingOnlyMissingFailureDomains(t *testing.T) {
+	c := prepareFailureDomains("zone-a", "zone-b")
+	instance := &v1alpha1.EgressIP{Spec: v1alpha1.EgressIPSpec{
+		IPs:               []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.10"}},
+		AllFailureDomains: true,
+	}}
+
+	changed, _ := failuredomain.ExpandToAllFailureDomains(context.Background(), c, instance)
+
+	if !changed || len(instance.Spec.IPs) != 2 || instance.Spec.IPs[0].IP != "10.0.1.10" || instance.Spec.IPs[1].FailureDomain != "zone-b" {
+		t.Errorf("Only 'zone-b' should have been added! changed=%v, ips=%v", changed, instance.Spec.IPs)
+	}
+
+	changed, _ = failuredomain.ExpandToAllFailureDomains(context.Background(), c, instance)
+	if changed {
+		t.Errorf("Expanded EgressIP should not change again! ips=%v", instance.Spec.IPs)
+	}
+}
+
+func TestKeepingEgressIPWithoutFlag(t *testing.T) {
+	c := prepareFailureDomains("zone-a", "zone-b")
+	instance := &v1alpha1.EgressIP{Spec: v1alpha1.EgressIPSpec{
+		IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a"}},
+	}}
+
+	changed, _ := failuredomain.ExpandToAllFailureDomains(context.Background(), c, instance)
+
+	if changed || len(instance.Spec.IPs) != 1 {
+		t.Errorf("EgressIP listing its failure domains should not be changed! changed=%v, ips=%v", changed, instance.Spec.IPs)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain
+
+import (
+	"context"
+	"fmt"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FindFailureDomain returns the cluster-scoped failure domain with the given name.
+func FindFailureDomain(ctx context.Context, c client.Reader, name string) (*v1beta1.ClusterEgressIPFailureDomain, error) {
+	result := &v1beta1.ClusterEgressIPFailureDomain{}
+	err := c.Get(ctx, client.ObjectKey{Name: name}, result)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failure domain '%v' is not defined", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// FailureDomainsOfNode returns all failure domains whose node selector matches the node.
+func FailureDomainsOfNode(ctx context.Context, c client.Reader, node *corev1.Node) ([]v1beta1.ClusterEgressIPFailureDomain, error) {
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err := c.List(ctx, failureDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]v1beta1.ClusterEgressIPFailureDomain, 0)
+	for _, failureDomain := range failureDomains.Items {
+		if NodeMatchesSelector(node, &failureDomain.Spec.NodeSelector) {
+			result = append(result, failureDomain)
+		}
+	}
+
+	return result, nil
+}
+
+// IsNodeReady checks the ready condition of the node.
+func IsNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// IsNodeAddress checks if the IP is one of the addresses of the node itself.
+func IsNodeAddress(node *corev1.Node, ip *net.IP) bool {
+	for _, address := range node.Status.Addresses {
+		if address.Address == ip.String() {
+			return true
+		}
+	}
+
+	return false
+}
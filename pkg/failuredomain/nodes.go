@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failuredomain
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+)
+
+// ListNodesOfFailureDomain returns all nodes matching the node selector of the failure domain.
+func ListNodesOfFailureDomain(ctx context.Context, c client.Reader, failureDomain *v1beta1.ClusterEgressIPFailureDomain) ([]corev1.Node, error) {
+	nodes := &corev1.NodeList{}
+	err := c.List(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]corev1.Node, 0)
+	for _, node := range nodes.Items {
+		if NodeMatchesSelector(&node, &failureDomain.Spec.NodeSelector) {
+			result = append(result, node)
+		}
+	}
+
+	return result, nil
+}
+
+// NodeMatchesSelector checks the node against the node selector. The terms of the selector are ORed, the requirements
+// within a term are ANDed. A selector without terms matches no node.
+func NodeMatchesSelector(node *corev1.Node, selector *corev1.NodeSelector) bool {
+	for _, term := range selector.NodeSelectorTerms {
+		if nodeMatchesTerm(node, &term) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nodeMatchesTerm(node *corev1.Node, term *corev1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	for _, expression := range term.MatchExpressions {
+		if !requirementMatches(expression, labels.Set(node.Labels)) {
+			return false
+		}
+	}
+
+	for _, field := range term.MatchFields {
+		if field.Key != "metadata.name" {
+			return false
+		}
+
+		if !requirementMatches(field, labels.Set{field.Key: node.Name}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func requirementMatches(requirement corev1.NodeSelectorRequirement, values labels.Set) bool {
+	switch requirement.Operator {
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if len(requirement.Values) != 1 || !values.Has(requirement.Key) {
+			return false
+		}
+
+		expected, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		current, err := strconv.ParseInt(values.Get(requirement.Key), 10, 64)
+		if err != nil {
+			return false
+		}
+
+		if requirement.Operator == corev1.NodeSelectorOpGt {
+			return current > expected
+		}
+		return current < expected
+	}
+
+	var op selection.Operator
+	switch requirement.Operator {
+	case corev1.NodeSelectorOpIn:
+		op = selection.In
+	case corev1.NodeSelectorOpNotIn:
+		op = selection.NotIn
+	case corev1.NodeSelectorOpExists:
+		op = selection.Exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		op = selection.DoesNotExist
+	default:
+		return false
+	}
+
+	parsed, err := labels.NewRequirement(requirement.Key, op, requirement.Values)
+	if err != nil {
+		return false
+	}
+
+	return parsed.Matches(values)
+}
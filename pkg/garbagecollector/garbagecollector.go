@@ -0,0 +1,326 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// garbagecollector finds IPs within the failure domains that are assigned to hosts but not owned by any EgressIP.
+// These orphans are left behind by crashes or failed rollbacks. They are reported and optionally removed after a grace
+// period.
+package garbagecollector
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"net"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultInterval    = 10 * time.Minute
+	DefaultGracePeriod = 1 * time.Hour
+)
+
+var (
+	// Interval is the time between two runs of the garbage collector. A zero interval disables the garbage collector.
+	Interval time.Duration
+	// GracePeriod is the time an orphaned IP has to be seen before it gets removed.
+	GracePeriod time.Duration
+	// RemoveOrphans enables the removal of orphaned IPs. Without it the orphans are only reported.
+	RemoveOrphans bool
+)
+
+func init() {
+	Interval = durationFromEnv("ORPHAN_GC_INTERVAL", DefaultInterval)
+	GracePeriod = durationFromEnv("ORPHAN_GC_GRACE_PERIOD", DefaultGracePeriod)
+
+	remove, found := os.LookupEnv("ORPHAN_GC_REMOVE")
+	if found {
+		RemoveOrphans, _ = strconv.ParseBool(remove)
+	}
+}
+
+func durationFromEnv(name string, defaultValue time.Duration) time.Duration {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue
+	}
+
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return result
+}
+
+var _ manager.Runnable = &OrphanedIPCollector{}
+var _ manager.LeaderElectionRunnable = &OrphanedIPCollector{}
+
+// OrphanedIPCollector periodically compares the IPs assigned to the eligible hosts of all failure domains with the IPs
+// owned by the EgressIPs.
+type OrphanedIPCollector struct {
+	Client      client.Client
+	Provisioner provisioner.EgressIPProvisioner
+
+	Interval      time.Duration
+	GracePeriod   time.Duration
+	RemoveOrphans bool
+
+	Log logr.Logger
+
+	// firstSeen keeps the time an orphan has been detected the first time.
+	firstSeen map[string]time.Time
+}
+
+// OrphanedIP is an IP assigned to a host within a failure domain but not owned by any EgressIP.
+type OrphanedIP struct {
+	FailureDomain string
+	HostName      string
+	IP            *net.IP
+	FirstSeen     time.Time
+}
+
+// NewOrphanedIPCollector creates the garbage collector configured via the environment.
+func NewOrphanedIPCollector(c client.Client, p provisioner.EgressIPProvisioner, logger logr.Logger) *OrphanedIPCollector {
+	return &OrphanedIPCollector{
+		Client:        c,
+		Provisioner:   p,
+		Interval:      Interval,
+		GracePeriod:   GracePeriod,
+		RemoveOrphans: RemoveOrphans,
+		Log:           logger,
+	}
+}
+
+// NeedLeaderElection makes sure only the leading manager removes IPs.
+func (g *OrphanedIPCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs the garbage collector until the stop channel is closed.
+func (g *OrphanedIPCollector) Start(stop <-chan struct{}) error {
+	if g.Interval <= 0 {
+		g.Log.Info("garbage collector for orphaned ips is disabled")
+		return nil
+	}
+
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			_, err := g.Collect(context.Background())
+			if err != nil {
+				g.Log.Error(err, "garbage collection of orphaned ips failed")
+			}
+		}
+	}
+}
+
+// Collect runs a single garbage collection. It returns the orphans still assigned to the hosts.
+func (g *OrphanedIPCollector) Collect(ctx context.Context) ([]OrphanedIP, error) {
+	if g.firstSeen == nil {
+		g.firstSeen = make(map[string]time.Time)
+	}
+
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err := g.Client.List(ctx, failureDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	owned, unrecorded, err := g.ownedIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	result := make([]OrphanedIP, 0)
+
+	for _, failureDomain := range failureDomains.Items {
+		if unrecorded[failureDomain.Name] {
+			g.Log.Info("random ips of egressips are not recorded yet - skipping failure domain", "failure-domain", failureDomain.Name)
+			continue
+		}
+
+		orphans, err := g.orphansOfFailureDomain(ctx, &failureDomain, owned)
+		if err != nil {
+			g.Log.Error(err, "can not check failure domain for orphaned ips", "failure-domain", failureDomain.Name)
+			continue
+		}
+
+		remaining := 0
+		for _, orphan := range orphans {
+			key := orphan.IP.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if _, found := g.firstSeen[key]; !found {
+				g.firstSeen[key] = now
+			}
+			orphan.FirstSeen = g.firstSeen[key]
+
+			if g.RemoveOrphans && now.Sub(orphan.FirstSeen) >= g.GracePeriod {
+				err = g.Provisioner.RemoveIP(ctx, orphan.IP, orphan.HostName)
+				if err == nil {
+					g.Log.Info("removed orphaned ip",
+						"failure-domain", orphan.FailureDomain,
+						"host", orphan.HostName,
+						"ip", key,
+						"first-seen", orphan.FirstSeen,
+					)
+					metrics.RemovedOrphanedIPs.WithLabelValues(orphan.FailureDomain).Inc()
+					delete(g.firstSeen, key)
+					continue
+				}
+
+				g.Log.Error(err, "can not remove orphaned ip",
+					"failure-domain", orphan.FailureDomain,
+					"host", orphan.HostName,
+					"ip", key,
+				)
+			} else {
+				g.Log.Info("found orphaned ip",
+					"failure-domain", orphan.FailureDomain,
+					"host", orphan.HostName,
+					"ip", key,
+					"first-seen", orphan.FirstSeen,
+				)
+			}
+
+			remaining++
+			result = append(result, orphan)
+		}
+
+		metrics.OrphanedIPs.WithLabelValues(failureDomain.Name).Set(float64(remaining))
+	}
+
+	for key := range g.firstSeen {
+		if !seen[key] {
+			delete(g.firstSeen, key)
+		}
+	}
+
+	return result, nil
+}
+
+// orphansOfFailureDomain lists all IPs within the CIDR of the failure domain on the eligible hosts that are neither
+// owned by an EgressIP nor an address of the node itself.
+func (g *OrphanedIPCollector) orphansOfFailureDomain(ctx context.Context, failureDomain *v1beta1.ClusterEgressIPFailureDomain, owned map[string]bool) ([]OrphanedIP, error) {
+	if failureDomain.Spec.Cidr == "" {
+		return []OrphanedIP{}, nil
+	}
+
+	_, cidr, err := net.ParseCIDR(failureDomain.Spec.Cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := failuredomain.ListNodesOfFailureDomain(ctx, g.Client, failureDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]OrphanedIP, 0)
+	for _, node := range nodes {
+		ips, err := g.Provisioner.ListIPs(ctx, node.Name)
+		if err != nil {
+			g.Log.Error(err, "can not list ips of host", "failure-domain", failureDomain.Name, "host", node.Name)
+			continue
+		}
+
+		for _, ip := range ips {
+			if !cidr.Contains(*ip) || owned[ip.String()] || failuredomain.IsNodeAddress(&node, ip) {
+				continue
+			}
+
+			result = append(result, OrphanedIP{
+				FailureDomain: failureDomain.Name,
+				HostName:      node.Name,
+				IP:            ip,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// ownedIPs collects all IPs specified or assigned by any EgressIP. The failure domains with random IPs of EgressIPs not
+// recorded in their status are returned, too. Their random IPs can not be told apart from orphans.
+func (g *OrphanedIPCollector) ownedIPs(ctx context.Context) (map[string]bool, map[string]bool, error) {
+	egressIPs := &v1alpha1.EgressIPList{}
+	err := g.Client.List(ctx, egressIPs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned := make(map[string]bool)
+	unrecorded := make(map[string]bool)
+	for _, egressIP := range egressIPs.Items {
+		recorded := recordedFailureDomains(&egressIP)
+
+		for _, ip := range egressIP.Spec.IPs {
+			addOwnedIP(owned, ip.IP)
+
+			if ip.IP == "" && !recorded[ip.FailureDomain] {
+				unrecorded[ip.FailureDomain] = true
+			}
+		}
+
+		addOwnedIP(owned, egressIP.Status.IP.IP)
+		for _, ip := range egressIP.Status.IPs {
+			addOwnedIP(owned, ip.IP)
+		}
+	}
+
+	return owned, unrecorded, nil
+}
+
+// recordedFailureDomains returns the failure domains the status of the EgressIP records an assigned IP for.
+func recordedFailureDomains(egressIP *v1alpha1.EgressIP) map[string]bool {
+	result := make(map[string]bool)
+
+	if net.ParseIP(egressIP.Status.IP.IP) != nil {
+		result[egressIP.Status.IP.FailureDomain] = true
+	}
+	for _, ip := range egressIP.Status.IPs {
+		if net.ParseIP(ip.IP) != nil {
+			result[ip.FailureDomain] = true
+		}
+	}
+
+	return result
+}
+
+func addOwnedIP(owned map[string]bool, ip string) {
+	parsed := net.ParseIP(ip)
+	if parsed != nil {
+		owned[parsed.String()] = true
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package garbagecollector_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/garbagecollector"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"testing"
+	"time"
+)
+
+var log = zap.New(zap.UseDevMode(true)).WithName("garbagecollector_test")
+
+// hostIPs is a minimal provisioner keeping the secondary IPs per host.
+type hostIPs map[string][]string
+
+func (h hostIPs) FindHostForNewIP(_ context.Context, _ string, _ *corev1.NodeAffinity, _ map[string]int) (string, error) {
+	return "", nil
+}
+func (h hostIPs) AddSpecifiedIP(_ context.Context, _ *net.IP, _ string) error { return nil }
+func (h hostIPs) AddRandomIP(_ context.Context, _ string) (*net.IP, error)    { return nil, nil }
+func (h hostIPs) MoveIP(_ context.Context, _ *net.IP, _ string, _ string) error {
+	return nil
+}
+func (h hostIPs) CheckIP(_ context.Context, _ *net.IP, _ string) error { return nil }
+func (h hostIPs) CheckHost(_ context.Context, _ string) error          { return nil }
+func (h hostIPs) CheckBackend(_ context.Context) error                 { return nil }
+func (h hostIPs) FindIP(_ context.Context, _ *net.IP) (string, error)  { return "", nil }
+func (h hostIPs) AssignCIDR(_ context.Context, _ string) error         { return nil }
+func (h hostIPs) IPLimit(_ context.Context, _ string) (int, error)     { return 0, nil }
+func (h hostIPs) AddSpecifiedIPs(_ context.Context, _ []*net.IP, _ string) map[string]error {
+	return map[string]error{}
+}
+func (h hostIPs) AddRandomIPs(_ context.Context, _ int, _ string) ([]*net.IP, error) { return nil, nil }
+func (h hostIPs) MoveIPs(_ context.Context, _ []*net.IP, _ string, _ string) map[string]error {
+	return map[string]error{}
+}
+func (h hostIPs) RemoveIPs(_ context.Context, _ []*net.IP, _ string) map[string]error {
+	return map[string]error{}
+}
+func (h hostIPs) RemoveIP(_ context.Context, ip *net.IP, host string) error {
+	remaining := make([]string, 0)
+	for _, assigned := range h[host] {
+		if assigned != ip.String() {
+			remaining = append(remaining, assigned)
+		}
+	}
+	h[host] = remaining
+
+	return nil
+}
+func (h hostIPs) ListIPs(_ context.Context, host string) ([]*net.IP, error) {
+	result := make([]*net.IP, 0)
+	for _, assigned := range h[host] {
+		ip := net.ParseIP(assigned)
+		result = append(result, &ip)
+	}
+
+	return result, nil
+}
+
+func prepareCollector(ips hostIPs) *garbagecollector.OrphanedIPCollector {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+	_ = v1beta1.AddToScheme(scheme)
+
+	c := fake.NewFakeClientWithScheme(scheme,
+		&v1beta1.ClusterEgressIPFailureDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "zone-a"},
+			Spec: v1beta1.ClusterEgressIPFailureDomainSpec{
+				Cidr: "10.0.1.0/24",
+				NodeSelector: corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{
+									Key:      "topology.kubernetes.io/zone",
+									Operator: corev1.NodeSelectorOpIn,
+									Values:   []string{"zone-a"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "node-a",
+				Labels: map[string]string{"topology.kubernetes.io/zone": "zone-a"},
+			},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.1.5"}},
+			},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "node-b",
+				Labels: map[string]string{"topology.kubernetes.io/zone": "zone-b"},
+			},
+		},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant"},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.10"}},
+			},
+		},
+	)
+
+	return &garbagecollector.OrphanedIPCollector{
+		Client:      c,
+		Provisioner: ips,
+		GracePeriod: time.Hour,
+		Log:         log,
+	}
+}
+
+func TestReportingOrphanedIPs(t *testing.T) {
+	ips := hostIPs{
+		"node-a": {"10.0.1.5", "10.0.1.10", "10.0.1.11", "10.0.2.12"},
+		"node-b": {"10.0.1.13"},
+	}
+	sut := prepareCollector(ips)
+
+	orphans, err := sut.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Garbage collection failed: %v", err)
+	}
+
+	if len(orphans) != 1 {
+		t.Fatalf("Number of orphans does not match! expected=1, current=%v", len(orphans))
+	}
+
+	if orphans[0].IP.String() != "10.0.1.11" || orphans[0].HostName != "node-a" || orphans[0].FailureDomain != "zone-a" {
+		t.Errorf("Wrong orphan detected! expected='10.0.1.11' on 'node-a', current='%v' on '%v'",
+			orphans[0].IP.String(),
+			orphans[0].HostName,
+		)
+	}
+
+	if len(ips["node-a"]) != 4 {
+		t.Errorf("IPs have been removed while only reporting! expected=4, current=%v", len(ips["node-a"]))
+	}
+}
+
+func TestRemovingOrphanedIPsAfterGracePeriod(t *testing.T) {
+	ips := hostIPs{
+		"node-a": {"10.0.1.5", "10.0.1.10", "10.0.1.11"},
+	}
+	sut := prepareCollector(ips)
+	sut.RemoveOrphans = true
+
+	_, _ = sut.Collect(context.Background())
+	if len(ips["node-a"]) != 3 {
+		t.Errorf("Orphan has been removed within grace period! expected=3, current=%v", len(ips["node-a"]))
+	}
+
+	sut.GracePeriod = 0
+	orphans, err := sut.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Garbage collection failed: %v", err)
+	}
+
+	if len(orphans) != 0 {
+		t.Errorf("There should be no orphans left! expected=0, current=%v", len(orphans))
+	}
+
+	if len(ips["node-a"]) != 2 || ips["node-a"][0] != "10.0.1.5" || ips["node-a"][1] != "10.0.1.10" {
+		t.Errorf("Only the orphan should have been removed! expected=[10.0.1.5 10.0.1.10], current=%v", ips["node-a"])
+	}
+}
+
+func TestSkippingFailureDomainWithUnrecordedRandomIPs(t *testing.T) {
+	ips := hostIPs{
+		"node-a": {"10.0.1.5", "10.0.1.10", "10.0.1.11"},
+	}
+	sut := prepareCollector(ips)
+	sut.RemoveOrphans = true
+	sut.GracePeriod = 0
+	_ = sut.Client.Create(context.Background(), &v1alpha1.EgressIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "random", Namespace: "tenant"},
+		Spec: v1alpha1.EgressIPSpec{
+			IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-b", IP: "10.0.2.10"}, {FailureDomain: "zone-a"}},
+		},
+		Status: v1alpha1.EgressIPStatus{
+			IP: v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "zone-b", IP: "10.0.2.10"},
+		},
+	})
+
+	orphans, err := sut.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Garbage collection failed: %v", err)
+	}
+
+	if len(orphans) != 0 || len(ips["node-a"]) != 3 {
+		t.Errorf("Unrecorded random ip should not be collected! orphans=%v, ips=%v", orphans, ips["node-a"])
+	}
+}
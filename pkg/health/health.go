@@ -0,0 +1,134 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// health checks the backend of the provisioner for the liveness and readiness probes of the manager. The result of a
+// check is reused for a while to keep the calls to the cloud api low.
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCheckTimeout  = 5 * time.Second
+	DefaultCheckInterval = 30 * time.Second
+)
+
+var (
+	// CheckTimeout is the time the backend of the provisioner has to answer.
+	CheckTimeout time.Duration
+	// CheckInterval is the time the result of a check is reused.
+	CheckInterval time.Duration
+)
+
+func init() {
+	CheckTimeout = durationFromEnv("HEALTH_CHECK_TIMEOUT", DefaultCheckTimeout)
+	CheckInterval = durationFromEnv("HEALTH_CHECK_INTERVAL", DefaultCheckInterval)
+}
+
+func durationFromEnv(name string, defaultValue time.Duration) time.Duration {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue
+	}
+
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return result
+}
+
+// ProvisionerChecker checks the backend of the provisioner. A provisioner that could not be constructed fails the
+// readiness but not the liveness, since restarting the manager won't fix its configuration.
+type ProvisionerChecker struct {
+	// Provisioner is the checked provisioner. It is nil if it could not be constructed.
+	Provisioner provisioner.EgressIPProvisioner
+	// Err is the error of constructing the provisioner.
+	Err error
+
+	Timeout  time.Duration
+	Interval time.Duration
+
+	Log logr.Logger
+
+	lock    sync.Mutex
+	checked time.Time
+	result  error
+}
+
+// NewProvisionerChecker creates the checker for the provisioner or the error of its construction.
+func NewProvisionerChecker(p *provisioner.EgressIPProvisioner, err error, logger logr.Logger) *ProvisionerChecker {
+	result := &ProvisionerChecker{
+		Err:      err,
+		Timeout:  CheckTimeout,
+		Interval: CheckInterval,
+		Log:      logger,
+	}
+
+	if p != nil {
+		result.Provisioner = *p
+	}
+
+	return result
+}
+
+// Ready fails if the provisioner could not be constructed or its backend can't be reached.
+func (c *ProvisionerChecker) Ready(req *http.Request) error {
+	if c.Err != nil {
+		return fmt.Errorf("provisioner could not be created: %v", c.Err)
+	}
+
+	return c.check(req.Context())
+}
+
+// Alive fails if the backend of the provisioner can't be reached.
+func (c *ProvisionerChecker) Alive(req *http.Request) error {
+	if c.Provisioner == nil {
+		return nil
+	}
+
+	return c.check(req.Context())
+}
+
+// check calls the backend unless the last result is younger than the interval.
+func (c *ProvisionerChecker) check(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.checked.IsZero() && time.Since(c.checked) < c.Interval {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	c.result = c.Provisioner.CheckBackend(ctx)
+	c.checked = time.Now()
+	if c.result != nil {
+		c.Log.Info("backend of the provisioner can't be reached", "error", c.result.Error())
+	}
+
+	return c.result
+}
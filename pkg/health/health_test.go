@@ -0,0 +1,97 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health_test
+
+import (
+	"context"
+	"errors"
+	"github.com/klenkes74/egress-ip-operator/pkg/health"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"testing"
+	"time"
+)
+
+var log = zap.New(zap.UseDevMode(true)).WithName("health_test")
+
+// backend is a provisioner only answering the check of its backend.
+type backend struct {
+	provisioner.EgressIPProvisioner
+
+	err   error
+	calls int
+}
+
+func (b *backend) CheckBackend(_ context.Context) error {
+	b.calls++
+	return b.err
+}
+
+func prepareChecker(b *backend, err error) *health.ProvisionerChecker {
+	var p *provisioner.EgressIPProvisioner
+	if b != nil {
+		result := provisioner.EgressIPProvisioner(b)
+		p = &result
+	}
+
+	return health.NewProvisionerChecker(p, err, log)
+}
+
+func TestFailingUnreachableBackend(t *testing.T) {
+	sut := prepareChecker(&backend{err: errors.New("credentials expired")}, nil)
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	if err := sut.Ready(req); err == nil {
+		t.Errorf("Readiness should fail for an unreachable backend!")
+	}
+	if err := sut.Alive(req); err == nil {
+		t.Errorf("Liveness should fail for an unreachable backend!")
+	}
+}
+
+func TestFailingReadinessOfMissingProvisioner(t *testing.T) {
+	sut := prepareChecker(nil, errors.New("no provisioner defined"))
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	if err := sut.Ready(req); err == nil {
+		t.Errorf("Readiness should fail without provisioner!")
+	}
+	if err := sut.Alive(req); err != nil {
+		t.Errorf("Liveness should not fail without provisioner! error=%v", err)
+	}
+}
+
+func TestReusingResultWithinInterval(t *testing.T) {
+	b := &backend{}
+	sut := prepareChecker(b, nil)
+	sut.Interval = time.Hour
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	_ = sut.Ready(req)
+	_ = sut.Alive(req)
+
+	if b.calls != 1 {
+		t.Errorf("Backend should only be called once within the interval! current=%v", b.calls)
+	}
+
+	sut.Interval = 0
+	_ = sut.Ready(req)
+	if b.calls != 2 {
+		t.Errorf("Backend should be called again after the interval! current=%v", b.calls)
+	}
+}
@@ -20,43 +20,134 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
 	"net"
-	"reflect"
+	"os"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"strconv"
+	"sync"
 	"time"
 )
 
+const (
+	DefaultAlarmHistorySize = 100
+	DefaultAlarmFlapCycles  = 3
+	DefaultAlarmFlapWindow  = 1 * time.Hour
+
+	// AlarmActive is the state of an alarm nobody has taken care of yet.
+	AlarmActive = "active"
+	// AlarmAcknowledged is the state of an alarm an operator has acknowledged.
+	AlarmAcknowledged = "acknowledged"
+	// AlarmSilenced is the state of an alarm of a silenced EgressIP.
+	AlarmSilenced = "silenced"
+)
+
+var (
+	// AlarmHistorySize is the number of resolved alarms kept in the history.
+	AlarmHistorySize int
+	// AlarmFlapCycles is the number of raise/clear cycles within the AlarmFlapWindow that count as flapping.
+	AlarmFlapCycles int
+	// AlarmFlapWindow is the window the raise/clear cycles are counted in.
+	AlarmFlapWindow time.Duration
+)
+
+func init() {
+	AlarmHistorySize = intFromEnv("ALARM_HISTORY_SIZE", DefaultAlarmHistorySize)
+	AlarmFlapCycles = intFromEnv("ALARM_FLAP_CYCLES", DefaultAlarmFlapCycles)
+
+	AlarmFlapWindow = DefaultAlarmFlapWindow
+	value, found := os.LookupEnv("ALARM_FLAP_WINDOW")
+	if found {
+		window, err := time.ParseDuration(value)
+		if err == nil {
+			AlarmFlapWindow = window
+		}
+	}
+}
+
+func intFromEnv(name string, defaultValue int) int {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return result
+}
+
 // AlarmStore -- the store for keeping alarms of the aws-egress-ip-operator
 type AlarmStore interface {
 	// Adds a failed namespace to the alarm store
 	AddAlarm(namespace string, ips []*net.IP)
 
-	// Removes a recovered namespace from the alarm store
+	// Adds the failed IPs of an EgressIP to the alarm store
+	AddEgressIPAlarm(namespace string, egressIP string, ips []FailedIP)
+
+	// Removes all alarms of a recovered namespace from the alarm store
 	RemoveAlarm(namespace string)
 
+	// Removes the alarm of a recovered EgressIP from the alarm store
+	RemoveEgressIPAlarm(namespace string, egressIP string)
+
 	RemoveAlarmForIP(namespace string, ip *net.IP)
 
-	// Retrieves all failed namespaces from the alarm store
+	// Retrieves all alarms from the alarm store keyed by AlarmKey
 	GetFailed() map[string]*FailedEgressIP
+
+	// Retrieves the resolved alarms, the oldest first
+	GetHistory() []ResolvedAlarm
+
+	// Checks if the alarm of the EgressIP has been raised and cleared too often within the flap window
+	IsFlapping(namespace string, egressIP string) bool
+
+	// Sets the acknowledgement and silence of the alarm of an EgressIP. Alarms first occurring after acknowledged
+	// are active again.
+	SetAlarmState(namespace string, egressIP string, acknowledged time.Time, silenced bool)
 }
 
 // ensures that the PrometheusLinkedAlarmStore is a valid AlarmStore
 var _ AlarmStore = &PrometheusLinkedAlarmStore{}
 
-// PrometheusLinkedAlarmStore -- a simple in memory implementation of the AlarmStore
+// PrometheusLinkedAlarmStore -- a simple in memory implementation of the AlarmStore. It is safe for concurrent use.
 type PrometheusLinkedAlarmStore struct {
+	lock sync.RWMutex
+	// failures keeps the current alarms keyed by AlarmKey.
 	failures map[string]*FailedEgressIP
-	counter  prometheus.GaugeVec
+	counter  *prometheus.GaugeVec
+	flapping *prometheus.GaugeVec
+
+	// history keeps the last resolved alarms, the oldest first.
+	history     []ResolvedAlarm
+	historySize int
+
+	// cleared keeps the times the alarms have been cleared within the flap window keyed by AlarmKey.
+	cleared    map[string][]time.Time
+	flapCycles int
+	flapWindow time.Duration
+
+	// states keeps the acknowledgement and silence of the alarms keyed by AlarmKey.
+	states map[string]alarmState
 
 	Log logr.Logger
 }
 
-var singletonAlarmStore *PrometheusLinkedAlarmStore
+type alarmState struct {
+	acknowledged time.Time
+	silenced     bool
+}
+
+var (
+	singletonAlarmStore *PrometheusLinkedAlarmStore
+	singletonOnce       sync.Once
+)
 
 // NewAlarmStore -- creates the default implementation of the alarm store
 func NewAlarmStore(logger logr.Logger) *AlarmStore {
-	if singletonAlarmStore == nil {
+	singletonOnce.Do(func() {
 		createAlarmStore(logger)
-	}
+	})
 
 	result := AlarmStore(singletonAlarmStore)
 
@@ -64,89 +155,326 @@ func NewAlarmStore(logger logr.Logger) *AlarmStore {
 }
 
 func createAlarmStore(logger logr.Logger) {
-	counter := *prometheus.NewGaugeVec(
+	counter := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: "egress_ip",
 			Name:      "handling_failures",
 			Help:      "Failures while handling egress-ips",
 		},
-		[]string{"namespace"},
+		[]string{"namespace", "egressip", "failure_domain", "ip", "state"},
 	)
 	err := metrics.Registry.Register(counter)
 	if err != nil {
 		logger.Error(err, "Can't register the new gauge")
 	}
 
+	flapping := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "alarm_flapping",
+			Help:      "1 if the alarm of the egressip has been raised and cleared too often within the flap window, 0 otherwise",
+		},
+		[]string{"namespace", "egressip"},
+	)
+	err = metrics.Registry.Register(flapping)
+	if err != nil {
+		logger.Error(err, "Can't register the new gauge")
+	}
+
 	singletonAlarmStore = &PrometheusLinkedAlarmStore{
-		failures: make(map[string]*FailedEgressIP),
-		counter:  counter,
-		Log:      logger,
+		failures:    make(map[string]*FailedEgressIP),
+		counter:     counter,
+		flapping:    flapping,
+		history:     make([]ResolvedAlarm, 0),
+		historySize: AlarmHistorySize,
+		cleared:     make(map[string][]time.Time),
+		flapCycles:  AlarmFlapCycles,
+		flapWindow:  AlarmFlapWindow,
+		states:      make(map[string]alarmState),
+		Log:         logger,
 	}
 }
 
+// AlarmKey returns the key of the alarm of the EgressIP: '<namespace>/<egressip>' or just the namespace for alarms
+// not belonging to a single EgressIP.
+func AlarmKey(namespace string, egressIP string) string {
+	if egressIP == "" {
+		return namespace
+	}
+
+	return namespace + "/" + egressIP
+}
+
 // AddAlarm -- Adds a failed namespace to the alarm store
-func (s PrometheusLinkedAlarmStore) AddAlarm(namespace string, ips []*net.IP) {
-	if s.failures[namespace] == nil {
+func (s *PrometheusLinkedAlarmStore) AddAlarm(namespace string, ips []*net.IP) {
+	failedIPs := make([]FailedIP, len(ips))
+	for i, ip := range ips {
+		failedIPs[i] = FailedIP{IP: ip}
+	}
+
+	s.AddEgressIPAlarm(namespace, "", failedIPs)
+}
+
+// AddEgressIPAlarm -- Adds the failed IPs of an EgressIP to the alarm store
+func (s *PrometheusLinkedAlarmStore) AddEgressIPAlarm(namespace string, egressIP string, ips []FailedIP) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := AlarmKey(namespace, egressIP)
+	alarm := s.failures[key]
+	if alarm == nil {
 		timeStamp := time.Now()
 
-		alarm := FailedEgressIP{
+		alarm = &FailedEgressIP{
 			Namespace:       namespace,
-			FailedIPs:       ips,
+			EgressIP:        egressIP,
 			FirstOccurrence: timeStamp,
 			LastOccurrence:  timeStamp,
 			Counter:         float64(1),
 		}
 
-		s.failures[namespace] = &alarm
-
+		s.failures[key] = alarm
 	} else {
-		s.failures[namespace].Counter = s.failures[namespace].Counter + 1
-		s.failures[namespace].LastOccurrence = time.Now()
-		s.failures[namespace].FailedIPs = ips
+		s.deleteSeries(alarm)
+
+		alarm.Counter = alarm.Counter + 1
+		alarm.LastOccurrence = time.Now()
+	}
+
+	alarm.Failures = ips
+	alarm.FailedIPs = make([]*net.IP, len(ips))
+	for i, ip := range ips {
+		alarm.FailedIPs[i] = ip.IP
 	}
+	alarm.State = s.stateOf(alarm)
 
-	s.counter.WithLabelValues(namespace).Set(s.failures[namespace].Counter)
+	s.setSeries(alarm)
+	s.updateFlapping(namespace, egressIP, time.Now())
 }
 
-// RemoveAlarm -- Removes a recovered namespace from the alarm store
-func (s PrometheusLinkedAlarmStore) RemoveAlarm(namespace string) {
-	if s.failures[namespace] != nil {
-		s.counter.WithLabelValues(namespace).Set(0)
+// RemoveAlarm -- Removes all alarms of a recovered namespace from the alarm store
+func (s *PrometheusLinkedAlarmStore) RemoveAlarm(namespace string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-		delete(s.failures, namespace)
+	for key, alarm := range s.failures {
+		if alarm.Namespace == namespace {
+			s.removeAlarm(key)
+		}
 	}
 }
 
-// RemoveAlarmForIP -- Removes the alarm for a single IP. If there are still IPs in alarm, keep the alarm, if that has
-// been the last IP, remove the alarm.
-func (s PrometheusLinkedAlarmStore) RemoveAlarmForIP(namespace string, ip *net.IP) {
-	if s.failures[namespace] != nil {
-		newFailures := make([]*net.IP, 0)
+// RemoveEgressIPAlarm -- Removes the alarm of a recovered EgressIP from the alarm store
+func (s *PrometheusLinkedAlarmStore) RemoveEgressIPAlarm(namespace string, egressIP string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removeAlarm(AlarmKey(namespace, egressIP))
+}
 
-		for _, oldIP := range s.failures[namespace].FailedIPs {
-			if !reflect.DeepEqual(oldIP, ip) {
-				newFailures = append(newFailures, oldIP)
+func (s *PrometheusLinkedAlarmStore) removeAlarm(key string) {
+	alarm := s.failures[key]
+	if alarm == nil {
+		return
+	}
+	s.deleteSeries(alarm)
+	delete(s.failures, key)
+
+	now := time.Now()
+	s.history = append(s.history, ResolvedAlarm{FailedEgressIP: *alarm, Resolved: now})
+	if len(s.history) > s.historySize {
+		s.history = s.history[len(s.history)-s.historySize:]
+	}
+
+	s.cleared[key] = append(s.cleared[key], now)
+	if s.updateFlapping(alarm.Namespace, alarm.EgressIP, now) {
+		s.Log.Info("alarm is flapping",
+			"namespace", alarm.Namespace,
+			"egressip", alarm.EgressIP,
+			"cycles", len(s.cleared[key]),
+			"window", s.flapWindow,
+		)
+	}
+}
+
+// RemoveAlarmForIP -- Removes the alarms for a single IP of the namespace. If there are still IPs in alarm, keep the
+// alarm, if that has been the last IP, remove the alarm.
+func (s *PrometheusLinkedAlarmStore) RemoveAlarmForIP(namespace string, ip *net.IP) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, alarm := range s.failures {
+		if alarm.Namespace != namespace {
+			continue
+		}
+
+		newFailures := make([]FailedIP, 0)
+		newFailedIPs := make([]*net.IP, 0)
+
+		for _, oldIP := range alarm.Failures {
+			if oldIP.IP != nil && ip != nil && oldIP.IP.Equal(*ip) {
+				s.counter.DeleteLabelValues(alarm.labelValues(oldIP)...)
+				continue
 			}
+
+			newFailures = append(newFailures, oldIP)
+			newFailedIPs = append(newFailedIPs, oldIP.IP)
 		}
 
 		if len(newFailures) > 0 {
-			s.failures[namespace].FailedIPs = newFailures
+			alarm.Failures = newFailures
+			alarm.FailedIPs = newFailedIPs
 		} else {
-			s.RemoveAlarm(namespace)
+			s.removeAlarm(key)
 		}
 	}
 }
 
-// GetFailed -- Retrieves all failed namespaces from the alarm store
-func (s PrometheusLinkedAlarmStore) GetFailed() map[string]*FailedEgressIP {
-	return s.failures
+// GetFailed -- Retrieves a snapshot of all alarms from the alarm store keyed by AlarmKey
+func (s *PrometheusLinkedAlarmStore) GetFailed() map[string]*FailedEgressIP {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := make(map[string]*FailedEgressIP, len(s.failures))
+	for key, alarm := range s.failures {
+		alarmCopy := *alarm
+		alarmCopy.Failures = append([]FailedIP{}, alarm.Failures...)
+		alarmCopy.FailedIPs = append([]*net.IP{}, alarm.FailedIPs...)
+
+		result[key] = &alarmCopy
+	}
+
+	return result
+}
+
+// GetHistory -- Retrieves a snapshot of the resolved alarms, the oldest first
+func (s *PrometheusLinkedAlarmStore) GetHistory() []ResolvedAlarm {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]ResolvedAlarm{}, s.history...)
+}
+
+// IsFlapping -- Checks if the alarm of the EgressIP has been raised and cleared at least the configured number of
+// cycles within the flap window
+func (s *PrometheusLinkedAlarmStore) IsFlapping(namespace string, egressIP string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.updateFlapping(namespace, egressIP, time.Now())
+}
+
+// SetAlarmState -- Sets the acknowledgement and silence of the alarm of an EgressIP. The state of a current alarm is
+// updated at once.
+func (s *PrometheusLinkedAlarmStore) SetAlarmState(namespace string, egressIP string, acknowledged time.Time, silenced bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := AlarmKey(namespace, egressIP)
+	if acknowledged.IsZero() && !silenced {
+		delete(s.states, key)
+	} else {
+		s.states[key] = alarmState{acknowledged: acknowledged, silenced: silenced}
+	}
+
+	alarm := s.failures[key]
+	if alarm != nil {
+		state := s.stateOf(alarm)
+		if state != alarm.State {
+			s.deleteSeries(alarm)
+			alarm.State = state
+			s.setSeries(alarm)
+		}
+	}
+}
+
+// stateOf returns the state of the alarm. Silence wins over acknowledgement, an acknowledgement only covers alarms
+// that have occurred first before it.
+func (s *PrometheusLinkedAlarmStore) stateOf(alarm *FailedEgressIP) string {
+	state, found := s.states[AlarmKey(alarm.Namespace, alarm.EgressIP)]
+	switch {
+	case !found:
+		return AlarmActive
+	case state.silenced:
+		return AlarmSilenced
+	case !state.acknowledged.IsZero() && !alarm.FirstOccurrence.After(state.acknowledged):
+		return AlarmAcknowledged
+	default:
+		return AlarmActive
+	}
+}
+
+// updateFlapping drops the clear times outside the flap window and sets the flapping gauge of the EgressIP.
+func (s *PrometheusLinkedAlarmStore) updateFlapping(namespace string, egressIP string, now time.Time) bool {
+	key := AlarmKey(namespace, egressIP)
+
+	remaining := make([]time.Time, 0)
+	for _, cleared := range s.cleared[key] {
+		if now.Sub(cleared) < s.flapWindow {
+			remaining = append(remaining, cleared)
+		}
+	}
+
+	if len(remaining) == 0 {
+		delete(s.cleared, key)
+		s.flapping.DeleteLabelValues(namespace, egressIP)
+		return false
+	}
+	s.cleared[key] = remaining
+
+	flapping := s.flapCycles > 0 && len(remaining) >= s.flapCycles
+	if flapping {
+		s.flapping.WithLabelValues(namespace, egressIP).Set(1)
+	} else {
+		s.flapping.WithLabelValues(namespace, egressIP).Set(0)
+	}
+
+	return flapping
+}
+
+// setSeries sets the gauge for every failed IP of the alarm to the counter of the alarm.
+func (s *PrometheusLinkedAlarmStore) setSeries(alarm *FailedEgressIP) {
+	for _, ip := range alarm.Failures {
+		s.counter.WithLabelValues(alarm.labelValues(ip)...).Set(alarm.Counter)
+	}
+}
+
+// deleteSeries removes the gauge for every failed IP of the alarm.
+func (s *PrometheusLinkedAlarmStore) deleteSeries(alarm *FailedEgressIP) {
+	for _, ip := range alarm.Failures {
+		s.counter.DeleteLabelValues(alarm.labelValues(ip)...)
+	}
 }
 
 // FailedEgressIP - This is the data for the failure.
 type FailedEgressIP struct {
-	Namespace       string    // The failed namespace
-	FailedIPs       []*net.IP // The failed IPs
-	FirstOccurrence time.Time // First occurrence of this failure
-	LastOccurrence  time.Time // Last occurrence of this failure
-	Counter         float64   // Failure counter
+	Namespace       string     // The failed namespace
+	EgressIP        string     // The failed EgressIP
+	FailedIPs       []*net.IP  // The failed IPs
+	Failures        []FailedIP // The failed IPs with their failure domains
+	FirstOccurrence time.Time  // First occurrence of this failure
+	LastOccurrence  time.Time  // Last occurrence of this failure
+	Counter         float64    // Failure counter
+	State           string     // active, acknowledged or silenced
+}
+
+// ResolvedAlarm - An alarm that has been cleared.
+type ResolvedAlarm struct {
+	FailedEgressIP
+	Resolved time.Time // Time the alarm has been cleared
+}
+
+// FailedIP - A single failed IP of an EgressIP.
+type FailedIP struct {
+	FailureDomain string  // The failure domain of the IP
+	IP            *net.IP // The failed IP, nil for a random IP not assigned yet
+}
+
+func (f *FailedEgressIP) labelValues(ip FailedIP) []string {
+	address := ""
+	if ip.IP != nil {
+		address = ip.IP.String()
+	}
+
+	return []string{f.Namespace, f.EgressIP, ip.FailureDomain, address, f.State}
 }
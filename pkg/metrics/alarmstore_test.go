@@ -1,10 +1,13 @@
 package metrics_test
 
 import (
+	"fmt"
 	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
 	"net"
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
 	"testing"
 	"time"
 )
@@ -158,3 +161,260 @@ func TestAddTwoDifferentNamespaces(t *testing.T) {
 	store.RemoveAlarm("other")
 	store.RemoveAlarm(expectedNamespace)
 }
+
+func TestAddEgressIPAlarmLabelsEveryIP(t *testing.T) {
+	store := *metrics.NewAlarmStore(log.WithName("alarm-store"))
+
+	ip1 := net.ParseIP("1.1.1.1")
+	ip2 := net.ParseIP("2.2.2.2")
+	store.AddEgressIPAlarm("labelled", "egress", []metrics.FailedIP{
+		{FailureDomain: "zone-a", IP: &ip1},
+		{FailureDomain: "zone-b", IP: &ip2},
+	})
+
+	series := gatherHandlingFailures(t, "labelled")
+	if len(series) != 2 {
+		t.Fatalf("Number of alarm series does not match! expected=2, current=%v", len(series))
+	}
+	if series["zone-a/1.1.1.1"] != "egress" || series["zone-b/2.2.2.2"] != "egress" {
+		t.Errorf("Alarm series are not labelled by failure domain, ip and egressip! current='%v'", series)
+	}
+
+	store.RemoveAlarmForIP("labelled", &ip1)
+
+	series = gatherHandlingFailures(t, "labelled")
+	if len(series) != 1 || series["zone-b/2.2.2.2"] != "egress" {
+		t.Errorf("Only the series of the remaining ip should be left! current='%v'", series)
+	}
+
+	store.RemoveAlarm("labelled")
+
+	if len(gatherHandlingFailures(t, "labelled")) != 0 {
+		t.Error("There should be no alarm series left!")
+	}
+}
+
+func TestKeepingAlarmsOfEgressIPsOfSameNamespaceApart(t *testing.T) {
+	store := *metrics.NewAlarmStore(log.WithName("alarm-store"))
+
+	ip1 := net.ParseIP("7.7.7.1")
+	ip2 := net.ParseIP("7.7.7.2")
+	store.AddEgressIPAlarm("siblings", "first", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip1}})
+	store.AddEgressIPAlarm("siblings", "second", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip2}})
+
+	failed := store.GetFailed()
+	first := failed[metrics.AlarmKey("siblings", "first")]
+	second := failed[metrics.AlarmKey("siblings", "second")]
+	if first == nil || second == nil || first.Counter != 1 || second.Counter != 1 {
+		t.Fatalf("Every egressip should have its own alarm! first='%v', second='%v'", first, second)
+	}
+
+	store.RemoveEgressIPAlarm("siblings", "first")
+
+	series := gatherHandlingFailures(t, "siblings")
+	if len(series) != 1 || series["zone-a/7.7.7.2"] != "second" {
+		t.Errorf("Only the alarm of egressip 'second' should be left! current='%v'", series)
+	}
+
+	store.RemoveAlarm("siblings")
+
+	if len(gatherHandlingFailures(t, "siblings")) != 0 {
+		t.Error("There should be no alarm series left!")
+	}
+}
+
+func TestConcurrentAlarms(t *testing.T) {
+	store := *metrics.NewAlarmStore(log.WithName("alarm-store"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		namespace := fmt.Sprintf("concurrent-%v", i%3)
+		ip := net.ParseIP(fmt.Sprintf("10.0.0.%v", i))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				store.AddEgressIPAlarm(namespace, "egress", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip}})
+				for _, failed := range store.GetFailed() {
+					_ = failed.Counter
+				}
+				store.RemoveAlarmForIP(namespace, &ip)
+				store.RemoveAlarm(namespace)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		if store.GetFailed()[metrics.AlarmKey(fmt.Sprintf("concurrent-%v", i), "egress")] != nil {
+			t.Errorf("There should be no alarm left for namespace 'concurrent-%v'!", i)
+		}
+	}
+}
+
+func TestKeepingHistoryOfResolvedAlarms(t *testing.T) {
+	store := *metrics.NewAlarmStore(log.WithName("alarm-store"))
+
+	ip := net.ParseIP("4.4.4.4")
+	store.AddEgressIPAlarm("history", "egress", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip}})
+	store.RemoveAlarm("history")
+
+	history := store.GetHistory()
+	if len(history) == 0 {
+		t.Fatal("There should be a resolved alarm in the history!")
+	}
+
+	resolved := history[len(history)-1]
+	if resolved.Namespace != "history" || resolved.EgressIP != "egress" || len(resolved.Failures) != 1 {
+		t.Errorf("Wrong alarm in the history! expected='history/egress', current='%v/%v'", resolved.Namespace, resolved.EgressIP)
+	}
+
+	if resolved.Resolved.Before(resolved.FirstOccurrence) {
+		t.Errorf("Alarm has been resolved before it occurred! first-occurrence='%v', resolved='%v'",
+			resolved.FirstOccurrence,
+			resolved.Resolved,
+		)
+	}
+}
+
+func TestDetectingFlappingAlarms(t *testing.T) {
+	store := *metrics.NewAlarmStore(log.WithName("alarm-store"))
+
+	ip := net.ParseIP("5.5.5.5")
+	for i := 0; i < metrics.DefaultAlarmFlapCycles; i++ {
+		if store.IsFlapping("flapping", "egress") {
+			t.Fatalf("Alarm should not flap after %v cycles!", i)
+		}
+
+		store.AddEgressIPAlarm("flapping", "egress", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip}})
+		store.RemoveAlarm("flapping")
+	}
+
+	if !store.IsFlapping("flapping", "egress") {
+		t.Errorf("Alarm should flap after %v cycles!", metrics.DefaultAlarmFlapCycles)
+	}
+
+	if store.IsFlapping("other-namespace", "egress") {
+		t.Error("Alarm of another namespace should not flap!")
+	}
+}
+
+func TestKeepingStateAndFlappingPerEgressIP(t *testing.T) {
+	store := *metrics.NewAlarmStore(log.WithName("alarm-store"))
+
+	ip := net.ParseIP("8.8.8.8")
+	store.AddEgressIPAlarm("per-egressip", "broken", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip}})
+	store.SetAlarmState("per-egressip", "broken", time.Time{}, true)
+
+	for i := 0; i < metrics.DefaultAlarmFlapCycles; i++ {
+		store.SetAlarmState("per-egressip", "healthy", time.Time{}, false)
+		store.RemoveEgressIPAlarm("per-egressip", "healthy")
+	}
+
+	alarm := store.GetFailed()[metrics.AlarmKey("per-egressip", "broken")]
+	if alarm == nil || alarm.State != metrics.AlarmSilenced {
+		t.Errorf("Alarm of egressip 'broken' should still be silenced! current='%v'", alarm)
+	}
+
+	if store.IsFlapping("per-egressip", "broken") || store.IsFlapping("per-egressip", "healthy") {
+		t.Error("Healthy sibling egressips should not make the alarm flap!")
+	}
+
+	store.RemoveAlarm("per-egressip")
+	store.SetAlarmState("per-egressip", "broken", time.Time{}, false)
+}
+
+func TestAcknowledgingAndSilencingAlarms(t *testing.T) {
+	store := *metrics.NewAlarmStore(log.WithName("alarm-store"))
+
+	ip := net.ParseIP("6.6.6.6")
+	store.AddEgressIPAlarm("acknowledged", "egress", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip}})
+	checkAlarmState(t, store, "acknowledged", metrics.AlarmActive)
+
+	store.SetAlarmState("acknowledged", "egress", time.Now(), false)
+	checkAlarmState(t, store, "acknowledged", metrics.AlarmAcknowledged)
+
+	store.SetAlarmState("acknowledged", "egress", time.Now(), true)
+	checkAlarmState(t, store, "acknowledged", metrics.AlarmSilenced)
+
+	store.SetAlarmState("acknowledged", "egress", time.Time{}, false)
+	checkAlarmState(t, store, "acknowledged", metrics.AlarmActive)
+
+	store.SetAlarmState("acknowledged", "egress", time.Now().Add(-time.Hour), false)
+	checkAlarmState(t, store, "acknowledged", metrics.AlarmActive)
+
+	store.RemoveAlarm("acknowledged")
+	store.SetAlarmState("acknowledged", "egress", time.Time{}, false)
+}
+
+func checkAlarmState(t *testing.T, store metrics.AlarmStore, namespace string, expected string) {
+	t.Helper()
+
+	if current := store.GetFailed()[metrics.AlarmKey(namespace, "egress")].State; current != expected {
+		t.Errorf("Wrong state of the alarm! expected='%v', current='%v'", expected, current)
+	}
+
+	series := gatherAlarmStates(t, namespace)
+	if len(series) != 1 || series[0] != expected {
+		t.Errorf("Wrong state of the alarm series! expected='[%v]', current='%v'", expected, series)
+	}
+}
+
+// gatherAlarmStates returns the state label of all alarm series of the namespace.
+func gatherAlarmStates(t *testing.T, namespace string) []string {
+	families, err := crmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Can't gather metrics: %v", err)
+	}
+
+	result := make([]string, 0)
+	for _, family := range families {
+		if family.GetName() != "egress_ip_handling_failures" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["namespace"] == namespace {
+				result = append(result, labels["state"])
+			}
+		}
+	}
+
+	return result
+}
+
+// gatherHandlingFailures returns the egressip label of all alarm series of the namespace keyed by
+// '<failure domain>/<ip>'.
+func gatherHandlingFailures(t *testing.T, namespace string) map[string]string {
+	families, err := crmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Can't gather metrics: %v", err)
+	}
+
+	result := make(map[string]string)
+	for _, family := range families {
+		if family.GetName() != "egress_ip_handling_failures" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["namespace"] == namespace {
+				result[labels["failure_domain"]+"/"+labels["ip"]] = labels["egressip"]
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// FailureDomainAddresses -- number of host addresses within the CIDR of a failure domain
+	FailureDomainAddresses = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "failure_domain_addresses",
+			Help:      "Host addresses within the cidr of a failure domain",
+		},
+		[]string{"failure_domain"},
+	)
+
+	// FailureDomainAllocatedAddresses -- number of addresses within a failure domain owned by EgressIPs
+	FailureDomainAllocatedAddresses = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "failure_domain_allocated_addresses",
+			Help:      "Addresses within the cidr of a failure domain owned by egress-ips",
+		},
+		[]string{"failure_domain"},
+	)
+
+	// FailureDomainFreeAddresses -- number of addresses within a failure domain neither allocated nor used by nodes
+	FailureDomainFreeAddresses = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "failure_domain_free_addresses",
+			Help:      "Addresses within the cidr of a failure domain neither owned by egress-ips nor used by nodes",
+		},
+		[]string{"failure_domain"},
+	)
+
+	// FailureDomainEligibleNodes -- number of nodes matching the node selector of a failure domain
+	FailureDomainEligibleNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "failure_domain_eligible_nodes",
+			Help:      "Nodes matching the node selector of a failure domain",
+		},
+		[]string{"failure_domain"},
+	)
+
+	// NodeIPs -- number of egress IPs of a failure domain assigned to a node
+	NodeIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "node_ips",
+			Help:      "Egress ips of a failure domain assigned to a node",
+		},
+		[]string{"failure_domain", "host"},
+	)
+
+	// NodeIPLimit -- maximum number of egress IPs a node can serve. Nodes without a limit are not reported.
+	NodeIPLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "node_ip_limit",
+			Help:      "Maximum number of egress ips a node can serve",
+		},
+		[]string{"failure_domain", "host"},
+	)
+
+	// Allocations -- number of IPs assigned to EgressIPs
+	Allocations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "egress_ip",
+			Name:      "allocations_total",
+			Help:      "Ips assigned to egress-ips",
+		},
+		[]string{"failure_domain"},
+	)
+
+	// Releases -- number of IPs released by EgressIPs
+	Releases = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "egress_ip",
+			Name:      "releases_total",
+			Help:      "Ips released by egress-ips",
+		},
+		[]string{"failure_domain"},
+	)
+
+	// Moves -- number of IPs moved from one host to another
+	Moves = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "egress_ip",
+			Name:      "moves_total",
+			Help:      "Ips of egress-ips moved to another host",
+		},
+		[]string{"failure_domain"},
+	)
+)
+
+// ForgetFailureDomain removes the capacity series of a deleted failure domain. The series of its hosts are removed by
+// ForgetNode.
+func ForgetFailureDomain(failureDomain string) {
+	FailureDomainAddresses.DeleteLabelValues(failureDomain)
+	FailureDomainAllocatedAddresses.DeleteLabelValues(failureDomain)
+	FailureDomainFreeAddresses.DeleteLabelValues(failureDomain)
+	FailureDomainEligibleNodes.DeleteLabelValues(failureDomain)
+}
+
+// ForgetNode removes the capacity series of a host no longer eligible for the failure domain.
+func ForgetNode(failureDomain string, hostName string) {
+	NodeIPs.DeleteLabelValues(failureDomain, hostName)
+	NodeIPLimit.DeleteLabelValues(failureDomain, hostName)
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		FailureDomainAddresses,
+		FailureDomainAllocatedAddresses,
+		FailureDomainFreeAddresses,
+		FailureDomainEligibleNodes,
+		NodeIPs,
+		NodeIPLimit,
+		Allocations,
+		Releases,
+		Moves,
+	)
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"time"
+)
+
+// Result codes of cloud API calls. Every cloud provider maps its own error codes to these.
+const (
+	CloudAPISuccess          = "success"
+	CloudAPIThrottled        = "throttled"
+	CloudAPIUnauthorized     = "unauthorized"
+	CloudAPIInvalidParameter = "invalid_parameter"
+	CloudAPINotFound         = "not_found"
+	CloudAPILimitExceeded    = "limit_exceeded"
+	CloudAPIUnavailable      = "unavailable"
+	CloudAPIOther            = "other"
+)
+
+var (
+	// CloudAPIDuration -- latency of the calls to the cloud API by result code
+	CloudAPIDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "egress_ip",
+			Name:      "cloud_api_duration_seconds",
+			Help:      "Latency of the calls to the cloud api",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"provider", "operation", "region", "code"},
+	)
+
+	// CloudAPIRequests -- calls to the cloud API by result code
+	CloudAPIRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "egress_ip",
+			Name:      "cloud_api_requests_total",
+			Help:      "Calls to the cloud api by result code",
+		},
+		[]string{"provider", "operation", "region", "code"},
+	)
+
+	// FailoverDuration -- time from the failure of a node to the IP being confirmed on the new host
+	FailoverDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "egress_ip",
+			Name:      "failover_duration_seconds",
+			Help:      "Time from the failure of a node to the ip being confirmed on the new host",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+		},
+		[]string{"failure_domain"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(CloudAPIDuration, CloudAPIRequests, FailoverDuration)
+}
+
+// ObserveCloudAPICall records the latency and the result code of a single call to the cloud API started at start.
+func ObserveCloudAPICall(provider string, operation string, region string, start time.Time, code string) {
+	CloudAPIDuration.WithLabelValues(provider, operation, region, code).Observe(time.Since(start).Seconds())
+	CloudAPIRequests.WithLabelValues(provider, operation, region, code).Inc()
+}
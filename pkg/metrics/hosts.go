@@ -0,0 +1,38 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// MisconfiguredHosts -- 1 if an eligible host of a failure domain is not configured to serve egress IPs, 0 otherwise
+	MisconfiguredHosts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "host_misconfigured",
+			Help:      "Eligible hosts of a failure domain not configured to serve egress-ips",
+		},
+		[]string{"failure_domain", "host"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(MisconfiguredHosts)
+}
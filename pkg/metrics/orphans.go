@@ -0,0 +1,48 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// OrphanedIPs -- number of IPs within a failure domain that are assigned to hosts but not owned by any EgressIP
+	OrphanedIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "orphaned_ips",
+			Help:      "IPs assigned to hosts within a failure domain but not owned by any egress-ip",
+		},
+		[]string{"failure_domain"},
+	)
+
+	// RemovedOrphanedIPs -- number of orphaned IPs removed by the garbage collector
+	RemovedOrphanedIPs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "egress_ip",
+			Name:      "orphaned_ips_removed_total",
+			Help:      "Orphaned IPs removed from hosts by the garbage collector",
+		},
+		[]string{"failure_domain"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(OrphanedIPs, RemovedOrphanedIPs)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// PolicyNamespaceIPs -- number of egress IPs assigned to a namespace selected by a policy
+	PolicyNamespaceIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "policy_namespace_ips",
+			Help:      "Egress ips assigned to a namespace selected by a policy",
+		},
+		[]string{"policy", "namespace"},
+	)
+
+	// PolicyNamespaceFailureDomainIPs -- number of egress IPs of a failure domain assigned to a namespace selected by
+	// a policy
+	PolicyNamespaceFailureDomainIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "policy_namespace_failure_domain_ips",
+			Help:      "Egress ips of a failure domain assigned to a namespace selected by a policy",
+		},
+		[]string{"policy", "namespace", "failure_domain"},
+	)
+
+	// PolicyNamespaceViolations -- number of violations of a policy by a namespace
+	PolicyNamespaceViolations = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "policy_namespace_violations",
+			Help:      "Violations of a policy by a namespace",
+		},
+		[]string{"policy", "namespace"},
+	)
+
+	// PolicyMaxIPs -- maximum number of egress IPs of every namespace selected by a policy. Policies without a maximum
+	// are not reported.
+	PolicyMaxIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "policy_max_ips",
+			Help:      "Maximum number of egress ips of every namespace selected by a policy",
+		},
+		[]string{"policy"},
+	)
+
+	// PolicyMaxIPsPerFailureDomain -- maximum number of egress IPs within a failure domain of every namespace selected
+	// by a policy. Policies without a maximum are not reported.
+	PolicyMaxIPsPerFailureDomain = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "egress_ip",
+			Name:      "policy_max_ips_per_failure_domain",
+			Help:      "Maximum number of egress ips within a failure domain of every namespace selected by a policy",
+		},
+		[]string{"policy"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		PolicyNamespaceIPs,
+		PolicyNamespaceFailureDomainIPs,
+		PolicyNamespaceViolations,
+		PolicyMaxIPs,
+		PolicyMaxIPsPerFailureDomain,
+	)
+}
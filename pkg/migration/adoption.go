@@ -0,0 +1,284 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migration
+
+import (
+	"context"
+	"fmt"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+// AdoptedEgressIPName is the name of the EgressIPs created for namespaces without EgressIP.
+const AdoptedEgressIPName = "adopted"
+
+// AdoptionMode is the adoption mode read from the environment variable ADOPTION_MODE.
+var AdoptionMode string
+
+func init() {
+	AdoptionMode = os.Getenv("ADOPTION_MODE")
+}
+
+// PlanAdoption discovers the egress IPs of the NetNamespaces served by HostSubnets but not owned by any EgressIP. Every
+// IP is matched to the failure domain containing it and selecting the node serving it. Namespaces without EgressIP
+// get a new one, a single EgressIP of the namespace adopts the IPs. Both are marked as adopted, so the operator takes
+// the IPs over without moving them. Namespaces with IPs that can not be matched are reported and left alone.
+func PlanAdoption(ctx context.Context, c client.Reader) (*Report, error) {
+	result := newReport()
+
+	hostSubnets := &netv1.HostSubnetList{}
+	err := c.List(ctx, hostSubnets)
+	if err != nil {
+		return nil, err
+	}
+
+	netNamespaces := &netv1.NetNamespaceList{}
+	err = c.List(ctx, netNamespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := &corev1.NodeList{}
+	err = c.List(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err = c.List(ctx, failureDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	egressIPs := &v1alpha1.EgressIPList{}
+	err = c.List(ctx, egressIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string]string)
+	for _, hostSubnet := range hostSubnets.Items {
+		for _, ip := range hostSubnet.EgressIPs {
+			hosts[ip] = hostSubnet.Host
+		}
+	}
+
+	owned := make(map[string]bool)
+	for _, egressIP := range egressIPs.Items {
+		for _, assigned := range egressIP.Status.IPs {
+			owned[assigned.IP] = true
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, netNamespace := range netNamespaces.Items {
+		unowned := make([]string, 0)
+		for _, ip := range netNamespace.EgressIPs {
+			used[ip] = true
+			if !owned[ip] {
+				unowned = append(unowned, ip)
+			}
+		}
+		if len(unowned) == 0 {
+			continue
+		}
+
+		ips, err := adoptedIPs(unowned, hosts, nodes.Items, failureDomains.Items)
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("namespace '%v' can not be adopted: %v", netNamespace.NetName, err.Error()))
+			continue
+		}
+
+		existing := make([]v1alpha1.EgressIP, 0)
+		for _, egressIP := range egressIPs.Items {
+			if egressIP.Namespace == netNamespace.NetName {
+				existing = append(existing, egressIP)
+			}
+		}
+
+		switch len(existing) {
+		case 0:
+			result.EgressIPs = append(result.EgressIPs, v1alpha1.EgressIP{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        AdoptedEgressIPName,
+					Namespace:   netNamespace.NetName,
+					Annotations: map[string]string{openshift.AdoptedAnnotation: "true"},
+				},
+				Spec: v1alpha1.EgressIPSpec{IPs: ips},
+			})
+		case 1:
+			adopting, err := adoptInto(&existing[0], ips)
+			if err != nil {
+				result.Problems = append(result.Problems, fmt.Sprintf("namespace '%v' can not be adopted: %v", netNamespace.NetName, err.Error()))
+				continue
+			}
+			result.Adopted = append(result.Adopted, *adopting)
+		default:
+			result.Problems = append(result.Problems, fmt.Sprintf("namespace '%v' can not be adopted: it has %v egressips", netNamespace.NetName, len(existing)))
+		}
+	}
+
+	ips := make([]string, 0)
+	for ip := range hosts {
+		if !used[ip] && !owned[ip] {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		result.Problems = append(result.Problems, fmt.Sprintf("ip '%v' of host '%v' is not used by any namespace", ip, hosts[ip]))
+	}
+
+	return result, nil
+}
+
+// adoptedIPs matches the IPs to the failure domains containing them and selecting the nodes serving them. Failure
+// domains without CIDR match by their node selector only, if no failure domain with CIDR matches.
+func adoptedIPs(ips []string, hosts map[string]string, nodes []corev1.Node, failureDomains []v1beta1.ClusterEgressIPFailureDomain) ([]v1alpha1.FailureDomainEgressIPSpec, error) {
+	result := make([]v1alpha1.FailureDomainEgressIPSpec, 0, len(ips))
+	listed := make(map[string]string)
+
+	for _, value := range ips {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("'%v' is not a valid ip", value)
+		}
+
+		hostName, found := hosts[value]
+		if !found {
+			return nil, fmt.Errorf("ip '%v' is not served by any host", value)
+		}
+
+		node := findNode(nodes, hostName)
+		if node == nil {
+			return nil, fmt.Errorf("host '%v' serving ip '%v' is not a node", hostName, value)
+		}
+
+		name, err := matchFailureDomain(ip, node, failureDomains)
+		if err != nil {
+			return nil, err
+		}
+		if other, found := listed[name]; found {
+			return nil, fmt.Errorf("ips '%v' and '%v' are both within failure domain '%v'", other, value, name)
+		}
+		listed[name] = value
+
+		result = append(result, v1alpha1.FailureDomainEgressIPSpec{FailureDomain: name, IP: ip.String()})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].FailureDomain < result[j].FailureDomain
+	})
+
+	return result, nil
+}
+
+func matchFailureDomain(ip net.IP, node *corev1.Node, failureDomains []v1beta1.ClusterEgressIPFailureDomain) (string, error) {
+	byCidr := make([]string, 0)
+	bySelector := make([]string, 0)
+
+	for i, failureDomain := range failureDomains {
+		if !failuredomain.NodeMatchesSelector(node, &failureDomains[i].Spec.NodeSelector) {
+			continue
+		}
+
+		if failureDomain.Spec.Cidr == "" {
+			bySelector = append(bySelector, failureDomain.Name)
+			continue
+		}
+
+		_, cidr, err := net.ParseCIDR(failureDomain.Spec.Cidr)
+		if err == nil && cidr.Contains(ip) {
+			byCidr = append(byCidr, failureDomain.Name)
+		}
+	}
+
+	candidates := byCidr
+	if len(candidates) == 0 {
+		candidates = bySelector
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no failure domain contains ip '%v' and selects node '%v'", ip.String(), node.Name)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("ip '%v' on node '%v' matches the failure domains %v", ip.String(), node.Name, candidates)
+	}
+}
+
+// adoptInto adds the IPs to a copy of the EgressIP and marks it as adopted. IPs of failure domains the EgressIP
+// specifies another IP for can not be adopted.
+func adoptInto(egressIP *v1alpha1.EgressIP, ips []v1alpha1.FailureDomainEgressIPSpec) (*v1alpha1.EgressIP, error) {
+	result := egressIP.DeepCopy()
+
+	for _, adopted := range ips {
+		for _, assigned := range egressIP.Status.IPs {
+			if assigned.FailureDomain == adopted.FailureDomain && assigned.IP != adopted.IP {
+				return nil, fmt.Errorf("egressip '%v' is assigned ip '%v' instead of '%v' in failure domain '%v'",
+					egressIP.Name, assigned.IP, adopted.IP, assigned.FailureDomain,
+				)
+			}
+		}
+
+		found := false
+		for i, spec := range result.Spec.IPs {
+			if spec.FailureDomain != adopted.FailureDomain {
+				continue
+			}
+			if spec.IP != "" && spec.IP != adopted.IP {
+				return nil, fmt.Errorf("egressip '%v' specifies ip '%v' instead of '%v' in failure domain '%v'",
+					egressIP.Name, spec.IP, adopted.IP, spec.FailureDomain,
+				)
+			}
+
+			result.Spec.IPs[i].IP = adopted.IP
+			found = true
+		}
+
+		if !found {
+			result.Spec.IPs = append(result.Spec.IPs, adopted)
+		}
+	}
+
+	if result.Annotations == nil {
+		result.Annotations = make(map[string]string)
+	}
+	result.Annotations[openshift.AdoptedAnnotation] = "true"
+
+	return result, nil
+}
+
+func findNode(nodes []corev1.Node, name string) *corev1.Node {
+	for i := range nodes {
+		if nodes[i].Name == name {
+			return &nodes[i]
+		}
+	}
+
+	return nil
+}
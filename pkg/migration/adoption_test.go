@@ -0,0 +1,126 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migration_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/migration"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+func zone(name string, cidr string) *v1beta1.ClusterEgressIPFailureDomain {
+	return &v1beta1.ClusterEgressIPFailureDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1beta1.ClusterEgressIPFailureDomainSpec{
+			Cidr: cidr,
+			NodeSelector: corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      "topology.kubernetes.io/zone",
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{name},
+				}},
+			}}},
+		},
+	}
+}
+
+func hostedNode(name string, zone string, ips ...string) []runtime.Object {
+	return []runtime.Object{
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"topology.kubernetes.io/zone": zone}}},
+		&netv1.HostSubnet{ObjectMeta: metav1.ObjectMeta{Name: name}, Host: name, EgressIPs: ips},
+	}
+}
+
+func netNamespace(name string, ips ...string) *netv1.NetNamespace {
+	return &netv1.NetNamespace{ObjectMeta: metav1.ObjectMeta{Name: name}, NetName: name, EgressIPs: ips}
+}
+
+func prepareAdoption(objects ...runtime.Object) client.Client {
+	objects = append(objects, zone("zone-a", "10.0.1.0/24"), zone("zone-b", "10.0.2.0/24"))
+	objects = append(objects, hostedNode("node-a", "zone-a", "10.0.1.10", "10.0.1.20", "10.0.1.30", "10.0.1.99")...)
+	objects = append(objects, hostedNode("node-b", "zone-b", "10.0.2.10", "10.0.1.40")...)
+
+	return prepareClient(objects...)
+}
+
+func TestPlanningAdoption(t *testing.T) {
+	c := prepareAdoption(
+		netNamespace("new", "10.0.1.10", "10.0.2.10"),
+		netNamespace("existing", "10.0.1.20"),
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "existing"},
+			Spec:       v1alpha1.EgressIPSpec{IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a"}}},
+		},
+		netNamespace("owned", "10.0.1.30"),
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "owned"},
+			Status:     v1alpha1.EgressIPStatus{IPs: []v1alpha1.AssignedEgressIP{{FailureDomain: "zone-a", IP: "10.0.1.30", HostName: "node-a"}}},
+		},
+		netNamespace("mismatched", "10.0.1.40"),
+		netNamespace("unserved", "10.0.1.50"),
+	)
+
+	report, err := migration.PlanAdoption(context.Background(), c)
+	if err != nil {
+		t.Fatalf("Adoption could not be planned: %v", err)
+	}
+
+	if len(report.EgressIPs) != 1 {
+		t.Fatalf("Namespace 'new' should get an egressip! current=%v", report.EgressIPs)
+	}
+	created := report.EgressIPs[0]
+	if created.Namespace != "new" || created.Name != migration.AdoptedEgressIPName || created.Annotations[openshift.AdoptedAnnotation] != "true" {
+		t.Errorf("Created egressip should be marked as adopted! current=%v", created.ObjectMeta)
+	}
+	if len(created.Spec.IPs) != 2 || created.Spec.IPs[0].FailureDomain != "zone-a" || created.Spec.IPs[1].IP != "10.0.2.10" {
+		t.Errorf("Wrong ips of created egressip! current=%v", created.Spec.IPs)
+	}
+
+	if len(report.Adopted) != 1 || report.Adopted[0].Spec.IPs[0].IP != "10.0.1.20" || report.Adopted[0].Annotations[openshift.AdoptedAnnotation] != "true" {
+		t.Errorf("Existing egressip should adopt '10.0.1.20'! current=%v", report.Adopted)
+	}
+
+	// 10.0.1.40 is served by a node of zone-b, 10.0.1.50 by no host and 10.0.1.99 is not used
+	if len(report.Problems) != 3 {
+		t.Errorf("Wrong problems! expected=3, current=%v", report.Problems)
+	}
+}
+
+func TestApplyingAdoption(t *testing.T) {
+	c := prepareAdoption(netNamespace("new", "10.0.1.10"))
+
+	report, _ := migration.PlanAdoption(context.Background(), c)
+	err := migration.Apply(context.Background(), c, report)
+	if err != nil {
+		t.Fatalf("Adoption could not be applied: %v", err)
+	}
+
+	egressIP := &v1alpha1.EgressIP{}
+	err = c.Get(context.Background(), types.NamespacedName{Namespace: "new", Name: migration.AdoptedEgressIPName}, egressIP)
+	if err != nil || len(egressIP.Spec.IPs) != 1 || egressIP.Spec.IPs[0].IP != "10.0.1.10" {
+		t.Errorf("Adopted egressip has not been created! error=%v, current=%v", err, egressIP.Spec)
+	}
+}
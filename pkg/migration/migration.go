@@ -0,0 +1,470 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// migration converts the EgressIPAMs and namespace annotations of the egressip-ipam-operator of redhat-cop into
+// EgressIPFailureDomains and EgressIPs. Every CIDR assignment of an EgressIPAM becomes a failure domain, every annotated
+// namespace gets an EgressIP keeping the IPs already assigned. The adoption imports the egress IPs of the NetNamespaces
+// configured by hand into EgressIPs. Both run once at start of the manager, either as dry-run only reporting the
+// planned objects or creating them.
+package migration
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"os"
+	"regexp"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sort"
+	"strings"
+)
+
+const (
+	// ModeDisabled skips the migration.
+	ModeDisabled = ""
+	// ModeDryRun only reports the objects the migration would create.
+	ModeDryRun = "dry-run"
+	// ModeApply creates the objects reported.
+	ModeApply = "apply"
+)
+
+const (
+	// EgressIPAMAnnotation names the EgressIPAM of an annotated namespace.
+	EgressIPAMAnnotation = "egressip-ipam-operator.redhat-cop.io/egressipam"
+	// EgressIPsAnnotation lists the IPs the egressip-ipam-operator assigned to the namespace separated by commas.
+	EgressIPsAnnotation = "egressip-ipam-operator.redhat-cop.io/egressips"
+	// MigratedFromAnnotation marks the objects created by the migration with the EgressIPAM they are migrated from.
+	MigratedFromAnnotation = v1alpha1.MigratedFromAnnotation
+)
+
+// +kubebuilder:rbac:groups=redhatcop.redhat.io,resources=egressipams,verbs=get;list
+
+// EgressIPAMKind is the kind of the EgressIPAMs of the egressip-ipam-operator. They are read as unstructured objects,
+// so the operator does not depend on the types of the egressip-ipam-operator.
+var EgressIPAMKind = schema.GroupVersionKind{Group: "redhatcop.redhat.io", Version: "v1alpha1", Kind: "EgressIPAM"}
+
+// Mode is the migration mode read from the environment variable MIGRATION_MODE.
+var Mode string
+
+func init() {
+	Mode = os.Getenv("MIGRATION_MODE")
+}
+
+// Report lists the objects the migration creates, the EgressIPs adopting IPs, the objects already existing and the
+// namespaces that can not be migrated.
+type Report struct {
+	FailureDomains []v1beta1.ClusterEgressIPFailureDomain
+	EgressIPs      []v1alpha1.EgressIP
+	Adopted        []v1alpha1.EgressIP
+	Existing       []string
+	Problems       []string
+}
+
+func newReport() *Report {
+	return &Report{
+		FailureDomains: make([]v1beta1.ClusterEgressIPFailureDomain, 0),
+		EgressIPs:      make([]v1alpha1.EgressIP, 0),
+		Adopted:        make([]v1alpha1.EgressIP, 0),
+		Existing:       make([]string, 0),
+		Problems:       make([]string, 0),
+	}
+}
+
+// egressIPAM is the part of an EgressIPAM needed for the migration.
+type egressIPAM struct {
+	Name string
+	Spec struct {
+		CidrAssignments []struct {
+			LabelValue  string   `json:"labelValue"`
+			CIDR        string   `json:"CIDR"`
+			ReservedIPs []string `json:"reservedIPs,omitempty"`
+		} `json:"cidrAssignments"`
+		TopologyLabel string                `json:"topologyLabel"`
+		NodeSelector  *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	}
+}
+
+// Plan reads the EgressIPAMs and annotated namespaces and returns the objects to create. The EgressIPs are marked as
+// adopted, so the operator takes the IPs over from the hosts serving them. Clusters without the EgressIPAM CRD get an
+// empty report.
+func Plan(ctx context.Context, c client.Reader) (*Report, error) {
+	result := newReport()
+
+	ipams, err := listEgressIPAMs(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	failureDomains := make(map[string]map[string]*net.IPNet)
+	for _, ipam := range ipams {
+		failureDomains[ipam.Name] = make(map[string]*net.IPNet)
+
+		for _, assignment := range ipam.Spec.CidrAssignments {
+			name := failureDomainName(ipam.Name, assignment.LabelValue)
+
+			_, cidr, err := net.ParseCIDR(assignment.CIDR)
+			if err != nil {
+				result.Problems = append(result.Problems, fmt.Sprintf("cidr '%v' of egressipam '%v' is not valid", assignment.CIDR, ipam.Name))
+				continue
+			}
+			failureDomains[ipam.Name][name] = cidr
+
+			exists, err := failureDomainExists(ctx, c, name)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				result.Existing = append(result.Existing, "clusteregressipfailuredomain/"+name)
+				continue
+			}
+
+			result.FailureDomains = append(result.FailureDomains, v1beta1.ClusterEgressIPFailureDomain{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Annotations: map[string]string{MigratedFromAnnotation: "egressipam/" + ipam.Name},
+				},
+				Spec: v1beta1.ClusterEgressIPFailureDomainSpec{
+					Cidr:         cidr.String(),
+					NodeSelector: nodeSelector(ipam.Spec.TopologyLabel, assignment.LabelValue, ipam.Spec.NodeSelector),
+					Reserved:     assignment.ReservedIPs,
+				},
+			})
+		}
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	err = c.List(ctx, namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, namespace := range namespaces.Items {
+		ipamName, found := namespace.Annotations[EgressIPAMAnnotation]
+		if !found {
+			continue
+		}
+
+		cidrs, found := failureDomains[ipamName]
+		if !found {
+			result.Problems = append(result.Problems, fmt.Sprintf("namespace '%v' references unknown egressipam '%v'", namespace.Name, ipamName))
+			continue
+		}
+
+		ips, err := egressIPsOfNamespace(namespace.Annotations[EgressIPsAnnotation], cidrs)
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("namespace '%v' can not be migrated: %v", namespace.Name, err.Error()))
+			continue
+		}
+
+		existing := &v1alpha1.EgressIP{}
+		err = c.Get(ctx, types.NamespacedName{Namespace: namespace.Name, Name: ipamName}, existing)
+		if err == nil {
+			result.Existing = append(result.Existing, "egressip/"+namespace.Name+"/"+ipamName)
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+
+		result.EgressIPs = append(result.EgressIPs, v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ipamName,
+				Namespace: namespace.Name,
+				Annotations: map[string]string{
+					MigratedFromAnnotation:      "egressipam/" + ipamName,
+					openshift.AdoptedAnnotation: "true",
+				},
+			},
+			Spec: v1alpha1.EgressIPSpec{IPs: ips},
+		})
+	}
+
+	return result, nil
+}
+
+// listEgressIPAMs returns the EgressIPAMs sorted by name. Clusters without the CRD have no EgressIPAMs.
+func listEgressIPAMs(ctx context.Context, c client.Reader) ([]egressIPAM, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(EgressIPAMKind.GroupVersion().WithKind(EgressIPAMKind.Kind + "List"))
+
+	err := c.List(ctx, list)
+	if err != nil {
+		if meta.IsNoMatchError(err) || errors.IsNotFound(err) {
+			return []egressIPAM{}, nil
+		}
+		return nil, err
+	}
+
+	result := make([]egressIPAM, 0, len(list.Items))
+	for _, item := range list.Items {
+		ipam := egressIPAM{Name: item.GetName()}
+
+		spec, _ := item.Object["spec"].(map[string]interface{})
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &ipam.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("egressipam '%v' can not be read: %v", item.GetName(), err)
+		}
+
+		result = append(result, ipam)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+func failureDomainExists(ctx context.Context, c client.Reader, name string) (bool, error) {
+	err := c.Get(ctx, types.NamespacedName{Name: name}, &v1beta1.ClusterEgressIPFailureDomain{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+var invalidNameCharacters = regexp.MustCompile("[^a-z0-9-]+")
+
+// failureDomainName derives the name of the failure domain from the EgressIPAM and the label value of the CIDR
+// assignment.
+func failureDomainName(ipam string, labelValue string) string {
+	return strings.Trim(invalidNameCharacters.ReplaceAllString(strings.ToLower(ipam+"-"+labelValue), "-"), "-")
+}
+
+// nodeSelector selects the nodes with the topology label set to the label value and matching the node selector of the
+// EgressIPAM.
+func nodeSelector(topologyLabel string, labelValue string, selector *metav1.LabelSelector) corev1.NodeSelector {
+	requirements := []corev1.NodeSelectorRequirement{{
+		Key:      topologyLabel,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{labelValue},
+	}}
+
+	if selector != nil {
+		keys := make([]string, 0, len(selector.MatchLabels))
+		for key := range selector.MatchLabels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			requirements = append(requirements, corev1.NodeSelectorRequirement{
+				Key:      key,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{selector.MatchLabels[key]},
+			})
+		}
+
+		for _, expression := range selector.MatchExpressions {
+			requirements = append(requirements, corev1.NodeSelectorRequirement{
+				Key:      expression.Key,
+				Operator: corev1.NodeSelectorOperator(expression.Operator),
+				Values:   expression.Values,
+			})
+		}
+	}
+
+	return corev1.NodeSelector{
+		NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: requirements}},
+	}
+}
+
+// egressIPsOfNamespace maps the IPs of the annotation to the failure domains containing them. Without IPs the namespace
+// gets a random IP in every failure domain of the EgressIPAM.
+func egressIPsOfNamespace(annotation string, cidrs map[string]*net.IPNet) ([]v1alpha1.FailureDomainEgressIPSpec, error) {
+	names := make([]string, 0, len(cidrs))
+	for name := range cidrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	assigned := make(map[string]string)
+	for _, value := range strings.Split(annotation, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("'%v' is not a valid ip", value)
+		}
+
+		found := false
+		for _, name := range names {
+			if cidrs[name].Contains(ip) {
+				if _, duplicate := assigned[name]; duplicate {
+					return nil, fmt.Errorf("ips '%v' and '%v' are both within failure domain '%v'", assigned[name], ip.String(), name)
+				}
+
+				assigned[name] = ip.String()
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("ip '%v' is not within any cidr of the egressipam", ip.String())
+		}
+	}
+
+	result := make([]v1alpha1.FailureDomainEgressIPSpec, 0, len(names))
+	for _, name := range names {
+		if len(assigned) > 0 {
+			if ip, found := assigned[name]; found {
+				result = append(result, v1alpha1.FailureDomainEgressIPSpec{FailureDomain: name, IP: ip})
+			}
+			continue
+		}
+
+		result = append(result, v1alpha1.FailureDomainEgressIPSpec{FailureDomain: name})
+	}
+
+	return result, nil
+}
+
+// Apply creates the failure domains and EgressIPs of the report and updates the adopting EgressIPs. The failure domains
+// are created first, so the EgressIPs pass the admission webhooks. Objects created meanwhile are skipped.
+func Apply(ctx context.Context, c client.Client, report *Report) error {
+	for i := range report.FailureDomains {
+		err := c.Create(ctx, report.FailureDomains[i].DeepCopy())
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failure domain '%v' could not be created: %v", report.FailureDomains[i].Name, err)
+		}
+	}
+
+	for i := range report.EgressIPs {
+		err := c.Create(ctx, report.EgressIPs[i].DeepCopy())
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("egressip '%v/%v' could not be created: %v", report.EgressIPs[i].Namespace, report.EgressIPs[i].Name, err)
+		}
+	}
+
+	for i := range report.Adopted {
+		err := c.Update(ctx, report.Adopted[i].DeepCopy())
+		if err != nil {
+			return fmt.Errorf("egressip '%v/%v' could not be updated: %v", report.Adopted[i].Namespace, report.Adopted[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Log writes the report to the log, one line per object.
+func (r *Report) Log(log logr.Logger) {
+	for _, failureDomain := range r.FailureDomains {
+		log.Info("failure domain to create", "failuredomain", failureDomain.Name,
+			"cidr", failureDomain.Spec.Cidr, "reserved", failureDomain.Spec.Reserved,
+			"migrated-from", failureDomain.Annotations[MigratedFromAnnotation],
+		)
+	}
+	for _, egressIP := range r.EgressIPs {
+		log.Info("egressip to create", "egressip", egressIP.Namespace+"/"+egressIP.Name, "ips", egressIP.Spec.IPs)
+	}
+	for _, egressIP := range r.Adopted {
+		log.Info("egressip to adopt ips", "egressip", egressIP.Namespace+"/"+egressIP.Name, "ips", egressIP.Spec.IPs)
+	}
+	for _, existing := range r.Existing {
+		log.Info("object already exists", "object", existing)
+	}
+	for _, problem := range r.Problems {
+		log.Info("can not be migrated", "problem", problem)
+	}
+
+	log.Info("migration report",
+		"failure-domains", len(r.FailureDomains), "egressips", len(r.EgressIPs), "adopting", len(r.Adopted),
+		"existing", len(r.Existing), "problems", len(r.Problems),
+	)
+}
+
+var _ manager.Runnable = &Migrator{}
+var _ manager.LeaderElectionRunnable = &Migrator{}
+
+// Migrator runs the migration and afterwards the adoption once at start of the manager.
+type Migrator struct {
+	Reader       client.Reader
+	Client       client.Client
+	Mode         string
+	AdoptionMode string
+
+	Log logr.Logger
+}
+
+// NewMigrator creates the migration and adoption configured via the environment. The reader should read directly from
+// the kubernetes api, the EgressIPAMs are not cached.
+func NewMigrator(reader client.Reader, c client.Client, logger logr.Logger) *Migrator {
+	return &Migrator{
+		Reader:       reader,
+		Client:       c,
+		Mode:         Mode,
+		AdoptionMode: AdoptionMode,
+		Log:          logger,
+	}
+}
+
+// NeedLeaderElection makes sure only the leading manager creates the objects.
+func (m *Migrator) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs the migration and the adoption. Failures are logged and do not stop the manager.
+func (m *Migrator) Start(_ <-chan struct{}) error {
+	m.run("migration", m.Mode, Plan)
+	m.run("adoption", m.AdoptionMode, PlanAdoption)
+
+	return nil
+}
+
+func (m *Migrator) run(name string, mode string, plan func(context.Context, client.Reader) (*Report, error)) {
+	if mode == ModeDisabled {
+		return
+	}
+
+	ctx := context.Background()
+	log := m.Log.WithValues("run", name, "mode", mode)
+
+	report, err := plan(ctx, m.Reader)
+	if err != nil {
+		log.Error(err, "could not be planned")
+		return
+	}
+	report.Log(log)
+
+	if mode != ModeApply {
+		return
+	}
+
+	err = Apply(ctx, m.Client, report)
+	if err != nil {
+		log.Error(err, "could not be applied")
+		return
+	}
+
+	log.Info("applied", "failure-domains", len(report.FailureDomains), "egressips", len(report.EgressIPs), "adopting", len(report.Adopted))
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migration_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/migration"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func egressIPAM() *unstructured.Unstructured {
+	result := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"topologyLabel": "topology.kubernetes.io/zone",
+			"nodeSelector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{"node-role.kubernetes.io/worker": ""},
+			},
+			"cidrAssignments": []interface{}{
+				map[string]interface{}{"labelValue": "eu-central-1a", "CIDR": "10.0.1.0/24", "reservedIPs": []interface{}{"10.0.1.5"}},
+				map[string]interface{}{"labelValue": "eu-central-1b", "CIDR": "10.0.2.0/24"},
+			},
+		},
+	}}
+	result.SetGroupVersionKind(migration.EgressIPAMKind)
+	result.SetName("egressipam-aws")
+
+	return result
+}
+
+func namespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func prepareClient(objects ...runtime.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = netv1.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+	_ = v1beta1.AddToScheme(scheme)
+	scheme.AddKnownTypeWithName(migration.EgressIPAMKind, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(migration.EgressIPAMKind.GroupVersion().WithKind("EgressIPAMList"), &unstructured.UnstructuredList{})
+
+	return fake.NewFakeClientWithScheme(scheme, objects...)
+}
+
+func TestPlanningMigration(t *testing.T) {
+	c := prepareClient(
+		egressIPAM(),
+		namespace("assigned", map[string]string{
+			migration.EgressIPAMAnnotation: "egressipam-aws",
+			migration.EgressIPsAnnotation:  "10.0.1.10,10.0.2.10",
+		}),
+		namespace("unassigned", map[string]string{migration.EgressIPAMAnnotation: "egressipam-aws"}),
+		namespace("outside", map[string]string{
+			migration.EgressIPAMAnnotation: "egressipam-aws",
+			migration.EgressIPsAnnotation:  "10.0.3.10",
+		}),
+		namespace("unknown", map[string]string{migration.EgressIPAMAnnotation: "egressipam-gcp"}),
+		namespace("plain", nil),
+	)
+
+	report, err := migration.Plan(context.Background(), c)
+	if err != nil {
+		t.Fatalf("Migration could not be planned: %v", err)
+	}
+
+	if len(report.FailureDomains) != 2 || report.FailureDomains[0].Name != "egressipam-aws-eu-central-1a" {
+		t.Fatalf("Wrong failure domains! expected='egressipam-aws-eu-central-1a' and 'egressipam-aws-eu-central-1b', current=%v", report.FailureDomains)
+	}
+	zoneA := report.FailureDomains[0]
+	if zoneA.Spec.Cidr != "10.0.1.0/24" || len(zoneA.Spec.Reserved) != 1 || len(zoneA.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions) != 2 {
+		t.Errorf("Wrong spec of failure domain! current=%v", zoneA.Spec)
+	}
+
+	if len(report.EgressIPs) != 2 {
+		t.Fatalf("Wrong egressips! expected='assigned' and 'unassigned', current=%v", report.EgressIPs)
+	}
+	assigned := report.EgressIPs[0]
+	if assigned.Namespace != "assigned" || len(assigned.Spec.IPs) != 2 || assigned.Spec.IPs[0].IP != "10.0.1.10" || assigned.Spec.IPs[1].IP != "10.0.2.10" {
+		t.Errorf("Assigned ips should be kept! current=%v", assigned.Spec.IPs)
+	}
+	if assigned.Annotations[openshift.AdoptedAnnotation] != "true" {
+		t.Errorf("Migrated egressip should adopt the assigned ips! annotations=%v", assigned.Annotations)
+	}
+	unassigned := report.EgressIPs[1]
+	if len(unassigned.Spec.IPs) != 2 || unassigned.Spec.IPs[0].IP != "" {
+		t.Errorf("Namespace without ips should get random ips in all failure domains! current=%v", unassigned.Spec.IPs)
+	}
+
+	if len(report.Problems) != 2 {
+		t.Errorf("Namespaces 'outside' and 'unknown' should be reported! current=%v", report.Problems)
+	}
+}
+
+func TestApplyingMigration(t *testing.T) {
+	c := prepareClient(
+		egressIPAM(),
+		namespace("assigned", map[string]string{
+			migration.EgressIPAMAnnotation: "egressipam-aws",
+			migration.EgressIPsAnnotation:  "10.0.1.10",
+		}),
+		&v1beta1.ClusterEgressIPFailureDomain{ObjectMeta: metav1.ObjectMeta{Name: "egressipam-aws-eu-central-1a"}},
+	)
+
+	report, err := migration.Plan(context.Background(), c)
+	if err != nil {
+		t.Fatalf("Migration could not be planned: %v", err)
+	}
+	if len(report.FailureDomains) != 1 || len(report.Existing) != 1 {
+		t.Errorf("Existing failure domain should be skipped! created=%v, existing=%v", report.FailureDomains, report.Existing)
+	}
+
+	err = migration.Apply(context.Background(), c, report)
+	if err != nil {
+		t.Fatalf("Migration could not be applied: %v", err)
+	}
+
+	egressIP := &v1alpha1.EgressIP{}
+	err = c.Get(context.Background(), types.NamespacedName{Namespace: "assigned", Name: "egressipam-aws"}, egressIP)
+	if err != nil || egressIP.Annotations[migration.MigratedFromAnnotation] != "egressipam/egressipam-aws" {
+		t.Errorf("EgressIP has not been created! error=%v, current=%v", err, egressIP)
+	}
+
+	report, _ = migration.Plan(context.Background(), c)
+	if len(report.FailureDomains) != 0 || len(report.EgressIPs) != 0 {
+		t.Errorf("Migrated objects should not be created again! current=%v", report)
+	}
+}
+
+func TestPlanningWithoutEgressIPAMs(t *testing.T) {
+	c := prepareClient(namespace("plain", nil))
+
+	report, err := migration.Plan(context.Background(), c)
+	if err != nil || len(report.FailureDomains) != 0 || len(report.EgressIPs) != 0 {
+		t.Errorf("Cluster without egressipams should have nothing to migrate! error=%v, current=%v", err, report)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const DefaultFailbackHoldDown = 5 * time.Minute
+
+// FailbackHoldDown is the time a host preferred by the node affinity has to be ready before IPs move back to it. It
+// keeps the IPs from flapping between hosts recovering and failing again.
+var FailbackHoldDown time.Duration
+
+func init() {
+	FailbackHoldDown = DefaultFailbackHoldDown
+	holdDown, found := os.LookupEnv("FAILBACK_HOLD_DOWN")
+	if found {
+		value, err := time.ParseDuration(holdDown)
+		if err == nil {
+			FailbackHoldDown = value
+		}
+	}
+}
+
+// failBack moves the assigned IP to the host chosen for the node affinity if the current host does not match its
+// required terms any more or the chosen host is preferred over the current one. Moves to preferred hosts wait until the
+// host has been ready for the hold-down time, the remaining time is returned then. Without an eligible host the IP
+// stays where it is.
+func failBack(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, spec v1alpha1.FailureDomainEgressIPSpec, assigned *v1alpha1.AssignedEgressIP, log logr.Logger) (time.Duration, error) {
+	if spec.NodeAffinity == nil {
+		return 0, nil
+	}
+
+	current := &corev1.Node{}
+	err := client.Get(ctx, types.NamespacedName{Name: assigned.HostName}, current)
+	if err != nil {
+		return 0, err
+	}
+
+	required := failuredomain.MatchesRequiredAffinity(current, spec.NodeAffinity)
+	if required && !failuredomain.HasPreferences(spec.NodeAffinity) {
+		return 0, nil
+	}
+
+	newHostName, err := provisioner.FindHostForNewIP(ctx, assigned.FailureDomain, spec.NodeAffinity, nil)
+	if err != nil {
+		log.Info("no host matches the node affinity - the ip stays on its host", "failure-domain", assigned.FailureDomain, "ip", assigned.IP, "host", assigned.HostName, "error", err.Error())
+		return 0, nil
+	}
+	if newHostName == assigned.HostName {
+		return 0, nil
+	}
+
+	if required {
+		target := &corev1.Node{}
+		err = client.Get(ctx, types.NamespacedName{Name: newHostName}, target)
+		if err != nil {
+			return 0, err
+		}
+
+		if failuredomain.PreferenceOf(target, spec.NodeAffinity) <= failuredomain.PreferenceOf(current, spec.NodeAffinity) {
+			return 0, nil
+		}
+
+		remaining := FailbackHoldDown - time.Since(nodeReadySince(target))
+		if remaining > 0 {
+			log.Info("preferred host is held down", "failure-domain", assigned.FailureDomain, "ip", assigned.IP, "host", newHostName, "remaining", remaining.String())
+			return remaining, nil
+		}
+	}
+
+	return 0, moveIP(ctx, client, provisioner, recorder, instance, assigned, newHostName, HistoryReasonAffinity, log)
+}
+
+// nodeReadySince returns the time the node became ready. For nodes not ready the current time is returned.
+func nodeReadySince(node *corev1.Node) time.Time {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time
+		}
+	}
+
+	return time.Now()
+}
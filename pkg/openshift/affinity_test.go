@@ -0,0 +1,129 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+	"time"
+)
+
+// prepareAffinity prepares the EgressIP 'tenant/egress' with the IP 10.0.1.10 assigned to 'node-b' and the node affinity.
+// The node 'node-a' is labeled 'bandwidth=high' and has been ready since the given time.
+func prepareAffinity(affinity *corev1.NodeAffinity, readySince time.Time) client.Client {
+	preferred := node("node-a", "lifecycle-a", "10.0.1.5", corev1.ConditionTrue)
+	preferred.Labels["bandwidth"] = "high"
+	preferred.Status.Conditions[0].LastTransitionTime = metav1.NewTime(readySince)
+
+	return prepareClient(
+		failureDomain("lifecycle-a", "10.0.1.0/24"),
+		preferred,
+		node("node-b", "lifecycle-a", "10.0.1.6", corev1.ConditionTrue),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}},
+		&netv1.NetNamespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}, NetName: "tenant"},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: egressIPName.Name, Namespace: egressIPName.Namespace, Finalizers: []string{openshift.EgressIPFinalizer}},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.10", NodeAffinity: affinity}},
+			},
+			Status: v1alpha1.EgressIPStatus{
+				IPs: []v1alpha1.AssignedEgressIP{{FailureDomain: "lifecycle-a", IP: "10.0.1.10", HostName: "node-b"}},
+			},
+		},
+	)
+}
+
+func bandwidthTerm() corev1.NodeSelectorTerm {
+	return corev1.NodeSelectorTerm{
+		MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "bandwidth", Operator: corev1.NodeSelectorOpIn, Values: []string{"high"}}},
+	}
+}
+
+func preferredAffinity() *corev1.NodeAffinity {
+	return &corev1.NodeAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{{Weight: 10, Preference: bandwidthTerm()}},
+	}
+}
+
+func reconcileAffinity(t *testing.T, c client.Client, provisioner *hostIPs) (ctrl.Result, *v1alpha1.EgressIP) {
+	result, err := openshift.ManageEgressIP(
+		context.Background(),
+		ctrl.Request{NamespacedName: egressIPName},
+		c, provisioner, *metrics.NewAlarmStore(log), record.NewFakeRecorder(10), log,
+	)
+	if err != nil {
+		t.Fatalf("EgressIP could not be reconciled: %v", err)
+	}
+
+	instance := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), egressIPName, instance)
+	return result, instance
+}
+
+func TestFailingBackToPreferredHost(t *testing.T) {
+	c := prepareAffinity(preferredAffinity(), time.Now().Add(-time.Hour))
+	provisioner := &hostIPs{ips: map[string][]string{"node-b": {"10.0.1.10"}}, target: "node-a"}
+
+	_, instance := reconcileAffinity(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].HostName != "node-a" {
+		t.Errorf("IP should move back to the preferred host 'node-a'! current=%v", instance.Status.IPs)
+	}
+	if len(provisioner.ips["node-a"]) != 1 || len(provisioner.ips["node-b"]) != 0 {
+		t.Errorf("IP has not been moved on the hosts! current=%v", provisioner.ips)
+	}
+	if len(instance.Status.History) != 1 || instance.Status.History[0].Reason != openshift.HistoryReasonAffinity {
+		t.Errorf("Move should be recorded with reason '%v'! current=%v", openshift.HistoryReasonAffinity, instance.Status.History)
+	}
+}
+
+func TestHoldingDownPreferredHost(t *testing.T) {
+	c := prepareAffinity(preferredAffinity(), time.Now().Add(-time.Minute))
+	provisioner := &hostIPs{ips: map[string][]string{"node-b": {"10.0.1.10"}}, target: "node-a"}
+
+	result, instance := reconcileAffinity(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].HostName != "node-b" {
+		t.Errorf("IP should stay on 'node-b' during the hold-down! current=%v", instance.Status.IPs)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > openshift.FailbackHoldDown {
+		t.Errorf("EgressIP should be re-queued at the end of the hold-down! current=%v", result.RequeueAfter)
+	}
+}
+
+func TestMovingIPViolatingRequiredAffinity(t *testing.T) {
+	affinity := &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{bandwidthTerm()}},
+	}
+	c := prepareAffinity(affinity, time.Now())
+	provisioner := &hostIPs{ips: map[string][]string{"node-b": {"10.0.1.10"}}, target: "node-a"}
+
+	_, instance := reconcileAffinity(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].HostName != "node-a" {
+		t.Errorf("IP on a host not matching the required affinity should move without hold-down! current=%v", instance.Status.IPs)
+	}
+}
@@ -0,0 +1,392 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"net"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"time"
+)
+
+// ManageEgressIPClaim binds the claim to an EgressIP of the same name owned by the claim. The EgressIP gets an IP in as
+// many failure domains of the pool as requested and the assigned IPs are copied to the status of the claim. Deleting
+// the claim deletes the EgressIP, which releases the IPs. Claims exceeding the policies of their namespace fail.
+func ManageEgressIPClaim(ctx context.Context, req ctrl.Request, client client.Client, recorder record.EventRecorder, baseLogger logr.Logger) (ctrl.Result, error) {
+	log := baseLogger.WithValues("egressipclaim", req.NamespacedName)
+
+	instance := &v1alpha1.EgressIPClaim{}
+	err := client.Get(ctx, req.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("egressIPClaim not found - the request will not be re-queued")
+
+			return ctrl.Result{
+				Requeue: false,
+			}, nil
+		}
+
+		log.Info("egressIPClaim could not be loaded - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	if !instance.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	pool, err := findPool(ctx, client, instance.Spec.ClassName)
+	if err != nil {
+		return failClaim(ctx, client, recorder, instance, err, log)
+	}
+
+	policies, err := policy.PoliciesOf(ctx, client, instance.Namespace)
+	if err != nil {
+		log.Info("policies of the claim could not be loaded - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	err = policy.AllowsPool(policies, instance.Namespace, pool.Name)
+	if err != nil {
+		return failClaim(ctx, client, recorder, instance, err, log)
+	}
+
+	egressIP := &v1alpha1.EgressIP{}
+	err = client.Get(ctx, req.NamespacedName, egressIP)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Info("egressIP of the claim could not be loaded - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+	exists := err == nil
+
+	if exists && !metav1.IsControlledBy(egressIP, instance) {
+		return failClaim(ctx, client, recorder, instance,
+			fmt.Errorf("egressip '%v' already exists and is not bound to the claim", egressIP.Name), log,
+		)
+	}
+
+	ips, err := claimedIPs(ctx, client, instance, pool, policies, egressIP.Spec.IPs)
+	if err != nil {
+		return failClaim(ctx, client, recorder, instance, err, log)
+	}
+
+	err = checkClaimPolicies(ctx, client, instance, policies, ips)
+	if err != nil {
+		return failClaim(ctx, client, recorder, instance, err, log)
+	}
+
+	if !exists {
+		egressIP = &v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            instance.Name,
+				Namespace:       instance.Namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(instance, v1alpha1.GroupVersion.WithKind("EgressIPClaim"))},
+			},
+			Spec: v1alpha1.EgressIPSpec{IPs: ips, ReclaimPolicy: instance.Spec.ReclaimPolicy},
+		}
+
+		err = client.Create(ctx, egressIP)
+		if err != nil {
+			log.Info("egressIP of the claim could not be created - the request will be re-queued in 30 seconds")
+			return ctrl.Result{
+				RequeueAfter: 30 * time.Second,
+			}, err
+		}
+
+		log.Info("created egressip for claim", "pool", pool.Name, "ips", ips)
+	} else if !equality.Semantic.DeepEqual(egressIP.Spec.IPs, ips) || egressIP.Spec.ReclaimPolicy != instance.Spec.ReclaimPolicy {
+		egressIP.Spec.IPs = ips
+		egressIP.Spec.ReclaimPolicy = instance.Spec.ReclaimPolicy
+
+		err = client.Update(ctx, egressIP)
+		if err != nil {
+			log.Info("egressIP of the claim could not be updated - the request will be re-queued in 30 seconds")
+			return ctrl.Result{
+				RequeueAfter: 30 * time.Second,
+			}, err
+		}
+
+		log.Info("updated egressip for claim", "pool", pool.Name, "ips", ips)
+	}
+
+	phase, message := claimPhase(egressIP, len(ips))
+	if phase == v1alpha1.ClaimPhaseBound && instance.Status.Phase != v1alpha1.ClaimPhaseBound {
+		recorder.Event(instance, corev1.EventTypeNormal, EventReasonBound,
+			fmt.Sprintf("claim bound to %v ips of pool '%v'", len(ips), pool.Name),
+		)
+	}
+
+	instance.Status.Phase = phase
+	instance.Status.Message = message
+	instance.Status.ClassName = pool.Name
+	instance.Status.IPs = assignedIPs(egressIP)
+
+	err = client.Status().Update(ctx, instance)
+	if err != nil {
+		log.Info("status of the claim could not be updated - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// failClaim marks the claim as failed. The claim is reconciled again when the pools, failure domains or its EgressIP
+// change, so the request is not re-queued.
+func failClaim(ctx context.Context, client client.Client, recorder record.EventRecorder, instance *v1alpha1.EgressIPClaim, cause error, log logr.Logger) (ctrl.Result, error) {
+	log.Info("claim could not be bound", "error", cause.Error())
+
+	if instance.Status.Phase != v1alpha1.ClaimPhaseFailed || instance.Status.Message != cause.Error() {
+		recorder.Event(instance, corev1.EventTypeWarning, EventReasonBindingFailed, cause.Error())
+	}
+
+	instance.Status.Phase = v1alpha1.ClaimPhaseFailed
+	instance.Status.Message = cause.Error()
+
+	err := client.Status().Update(ctx, instance)
+	if err != nil {
+		log.Info("status of the claim could not be updated - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// claimPhase derives the phase of the claim from its EgressIP.
+func claimPhase(egressIP *v1alpha1.EgressIP, count int) (string, string) {
+	switch {
+	case egressIP.Status.Phase == "failed":
+		return v1alpha1.ClaimPhaseFailed, "egress ips could not be provisioned"
+	case egressIP.Status.Phase == "provisioned" && len(egressIP.Status.IPs) >= count:
+		return v1alpha1.ClaimPhaseBound, ""
+	default:
+		return v1alpha1.ClaimPhasePending, "waiting for the egress ips to be provisioned"
+	}
+}
+
+// checkClaimPolicies returns why the IPs of the claim would exceed the policies of its namespace, or nil if they are
+// within all policies.
+func checkClaimPolicies(ctx context.Context, client client.Client, instance *v1alpha1.EgressIPClaim, policies []v1alpha1.EgressIPPolicy, ips []v1alpha1.FailureDomainEgressIPSpec) error {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	egressIPs := &v1alpha1.EgressIPList{}
+	err := client.List(ctx, egressIPs)
+	if err != nil {
+		return err
+	}
+
+	claimed := &v1alpha1.EgressIP{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name, Namespace: instance.Namespace},
+		Spec:       v1alpha1.EgressIPSpec{IPs: ips},
+	}
+	violations := policy.Violations(policies, instance.Namespace, policy.SpecUsage(egressIPs.Items, claimed))
+	if len(violations) > 0 {
+		return fmt.Errorf("%v", violations[0])
+	}
+
+	return nil
+}
+
+// findPool returns the pool with the class name or the default pool without class name.
+func findPool(ctx context.Context, client client.Client, className string) (*v1alpha1.EgressIPPool, error) {
+	if className != "" {
+		result := &v1alpha1.EgressIPPool{}
+		err := client.Get(ctx, types.NamespacedName{Name: className}, result)
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("pool '%v' is not defined", className)
+		}
+
+		return result, err
+	}
+
+	pools := &v1alpha1.EgressIPPoolList{}
+	err := client.List(ctx, pools)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *v1alpha1.EgressIPPool
+	for i, pool := range pools.Items {
+		if pool.Annotations[v1alpha1.DefaultPoolAnnotation] != "true" {
+			continue
+		}
+		if result != nil {
+			return nil, fmt.Errorf("pools '%v' and '%v' are both marked as default", result.Name, pool.Name)
+		}
+
+		result = &pools.Items[i]
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("no pool is marked as default")
+	}
+
+	return result, nil
+}
+
+// poolCandidate is a failure domain of a pool with the part of its address space within the CIDRs of the pool. The
+// address space is nil for pools without CIDRs.
+type poolCandidate struct {
+	failureDomain *v1beta1.ClusterEgressIPFailureDomain
+	space         *failuredomain.AddressSpace
+	free          int64
+}
+
+// claimedIPs returns the IPs of the EgressIP satisfying the claim. The IPs of failure domains still in the pool are kept
+// and the missing ones are taken from the failure domains with the most free addresses. Pools with CIDRs get the first
+// free IP of these networks, otherwise a random IP is left to the provisioner. Failure domains not allowed by the
+// policies are no candidates.
+func claimedIPs(ctx context.Context, client client.Client, instance *v1alpha1.EgressIPClaim, pool *v1alpha1.EgressIPPool, policies []v1alpha1.EgressIPPolicy, current []v1alpha1.FailureDomainEgressIPSpec) ([]v1alpha1.FailureDomainEgressIPSpec, error) {
+	all, err := candidatesOfPool(ctx, client, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]poolCandidate, 0, len(all))
+	for _, candidate := range all {
+		if policy.AllowsFailureDomain(policies, candidate.failureDomain.Name) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	count := instance.Spec.Count
+	if count < 1 {
+		count = 1
+	}
+	if instance.Spec.OnePerFailureDomain {
+		count = len(candidates)
+	}
+	if count == 0 || count > len(candidates) {
+		return nil, fmt.Errorf("pool '%v' has %v usable failure domains but %v ips are claimed", pool.Name, len(candidates), count)
+	}
+
+	result := make([]v1alpha1.FailureDomainEgressIPSpec, 0, count)
+	kept := make(map[string]bool)
+	for _, spec := range current {
+		for _, candidate := range candidates {
+			if candidate.failureDomain.Name == spec.FailureDomain && len(result) < count {
+				result = append(result, spec)
+				kept[spec.FailureDomain] = true
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		if len(result) >= count {
+			break
+		}
+		if kept[candidate.failureDomain.Name] {
+			continue
+		}
+
+		spec := v1alpha1.FailureDomainEgressIPSpec{FailureDomain: candidate.failureDomain.Name}
+		if candidate.space != nil {
+			ip, err := pickRandomIP(ctx, client, candidate.failureDomain, candidate.space)
+			if err != nil {
+				return nil, err
+			}
+			spec.IP = ip.String()
+		}
+
+		result = append(result, spec)
+	}
+
+	return result, nil
+}
+
+// candidatesOfPool returns the failure domains of the pool sorted by their free addresses of the last reconciliation,
+// the most free first. Failure domains outside of all CIDRs of the pool are left out.
+func candidatesOfPool(ctx context.Context, client client.Client, pool *v1alpha1.EgressIPPool) ([]poolCandidate, error) {
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err := client.List(ctx, failureDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]bool)
+	for _, name := range pool.Spec.FailureDomains {
+		listed[name] = true
+	}
+
+	result := make([]poolCandidate, 0)
+	for i, failureDomain := range failureDomains.Items {
+		if len(listed) > 0 && !listed[failureDomain.Name] {
+			continue
+		}
+
+		candidate := poolCandidate{failureDomain: &failureDomains.Items[i]}
+		capacity, _ := CapacityOfFailureDomain(types.NamespacedName{Name: failureDomain.Name})
+		candidate.free = capacity.Free
+
+		if len(pool.Spec.Cidrs) == 0 {
+			result = append(result, candidate)
+			continue
+		}
+
+		space, err := failuredomain.NewAddressSpace(candidate.failureDomain)
+		if err != nil {
+			continue
+		}
+
+		for _, value := range pool.Spec.Cidrs {
+			_, cidr, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, fmt.Errorf("cidr '%v' of pool '%v' is not valid", value, pool.Name)
+			}
+
+			within := space.Within(cidr)
+			if within.Size() > 0 {
+				candidate.space = within
+				result = append(result, candidate)
+				break
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].free != result[j].free {
+			return result[i].free > result[j].free
+		}
+		return result[i].failureDomain.Name < result[j].failureDomain.Name
+	})
+
+	return result, nil
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+var claimName = types.NamespacedName{Namespace: "tenant", Name: "claim"}
+
+func defaultPool(spec v1alpha1.EgressIPPoolSpec) *v1alpha1.EgressIPPool {
+	return &v1alpha1.EgressIPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "standard",
+			Annotations: map[string]string{v1alpha1.DefaultPoolAnnotation: "true"},
+		},
+		Spec: spec,
+	}
+}
+
+func prepareClaim(spec v1alpha1.EgressIPClaimSpec, objects ...runtime.Object) client.Client {
+	return prepareClient(append([]runtime.Object{
+		failureDomain("claim-a", "10.0.1.0/24"),
+		failureDomain("claim-b", "10.0.2.0/24"),
+		failureDomain("claim-c", "10.0.3.0/24"),
+		node("node-a", "claim-a", "10.0.1.5", corev1.ConditionTrue),
+		&v1alpha1.EgressIPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: claimName.Name, Namespace: claimName.Namespace},
+			Spec:       spec,
+		},
+	}, objects...)...)
+}
+
+func reconcileClaim(t *testing.T, c client.Client) (*v1alpha1.EgressIPClaim, *v1alpha1.EgressIP) {
+	_, err := openshift.ManageEgressIPClaim(context.Background(), ctrl.Request{NamespacedName: claimName}, c, record.NewFakeRecorder(10), log)
+	if err != nil {
+		t.Fatalf("EgressIPClaim could not be reconciled: %v", err)
+	}
+
+	claim := &v1alpha1.EgressIPClaim{}
+	_ = c.Get(context.Background(), claimName, claim)
+
+	egressIP := &v1alpha1.EgressIP{}
+	err = c.Get(context.Background(), claimName, egressIP)
+	if err != nil {
+		return claim, nil
+	}
+
+	return claim, egressIP
+}
+
+func TestBindingClaimToDefaultPool(t *testing.T) {
+	c := prepareClaim(v1alpha1.EgressIPClaimSpec{Count: 2}, defaultPool(v1alpha1.EgressIPPoolSpec{
+		FailureDomains: []string{"claim-b", "claim-c"},
+	}))
+
+	claim, egressIP := reconcileClaim(t, c)
+
+	if egressIP == nil {
+		t.Fatalf("EgressIP of the claim has not been created!")
+	}
+	if !metav1.IsControlledBy(egressIP, claim) {
+		t.Errorf("EgressIP should be owned by the claim! owners=%v", egressIP.OwnerReferences)
+	}
+	if len(egressIP.Spec.IPs) != 2 || egressIP.Spec.IPs[0].FailureDomain != "claim-b" || egressIP.Spec.IPs[1].FailureDomain != "claim-c" {
+		t.Errorf("EgressIP should get an ip in 'claim-b' and 'claim-c'! current=%v", egressIP.Spec.IPs)
+	}
+	if claim.Status.Phase != v1alpha1.ClaimPhasePending || claim.Status.ClassName != "standard" {
+		t.Errorf("Claim should be pending with the default pool! current=%v", claim.Status)
+	}
+
+	egressIP.Status.Phase = "provisioned"
+	egressIP.Status.IPs = []v1alpha1.AssignedEgressIP{
+		{FailureDomain: "claim-b", IP: "10.0.2.10", HostName: "node-b"},
+		{FailureDomain: "claim-c", IP: "10.0.3.10", HostName: "node-c"},
+	}
+	_ = c.Status().Update(context.Background(), egressIP)
+
+	claim, _ = reconcileClaim(t, c)
+
+	if claim.Status.Phase != v1alpha1.ClaimPhaseBound || len(claim.Status.IPs) != 2 || claim.Status.IPs[0] != "10.0.2.10" {
+		t.Errorf("Claim should be bound to the assigned ips! current=%v", claim.Status)
+	}
+}
+
+func TestBindingClaimToOneIPPerFailureDomain(t *testing.T) {
+	c := prepareClaim(v1alpha1.EgressIPClaimSpec{ClassName: "standard", OnePerFailureDomain: true}, defaultPool(v1alpha1.EgressIPPoolSpec{}))
+
+	_, egressIP := reconcileClaim(t, c)
+
+	if egressIP == nil || len(egressIP.Spec.IPs) != 3 {
+		t.Errorf("EgressIP should get an ip in every failure domain! current=%v", egressIP)
+	}
+}
+
+func TestBindingClaimToCidrsOfPool(t *testing.T) {
+	c := prepareClaim(v1alpha1.EgressIPClaimSpec{}, defaultPool(v1alpha1.EgressIPPoolSpec{
+		Cidrs: []string{"10.0.1.0/29"},
+	}))
+
+	_, egressIP := reconcileClaim(t, c)
+
+	// 10.0.1.5 is the address of node-a
+	if egressIP == nil || len(egressIP.Spec.IPs) != 1 || egressIP.Spec.IPs[0].FailureDomain != "claim-a" || egressIP.Spec.IPs[0].IP != "10.0.1.1" {
+		t.Errorf("EgressIP should get the first ip of the cidr of the pool! current=%v", egressIP)
+	}
+}
+
+func TestFailingClaims(t *testing.T) {
+	foreign := &v1alpha1.EgressIP{ObjectMeta: metav1.ObjectMeta{Name: claimName.Name, Namespace: claimName.Namespace}}
+	restricted := &v1alpha1.EgressIPPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "premium-only"},
+		Spec:       v1alpha1.EgressIPPolicySpec{AllowedPools: []string{"premium"}},
+	}
+
+	tests := []struct {
+		name    string
+		spec    v1alpha1.EgressIPClaimSpec
+		objects []runtime.Object
+	}{
+		{"without default pool", v1alpha1.EgressIPClaimSpec{}, []runtime.Object{}},
+		{"with unknown pool", v1alpha1.EgressIPClaimSpec{ClassName: "premium"}, []runtime.Object{defaultPool(v1alpha1.EgressIPPoolSpec{})}},
+		{"with too many ips", v1alpha1.EgressIPClaimSpec{Count: 4}, []runtime.Object{defaultPool(v1alpha1.EgressIPPoolSpec{})}},
+		{"with foreign egressip", v1alpha1.EgressIPClaimSpec{}, []runtime.Object{defaultPool(v1alpha1.EgressIPPoolSpec{}), foreign}},
+		{"with pool not allowed", v1alpha1.EgressIPClaimSpec{}, []runtime.Object{defaultPool(v1alpha1.EgressIPPoolSpec{}), restricted}},
+		{"with quota exceeded", v1alpha1.EgressIPClaimSpec{Count: 2}, []runtime.Object{defaultPool(v1alpha1.EgressIPPoolSpec{}), quotaPolicy(1)}},
+	}
+
+	for _, test := range tests {
+		c := prepareClaim(test.spec, test.objects...)
+
+		claim, _ := reconcileClaim(t, c)
+		if claim.Status.Phase != v1alpha1.ClaimPhaseFailed || claim.Status.Message == "" {
+			t.Errorf("Claim %v should fail! current=%v", test.name, claim.Status)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SetFailureDomainCondition adds or replaces the condition of the same type. The transition time is only changed when
+// the status of the condition changes.
+func SetFailureDomainCondition(status *v1beta1.ClusterEgressIPFailureDomainStatus, condition v1beta1.FailureDomainCondition) {
+	for i, existing := range status.Conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		} else {
+			condition.LastTransitionTime = metav1.Now()
+		}
+
+		status.Conditions[i] = condition
+		return
+	}
+
+	condition.LastTransitionTime = metav1.Now()
+	status.Conditions = append(status.Conditions, condition)
+}
@@ -18,15 +18,48 @@ package openshift
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/policy"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+	netv1 "github.com/openshift/api/network/v1"
+	"go.opentelemetry.io/otel/label"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"net"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+	"time"
 )
 
-func ManageEgressIP(req ctrl.Request, client client.Client, baseLogger logr.Logger) (ctrl.Result, error) {
-	ctx := context.Background()
+// RandomIPAttempts is the number of random IPs requested from the provisioner before giving up if all of them are
+// quarantined.
+const RandomIPAttempts = 5
+
+// EgressIPFinalizer makes sure the IPs of an EgressIP are released before it is deleted.
+const EgressIPFinalizer = "egressip.kaiserpfalz-edv.de/release-ips"
+
+const (
+	// AlarmAcknowledgedAnnotation acknowledges the alarms of the EgressIP that occurred first before the given RFC3339
+	// time stamp.
+	AlarmAcknowledgedAnnotation = "egressip.kaiserpfalz-edv.de/alarm-acknowledged"
+	// AlarmSilencedAnnotation silences all alarms of the EgressIP while set to "true".
+	AlarmSilencedAnnotation = "egressip.kaiserpfalz-edv.de/alarm-silenced"
+	// AdoptedAnnotation marks EgressIPs created for IPs already served by hosts while set to "true". Their specified
+	// IPs are taken over from the hosts serving them instead of being allocated again.
+	AdoptedAnnotation = "egressip.kaiserpfalz-edv.de/adopted"
+)
+
+func ManageEgressIP(ctx context.Context, req ctrl.Request, client client.Client, provisioner provisioner.EgressIPProvisioner, alarms metrics.AlarmStore, recorder record.EventRecorder, baseLogger logr.Logger) (ctrl.Result, error) {
 	log := baseLogger.WithValues("egressip", req.NamespacedName)
 
 	instance := &v1alpha1.EgressIP{}
@@ -37,17 +70,770 @@ func ManageEgressIP(req ctrl.Request, client client.Client, baseLogger logr.Logg
 
 			return ctrl.Result{
 				Requeue: false,
-			}, err
+			}, nil
 		}
 
 		log.Info("egressIP could not be loaded - the request will be re-queued in 30 seconds")
 		return ctrl.Result{
-			RequeueAfter: 30,
+			RequeueAfter: 30 * time.Second,
 		}, err
 	}
 
-	// TODO 2020-09-20 rlichti replace this logging by real working code
-	log.Info("working on", "egressip", instance)
+	if !instance.DeletionTimestamp.IsZero() {
+		return releaseEgressIP(ctx, client, provisioner, alarms, recorder, instance, log)
+	}
+
+	changed, err := failuredomain.ExpandToAllFailureDomains(ctx, client, instance)
+	if err != nil {
+		log.Info("failure domains could not be listed - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	if !containsString(instance.Finalizers, EgressIPFinalizer) {
+		instance.Finalizers = append(instance.Finalizers, EgressIPFinalizer)
+		changed = true
+	}
+
+	if changed {
+		err = client.Update(ctx, instance)
+		if err != nil {
+			log.Info("finalizer or failure domains could not be added - the request will be re-queued in 30 seconds")
+			return ctrl.Result{
+				RequeueAfter: 30 * time.Second,
+			}, err
+		}
+	}
+
+	failures, failback := assignIPs(ctx, client, provisioner, recorder, instance, log)
+
+	err = updateNetNamespace(ctx, client, instance, assignedIPs(instance))
+	if err != nil {
+		log.Info("egress ips of netnamespace could not be updated", "error", err.Error())
+		for _, ip := range instance.Status.IPs {
+			failures = append(failures, failedIP(ip.FailureDomain, ip.IP))
+		}
+	}
+
+	acknowledged, silenced := alarmStateOf(instance, log)
+	alarms.SetAlarmState(instance.Namespace, instance.Name, acknowledged, silenced)
+
+	if len(failures) > 0 {
+		alarms.AddEgressIPAlarm(instance.Namespace, instance.Name, failures)
+
+		instance.Status.Phase = "failed"
+		instance.Status.Message = fmt.Sprintf("%v of %v ips could not be provisioned", len(failures), len(instance.Spec.IPs))
+	} else {
+		alarms.RemoveEgressIPAlarm(instance.Namespace, instance.Name)
+
+		instance.Status.Phase = "provisioned"
+		instance.Status.Message = fmt.Sprintf("%v ips are provisioned", len(instance.Status.IPs))
+	}
+
+	instance.Status.IP = v1alpha1.FailureDomainEgressIPSpec{}
+	instance.Status.HostName = ""
+	if len(instance.Status.IPs) > 0 {
+		instance.Status.IP.FailureDomain = instance.Status.IPs[0].FailureDomain
+		instance.Status.IP.IP = instance.Status.IPs[0].IP
+		instance.Status.HostName = instance.Status.IPs[0].HostName
+	}
+
+	err = client.Status().Update(ctx, instance)
+	if err != nil {
+		log.Info("status could not be updated - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	if len(failures) > 0 {
+		return ctrl.Result{}, fmt.Errorf("%v ips of egressip '%v' could not be provisioned", len(failures), req.NamespacedName)
+	}
+
+	return ctrl.Result{
+		RequeueAfter: failback,
+	}, nil
+}
+
+// assignIPs releases the IPs no longer specified, checks the assigned IPs and assigns the missing ones. The status of
+// the instance reflects the assigned IPs afterwards. Missing IPs exceeding the policies of the namespace are not
+// assigned. The time until the first IP waiting for its preferred host may move back is returned, too.
+func assignIPs(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, log logr.Logger) ([]metrics.FailedIP, time.Duration) {
+	failures := make([]metrics.FailedIP, 0)
+	assigned := make([]v1alpha1.AssignedEgressIP, 0)
+	var failback time.Duration
+
+	quota, err := policy.NewQuota(ctx, client, instance)
+	if err != nil {
+		log.Info("policies could not be loaded", "error", err.Error())
+		for _, spec := range instance.Spec.IPs {
+			if indexOfFailureDomain(instance.Status.IPs, spec.FailureDomain) < 0 {
+				failures = append(failures, failedIP(spec.FailureDomain, spec.IP))
+			}
+		}
+		return failures, 0
+	}
+
+	unspecified := make([]v1alpha1.AssignedEgressIP, 0)
+	for _, current := range instance.Status.IPs {
+		if isSpecified(instance.Spec.IPs, current) {
+			assigned = append(assigned, current)
+		} else {
+			unspecified = append(unspecified, current)
+		}
+	}
+
+	for _, current := range releaseIPs(ctx, client, provisioner, recorder, instance, unspecified, HistoryReasonUnspecified, specActor(instance), log) {
+		failures = append(failures, failedIP(current.FailureDomain, current.IP))
+		assigned = append(assigned, current)
+	}
+
+	// the failed hosts are evacuated in place, so the moves are reflected in assigned
+	instance.Status.IPs = assigned
+	moveFailures := evacuateFailedHosts(ctx, client, provisioner, recorder, instance, log)
+
+	for _, spec := range instance.Spec.IPs {
+		if index := indexOfFailureDomain(assigned, spec.FailureDomain); index >= 0 {
+			if err, failed := moveFailures[assigned[index].IP]; failed {
+				log.Info("ip of failed host could not be moved", "failure-domain", spec.FailureDomain, "ip", assigned[index].IP, "error", err.Error())
+				failures = append(failures, failedIP(spec.FailureDomain, assigned[index].IP))
+				quota.Add(spec.FailureDomain)
+				continue
+			}
+
+			ipCtx, span := tracing.Start(ctx, "EgressIP.CheckIP", ipAttributes(spec.FailureDomain, assigned[index].IP, assigned[index].HostName)...)
+			err := checkAssignedIP(ipCtx, client, provisioner, recorder, instance, &assigned[index], log)
+			if err == nil {
+				var remaining time.Duration
+				remaining, err = failBack(ipCtx, client, provisioner, recorder, instance, spec, &assigned[index], log)
+				if remaining > 0 && (failback == 0 || remaining < failback) {
+					failback = remaining
+				}
+			}
+			span.SetAttributes(tracing.TargetHostKey.String(assigned[index].HostName))
+			tracing.End(span, err)
+			if err != nil {
+				log.Info("ip could not be verified", "failure-domain", spec.FailureDomain, "ip", assigned[index].IP, "error", err.Error())
+				failures = append(failures, failedIP(spec.FailureDomain, assigned[index].IP))
+			}
+			quota.Add(spec.FailureDomain)
+			continue
+		}
+
+		if spec.IP != "" && instance.Annotations[AdoptedAnnotation] == "true" {
+			ipCtx, span := tracing.Start(ctx, "EgressIP.AdoptIP", ipAttributes(spec.FailureDomain, spec.IP, "")...)
+			result, err := adoptIP(ipCtx, client, provisioner, recorder, instance, spec, log)
+			if result != nil {
+				span.SetAttributes(tracing.TargetHostKey.String(result.HostName))
+			}
+			tracing.End(span, err)
+			if err != nil {
+				log.Info("ip could not be adopted", "failure-domain", spec.FailureDomain, "ip", spec.IP, "error", err.Error())
+				failures = append(failures, failedIP(spec.FailureDomain, spec.IP))
+				continue
+			}
+			if result != nil {
+				assigned = append(assigned, *result)
+				quota.Add(spec.FailureDomain)
+				continue
+			}
+		}
+
+		if err := quota.Allow(spec.FailureDomain); err != nil {
+			log.Info("ip exceeds the policies", "failure-domain", spec.FailureDomain, "ip", spec.IP, "error", err.Error())
+			recordIPEvent(ctx, client, recorder, instance, "", corev1.EventTypeWarning, EventReasonQuotaExceeded,
+				fmt.Sprintf("ip '%v' in failure domain '%v' is not allocated: %v", spec.IP, spec.FailureDomain, err.Error()),
+			)
+			failures = append(failures, failedIP(spec.FailureDomain, spec.IP))
+			continue
+		}
+
+		ipCtx, span := tracing.Start(ctx, "EgressIP.AllocateIP", ipAttributes(spec.FailureDomain, spec.IP, "")...)
+		result, err := allocateIP(ipCtx, client, provisioner, recorder, instance, spec, log)
+		if result != nil {
+			span.SetAttributes(ipAttributes(result.FailureDomain, result.IP, result.HostName)...)
+		}
+		tracing.End(span, err)
+		if err != nil {
+			failures = append(failures, failedIP(spec.FailureDomain, spec.IP))
+			continue
+		}
+
+		assigned = append(assigned, *result)
+		quota.Add(spec.FailureDomain)
+	}
+
+	instance.Status.IPs = assigned
+	return failures, failback
+}
+
+// adoptIP takes over the specified IP from the host already serving it without touching the host. Adopted IPs are not
+// checked against the policies since dropping them would cut the namespace off. Nil is returned if no host serves the
+// IP, so it is allocated as usual.
+func adoptIP(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, spec v1alpha1.FailureDomainEgressIPSpec, log logr.Logger) (*v1alpha1.AssignedEgressIP, error) {
+	ip := net.ParseIP(spec.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("ip '%v' is not a valid ip", spec.IP)
+	}
+
+	hostName, err := provisioner.FindIP(ctx, &ip)
+	if err != nil || hostName == "" {
+		return nil, err
+	}
+
+	log.Info("adopted ip", "failure-domain", spec.FailureDomain, "ip", ip.String(), "host", hostName)
+	recordIPEvent(ctx, client, recorder, instance, hostName, corev1.EventTypeNormal, EventReasonAdopted,
+		fmt.Sprintf("ip '%v' adopted from host '%v' in failure domain '%v'", ip.String(), hostName, spec.FailureDomain),
+	)
+	recordHistory(ctx, client, instance, v1alpha1.EgressIPHistoryEntry{
+		Action:        v1alpha1.HistoryActionAssigned,
+		FailureDomain: spec.FailureDomain,
+		IP:            ip.String(),
+		NewHostName:   hostName,
+		Reason:        HistoryReasonAdopted,
+		Actor:         specActor(instance),
+	}, log)
+
+	return &v1alpha1.AssignedEgressIP{
+		FailureDomain: spec.FailureDomain,
+		IP:            ip.String(),
+		HostName:      hostName,
+	}, nil
+}
+
+// allocateIP assigns the specified IP or a random one to the host chosen by the provisioner for the node affinity.
+func allocateIP(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, spec v1alpha1.FailureDomainEgressIPSpec, log logr.Logger) (*v1alpha1.AssignedEgressIP, error) {
+	hostName, err := provisioner.FindHostForNewIP(ctx, spec.FailureDomain, spec.NodeAffinity, nil)
+	if err == nil {
+		var ip *net.IP
+		ip, err = addIP(ctx, client, provisioner, instance.Namespace, spec, hostName, log)
+		if err == nil {
+			log.Info("assigned ip", "failure-domain", spec.FailureDomain, "ip", ip.String(), "host", hostName)
+			metrics.Allocations.WithLabelValues(spec.FailureDomain).Inc()
+			recordIPEvent(ctx, client, recorder, instance, hostName, corev1.EventTypeNormal, EventReasonAssigned,
+				fmt.Sprintf("ip '%v' assigned to host '%v' in failure domain '%v'", ip.String(), hostName, spec.FailureDomain),
+			)
+			recordHistory(ctx, client, instance, v1alpha1.EgressIPHistoryEntry{
+				Action:        v1alpha1.HistoryActionAssigned,
+				FailureDomain: spec.FailureDomain,
+				IP:            ip.String(),
+				NewHostName:   hostName,
+				Reason:        HistoryReasonSpecified,
+				Actor:         specActor(instance),
+			}, log)
+
+			return &v1alpha1.AssignedEgressIP{
+				FailureDomain: spec.FailureDomain,
+				IP:            ip.String(),
+				HostName:      hostName,
+			}, nil
+		}
+	}
+
+	log.Info("ip could not be allocated", "failure-domain", spec.FailureDomain, "ip", spec.IP, "host", hostName, "error", err.Error())
+	recordIPEvent(ctx, client, recorder, instance, hostName, corev1.EventTypeWarning, EventReasonAllocationFailed,
+		fmt.Sprintf("ip '%v' could not be allocated on host '%v' in failure domain '%v': %v", spec.IP, hostName, spec.FailureDomain, err.Error()),
+	)
+
+	if isCapacityExhausted(ctx, client, provisioner, spec.FailureDomain, log) {
+		recordIPEvent(ctx, client, recorder, instance, "", corev1.EventTypeWarning, EventReasonCapacityExhausted,
+			fmt.Sprintf("failure domain '%v' has no capacity left for ip '%v'", spec.FailureDomain, spec.IP),
+		)
+	}
+
+	return nil, err
+}
+
+// addIP adds the specified IP or a random one to the host. Specified IPs excluded or reserved in the failure domain or
+// quarantined for another namespace are refused, IPs quarantined for the namespace itself are reclaimed. For failure
+// domains restricting the random IPs or having quarantined IPs the operator picks the IP instead of the provisioner.
+// Unknown failure domains are left to the provisioner.
+func addIP(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, namespace string, spec v1alpha1.FailureDomainEgressIPSpec, hostName string, log logr.Logger) (*net.IP, error) {
+	failureDomain := &v1beta1.ClusterEgressIPFailureDomain{}
+	err := client.Get(ctx, types.NamespacedName{Name: spec.FailureDomain}, failureDomain)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var space *failuredomain.AddressSpace
+	if err == nil && failureDomain.Spec.Cidr != "" {
+		space, err = failuredomain.NewAddressSpace(failureDomain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	quarantined, err := quarantinedIPs(ctx, client, spec.FailureDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	var ip net.IP
+	if spec.IP != "" {
+		ip = net.ParseIP(spec.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("ip '%v' is not a valid ip", spec.IP)
+		}
+
+		if space != nil {
+			if reason := space.Excluded(ip); reason != "" {
+				return nil, fmt.Errorf("ip '%v' is %v", spec.IP, reason)
+			}
+		}
+
+		err = checkQuarantine(quarantined, ip, namespace)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if space == nil || (!restrictsRandomIPs(failureDomain) && len(quarantined) == 0) {
+			return addRandomIP(ctx, provisioner, quarantined, hostName)
+		}
+
+		picked, err := pickRandomIP(ctx, client, failureDomain, space)
+		if err != nil {
+			return nil, err
+		}
+		ip = *picked
+	}
+
+	err = provisioner.AddSpecifiedIP(ctx, &ip, hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, found := quarantined[ip.String()]; found {
+		reclaimIP(ctx, client, ip, log)
+	}
+
+	return &ip, nil
+}
+
+// addRandomIP lets the provisioner pick the IP. Quarantined IPs picked by the provisioner are kept on the host while
+// it picks again, so it can't pick them twice, and are removed afterwards. They are never handed out to another
+// namespace.
+func addRandomIP(ctx context.Context, provisioner provisioner.EgressIPProvisioner, quarantined map[string]string, hostName string) (*net.IP, error) {
+	held := make([]*net.IP, 0)
+	defer func() {
+		for _, ip := range held {
+			_ = provisioner.RemoveIP(ctx, ip, hostName)
+		}
+	}()
+
+	for attempt := 0; attempt < RandomIPAttempts; attempt++ {
+		ip, err := provisioner.AddRandomIP(ctx, hostName)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, found := quarantined[ip.String()]; !found {
+			return ip, nil
+		}
+		held = append(held, ip)
+	}
+
+	return nil, fmt.Errorf("no random ip outside the quarantine found within %v attempts", RandomIPAttempts)
+}
+
+func restrictsRandomIPs(failureDomain *v1beta1.ClusterEgressIPFailureDomain) bool {
+	return failureDomain.Spec.AllocationRange != nil || len(failureDomain.Spec.ExcludeRanges) > 0 || len(failureDomain.Spec.Reserved) > 0
+}
+
+// pickRandomIP returns the first IP of the address space of the failure domain neither claimed by an EgressIP nor the
+// address of a node of the failure domain.
+func pickRandomIP(ctx context.Context, client client.Client, failureDomain *v1beta1.ClusterEgressIPFailureDomain, space *failuredomain.AddressSpace) (*net.IP, error) {
+	_, cidr, err := net.ParseCIDR(failureDomain.Spec.Cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := allocatedIPsOfFailureDomain(ctx, client, failureDomain.Name, cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := failuredomain.ListNodesOfFailureDomain(ctx, client, failureDomain)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			if ip := net.ParseIP(address.Address); ip != nil {
+				used[ip.String()] = true
+			}
+		}
+	}
+
+	return space.NextFreeIP(used)
+}
+
+// isCapacityExhausted checks if the failure domain has no free address left or all eligible hosts serve their limit.
+func isCapacityExhausted(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, failureDomainName string, log logr.Logger) bool {
+	instance, err := failuredomain.FindFailureDomain(ctx, client, failureDomainName)
+	if err != nil {
+		return false
+	}
+
+	capacity, err := capacityOfFailureDomain(ctx, client, provisioner, instance, log)
+	if err != nil {
+		return false
+	}
+
+	return capacity.exhausted()
+}
+
+// checkAssignedIP makes sure the IP is served by its host. IPs drifted to another host are recorded there and lost IPs
+// are re-added. IPs of failed hosts have been moved by evacuateFailedHosts before.
+func checkAssignedIP(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, assigned *v1alpha1.AssignedEgressIP, log logr.Logger) error {
+	ip := net.ParseIP(assigned.IP)
+	if ip == nil {
+		return fmt.Errorf("ip '%v' is not a valid ip", assigned.IP)
+	}
+
+	err := provisioner.CheckIP(ctx, &ip, assigned.HostName)
+	if err == nil {
+		return nil
+	}
+
+	currentHostName, findErr := provisioner.FindIP(ctx, &ip)
+	if findErr == nil && currentHostName != "" {
+		log.Info("ip has drifted to another host", "ip", assigned.IP, "expected-host", assigned.HostName, "current-host", currentHostName)
+		recordHistory(ctx, client, instance, v1alpha1.EgressIPHistoryEntry{
+			Action:        v1alpha1.HistoryActionMoved,
+			FailureDomain: assigned.FailureDomain,
+			IP:            assigned.IP,
+			OldHostName:   assigned.HostName,
+			NewHostName:   currentHostName,
+			Reason:        HistoryReasonDrifted,
+			Actor:         OperatorActor,
+		}, log)
+		assigned.HostName = currentHostName
+		return nil
+	}
+
+	err = provisioner.AddSpecifiedIP(ctx, &ip, assigned.HostName)
+	if err != nil {
+		return err
+	}
+
+	recordIPEvent(ctx, client, recorder, instance, assigned.HostName, corev1.EventTypeNormal, EventReasonAssigned,
+		fmt.Sprintf("lost ip '%v' re-assigned to host '%v' in failure domain '%v'", assigned.IP, assigned.HostName, assigned.FailureDomain),
+	)
+	recordHistory(ctx, client, instance, v1alpha1.EgressIPHistoryEntry{
+		Action:        v1alpha1.HistoryActionAssigned,
+		FailureDomain: assigned.FailureDomain,
+		IP:            assigned.IP,
+		NewHostName:   assigned.HostName,
+		Reason:        HistoryReasonLost,
+		Actor:         OperatorActor,
+	}, log)
+	return nil
+}
+
+// moveIP moves the assigned IP to the new host and records the move for the reason. The assigned IP is updated before
+// the IP is checked on the new host.
+func moveIP(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, assigned *v1alpha1.AssignedEgressIP, newHostName string, reason string, log logr.Logger) error {
+	ip := net.ParseIP(assigned.IP)
+	if ip == nil {
+		return fmt.Errorf("ip '%v' is not a valid ip", assigned.IP)
+	}
+
+	err := provisioner.MoveIP(ctx, &ip, assigned.HostName, newHostName)
+	if err != nil {
+		return err
+	}
+
+	recordMove(ctx, client, recorder, instance, assigned, newHostName, reason, log)
+
+	return provisioner.CheckIP(ctx, &ip, newHostName)
+}
+
+// ipAttributes returns the span attributes of an IP of an EgressIP.
+func ipAttributes(failureDomain string, ip string, hostName string) []label.KeyValue {
+	return []label.KeyValue{
+		tracing.FailureDomainKey.String(failureDomain),
+		tracing.IPKey.String(ip),
+		tracing.HostKey.String(hostName),
+	}
+}
+
+// nodeFailedSince returns the time the node became not ready. For unknown nodes the time is zero.
+func nodeFailedSince(node *corev1.Node) time.Time {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time
+		}
+	}
+
+	return time.Time{}
+}
+
+// releaseIPs removes the IPs from their hosts. The IPs are grouped by host and every group is removed with a single call
+// of the provisioner. IPs of EgressIPs with the reclaim policy Retain are quarantined first. The reason and actor are
+// recorded in the history. The IPs that could not be released are returned.
+func releaseIPs(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, released []v1alpha1.AssignedEgressIP, reason string, actor string, log logr.Logger) []v1alpha1.AssignedEgressIP {
+	remaining := make([]v1alpha1.AssignedEgressIP, 0)
+
+	hostNames := make([]string, 0)
+	groups := make(map[string][]v1alpha1.AssignedEgressIP)
+	for _, assigned := range released {
+		if net.ParseIP(assigned.IP) == nil {
+			continue
+		}
+
+		if instance.Spec.ReclaimPolicy == v1alpha1.ReclaimPolicyRetain {
+			err := quarantineIP(ctx, client, recorder, instance, assigned, log)
+			if err != nil {
+				log.Info("ip could not be quarantined", "failure-domain", assigned.FailureDomain, "ip", assigned.IP, "error", err.Error())
+				remaining = append(remaining, assigned)
+				continue
+			}
+		}
+
+		if _, found := groups[assigned.HostName]; !found {
+			hostNames = append(hostNames, assigned.HostName)
+		}
+		groups[assigned.HostName] = append(groups[assigned.HostName], assigned)
+	}
+
+	for _, hostName := range hostNames {
+		group := groups[hostName]
+		ips := make([]*net.IP, len(group))
+		for i, assigned := range group {
+			ip := net.ParseIP(assigned.IP)
+			ips[i] = &ip
+		}
+
+		hostCtx, span := tracing.Start(ctx, "EgressIP.ReleaseIPs", tracing.IPs(ips), tracing.HostKey.String(hostName))
+		failures := provisioner.RemoveIPs(hostCtx, ips, hostName)
+		tracing.EndWithFailures(span, failures)
+
+		for i, assigned := range group {
+			if err, failed := failures[ips[i].String()]; failed {
+				log.Info("ip could not be released", "failure-domain", assigned.FailureDomain, "ip", assigned.IP, "host", hostName, "error", err.Error())
+				remaining = append(remaining, assigned)
+				continue
+			}
+
+			log.Info("released ip", "failure-domain", assigned.FailureDomain, "ip", assigned.IP, "host", hostName)
+			metrics.Releases.WithLabelValues(assigned.FailureDomain).Inc()
+			recordIPEvent(ctx, client, recorder, instance, hostName, corev1.EventTypeNormal, EventReasonReleased,
+				fmt.Sprintf("ip '%v' released from host '%v' in failure domain '%v'", assigned.IP, hostName, assigned.FailureDomain),
+			)
+			recordHistory(ctx, client, instance, v1alpha1.EgressIPHistoryEntry{
+				Action:        v1alpha1.HistoryActionReleased,
+				FailureDomain: assigned.FailureDomain,
+				IP:            assigned.IP,
+				OldHostName:   hostName,
+				Reason:        reason,
+				Actor:         actor,
+			}, log)
+		}
+	}
+
+	return remaining
+}
+
+// releaseEgressIP removes all assigned IPs of the deleted EgressIP from the hosts and the NetNamespace and removes the
+// finalizer afterwards.
+func releaseEgressIP(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, alarms metrics.AlarmStore, recorder record.EventRecorder, instance *v1alpha1.EgressIP, log logr.Logger) (ctrl.Result, error) {
+	if !containsString(instance.Finalizers, EgressIPFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	remaining := releaseIPs(ctx, client, provisioner, recorder, instance, instance.Status.IPs, HistoryReasonDeleted, OperatorActor, log)
+
+	if len(remaining) > 0 {
+		instance.Status.IPs = remaining
+		err := client.Status().Update(ctx, instance)
+		if err != nil {
+			log.Info("status could not be updated", "error", err.Error())
+		}
+
+		return ctrl.Result{}, fmt.Errorf("%v ips of egressip '%v/%v' could not be released", len(remaining), instance.Namespace, instance.Name)
+	}
+
+	err := updateNetNamespace(ctx, client, instance, []string{})
+	if err != nil && !errors.IsNotFound(err) {
+		log.Info("egress ips of netnamespace could not be removed - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	alarms.RemoveEgressIPAlarm(instance.Namespace, instance.Name)
+	alarms.SetAlarmState(instance.Namespace, instance.Name, time.Time{}, false)
+
+	instance.Finalizers = removeString(instance.Finalizers, EgressIPFinalizer)
+	err = client.Update(ctx, instance)
+	if err != nil {
+		log.Info("finalizer could not be removed - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
 
 	return ctrl.Result{}, nil
 }
+
+// updateNetNamespace sets the egress IPs of the NetNamespace of the namespace of the instance. The IPs of the instance
+// are replaced by ips, the IPs assigned to the other EgressIPs of the namespace are kept.
+func updateNetNamespace(ctx context.Context, client client.Client, instance *v1alpha1.EgressIP, ips []string) error {
+	netNamespace := &netv1.NetNamespace{}
+	err := client.Get(ctx, types.NamespacedName{Name: instance.Namespace}, netNamespace)
+	if err != nil {
+		return err
+	}
+
+	ips, err = namespaceIPs(ctx, client, instance, ips)
+	if err != nil {
+		return err
+	}
+
+	if equalStrings(netNamespace.EgressIPs, ips) {
+		return nil
+	}
+
+	netNamespace.EgressIPs = ips
+	return client.Update(ctx, netNamespace)
+}
+
+// namespaceIPs returns the IPs of the instance followed by the IPs assigned to the other EgressIPs of its namespace,
+// ordered by their names.
+func namespaceIPs(ctx context.Context, c client.Client, instance *v1alpha1.EgressIP, ips []string) ([]string, error) {
+	egressIPs := &v1alpha1.EgressIPList{}
+	err := c.List(ctx, egressIPs, client.InNamespace(instance.Namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(egressIPs.Items, func(i, j int) bool {
+		return egressIPs.Items[i].Name < egressIPs.Items[j].Name
+	})
+
+	result := make([]string, 0)
+	for _, ip := range ips {
+		if !containsString(result, ip) {
+			result = append(result, ip)
+		}
+	}
+
+	for _, egressIP := range egressIPs.Items {
+		if egressIP.Name == instance.Name {
+			continue
+		}
+
+		for _, ip := range assignedIPs(&egressIP) {
+			if !containsString(result, ip) {
+				result = append(result, ip)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func assignedIPs(instance *v1alpha1.EgressIP) []string {
+	result := make([]string, len(instance.Status.IPs))
+	for i, ip := range instance.Status.IPs {
+		result[i] = ip.IP
+	}
+
+	return result
+}
+
+// isSpecified checks if the assigned IP is still wanted by the spec.
+func isSpecified(specs []v1alpha1.FailureDomainEgressIPSpec, assigned v1alpha1.AssignedEgressIP) bool {
+	for _, spec := range specs {
+		if spec.FailureDomain != assigned.FailureDomain {
+			continue
+		}
+
+		return spec.IP == "" || net.ParseIP(spec.IP).Equal(net.ParseIP(assigned.IP))
+	}
+
+	return false
+}
+
+func indexOfFailureDomain(assigned []v1alpha1.AssignedEgressIP, failureDomain string) int {
+	for i, ip := range assigned {
+		if ip.FailureDomain == failureDomain {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func failedIP(failureDomain string, ip string) metrics.FailedIP {
+	result := metrics.FailedIP{FailureDomain: failureDomain}
+
+	parsed := net.ParseIP(ip)
+	if parsed != nil {
+		result.IP = &parsed
+	}
+
+	return result
+}
+
+// alarmStateOf reads the acknowledgement and silence of the alarms from the annotations of the EgressIP. Invalid
+// values are logged and ignored.
+func alarmStateOf(instance *v1alpha1.EgressIP, log logr.Logger) (time.Time, bool) {
+	acknowledged := time.Time{}
+	if value, found := instance.Annotations[AlarmAcknowledgedAnnotation]; found {
+		timeStamp, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Info("acknowledgement of alarm is no valid RFC3339 time stamp - ignoring it", "value", value)
+		} else {
+			acknowledged = timeStamp
+		}
+	}
+
+	silenced := false
+	if value, found := instance.Annotations[AlarmSilencedAnnotation]; found {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Info("silence of alarm is no valid boolean - ignoring it", "value", value)
+		} else {
+			silenced = parsed
+		}
+	}
+
+	return acknowledged, silenced
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeString(values []string, value string) []string {
+	result := make([]string, 0)
+	for _, candidate := range values {
+		if candidate != value {
+			result = append(result, candidate)
+		}
+	}
+
+	return result
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
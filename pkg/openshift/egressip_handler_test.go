@@ -0,0 +1,543 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"errors"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	netv1 "github.com/openshift/api/network/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+var egressIPName = types.NamespacedName{Namespace: "tenant", Name: "egress"}
+
+func prepareEgressIP(spec ...v1alpha1.FailureDomainEgressIPSpec) client.Client {
+	return prepareClient(
+		failureDomain("lifecycle-a", "10.0.1.0/24"),
+		node("node-a", "lifecycle-a", "10.0.1.5", corev1.ConditionTrue),
+		node("node-b", "lifecycle-a", "10.0.1.6", corev1.ConditionTrue),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}},
+		&netv1.NetNamespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}, NetName: "tenant"},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: egressIPName.Name, Namespace: egressIPName.Namespace},
+			Spec:       v1alpha1.EgressIPSpec{IPs: spec},
+		},
+	)
+}
+
+func reconcileEgressIP(t *testing.T, c client.Client, provisioner *hostIPs) *v1alpha1.EgressIP {
+	_, err := openshift.ManageEgressIP(
+		context.Background(),
+		ctrl.Request{NamespacedName: egressIPName},
+		c, provisioner, *metrics.NewAlarmStore(log), record.NewFakeRecorder(10), log,
+	)
+	if err != nil {
+		t.Fatalf("EgressIP could not be reconciled: %v", err)
+	}
+
+	result := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), egressIPName, result)
+	return result
+}
+
+func TestAllocatingSpecifiedIP(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+	allocations := testutil.ToFloat64(metrics.Allocations.WithLabelValues("lifecycle-a"))
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].IP != "10.0.1.10" || instance.Status.IPs[0].HostName != "node-a" {
+		t.Errorf("Wrong ips in status! expected='10.0.1.10' on 'node-a', current=%v", instance.Status.IPs)
+	}
+	if instance.Status.Phase != "provisioned" {
+		t.Errorf("Wrong phase! expected='provisioned', current='%v'", instance.Status.Phase)
+	}
+	if len(instance.Finalizers) != 1 || instance.Finalizers[0] != openshift.EgressIPFinalizer {
+		t.Errorf("Finalizer is missing! current=%v", instance.Finalizers)
+	}
+	if len(provisioner.ips["node-a"]) != 1 {
+		t.Errorf("IP has not been added to the host! current=%v", provisioner.ips)
+	}
+
+	netNamespace := &netv1.NetNamespace{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "tenant"}, netNamespace)
+	if len(netNamespace.EgressIPs) != 1 || netNamespace.EgressIPs[0] != "10.0.1.10" {
+		t.Errorf("Wrong egress ips in netnamespace! expected=[10.0.1.10], current=%v", netNamespace.EgressIPs)
+	}
+
+	current := testutil.ToFloat64(metrics.Allocations.WithLabelValues("lifecycle-a"))
+	if current != allocations+1 {
+		t.Errorf("Allocation has not been counted! expected=%v, current=%v", allocations+1, current)
+	}
+
+	reconcileEgressIP(t, c, provisioner)
+	current = testutil.ToFloat64(metrics.Allocations.WithLabelValues("lifecycle-a"))
+	if current != allocations+1 || len(provisioner.ips["node-a"]) != 1 {
+		t.Errorf("Assigned ip has been allocated again! allocations=%v, ips=%v", current-allocations, provisioner.ips)
+	}
+}
+
+func TestAllocatingIPInAllFailureDomains(t *testing.T) {
+	c := prepareEgressIP()
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if !instance.Spec.AllFailureDomains || len(instance.Spec.IPs) != 1 || instance.Spec.IPs[0].FailureDomain != "lifecycle-a" {
+		t.Errorf("EgressIP without ips should use all failure domains! spec=%v", instance.Spec)
+	}
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].FailureDomain != "lifecycle-a" {
+		t.Errorf("Random ip in 'lifecycle-a' should have been allocated! current=%v", instance.Status.IPs)
+	}
+}
+
+// prepareRestrictedEgressIP prepares an EgressIP in the failure domain 'lifecycle-a' handing out random IPs only from
+// 10.0.1.50 to 10.0.1.60 with 10.0.1.50 reserved.
+func prepareRestrictedEgressIP(spec v1alpha1.FailureDomainEgressIPSpec) client.Client {
+	restricted := failureDomain("lifecycle-a", "10.0.1.0/24")
+	restricted.Spec.AllocationRange = &v1beta1.IPRange{Start: "10.0.1.50", End: "10.0.1.60"}
+	restricted.Spec.ExcludeRanges = []v1beta1.IPRange{{Start: "10.0.1.52", End: "10.0.1.54"}}
+	restricted.Spec.Reserved = []string{"10.0.1.50"}
+
+	return prepareClient(
+		restricted,
+		node("node-a", "lifecycle-a", "10.0.1.51", corev1.ConditionTrue),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}},
+		&netv1.NetNamespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}, NetName: "tenant"},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: egressIPName.Name, Namespace: egressIPName.Namespace},
+			Spec:       v1alpha1.EgressIPSpec{IPs: []v1alpha1.FailureDomainEgressIPSpec{spec}},
+		},
+	)
+}
+
+func TestAllocatingRandomIPWithinAllocationRange(t *testing.T) {
+	c := prepareRestrictedEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].IP != "10.0.1.55" {
+		t.Errorf("Random ip should skip reserved, excluded and node addresses! expected='10.0.1.55', current=%v", instance.Status.IPs)
+	}
+}
+
+func TestRefusingReservedIP(t *testing.T) {
+	c := prepareRestrictedEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.50"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	_, err := openshift.ManageEgressIP(
+		context.Background(),
+		ctrl.Request{NamespacedName: egressIPName},
+		c, provisioner, *metrics.NewAlarmStore(log), record.NewFakeRecorder(10), log,
+	)
+	if err == nil {
+		t.Errorf("Reserved ip should not be allocated! ips=%v", provisioner.ips)
+	}
+	if len(provisioner.ips["node-a"]) != 0 {
+		t.Errorf("Reserved ip has been added to the host! current=%v", provisioner.ips)
+	}
+}
+
+func TestAdoptingIPWithoutMovingIt(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	instance := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), egressIPName, instance)
+	instance.Annotations = map[string]string{openshift.AdoptedAnnotation: "true"}
+	_ = c.Update(context.Background(), instance)
+
+	provisioner := &hostIPs{ips: map[string][]string{"node-b": {"10.0.1.10"}}, target: "node-a"}
+
+	instance = reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].HostName != "node-b" {
+		t.Errorf("Adopted ip should stay on 'node-b'! current=%v", instance.Status.IPs)
+	}
+	if len(provisioner.ips["node-a"]) != 0 || len(provisioner.ips["node-b"]) != 1 {
+		t.Errorf("Adopted ip has been moved! current=%v", provisioner.ips)
+	}
+}
+
+func TestReleasingIPOfRemovedFailureDomain(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+	releases := testutil.ToFloat64(metrics.Releases.WithLabelValues("lifecycle-a"))
+
+	instance := reconcileEgressIP(t, c, provisioner)
+	instance.Spec.IPs = []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-b"}}
+	_ = c.Update(context.Background(), instance)
+
+	instance = reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].FailureDomain != "lifecycle-b" {
+		t.Errorf("Wrong ips in status! expected only failure domain 'lifecycle-b', current=%v", instance.Status.IPs)
+	}
+
+	current := testutil.ToFloat64(metrics.Releases.WithLabelValues("lifecycle-a"))
+	if current != releases+1 {
+		t.Errorf("Release has not been counted! expected=%v, current=%v", releases+1, current)
+	}
+}
+
+func TestKeepingIPsOfOtherEgressIPsInNetNamespace(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+	_ = c.Create(context.Background(), &v1alpha1.EgressIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: egressIPName.Namespace},
+		Spec:       v1alpha1.EgressIPSpec{IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.20"}}},
+		Status: v1alpha1.EgressIPStatus{
+			IPs: []v1alpha1.AssignedEgressIP{{FailureDomain: "lifecycle-a", IP: "10.0.1.20", HostName: "node-b"}},
+		},
+	})
+	_ = c.Create(context.Background(), &v1alpha1.EgressIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "elsewhere"},
+		Spec:       v1alpha1.EgressIPSpec{IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.30"}}},
+		Status: v1alpha1.EgressIPStatus{
+			IPs: []v1alpha1.AssignedEgressIP{{FailureDomain: "lifecycle-a", IP: "10.0.1.30", HostName: "node-b"}},
+		},
+	})
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	netNamespace := &netv1.NetNamespace{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "tenant"}, netNamespace)
+	if strings.Join(netNamespace.EgressIPs, ",") != "10.0.1.10,10.0.1.20" {
+		t.Errorf("Wrong egress ips in netnamespace! expected=[10.0.1.10 10.0.1.20], current=%v", netNamespace.EgressIPs)
+	}
+
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	_ = c.Update(context.Background(), instance)
+	reconcileEgressIP(t, c, provisioner)
+
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "tenant"}, netNamespace)
+	if strings.Join(netNamespace.EgressIPs, ",") != "10.0.1.20" {
+		t.Errorf("Wrong egress ips in netnamespace after deletion! expected=[10.0.1.20], current=%v", netNamespace.EgressIPs)
+	}
+}
+
+func TestIgnoringMissingEgressIP(t *testing.T) {
+	result, err := openshift.ManageEgressIP(
+		context.Background(),
+		ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "tenant", Name: "missing"}},
+		prepareEgressIP(), &hostIPs{ips: map[string][]string{}}, *metrics.NewAlarmStore(log), record.NewFakeRecorder(10), log,
+	)
+
+	if err != nil || result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("Missing egressip should be ignored! result=%v, err=%v", result, err)
+	}
+}
+
+func TestMovingIPOfFailedNode(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.11"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+	moves := testutil.ToFloat64(metrics.Moves.WithLabelValues("lifecycle-a"))
+
+	reconcileEgressIP(t, c, provisioner)
+
+	failed := &corev1.Node{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "node-a"}, failed)
+	failed.Status.Conditions[0].Status = corev1.ConditionFalse
+	failed.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-5 * time.Second))
+	_ = c.Update(context.Background(), failed)
+	provisioner.target = "node-b"
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if instance.Status.IPs[0].HostName != "node-b" || len(provisioner.ips["node-b"]) != 1 || len(provisioner.ips["node-a"]) != 0 {
+		t.Errorf("IP has not been moved to 'node-b'! status=%v, ips=%v", instance.Status.IPs, provisioner.ips)
+	}
+
+	current := testutil.ToFloat64(metrics.Moves.WithLabelValues("lifecycle-a"))
+	if current != moves+1 {
+		t.Errorf("Move has not been counted! expected=%v, current=%v", moves+1, current)
+	}
+
+	count, sum := gatherFailoverDuration(t, "lifecycle-a")
+	if count != 1 || sum < 5 {
+		t.Errorf("Failover duration has not been observed! expected 1 observation of at least 5s, current=%v observations with %vs", count, sum)
+	}
+}
+
+// prepareFailedNode reconciles the EgressIP and the EgressIP 'other' with their IPs on 'node-a' and fails the node.
+func prepareFailedNode(t *testing.T, provisioner *hostIPs) client.Client {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	_ = c.Create(context.Background(), &v1alpha1.EgressIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: egressIPName.Namespace, Finalizers: []string{openshift.EgressIPFinalizer}},
+		Spec:       v1alpha1.EgressIPSpec{IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.20"}}},
+		Status: v1alpha1.EgressIPStatus{
+			IPs: []v1alpha1.AssignedEgressIP{{FailureDomain: "lifecycle-a", IP: "10.0.1.20", HostName: "node-a"}},
+		},
+	})
+	provisioner.ips["node-a"] = []string{"10.0.1.20"}
+	provisioner.target = "node-a"
+
+	reconcileEgressIP(t, c, provisioner)
+
+	failed := &corev1.Node{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "node-a"}, failed)
+	failed.Status.Conditions[0].Status = corev1.ConditionFalse
+	_ = c.Update(context.Background(), failed)
+
+	return c
+}
+
+func TestMovingIPsOfFailedNodeInOneBatch(t *testing.T) {
+	provisioner := &hostIPs{ips: map[string][]string{}}
+	c := prepareFailedNode(t, provisioner)
+	provisioner.target = "node-b"
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	other := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), types.NamespacedName{Namespace: egressIPName.Namespace, Name: "other"}, other)
+	if instance.Status.IPs[0].HostName != "node-b" || other.Status.IPs[0].HostName != "node-b" {
+		t.Errorf("IPs have not been moved to 'node-b'! status=%v, other=%v", instance.Status.IPs, other.Status.IPs)
+	}
+	if len(other.Status.History) != 1 || other.Status.History[0].Reason != openshift.HistoryReasonNodeFailure {
+		t.Errorf("Move of the other egressip has not been recorded! current=%v", other.Status.History)
+	}
+	if provisioner.moveBatches != 1 || len(provisioner.ips["node-b"]) != 2 {
+		t.Errorf("IPs have not been moved with a single call! expected=1, current=%v, ips=%v", provisioner.moveBatches, provisioner.ips)
+	}
+}
+
+// conflictingClient fails the first status update of the named EgressIP with a conflict.
+type conflictingClient struct {
+	client.Client
+	name      string
+	conflicts int
+}
+
+func (c *conflictingClient) Status() client.StatusWriter {
+	return &conflictingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type conflictingStatusWriter struct {
+	client.StatusWriter
+	client *conflictingClient
+}
+
+func (w *conflictingStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if egressIP, ok := obj.(*v1alpha1.EgressIP); ok && egressIP.Name == w.client.name && w.client.conflicts == 0 {
+		w.client.conflicts++
+		return apierrors.NewConflict(v1alpha1.GroupVersion.WithResource("egressips").GroupResource(), egressIP.Name, errors.New("changed"))
+	}
+
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func TestRetryingStatusOfOtherEgressIPWithMovedIPs(t *testing.T) {
+	provisioner := &hostIPs{ips: map[string][]string{}}
+	c := &conflictingClient{Client: prepareFailedNode(t, provisioner), name: "other"}
+	provisioner.target = "node-b"
+
+	reconcileEgressIP(t, c, provisioner)
+
+	other := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), types.NamespacedName{Namespace: egressIPName.Namespace, Name: "other"}, other)
+	if c.conflicts != 1 || other.Status.IPs[0].HostName != "node-b" || other.Status.HostName != "node-b" {
+		t.Errorf("Status of the other egressip has not been retried! conflicts=%v, other=%v", c.conflicts, other.Status)
+	}
+	if len(other.Status.History) != 1 {
+		t.Errorf("Move of the other egressip has not been recorded once! expected=1, current=%v", other.Status.History)
+	}
+}
+
+func TestSpreadingIPsOfFailedNodeOverHosts(t *testing.T) {
+	provisioner := &hostIPs{ips: map[string][]string{}}
+	c := prepareFailedNode(t, provisioner)
+	provisioner.targets = []string{"node-b", "node-c"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	other := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), types.NamespacedName{Namespace: egressIPName.Namespace, Name: "other"}, other)
+	if instance.Status.IPs[0].HostName != "node-b" || other.Status.IPs[0].HostName != "node-c" {
+		t.Errorf("IPs have not been spread over the hosts! status=%v, other=%v", instance.Status.IPs, other.Status.IPs)
+	}
+}
+
+func TestKeepingIPsOfFailedNodeWithinIPLimit(t *testing.T) {
+	provisioner := &hostIPs{ips: map[string][]string{"node-b": {"10.0.1.30"}}, limit: 2}
+	c := prepareFailedNode(t, provisioner)
+	provisioner.target = "node-b"
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	other := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), types.NamespacedName{Namespace: egressIPName.Namespace, Name: "other"}, other)
+	if instance.Status.IPs[0].HostName != "node-b" || other.Status.IPs[0].HostName != "node-a" {
+		t.Errorf("Only the ip within the limit should be moved! status=%v, other=%v", instance.Status.IPs, other.Status.IPs)
+	}
+	if len(provisioner.ips["node-b"]) != 2 {
+		t.Errorf("IP limit of the host exceeded! expected=%v, current=%v", 2, provisioner.ips["node-b"])
+	}
+}
+
+func TestReleasingIPsInOneBatch(t *testing.T) {
+	c := prepareEgressIP(
+		v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"},
+		v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-b", IP: "10.0.2.10"},
+	)
+	_ = c.Create(context.Background(), failureDomain("lifecycle-b", "10.0.2.0/24"))
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	reconcileEgressIP(t, c, provisioner)
+
+	instance := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), egressIPName, instance)
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	_ = c.Update(context.Background(), instance)
+
+	reconcileEgressIP(t, c, provisioner)
+
+	if provisioner.removeBatches != 1 || len(provisioner.ips["node-a"]) != 0 {
+		t.Errorf("IPs of the host have not been released with a single call! expected=1, current=%v, ips=%v", provisioner.removeBatches, provisioner.ips)
+	}
+}
+
+func gatherFailoverDuration(t *testing.T, failureDomain string) (uint64, float64) {
+	families, err := crmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Can't gather metrics: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "egress_ip_failover_duration_seconds" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "failure_domain" && label.GetValue() == failureDomain {
+					return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+
+	return 0, 0
+}
+
+func TestRecordingAssignedEvents(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.12"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+	recorder := record.NewFakeRecorder(10)
+
+	_, err := openshift.ManageEgressIP(context.Background(), ctrl.Request{NamespacedName: egressIPName}, c, provisioner, *metrics.NewAlarmStore(log), recorder, log)
+	if err != nil {
+		t.Fatalf("EgressIP could not be reconciled: %v", err)
+	}
+
+	expected := "Normal Assigned ip '10.0.1.12' assigned to host 'node-a' in failure domain 'lifecycle-a'"
+	events := receiveEvents(recorder)
+	if len(events) != 3 || events[0] != expected || events[1] != expected || events[2] != expected {
+		t.Errorf("Events for egressip, namespace and node expected! expected='%v', current=%v", expected, events)
+	}
+}
+
+func TestRecordingCapacityExhaustedEvents(t *testing.T) {
+	c := prepareClient(
+		failureDomain("lifecycle-full", "10.0.1.4/30"),
+		node("node-a", "lifecycle-full", "10.0.1.5", corev1.ConditionTrue),
+		node("node-b", "lifecycle-full", "10.0.1.6", corev1.ConditionTrue),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: egressIPName.Name, Namespace: egressIPName.Namespace},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-full", IP: "10.0.1.7"}},
+			},
+		},
+	)
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a", err: errors.New("no free ip")}
+	recorder := record.NewFakeRecorder(10)
+
+	_, err := openshift.ManageEgressIP(context.Background(), ctrl.Request{NamespacedName: egressIPName}, c, provisioner, *metrics.NewAlarmStore(log), recorder, log)
+	if err == nil {
+		t.Fatalf("Failed allocation should return an error")
+	}
+
+	reasons := make(map[string]int)
+	for _, event := range receiveEvents(recorder) {
+		reasons[strings.Split(event, " ")[1]]++
+	}
+
+	if reasons[openshift.EventReasonAllocationFailed] != 3 || reasons[openshift.EventReasonCapacityExhausted] != 2 {
+		t.Errorf("Wrong events recorded! expected 3 allocation-failed and 2 capacity-exhausted, current=%v", reasons)
+	}
+}
+
+func TestSilencingAlarmByAnnotation(t *testing.T) {
+	c := prepareClient(
+		failureDomain("lifecycle-silenced", "10.0.1.0/24"),
+		node("node-a", "lifecycle-silenced", "10.0.1.5", corev1.ConditionTrue),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        egressIPName.Name,
+				Namespace:   egressIPName.Namespace,
+				Annotations: map[string]string{openshift.AlarmSilencedAnnotation: "true"},
+			},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-silenced", IP: "10.0.1.7"}},
+			},
+		},
+	)
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a", err: errors.New("no free ip")}
+	alarms := *metrics.NewAlarmStore(log)
+
+	_, _ = openshift.ManageEgressIP(context.Background(), ctrl.Request{NamespacedName: egressIPName}, c, provisioner, alarms, record.NewFakeRecorder(10), log)
+
+	alarm := alarms.GetFailed()[metrics.AlarmKey(egressIPName.Namespace, egressIPName.Name)]
+	if alarm == nil || alarm.State != metrics.AlarmSilenced {
+		t.Errorf("Alarm of the egressip should be silenced! current='%v'", alarm)
+	}
+
+	alarms.RemoveEgressIPAlarm(egressIPName.Namespace, egressIPName.Name)
+	alarms.SetAlarmState(egressIPName.Namespace, egressIPName.Name, time.Time{}, false)
+}
+
+func receiveEvents(recorder *record.FakeRecorder) []string {
+	result := make([]string, 0)
+	for {
+		select {
+		case event := <-recorder.Events:
+			result = append(result, event)
+		default:
+			return result
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons of the events recorded for the lifecycle of the egress IPs.
+const (
+	EventReasonAssigned          = "Assigned"
+	EventReasonMoved             = "Moved"
+	EventReasonReleased          = "Released"
+	EventReasonAllocationFailed  = "AllocationFailed"
+	EventReasonCapacityExhausted = "CapacityExhausted"
+	EventReasonBound             = "Bound"
+	EventReasonBindingFailed     = "BindingFailed"
+	EventReasonQuotaExceeded     = "QuotaExceeded"
+	EventReasonPolicyViolated    = "PolicyViolated"
+	EventReasonAdopted           = "Adopted"
+	EventReasonQuarantined       = "Quarantined"
+)
+
+// recordIPEvent records the event on the EgressIP, its namespace and the node serving the IP. Tenants see the event
+// via `kubectl describe egressip` or the events of their namespace.
+func recordIPEvent(ctx context.Context, client client.Client, recorder record.EventRecorder, instance *v1alpha1.EgressIP, hostName string, eventType string, reason string, message string) {
+	recorder.Event(instance, eventType, reason, message)
+
+	namespace := &corev1.Namespace{}
+	err := client.Get(ctx, types.NamespacedName{Name: instance.Namespace}, namespace)
+	if err == nil {
+		recorder.Event(namespace, eventType, reason, message)
+	}
+
+	recordNodeEvent(ctx, client, recorder, hostName, eventType, reason, message)
+}
+
+// recordNodeEvent records the event on the node. Unknown nodes are skipped since events have to reference an existing
+// object to be shown by `kubectl describe node`.
+func recordNodeEvent(ctx context.Context, client client.Client, recorder record.EventRecorder, hostName string, eventType string, reason string, message string) {
+	if hostName == "" {
+		return
+	}
+
+	node := &corev1.Node{}
+	err := client.Get(ctx, types.NamespacedName{Name: hostName}, node)
+	if err == nil {
+		recorder.Event(node, eventType, reason, message)
+	}
+}
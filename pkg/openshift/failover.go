@@ -0,0 +1,284 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"time"
+)
+
+// evacuee is an IP assigned to a failed host together with the EgressIP it belongs to.
+type evacuee struct {
+	egressIP *v1alpha1.EgressIP
+	assigned *v1alpha1.AssignedEgressIP
+	ip       net.IP
+}
+
+// evacuateFailedHosts moves the IPs of the instance assigned to hosts gone or not ready to other hosts. The IPs of the
+// instance are updated in place. The errors of the IPs that could not be moved are returned by IP.
+func evacuateFailedHosts(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, log logr.Logger) map[string]error {
+	failures := make(map[string]error)
+
+	evacuated := make(map[string]bool)
+	for _, assigned := range instance.Status.IPs {
+		if evacuated[assigned.HostName] {
+			continue
+		}
+		evacuated[assigned.HostName] = true
+
+		node := &corev1.Node{}
+		err := client.Get(ctx, types.NamespacedName{Name: assigned.HostName}, node)
+		if err != nil && !errors.IsNotFound(err) {
+			for _, ip := range instance.Status.IPs {
+				if ip.HostName == assigned.HostName {
+					failures[ip.IP] = err
+				}
+			}
+			continue
+		}
+
+		if err == nil && failuredomain.IsNodeReady(node) {
+			continue
+		}
+
+		for ip, err := range evacuateHost(ctx, client, provisioner, recorder, instance, assigned.HostName, nodeFailedSince(node), log) {
+			failures[ip] = err
+		}
+	}
+
+	return failures
+}
+
+// evacuateHost moves all IPs assigned to the failed host to other hosts of their failure domains. The IPs of all
+// EgressIPs served by the host are grouped by their new host and every group is moved with a single call of the
+// provisioner. The IPs already grouped for a host count as pending when choosing the next host and against the IP limit
+// of the host, since the hosts only serve them after the move. The IPs of the instance are updated in place, the status
+// of the other EgressIPs is persisted. The errors of the IPs of the instance that could not be moved are returned by IP.
+func evacuateHost(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1alpha1.EgressIP, hostName string, failedSince time.Time, log logr.Logger) map[string]error {
+	failures := make(map[string]error)
+
+	served := []*v1alpha1.EgressIP{instance}
+	egressIPs := &v1alpha1.EgressIPList{}
+	err := client.List(ctx, egressIPs)
+	if err != nil {
+		log.Info("egressIPs could not be listed - only the ips of this egressip are moved", "host", hostName, "error", err.Error())
+	}
+	for i := range egressIPs.Items {
+		egressIP := &egressIPs.Items[i]
+		if egressIP.Namespace == instance.Namespace && egressIP.Name == instance.Name {
+			continue
+		}
+
+		if egressIP.DeletionTimestamp.IsZero() && isServedBy(egressIP, hostName) {
+			served = append(served, egressIP)
+		}
+	}
+
+	groups := make(map[string][]evacuee)
+	pending := make(map[string]int)
+	capacities := make(map[string]int)
+	for _, egressIP := range served {
+		for i := range egressIP.Status.IPs {
+			assigned := &egressIP.Status.IPs[i]
+			if assigned.HostName != hostName || !isSpecified(egressIP.Spec.IPs, *assigned) {
+				continue
+			}
+
+			ip := net.ParseIP(assigned.IP)
+			if ip == nil {
+				continue
+			}
+
+			newHostName, err := provisioner.FindHostForNewIP(ctx, assigned.FailureDomain, nodeAffinityOf(egressIP, assigned.FailureDomain), pending)
+			if err == nil && newHostName != hostName {
+				err = checkCapacity(ctx, provisioner, newHostName, pending, capacities)
+			}
+			if err != nil {
+				log.Info("no host found for ip of failed host", "egressip", egressIP.Namespace+"/"+egressIP.Name, "ip", assigned.IP, "host", hostName, "error", err.Error())
+				if egressIP == instance {
+					failures[assigned.IP] = err
+				}
+				continue
+			}
+
+			if newHostName != hostName {
+				groups[newHostName] = append(groups[newHostName], evacuee{egressIP: egressIP, assigned: assigned, ip: ip})
+				pending[newHostName]++
+			}
+		}
+	}
+
+	newHostNames := make([]string, 0, len(groups))
+	for newHostName := range groups {
+		newHostNames = append(newHostNames, newHostName)
+	}
+	sort.Strings(newHostNames)
+
+	moved := make(map[*v1alpha1.EgressIP][]v1alpha1.AssignedEgressIP)
+	for _, newHostName := range newHostNames {
+		evacuees := groups[newHostName]
+		ips := make([]*net.IP, len(evacuees))
+		for i := range evacuees {
+			ips[i] = &evacuees[i].ip
+		}
+
+		batchCtx, span := tracing.Start(ctx, "EgressIP.MoveIPs", tracing.IPs(ips), tracing.HostKey.String(hostName), tracing.TargetHostKey.String(newHostName))
+		moveFailures := provisioner.MoveIPs(batchCtx, ips, hostName, newHostName)
+		tracing.EndWithFailures(span, moveFailures)
+
+		for _, e := range evacuees {
+			if err, failed := moveFailures[e.ip.String()]; failed {
+				log.Info("ip of failed host could not be moved", "egressip", e.egressIP.Namespace+"/"+e.egressIP.Name, "ip", e.assigned.IP, "old-host", hostName, "new-host", newHostName, "error", err.Error())
+				if e.egressIP == instance {
+					failures[e.assigned.IP] = err
+				}
+				continue
+			}
+
+			recordMove(ctx, client, recorder, e.egressIP, e.assigned, newHostName, HistoryReasonNodeFailure, log)
+			if !failedSince.IsZero() {
+				metrics.FailoverDuration.WithLabelValues(e.assigned.FailureDomain).Observe(time.Since(failedSince).Seconds())
+			}
+			moved[e.egressIP] = append(moved[e.egressIP], *e.assigned)
+		}
+	}
+
+	for egressIP, ips := range moved {
+		if egressIP == instance {
+			continue
+		}
+
+		err := persistMoves(ctx, client, egressIP, ips)
+		if err != nil {
+			log.Info("status of egressip with moved ips could not be updated", "egressip", egressIP.Namespace+"/"+egressIP.Name, "error", err.Error())
+		}
+	}
+
+	return failures
+}
+
+// persistMoves writes the moved IPs and their history entries recorded in the EgressIP to its status. The EgressIP is
+// loaded again for every attempt, so changes of its status since it has been listed are kept and conflicts are retried.
+// The IPs are already moved, a lost update would show them on the failed host.
+func persistMoves(ctx context.Context, c client.Client, egressIP *v1alpha1.EgressIP, ips []v1alpha1.AssignedEgressIP) error {
+	entries := egressIP.Status.History
+	if len(entries) > len(ips) {
+		entries = entries[len(entries)-len(ips):]
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current := &v1alpha1.EgressIP{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: egressIP.Namespace, Name: egressIP.Name}, current)
+		if err != nil {
+			return err
+		}
+
+		for _, ip := range ips {
+			for i := range current.Status.IPs {
+				if current.Status.IPs[i].FailureDomain == ip.FailureDomain && current.Status.IPs[i].IP == ip.IP {
+					current.Status.IPs[i].HostName = ip.HostName
+				}
+			}
+		}
+		if len(current.Status.IPs) > 0 {
+			current.Status.HostName = current.Status.IPs[0].HostName
+		}
+
+		current.Status.History = append(current.Status.History, entries...)
+		if len(current.Status.History) > HistorySize {
+			current.Status.History = current.Status.History[len(current.Status.History)-HistorySize:]
+		}
+
+		return c.Status().Update(ctx, current)
+	})
+}
+
+// checkCapacity returns an error if the host can not serve another IP besides its pending ones. The capacities of the
+// hosts are the free IPs before the move, they are cached to ask the provisioner only once per host.
+func checkCapacity(ctx context.Context, provisioner provisioner.EgressIPProvisioner, hostName string, pending map[string]int, capacities map[string]int) error {
+	capacity, known := capacities[hostName]
+	if !known {
+		limit, err := provisioner.IPLimit(ctx, hostName)
+		if err != nil {
+			return err
+		}
+		if limit == 0 {
+			capacities[hostName] = -1
+			return nil
+		}
+
+		ips, err := provisioner.ListIPs(ctx, hostName)
+		if err != nil {
+			return err
+		}
+
+		capacity = limit - len(ips)
+		capacities[hostName] = capacity
+	}
+
+	if capacity >= 0 && pending[hostName] >= capacity {
+		return fmt.Errorf("host '%v' has no capacity left for another ip", hostName)
+	}
+
+	return nil
+}
+
+// nodeAffinityOf returns the node affinity of the IP of the EgressIP in the failure domain.
+func nodeAffinityOf(egressIP *v1alpha1.EgressIP, failureDomain string) *corev1.NodeAffinity {
+	for _, spec := range egressIP.Spec.IPs {
+		if spec.FailureDomain == failureDomain {
+			return spec.NodeAffinity
+		}
+	}
+
+	return nil
+}
+
+// recordMove records the move of the assigned IP to the new host for the reason and updates the assigned IP.
+func recordMove(ctx context.Context, client client.Client, recorder record.EventRecorder, instance *v1alpha1.EgressIP, assigned *v1alpha1.AssignedEgressIP, newHostName string, reason string, log logr.Logger) {
+	log.Info("moved ip", "egressip", instance.Namespace+"/"+instance.Name, "failure-domain", assigned.FailureDomain, "ip", assigned.IP, "old-host", assigned.HostName, "new-host", newHostName, "reason", reason)
+	metrics.Moves.WithLabelValues(assigned.FailureDomain).Inc()
+	recordIPEvent(ctx, client, recorder, instance, newHostName, corev1.EventTypeNormal, EventReasonMoved,
+		fmt.Sprintf("ip '%v' moved from host '%v' to host '%v' in failure domain '%v'", assigned.IP, assigned.HostName, newHostName, assigned.FailureDomain),
+	)
+	recordHistory(ctx, client, instance, v1alpha1.EgressIPHistoryEntry{
+		Action:        v1alpha1.HistoryActionMoved,
+		FailureDomain: assigned.FailureDomain,
+		IP:            assigned.IP,
+		OldHostName:   assigned.HostName,
+		NewHostName:   newHostName,
+		Reason:        reason,
+		Actor:         OperatorActor,
+	}, log)
+
+	assigned.HostName = newHostName
+}
@@ -18,36 +18,361 @@ package openshift
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"net"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"sync"
+	"time"
 )
 
-func ManageEgressIPFailureDomain(req ctrl.Request, client client.Client, baseLogger logr.Logger) (ctrl.Result, error) {
-	ctx := context.Background()
+func ManageEgressIPFailureDomain(ctx context.Context, req ctrl.Request, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, baseLogger logr.Logger) (ctrl.Result, error) {
 	log := baseLogger.WithValues("egressipfailuredomain", req.NamespacedName)
 
-	instance := &v1alpha1.EgressIPFailureDomain{}
+	instance := &v1beta1.ClusterEgressIPFailureDomain{}
 	err := client.Get(ctx, req.NamespacedName, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("egressIPFailureDomain not found - the request will not be re-queued")
+			storeCapacity(req.NamespacedName, nil)
+			forgetHostChecks(req.Name)
 
 			return ctrl.Result{
 				Requeue: false,
-			}, err
+			}, nil
 		}
 
 		log.Info("egressIPFailureDomain could not be loaded - the request will be re-queued in 30 seconds")
 		return ctrl.Result{
-			RequeueAfter: 30,
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	status := instance.Status.DeepCopy()
+
+	configured, err := checkHostsOfFailureDomain(ctx, client, provisioner, instance, log)
+	if err != nil {
+		log.Info("eligible hosts could not be checked - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	err = updateCapacityOfFailureDomain(ctx, client, provisioner, recorder, instance, log)
+	if err != nil {
+		log.Info("capacity could not be calculated - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
 		}, err
 	}
 
-	// TODO 2020-09-20 rlichti replace this logging by real working code
-	log.Info("working on", "egressIPFailureDomain", instance)
+	if instance.Status.Phase == "" {
+		instance.Status.Phase = "pending"
+	}
+
+	if !equality.Semantic.DeepEqual(*status, instance.Status) {
+		err = client.Status().Update(ctx, instance)
+		if err != nil {
+			log.Info("status could not be updated - the request will be re-queued in 30 seconds")
+			return ctrl.Result{
+				RequeueAfter: 30 * time.Second,
+			}, err
+		}
+	}
+
+	if !configured {
+		log.Info("misconfigured hosts - the request will be re-queued for checking them again", "interval", HostCheckInterval)
+		return ctrl.Result{
+			RequeueAfter: HostCheckInterval,
+		}, nil
+	}
 
 	return ctrl.Result{}, nil
 }
+
+// checkHostsOfFailureDomain checks the eligible hosts of the failure domain and reflects the result in the
+// condition 'HostsConfigured' and the metric egress_ip_host_misconfigured. It returns if all hosts are configured.
+func checkHostsOfFailureDomain(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, instance *v1beta1.ClusterEgressIPFailureDomain, log logr.Logger) (bool, error) {
+	nodes, err := failuredomain.ListNodesOfFailureDomain(ctx, client, instance)
+	if err != nil {
+		return false, err
+	}
+
+	failures := checkHosts(ctx, provisioner, instance.Name, nodes, log)
+
+	condition := v1beta1.FailureDomainCondition{
+		Type:    v1beta1.FailureDomainHostsConfigured,
+		Status:  corev1.ConditionTrue,
+		Reason:  "AllHostsConfigured",
+		Message: fmt.Sprintf("%v eligible hosts are configured to serve egress ips", len(nodes)),
+	}
+	if len(failures) > 0 {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "HostsMisconfigured"
+		condition.Message = strings.Join(failures, "; ")
+	}
+	SetFailureDomainCondition(&instance.Status, condition)
+
+	return len(failures) == 0, nil
+}
+
+// FailureDomainCapacity is the capacity of a failure domain regarding the addresses of the CIDR and the IPs the eligible
+// hosts can serve.
+type FailureDomainCapacity struct {
+	Total     int64          `json:"total"`
+	Allocated int64          `json:"allocated"`
+	Free      int64          `json:"free"`
+	Eligible  int            `json:"eligible"`
+	Hosts     []HostCapacity `json:"hosts"`
+	Updated   time.Time      `json:"updated"`
+}
+
+// HostCapacity is the number of IPs of the failure domain served by a host compared with its limit. A limit of 0 means
+// unlimited.
+type HostCapacity struct {
+	HostName string `json:"hostname"`
+	IPs      int    `json:"ips"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+var (
+	// capacities keeps the capacity of every failure domain calculated by the last reconciliation.
+	capacities     = make(map[types.NamespacedName]FailureDomainCapacity)
+	capacitiesLock sync.RWMutex
+)
+
+// CapacityOfFailureDomain returns the capacity calculated by the last reconciliation of the failure domain. It does not
+// call the cloud provider.
+func CapacityOfFailureDomain(name types.NamespacedName) (FailureDomainCapacity, bool) {
+	capacitiesLock.RLock()
+	defer capacitiesLock.RUnlock()
+
+	result, found := capacities[name]
+	if found {
+		result.Hosts = append([]HostCapacity{}, result.Hosts...)
+	}
+
+	return result, found
+}
+
+// storeCapacity keeps the capacity of the failure domain and removes the capacity series of the hosts no longer
+// eligible. A capacity of nil forgets the deleted failure domain and removes all of its capacity series. It returns the
+// capacity of the last reconciliation.
+func storeCapacity(name types.NamespacedName, capacity *FailureDomainCapacity) (FailureDomainCapacity, bool) {
+	capacitiesLock.Lock()
+	defer capacitiesLock.Unlock()
+
+	previous, found := capacities[name]
+
+	eligible := make(map[string]bool)
+	if capacity != nil {
+		for _, host := range capacity.Hosts {
+			eligible[host.HostName] = true
+		}
+	}
+	for _, host := range previous.Hosts {
+		if !eligible[host.HostName] {
+			metrics.ForgetNode(name.Name, host.HostName)
+		}
+	}
+
+	if capacity == nil {
+		delete(capacities, name)
+		metrics.ForgetFailureDomain(name.Name)
+		return previous, found
+	}
+
+	capacities[name] = *capacity
+	return previous, found
+}
+
+func (h HostCapacity) exhausted() bool {
+	return h.Limit > 0 && h.IPs >= h.Limit
+}
+
+// exhausted checks if there is no free address left or every eligible host serves its limit of IPs.
+func (c *FailureDomainCapacity) exhausted() bool {
+	if c.Free <= 0 {
+		return true
+	}
+
+	if len(c.Hosts) == 0 {
+		return false
+	}
+
+	for _, host := range c.Hosts {
+		if !host.exhausted() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// capacityOfFailureDomain calculates the addresses of the CIDR, the allocated and free addresses and the IPs per
+// eligible host of the failure domain. Hosts whose IPs can not be listed are left out.
+func capacityOfFailureDomain(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, instance *v1beta1.ClusterEgressIPFailureDomain, log logr.Logger) (*FailureDomainCapacity, error) {
+	_, cidr, err := net.ParseCIDR(instance.Spec.Cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	space, err := failuredomain.NewAddressSpace(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := failuredomain.ListNodesOfFailureDomain(ctx, client, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	allocated, err := allocatedIPsOfFailureDomain(ctx, client, instance.Name, cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FailureDomainCapacity{
+		Total:     space.Size(),
+		Allocated: int64(len(allocated)),
+		Eligible:  len(nodes),
+		Hosts:     make([]HostCapacity, 0),
+		Updated:   time.Now(),
+	}
+
+	used := int64(0)
+	for value := range allocated {
+		if space.Contains(net.ParseIP(value)) {
+			used++
+		}
+	}
+
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			ip := net.ParseIP(address.Address)
+			if ip != nil && space.Contains(ip) && !allocated[ip.String()] {
+				used++
+			}
+		}
+
+		ips, err := provisioner.ListIPs(ctx, node.Name)
+		if err != nil {
+			log.Info("ips of host could not be listed", "host", node.Name, "error", err.Error())
+			continue
+		}
+
+		host := HostCapacity{HostName: node.Name}
+		for _, ip := range ips {
+			if cidr.Contains(*ip) && !failuredomain.IsNodeAddress(&node, ip) {
+				host.IPs++
+			}
+		}
+
+		host.Limit, err = provisioner.IPLimit(ctx, node.Name)
+		if err != nil {
+			log.Info("ip limit of host could not be read", "host", node.Name, "error", err.Error())
+			host.Limit = 0
+		}
+
+		result.Hosts = append(result.Hosts, host)
+	}
+
+	result.Free = result.Total - used
+	if result.Free < 0 {
+		result.Free = 0
+	}
+
+	return result, nil
+}
+
+// updateCapacityOfFailureDomain reflects the capacity of the failure domain in the capacity metrics and records an
+// event for every host and the failure domain running out of capacity since the last reconciliation.
+func updateCapacityOfFailureDomain(ctx context.Context, client client.Client, provisioner provisioner.EgressIPProvisioner, recorder record.EventRecorder, instance *v1beta1.ClusterEgressIPFailureDomain, log logr.Logger) error {
+	capacity, err := capacityOfFailureDomain(ctx, client, provisioner, instance, log)
+	if err != nil {
+		return err
+	}
+	previous, found := storeCapacity(types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}, capacity)
+
+	wasExhausted := make(map[string]bool, len(previous.Hosts))
+	for _, host := range previous.Hosts {
+		wasExhausted[host.HostName] = host.exhausted()
+	}
+
+	for _, host := range capacity.Hosts {
+		metrics.NodeIPs.WithLabelValues(instance.Name, host.HostName).Set(float64(host.IPs))
+		if host.Limit > 0 {
+			metrics.NodeIPLimit.WithLabelValues(instance.Name, host.HostName).Set(float64(host.Limit))
+		} else {
+			metrics.NodeIPLimit.DeleteLabelValues(instance.Name, host.HostName)
+		}
+
+		if host.exhausted() && !wasExhausted[host.HostName] {
+			recordNodeEvent(ctx, client, recorder, host.HostName, corev1.EventTypeWarning, EventReasonCapacityExhausted,
+				fmt.Sprintf("host '%v' serves %v of %v ips in failure domain '%v'", host.HostName, host.IPs, host.Limit, instance.Name),
+			)
+		}
+	}
+
+	metrics.FailureDomainAddresses.WithLabelValues(instance.Name).Set(float64(capacity.Total))
+	metrics.FailureDomainAllocatedAddresses.WithLabelValues(instance.Name).Set(float64(capacity.Allocated))
+	metrics.FailureDomainFreeAddresses.WithLabelValues(instance.Name).Set(float64(capacity.Free))
+	metrics.FailureDomainEligibleNodes.WithLabelValues(instance.Name).Set(float64(capacity.Eligible))
+
+	if capacity.exhausted() && !(found && previous.exhausted()) {
+		recorder.Event(instance, corev1.EventTypeWarning, EventReasonCapacityExhausted,
+			fmt.Sprintf("failure domain '%v' has %v free addresses and %v eligible hosts", instance.Name, capacity.Free, capacity.Eligible),
+		)
+	}
+
+	return nil
+}
+
+// allocatedIPsOfFailureDomain collects all IPs within the CIDR specified or assigned by EgressIPs for the failure
+// domain or quarantined in it.
+func allocatedIPsOfFailureDomain(ctx context.Context, client client.Client, failureDomain string, cidr *net.IPNet) (map[string]bool, error) {
+	egressIPs := &v1alpha1.EgressIPList{}
+	err := client.List(ctx, egressIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool)
+	add := func(domain string, ip string) {
+		parsed := net.ParseIP(ip)
+		if domain == failureDomain && parsed != nil && cidr.Contains(parsed) {
+			result[parsed.String()] = true
+		}
+	}
+
+	for _, egressIP := range egressIPs.Items {
+		for _, ip := range egressIP.Spec.IPs {
+			add(ip.FailureDomain, ip.IP)
+		}
+		for _, ip := range egressIP.Status.IPs {
+			add(ip.FailureDomain, ip.IP)
+		}
+	}
+
+	quarantined, err := quarantinedIPs(ctx, client, failureDomain)
+	if err != nil {
+		return nil, err
+	}
+	for ip := range quarantined {
+		add(failureDomain, ip)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,318 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapacityOfFailureDomain(t *testing.T) {
+	c := prepareClient(
+		failureDomain("capacity-a", "10.0.1.0/24"),
+		node("node-a", "capacity-a", "10.0.1.5", corev1.ConditionTrue),
+		node("node-b", "capacity-a", "10.0.1.6", corev1.ConditionTrue),
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant"},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "capacity-a", IP: "10.0.1.10"}},
+			},
+			Status: v1alpha1.EgressIPStatus{
+				IPs: []v1alpha1.AssignedEgressIP{
+					{FailureDomain: "capacity-a", IP: "10.0.1.10", HostName: "node-a"},
+					{FailureDomain: "capacity-b", IP: "10.0.2.10", HostName: "node-c"},
+				},
+			},
+		},
+	)
+	provisioner := &hostIPs{ips: map[string][]string{"node-a": {"10.0.1.10"}}, limit: 7}
+
+	_, err := openshift.ManageEgressIPFailureDomain(
+		context.Background(),
+		ctrl.Request{NamespacedName: types.NamespacedName{Name: "capacity-a"}},
+		c, provisioner, record.NewFakeRecorder(10), log,
+	)
+	if err != nil {
+		t.Fatalf("Failure domain could not be reconciled: %v", err)
+	}
+
+	for name, gauge := range map[string]struct {
+		current  prometheus.Gauge
+		expected float64
+	}{
+		"addresses":    {metrics.FailureDomainAddresses.WithLabelValues("capacity-a"), 254},
+		"allocated":    {metrics.FailureDomainAllocatedAddresses.WithLabelValues("capacity-a"), 1},
+		"free":         {metrics.FailureDomainFreeAddresses.WithLabelValues("capacity-a"), 251},
+		"eligible":     {metrics.FailureDomainEligibleNodes.WithLabelValues("capacity-a"), 2},
+		"ips-node-a":   {metrics.NodeIPs.WithLabelValues("capacity-a", "node-a"), 1},
+		"ips-node-b":   {metrics.NodeIPs.WithLabelValues("capacity-a", "node-b"), 0},
+		"limit-node-a": {metrics.NodeIPLimit.WithLabelValues("capacity-a", "node-a"), 7},
+	} {
+		current := testutil.ToFloat64(gauge.current)
+		if current != gauge.expected {
+			t.Errorf("Wrong value of '%v'! expected=%v, current=%v", name, gauge.expected, current)
+		}
+	}
+
+	capacity, found := openshift.CapacityOfFailureDomain(types.NamespacedName{Name: "capacity-a"})
+	if !found || capacity.Free != 251 || len(capacity.Hosts) != 2 {
+		t.Errorf("Capacity of the failure domain should be kept! expected free=251 and 2 hosts, current=%v", capacity)
+	}
+}
+
+func TestRemovingCapacitySeries(t *testing.T) {
+	c := prepareClient(
+		failureDomain("series-a", "10.0.1.0/24"),
+		node("node-a", "series-a", "10.0.1.5", corev1.ConditionTrue),
+		node("node-b", "series-a", "10.0.1.6", corev1.ConditionTrue),
+	)
+	provisioner := &hostIPs{ips: map[string][]string{}, limit: 7}
+	reconcile := func() {
+		_, err := openshift.ManageEgressIPFailureDomain(
+			context.Background(),
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: "series-a"}},
+			c, provisioner, record.NewFakeRecorder(10), log,
+		)
+		if err != nil {
+			t.Fatalf("Failure domain could not be reconciled: %v", err)
+		}
+	}
+
+	reconcile()
+	if hosts := gatherHosts(t, "egress_ip_node_ips", "series-a"); len(hosts) != 2 {
+		t.Errorf("Every eligible host should be reported! expected=[node-a node-b], current=%v", hosts)
+	}
+
+	left := &corev1.Node{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "node-b"}, left)
+	left.Labels["topology.kubernetes.io/zone"] = "series-b"
+	_ = c.Update(context.Background(), left)
+
+	reconcile()
+	for _, name := range []string{"egress_ip_node_ips", "egress_ip_node_ip_limit"} {
+		if hosts := gatherHosts(t, name, "series-a"); len(hosts) != 1 || hosts[0] != "node-a" {
+			t.Errorf("Series of the leaving host should be removed! metric=%v, expected=[node-a], current=%v", name, hosts)
+		}
+	}
+
+	_ = c.Delete(context.Background(), failureDomain("series-a", "10.0.1.0/24"))
+
+	reconcile()
+	for _, name := range []string{
+		"egress_ip_failure_domain_addresses",
+		"egress_ip_failure_domain_allocated_addresses",
+		"egress_ip_failure_domain_free_addresses",
+		"egress_ip_failure_domain_eligible_nodes",
+		"egress_ip_node_ips",
+		"egress_ip_node_ip_limit",
+	} {
+		if series := gatherHosts(t, name, "series-a"); len(series) != 0 {
+			t.Errorf("Series of the deleted failure domain should be removed! metric=%v, current=%v", name, series)
+		}
+	}
+}
+
+func TestRecordingExhaustedFailureDomainOnce(t *testing.T) {
+	c := prepareClient(
+		failureDomain("exhausted-a", "10.0.1.4/30"),
+		node("node-a", "exhausted-a", "10.0.1.5", corev1.ConditionTrue),
+		node("node-b", "exhausted-a", "10.0.1.6", corev1.ConditionTrue),
+	)
+	provisioner := &hostIPs{ips: map[string][]string{}}
+	recorder := record.NewFakeRecorder(10)
+	reconcile := func() string {
+		_, err := openshift.ManageEgressIPFailureDomain(
+			context.Background(),
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: "exhausted-a"}},
+			c, provisioner, recorder, log,
+		)
+		if err != nil {
+			t.Fatalf("Failure domain could not be reconciled: %v", err)
+		}
+
+		instance := failureDomain("exhausted-a", "10.0.1.4/30")
+		_ = c.Get(context.Background(), types.NamespacedName{Name: "exhausted-a"}, instance)
+		return instance.ResourceVersion
+	}
+
+	updated := reconcile()
+	if current := reconcile(); current != updated {
+		t.Errorf("Unchanged status should not be updated! expected=%v, current=%v", updated, current)
+	}
+
+	events := receiveEvents(recorder)
+	if len(events) != 1 || !strings.Contains(events[0], openshift.EventReasonCapacityExhausted) {
+		t.Errorf("Exhausted failure domain should be recorded once! expected 1 capacity-exhausted event, current=%v", events)
+	}
+}
+
+func TestIgnoringDeletedFailureDomain(t *testing.T) {
+	c := prepareClient()
+
+	result, err := openshift.ManageEgressIPFailureDomain(
+		context.Background(),
+		ctrl.Request{NamespacedName: types.NamespacedName{Name: "deleted-a"}},
+		c, &hostIPs{ips: map[string][]string{}}, record.NewFakeRecorder(10), log,
+	)
+	if err != nil || result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("Deleted failure domain should not be re-queued! result=%v, error=%v", result, err)
+	}
+}
+
+func TestCheckingHostsOnlyOnce(t *testing.T) {
+	c := prepareClient(
+		failureDomain("checks-a", "10.0.1.0/24"),
+		node("node-a", "checks-a", "10.0.1.5", corev1.ConditionTrue),
+		node("node-b", "checks-a", "10.0.1.6", corev1.ConditionTrue),
+	)
+	provisioner := &hostIPs{ips: map[string][]string{}, misconfigured: "node-b"}
+	reconcile := func() ctrl.Result {
+		result, err := openshift.ManageEgressIPFailureDomain(
+			context.Background(),
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: "checks-a"}},
+			c, provisioner, record.NewFakeRecorder(10), log,
+		)
+		if err != nil {
+			t.Fatalf("Failure domain could not be reconciled: %v", err)
+		}
+
+		return result
+	}
+
+	result := reconcile()
+	if result.RequeueAfter != openshift.HostCheckInterval {
+		t.Errorf("Failure domain with misconfigured host should be checked again! expected=%v, current=%v", openshift.HostCheckInterval, result.RequeueAfter)
+	}
+	if current := testutil.ToFloat64(metrics.MisconfiguredHosts.WithLabelValues("checks-a", "node-b")); current != 1 {
+		t.Errorf("Host 'node-b' should be reported as misconfigured! expected=1, current=%v", current)
+	}
+
+	reconcile()
+	if len(provisioner.checked) != 2 {
+		t.Errorf("Every host should be checked only once! expected=[node-a node-b], current=%v", provisioner.checked)
+	}
+
+	left := &corev1.Node{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "node-b"}, left)
+	left.Labels["topology.kubernetes.io/zone"] = "checks-b"
+	_ = c.Update(context.Background(), left)
+
+	result = reconcile()
+	if result.RequeueAfter != 0 {
+		t.Errorf("Failure domain without misconfigured hosts should not be re-queued! current=%v", result.RequeueAfter)
+	}
+	if len(provisioner.checked) != 2 {
+		t.Errorf("Leaving host should not be checked again! current=%v", provisioner.checked)
+	}
+	for _, checking := range openshift.FailureDomainsCheckingHost("node-b") {
+		if checking == "checks-a" {
+			t.Error("Leaving host should be forgotten!")
+		}
+	}
+	if hosts := gatherMisconfiguredHosts(t, "checks-a"); len(hosts) != 1 || hosts[0] != "node-a" {
+		t.Errorf("Series of the leaving host should be removed! expected=[node-a], current=%v", hosts)
+	}
+}
+
+// hostCheckingProvisioner reads the checked failure domains of the host while the cloud checks it.
+type hostCheckingProvisioner struct {
+	*hostIPs
+	checking map[string][]string
+}
+
+func (p *hostCheckingProvisioner) CheckHost(ctx context.Context, host string) error {
+	p.checking[host] = openshift.FailureDomainsCheckingHost(host)
+	return p.hostIPs.CheckHost(ctx, host)
+}
+
+func TestCheckingHostsWithoutBlockingOtherChecks(t *testing.T) {
+	c := prepareClient(
+		failureDomain("unblocked-a", "10.0.1.0/24"),
+		node("node-a", "unblocked-a", "10.0.1.5", corev1.ConditionTrue),
+	)
+	provisioner := &hostCheckingProvisioner{hostIPs: &hostIPs{ips: map[string][]string{}}, checking: map[string][]string{}}
+
+	done := make(chan error)
+	go func() {
+		_, err := openshift.ManageEgressIPFailureDomain(
+			context.Background(),
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: "unblocked-a"}},
+			c, provisioner, record.NewFakeRecorder(10), log,
+		)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failure domain could not be reconciled: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Checking the host blocks the results of the host checks!")
+	}
+
+	if _, found := provisioner.checking["node-a"]; !found {
+		t.Errorf("Host 'node-a' should have been checked! current=%v", provisioner.checking)
+	}
+}
+
+// gatherMisconfiguredHosts returns the host label of all series of egress_ip_host_misconfigured of the failure domain.
+func gatherMisconfiguredHosts(t *testing.T, failureDomain string) []string {
+	return gatherHosts(t, "egress_ip_host_misconfigured", failureDomain)
+}
+
+// gatherHosts returns the host label of all series of the metric of the failure domain. Series without a host label
+// are returned as empty host.
+func gatherHosts(t *testing.T, name string, failureDomain string) []string {
+	families, err := crmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Can't gather metrics: %v", err)
+	}
+
+	result := make([]string, 0)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["failure_domain"] == failureDomain {
+				result = append(result, labels["host"])
+			}
+		}
+	}
+
+	return result
+}
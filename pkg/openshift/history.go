@@ -0,0 +1,135 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"time"
+)
+
+// Reasons of the changes recorded in the history of the egress IPs.
+const (
+	HistoryReasonSpecified   = "Specified"
+	HistoryReasonUnspecified = "Unspecified"
+	HistoryReasonDeleted     = "Deleted"
+	HistoryReasonNodeFailure = "NodeFailure"
+	HistoryReasonDrifted     = "Drifted"
+	HistoryReasonLost        = "Lost"
+	HistoryReasonAdopted     = "Adopted"
+	HistoryReasonAffinity    = "Affinity"
+)
+
+const (
+	// OperatorActor is the actor of the changes the operator does on its own.
+	OperatorActor = "egress-ip-operator"
+	// HistoryEgressIPLabel labels the EgressIPHistory resources with the name of their EgressIP.
+	HistoryEgressIPLabel = "egressip.kaiserpfalz-edv.de/egressip"
+
+	DefaultHistorySize = 20
+)
+
+var (
+	// HistorySize is the number of changes kept in the status of the EgressIP.
+	HistorySize int
+	// HistoryResourcesEnabled records every change additionally as EgressIPHistory resource.
+	HistoryResourcesEnabled bool
+)
+
+func init() {
+	HistorySize = DefaultHistorySize
+	size, found := os.LookupEnv("HISTORY_SIZE")
+	if found {
+		value, err := strconv.Atoi(size)
+		if err == nil {
+			HistorySize = value
+		}
+	}
+
+	enabled, found := os.LookupEnv("HISTORY_RESOURCES_ENABLED")
+	if found {
+		HistoryResourcesEnabled, _ = strconv.ParseBool(enabled)
+	}
+}
+
+// recordHistory appends the change to the bounded history in the status of the EgressIP. With history resources
+// enabled the change is also stored as EgressIPHistory in the namespace of the EgressIP. The status is persisted by
+// the caller.
+func recordHistory(ctx context.Context, client client.Client, instance *v1alpha1.EgressIP, entry v1alpha1.EgressIPHistoryEntry, log logr.Logger) {
+	entry.Timestamp = metav1.Now()
+
+	instance.Status.History = append(instance.Status.History, entry)
+	if len(instance.Status.History) > HistorySize {
+		instance.Status.History = instance.Status.History[len(instance.Status.History)-HistorySize:]
+	}
+
+	if !HistoryResourcesEnabled {
+		return
+	}
+
+	history := &v1alpha1.EgressIPHistory{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: instance.Name + "-",
+			Namespace:    instance.Namespace,
+			Labels: map[string]string{
+				HistoryEgressIPLabel: instance.Name,
+			},
+		},
+		Spec: v1alpha1.EgressIPHistorySpec{
+			EgressIP:             instance.Name,
+			EgressIPHistoryEntry: entry,
+		},
+	}
+
+	err := client.Create(ctx, history)
+	if err != nil {
+		log.Error(err, "history of ip could not be recorded", "action", entry.Action, "ip", entry.IP)
+	}
+}
+
+// specActor returns the manager of the latest change of the spec of the EgressIP. Without managed fields the operator
+// is returned.
+func specActor(instance *v1alpha1.EgressIP) string {
+	result := OperatorActor
+	found := false
+	latest := time.Time{}
+
+	for _, field := range instance.ManagedFields {
+		if field.Manager == "" || field.FieldsV1 == nil || !bytes.Contains(field.FieldsV1.Raw, []byte(`"f:spec"`)) {
+			continue
+		}
+
+		changed := time.Time{}
+		if field.Time != nil {
+			changed = field.Time.Time
+		}
+
+		if !found || changed.After(latest) {
+			result = field.Manager
+			latest = changed
+			found = true
+		}
+	}
+
+	return result
+}
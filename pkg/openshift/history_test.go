@@ -0,0 +1,142 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+func prepareManagedEgressIP(spec ...v1alpha1.FailureDomainEgressIPSpec) client.Client {
+	changed := metav1.Now()
+
+	return prepareClient(
+		failureDomain("lifecycle-a", "10.0.1.0/24"),
+		node("node-a", "lifecycle-a", "10.0.1.5", corev1.ConditionTrue),
+		node("node-b", "lifecycle-a", "10.0.1.6", corev1.ConditionTrue),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}},
+		&netv1.NetNamespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}, NetName: "tenant"},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      egressIPName.Name,
+				Namespace: egressIPName.Namespace,
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{
+						Manager:    "kubectl",
+						Operation:  metav1.ManagedFieldsOperationUpdate,
+						Time:       &changed,
+						FieldsType: "FieldsV1",
+						FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:ips":{}}}`)},
+					},
+				},
+			},
+			Spec: v1alpha1.EgressIPSpec{IPs: spec},
+		},
+	)
+}
+
+func TestRecordingHistoryOfAssignmentAndMove(t *testing.T) {
+	c := prepareManagedEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.13"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	reconcileEgressIP(t, c, provisioner)
+
+	failed := &corev1.Node{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "node-a"}, failed)
+	failed.Status.Conditions[0].Status = corev1.ConditionFalse
+	_ = c.Update(context.Background(), failed)
+	provisioner.target = "node-b"
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	history := instance.Status.History
+	if len(history) != 2 {
+		t.Fatalf("Wrong number of history entries! expected=2, current=%v", history)
+	}
+
+	assigned := history[0]
+	if assigned.Action != v1alpha1.HistoryActionAssigned || assigned.IP != "10.0.1.13" ||
+		assigned.OldHostName != "" || assigned.NewHostName != "node-a" ||
+		assigned.Reason != openshift.HistoryReasonSpecified || assigned.Actor != "kubectl" || assigned.Timestamp.IsZero() {
+		t.Errorf("Wrong history entry of the assignment! current=%v", assigned)
+	}
+
+	moved := history[1]
+	if moved.Action != v1alpha1.HistoryActionMoved || moved.IP != "10.0.1.13" ||
+		moved.OldHostName != "node-a" || moved.NewHostName != "node-b" ||
+		moved.Reason != openshift.HistoryReasonNodeFailure || moved.Actor != openshift.OperatorActor {
+		t.Errorf("Wrong history entry of the move! current=%v", moved)
+	}
+}
+
+func TestBoundingHistoryInStatus(t *testing.T) {
+	defer func(size int) { openshift.HistorySize = size }(openshift.HistorySize)
+	openshift.HistorySize = 2
+
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+	instance.Spec.IPs = []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-b"}}
+	_ = c.Update(context.Background(), instance)
+
+	instance = reconcileEgressIP(t, c, provisioner)
+
+	history := instance.Status.History
+	if len(history) != 2 {
+		t.Fatalf("The oldest entry should have been dropped from the history! current=%v", history)
+	}
+
+	if history[0].Action != v1alpha1.HistoryActionReleased || history[0].Reason != openshift.HistoryReasonUnspecified ||
+		history[0].FailureDomain != "lifecycle-a" || history[0].OldHostName != "node-a" {
+		t.Errorf("Wrong history entry of the release! current=%v", history[0])
+	}
+	if history[1].Action != v1alpha1.HistoryActionAssigned || history[1].FailureDomain != "lifecycle-b" {
+		t.Errorf("Wrong history entry of the assignment! current=%v", history[1])
+	}
+}
+
+func TestRecordingHistoryResources(t *testing.T) {
+	defer func(enabled bool) { openshift.HistoryResourcesEnabled = enabled }(openshift.HistoryResourcesEnabled)
+	openshift.HistoryResourcesEnabled = true
+
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.14"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	reconcileEgressIP(t, c, provisioner)
+
+	histories := &v1alpha1.EgressIPHistoryList{}
+	_ = c.List(context.Background(), histories, client.InNamespace(egressIPName.Namespace),
+		client.MatchingLabels{openshift.HistoryEgressIPLabel: egressIPName.Name},
+	)
+	if len(histories.Items) != 1 {
+		t.Fatalf("Wrong number of history resources! expected=1, current=%v", histories.Items)
+	}
+
+	history := histories.Items[0].Spec
+	if history.EgressIP != egressIPName.Name || history.Action != v1alpha1.HistoryActionAssigned ||
+		history.IP != "10.0.1.14" || history.NewHostName != "node-a" || history.Actor != openshift.OperatorActor {
+		t.Errorf("Wrong history resource! current=%v", history)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultHostCheckInterval = 1 * time.Hour
+
+// HostCheckInterval is the time the result of checking an eligible host is reused. Hosts joining a failure domain or
+// replaced by a new node are checked at once.
+var HostCheckInterval time.Duration
+
+func init() {
+	HostCheckInterval = DefaultHostCheckInterval
+	interval, found := os.LookupEnv("HOST_CHECK_INTERVAL")
+	if found {
+		value, err := time.ParseDuration(interval)
+		if err == nil {
+			HostCheckInterval = value
+		}
+	}
+}
+
+// hostCheck is the result of checking a host. The failure is empty for a configured host.
+type hostCheck struct {
+	uid     types.UID
+	failure string
+	checked time.Time
+}
+
+var (
+	// hostChecks keeps the results of checking the eligible hosts keyed by failure domain and host name.
+	hostChecks     = make(map[string]map[string]hostCheck)
+	hostChecksLock sync.Mutex
+)
+
+// FailureDomainsCheckingHost returns the failure domains the host has been checked for as an eligible host.
+func FailureDomainsCheckingHost(hostName string) []string {
+	hostChecksLock.Lock()
+	defer hostChecksLock.Unlock()
+
+	result := make([]string, 0)
+	for failureDomain, checks := range hostChecks {
+		if _, found := checks[hostName]; found {
+			result = append(result, failureDomain)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// checkHosts checks the eligible hosts of the failure domain not checked within the HostCheckInterval and returns the
+// failures of all eligible hosts. Hosts no longer eligible are forgotten and their series of the metric
+// egress_ip_host_misconfigured are removed. The cloud is called without holding the lock of the results.
+func checkHosts(ctx context.Context, provisioner provisioner.EgressIPProvisioner, failureDomain string, nodes []corev1.Node, log logr.Logger) []string {
+	due := dueHostChecks(failureDomain, nodes)
+	for hostName, check := range due {
+		err := provisioner.CheckHost(ctx, hostName)
+		if err != nil {
+			log.Info("host is not configured to serve egress ips", "host", hostName, "error", err.Error())
+			check.failure = err.Error()
+		}
+
+		due[hostName] = check
+	}
+
+	hostChecksLock.Lock()
+	defer hostChecksLock.Unlock()
+
+	checks := hostChecks[failureDomain]
+	if checks == nil {
+		checks = make(map[string]hostCheck)
+		hostChecks[failureDomain] = checks
+	}
+
+	eligible := make(map[string]bool, len(nodes))
+	failures := make([]string, 0)
+	for _, node := range nodes {
+		eligible[node.Name] = true
+
+		check, found := due[node.Name]
+		if found {
+			checks[node.Name] = check
+		} else {
+			check = checks[node.Name]
+		}
+
+		if check.failure != "" {
+			metrics.MisconfiguredHosts.WithLabelValues(failureDomain, node.Name).Set(1)
+			failures = append(failures, fmt.Sprintf("%v: %v", node.Name, check.failure))
+			continue
+		}
+
+		metrics.MisconfiguredHosts.WithLabelValues(failureDomain, node.Name).Set(0)
+	}
+
+	for hostName := range checks {
+		if !eligible[hostName] {
+			forgetHost(failureDomain, hostName)
+		}
+	}
+
+	return failures
+}
+
+// dueHostChecks returns the new checks of the eligible hosts not checked within the HostCheckInterval or replaced by a
+// new node.
+func dueHostChecks(failureDomain string, nodes []corev1.Node) map[string]hostCheck {
+	hostChecksLock.Lock()
+	defer hostChecksLock.Unlock()
+
+	result := make(map[string]hostCheck)
+	for _, node := range nodes {
+		check, found := hostChecks[failureDomain][node.Name]
+		if !found || check.uid != node.UID || time.Since(check.checked) >= HostCheckInterval {
+			result[node.Name] = hostCheck{uid: node.UID, checked: time.Now()}
+		}
+	}
+
+	return result
+}
+
+// forgetHostChecks forgets all hosts checked for the deleted failure domain.
+func forgetHostChecks(failureDomain string) {
+	hostChecksLock.Lock()
+	defer hostChecksLock.Unlock()
+
+	for hostName := range hostChecks[failureDomain] {
+		forgetHost(failureDomain, hostName)
+	}
+	delete(hostChecks, failureDomain)
+}
+
+func forgetHost(failureDomain string, hostName string) {
+	delete(hostChecks[failureDomain], hostName)
+	metrics.MisconfiguredHosts.DeleteLabelValues(failureDomain, hostName)
+}
@@ -18,15 +18,23 @@ package openshift
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
 	netv1 "github.com/openshift/api/network/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
 )
 
-func ManageHostSubnet(req ctrl.Request, client client.Client, baseLogger logr.Logger) (ctrl.Result, error) {
-	ctx := context.Background()
+// ManageHostSubnet verifies all EgressIPs with IPs assigned to the host of the HostSubnet, so IPs removed from the
+// HostSubnet are restored.
+func ManageHostSubnet(ctx context.Context, req ctrl.Request, client client.Client, provisioner provisioner.EgressIPProvisioner, alarms metrics.AlarmStore, recorder record.EventRecorder, baseLogger logr.Logger) (ctrl.Result, error) {
 	log := baseLogger.WithValues("hostsubnet", req.NamespacedName)
 
 	instance := &netv1.HostSubnet{}
@@ -42,12 +50,49 @@ func ManageHostSubnet(req ctrl.Request, client client.Client, baseLogger logr.Lo
 
 		log.Info("HostSubnet could not be loaded - the request will be re-queued in 30 seconds")
 		return ctrl.Result{
-			RequeueAfter: 30,
+			RequeueAfter: 30 * time.Second,
 		}, err
 	}
 
-	// TODO 2020-09-20 rlichti replace this logging by real working code
-	log.Info("working on", "hostsubnet", instance)
+	egressIPs := &v1alpha1.EgressIPList{}
+	err = client.List(ctx, egressIPs)
+	if err != nil {
+		log.Info("egressIPs could not be listed - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	failed := 0
+	for _, egressIP := range egressIPs.Items {
+		if !isServedBy(&egressIP, instance.Host) {
+			continue
+		}
+
+		_, err = ManageEgressIP(
+			ctx,
+			ctrl.Request{NamespacedName: types.NamespacedName{Namespace: egressIP.Namespace, Name: egressIP.Name}},
+			client, provisioner, alarms, recorder, baseLogger,
+		)
+		if err != nil {
+			log.Info("egressIP could not be verified", "egressip", egressIP.Namespace+"/"+egressIP.Name, "error", err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return ctrl.Result{}, fmt.Errorf("%v egressips served by host '%v' could not be verified", failed, instance.Host)
+	}
 
 	return ctrl.Result{}, nil
 }
+
+func isServedBy(egressIP *v1alpha1.EgressIP, hostName string) bool {
+	for _, ip := range egressIP.Status.IPs {
+		if ip.HostName == hostName {
+			return true
+		}
+	}
+
+	return false
+}
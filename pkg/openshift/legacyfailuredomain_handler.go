@@ -0,0 +1,176 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+// ManageLegacyFailureDomain migrates a namespaced v1alpha1 EgressIPFailureDomain to the ClusterEgressIPFailureDomain of
+// the same name. The namespaced object stays the source of the spec while it exists and mirrors the status of the
+// cluster failure domain. When it is deleted, the annotation is removed and the cluster failure domain is managed on
+// its own from then on.
+func ManageLegacyFailureDomain(ctx context.Context, req ctrl.Request, client client.Client, baseLogger logr.Logger) (ctrl.Result, error) {
+	log := baseLogger.WithValues("egressipfailuredomain", req.NamespacedName)
+
+	instance := &v1alpha1.EgressIPFailureDomain{}
+	err := client.Get(ctx, req.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("egressIPFailureDomain not found - handing over the cluster failure domain")
+			err = handOverClusterFailureDomain(ctx, client, req.NamespacedName)
+			if err != nil {
+				log.Info("cluster failure domain could not be handed over - the request will be re-queued in 30 seconds")
+				return ctrl.Result{
+					RequeueAfter: 30 * time.Second,
+				}, err
+			}
+
+			return ctrl.Result{
+				Requeue: false,
+			}, nil
+		}
+
+		log.Info("egressIPFailureDomain could not be loaded - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	if instance.DeletionTimestamp != nil {
+		log.Info("egressIPFailureDomain is deleted - the request will not be re-queued")
+		return ctrl.Result{}, nil
+	}
+
+	cluster := &v1beta1.ClusterEgressIPFailureDomain{}
+	err = client.Get(ctx, types.NamespacedName{Name: instance.Name}, cluster)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Info("cluster failure domain could not be loaded - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	status := instance.Status.DeepCopy()
+	switch {
+	case errors.IsNotFound(err):
+		cluster = &v1beta1.ClusterEgressIPFailureDomain{}
+		instance.ConvertToCluster(cluster)
+
+		err = client.Create(ctx, cluster)
+		if err != nil {
+			log.Info("cluster failure domain could not be created - the request will be re-queued in 30 seconds")
+			return ctrl.Result{
+				RequeueAfter: 30 * time.Second,
+			}, err
+		}
+		log.Info("migrated to cluster failure domain", "clusteregressipfailuredomain", cluster.Name)
+
+		mirrorClusterStatus(instance, cluster)
+
+	case cluster.Annotations[v1alpha1.MigratedFromAnnotation] != instance.MigratedFrom():
+		log.Info("name is already used by another cluster failure domain", "clusteregressipfailuredomain", cluster.Name)
+
+		instance.Status.Phase = "failed"
+		instance.Status.Message = fmt.Sprintf("the name '%v' is already used by a cluster failure domain", cluster.Name)
+
+	default:
+		migrated := cluster.DeepCopy()
+		instance.ConvertToCluster(migrated)
+
+		if !equality.Semantic.DeepEqual(cluster.Spec, migrated.Spec) {
+			err = client.Update(ctx, migrated)
+			if err != nil {
+				log.Info("cluster failure domain could not be updated - the request will be re-queued in 30 seconds")
+				return ctrl.Result{
+					RequeueAfter: 30 * time.Second,
+				}, err
+			}
+			log.Info("updated cluster failure domain", "clusteregressipfailuredomain", cluster.Name)
+		}
+
+		mirrorClusterStatus(instance, migrated)
+	}
+
+	if equality.Semantic.DeepEqual(*status, instance.Status) {
+		return ctrl.Result{}, nil
+	}
+
+	err = client.Status().Update(ctx, instance)
+	if err != nil {
+		log.Info("status could not be updated - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// mirrorClusterStatus copies the phase and the conditions of the cluster failure domain to the namespaced one.
+func mirrorClusterStatus(instance *v1alpha1.EgressIPFailureDomain, cluster *v1beta1.ClusterEgressIPFailureDomain) {
+	instance.Status.Phase = cluster.Status.Phase
+	if instance.Status.Phase == "" {
+		instance.Status.Phase = "pending"
+	}
+	instance.Status.Message = fmt.Sprintf("migrated to cluster failure domain '%v'", cluster.Name)
+
+	instance.Status.Conditions = nil
+	for _, condition := range cluster.Status.Conditions {
+		instance.Status.Conditions = append(instance.Status.Conditions, v1alpha1.FailureDomainCondition{
+			Type:               condition.Type,
+			Status:             condition.Status,
+			LastTransitionTime: condition.LastTransitionTime,
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+		})
+	}
+}
+
+// handOverClusterFailureDomain removes the MigratedFromAnnotation of the cluster failure domain migrated from the
+// deleted namespaced failure domain. Cluster failure domains migrated from other ones are left alone.
+func handOverClusterFailureDomain(ctx context.Context, client client.Client, name types.NamespacedName) error {
+	cluster := &v1beta1.ClusterEgressIPFailureDomain{}
+	err := client.Get(ctx, types.NamespacedName{Name: name.Name}, cluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	legacy := &v1alpha1.EgressIPFailureDomain{}
+	legacy.Namespace = name.Namespace
+	legacy.Name = name.Name
+	if cluster.Annotations[v1alpha1.MigratedFromAnnotation] != legacy.MigratedFrom() {
+		return nil
+	}
+
+	delete(cluster.Annotations, v1alpha1.MigratedFromAnnotation)
+	return client.Update(ctx, cluster)
+}
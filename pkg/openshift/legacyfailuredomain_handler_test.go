@@ -0,0 +1,129 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"testing"
+)
+
+var legacyName = types.NamespacedName{Namespace: "egress-ip-operator", Name: "lifecycle-a"}
+
+func legacyFailureDomain(cidr string) *v1alpha1.EgressIPFailureDomain {
+	return &v1alpha1.EgressIPFailureDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: legacyName.Name, Namespace: legacyName.Namespace},
+		Spec:       v1alpha1.EgressIPFailureDomainSpec{Cidr: cidr, NodeSelector: failureDomain(legacyName.Name, cidr).Spec.NodeSelector},
+	}
+}
+
+func TestMigratingLegacyFailureDomain(t *testing.T) {
+	c := prepareClient(legacyFailureDomain("10.0.1.0/24"))
+
+	_, err := openshift.ManageLegacyFailureDomain(context.Background(), ctrl.Request{NamespacedName: legacyName}, c, log)
+	if err != nil {
+		t.Errorf("migration failed. error=%v", err)
+	}
+
+	cluster := &v1beta1.ClusterEgressIPFailureDomain{}
+	err = c.Get(context.Background(), types.NamespacedName{Name: legacyName.Name}, cluster)
+	if err != nil {
+		t.Fatalf("cluster failure domain not created. error=%v", err)
+	}
+	if cluster.Spec.Cidr != "10.0.1.0/24" {
+		t.Errorf("wrong cidr of the cluster failure domain. expected=%v, current=%v", "10.0.1.0/24", cluster.Spec.Cidr)
+	}
+	if cluster.Annotations[v1alpha1.MigratedFromAnnotation] != "egressipfailuredomain/egress-ip-operator/lifecycle-a" {
+		t.Errorf("wrong migrated-from annotation. expected=%v, current=%v", "egressipfailuredomain/egress-ip-operator/lifecycle-a", cluster.Annotations[v1alpha1.MigratedFromAnnotation])
+	}
+
+	legacy := &v1alpha1.EgressIPFailureDomain{}
+	_ = c.Get(context.Background(), legacyName, legacy)
+	if legacy.Status.Phase != "pending" {
+		t.Errorf("wrong phase of the namespaced failure domain. expected=%v, current=%v", "pending", legacy.Status.Phase)
+	}
+}
+
+func TestUpdatingMigratedFailureDomain(t *testing.T) {
+	cluster := failureDomain(legacyName.Name, "10.0.1.0/24")
+	cluster.Annotations = map[string]string{v1alpha1.MigratedFromAnnotation: "egressipfailuredomain/egress-ip-operator/lifecycle-a"}
+	cluster.Status.Phase = "provisioned"
+	c := prepareClient(legacyFailureDomain("10.0.2.0/24"), cluster)
+
+	_, err := openshift.ManageLegacyFailureDomain(context.Background(), ctrl.Request{NamespacedName: legacyName}, c, log)
+	if err != nil {
+		t.Errorf("migration failed. error=%v", err)
+	}
+
+	_ = c.Get(context.Background(), types.NamespacedName{Name: legacyName.Name}, cluster)
+	if cluster.Spec.Cidr != "10.0.2.0/24" {
+		t.Errorf("cidr of the cluster failure domain not updated. expected=%v, current=%v", "10.0.2.0/24", cluster.Spec.Cidr)
+	}
+
+	legacy := &v1alpha1.EgressIPFailureDomain{}
+	_ = c.Get(context.Background(), legacyName, legacy)
+	if legacy.Status.Phase != "provisioned" {
+		t.Errorf("phase of the cluster failure domain not mirrored. expected=%v, current=%v", "provisioned", legacy.Status.Phase)
+	}
+}
+
+func TestRefusingMigrationToUsedName(t *testing.T) {
+	cluster := failureDomain(legacyName.Name, "10.0.1.0/24")
+	c := prepareClient(legacyFailureDomain("10.0.2.0/24"), cluster)
+
+	_, err := openshift.ManageLegacyFailureDomain(context.Background(), ctrl.Request{NamespacedName: legacyName}, c, log)
+	if err != nil {
+		t.Errorf("migration failed. error=%v", err)
+	}
+
+	_ = c.Get(context.Background(), types.NamespacedName{Name: legacyName.Name}, cluster)
+	if cluster.Spec.Cidr != "10.0.1.0/24" {
+		t.Errorf("cluster failure domain of another owner changed. expected=%v, current=%v", "10.0.1.0/24", cluster.Spec.Cidr)
+	}
+
+	legacy := &v1alpha1.EgressIPFailureDomain{}
+	_ = c.Get(context.Background(), legacyName, legacy)
+	if legacy.Status.Phase != "failed" {
+		t.Errorf("wrong phase of the namespaced failure domain. expected=%v, current=%v", "failed", legacy.Status.Phase)
+	}
+}
+
+func TestHandingOverClusterFailureDomainOfDeletedLegacyOne(t *testing.T) {
+	cluster := failureDomain(legacyName.Name, "10.0.1.0/24")
+	cluster.Annotations = map[string]string{v1alpha1.MigratedFromAnnotation: "egressipfailuredomain/egress-ip-operator/lifecycle-a"}
+	c := prepareClient(cluster)
+
+	_, err := openshift.ManageLegacyFailureDomain(context.Background(), ctrl.Request{NamespacedName: legacyName}, c, log)
+	if err != nil {
+		t.Errorf("hand over failed. error=%v", err)
+	}
+
+	handedOver := &v1beta1.ClusterEgressIPFailureDomain{}
+	err = c.Get(context.Background(), types.NamespacedName{Name: legacyName.Name}, handedOver)
+	if errors.IsNotFound(err) {
+		t.Fatalf("cluster failure domain deleted")
+	}
+	if _, ok := handedOver.Annotations[v1alpha1.MigratedFromAnnotation]; ok {
+		t.Errorf("migrated-from annotation not removed. current=%v", handedOver.Annotations[v1alpha1.MigratedFromAnnotation])
+	}
+}
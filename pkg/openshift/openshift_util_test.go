@@ -0,0 +1,204 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var log = zap.New(zap.UseDevMode(true)).WithName("openshift_test")
+
+// hostIPs is a minimal provisioner keeping the IPs per host. New IPs are added to the host named by target or, if
+// targets are given, to the one of them serving the least IPs including the pending ones.
+type hostIPs struct {
+	ips           map[string][]string
+	target        string
+	targets       []string
+	limit         int
+	err           error
+	checked       []string
+	misconfigured string
+	moveBatches   int
+	removeBatches int
+}
+
+func (h *hostIPs) FindHostForNewIP(_ context.Context, _ string, _ *corev1.NodeAffinity, pending map[string]int) (string, error) {
+	if len(h.targets) == 0 {
+		return h.target, nil
+	}
+
+	result := h.targets[0]
+	for _, target := range h.targets[1:] {
+		if len(h.ips[target])+pending[target] < len(h.ips[result])+pending[result] {
+			result = target
+		}
+	}
+
+	return result, nil
+}
+func (h *hostIPs) AddSpecifiedIP(_ context.Context, ip *net.IP, host string) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	h.ips[host] = append(h.ips[host], ip.String())
+	return nil
+}
+func (h *hostIPs) AddRandomIP(ctx context.Context, host string) (*net.IP, error) {
+	ip := net.ParseIP(fmt.Sprintf("10.0.1.%v", 100+len(h.ips[host])))
+	return &ip, h.AddSpecifiedIP(ctx, &ip, host)
+}
+func (h *hostIPs) MoveIP(ctx context.Context, ip *net.IP, oldHost string, newHost string) error {
+	_ = h.RemoveIP(ctx, ip, oldHost)
+	return h.AddSpecifiedIP(ctx, ip, newHost)
+}
+func (h *hostIPs) CheckIP(_ context.Context, ip *net.IP, host string) error {
+	for _, assigned := range h.ips[host] {
+		if assigned == ip.String() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("ip '%v' is not assigned to host '%v'", ip.String(), host)
+}
+func (h *hostIPs) CheckHost(_ context.Context, host string) error {
+	h.checked = append(h.checked, host)
+	if host == h.misconfigured {
+		return fmt.Errorf("host '%v' is misconfigured", host)
+	}
+
+	return nil
+}
+func (h *hostIPs) CheckBackend(_ context.Context) error             { return h.err }
+func (h *hostIPs) AssignCIDR(_ context.Context, _ string) error     { return nil }
+func (h *hostIPs) IPLimit(_ context.Context, _ string) (int, error) { return h.limit, nil }
+func (h *hostIPs) FindIP(_ context.Context, ip *net.IP) (string, error) {
+	for host, ips := range h.ips {
+		for _, assigned := range ips {
+			if assigned == ip.String() {
+				return host, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+func (h *hostIPs) AddSpecifiedIPs(_ context.Context, _ []*net.IP, _ string) map[string]error {
+	return map[string]error{}
+}
+func (h *hostIPs) AddRandomIPs(_ context.Context, _ int, _ string) ([]*net.IP, error) {
+	return nil, nil
+}
+func (h *hostIPs) MoveIPs(ctx context.Context, ips []*net.IP, oldHost string, newHost string) map[string]error {
+	h.moveBatches++
+	result := make(map[string]error)
+	for _, ip := range ips {
+		if err := h.MoveIP(ctx, ip, oldHost, newHost); err != nil {
+			result[ip.String()] = err
+		}
+	}
+
+	return result
+}
+func (h *hostIPs) RemoveIPs(ctx context.Context, ips []*net.IP, host string) map[string]error {
+	h.removeBatches++
+	result := make(map[string]error)
+	for _, ip := range ips {
+		if err := h.RemoveIP(ctx, ip, host); err != nil {
+			result[ip.String()] = err
+		}
+	}
+
+	return result
+}
+func (h *hostIPs) RemoveIP(_ context.Context, ip *net.IP, host string) error {
+	remaining := make([]string, 0)
+	for _, assigned := range h.ips[host] {
+		if assigned != ip.String() {
+			remaining = append(remaining, assigned)
+		}
+	}
+	h.ips[host] = remaining
+
+	return nil
+}
+func (h *hostIPs) ListIPs(_ context.Context, host string) ([]*net.IP, error) {
+	result := make([]*net.IP, 0)
+	for _, assigned := range h.ips[host] {
+		ip := net.ParseIP(assigned)
+		result = append(result, &ip)
+	}
+
+	return result, nil
+}
+
+func failureDomain(name string, cidr string) *v1beta1.ClusterEgressIPFailureDomain {
+	return &v1beta1.ClusterEgressIPFailureDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1beta1.ClusterEgressIPFailureDomainSpec{
+			Cidr: cidr,
+			NodeSelector: corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      "topology.kubernetes.io/zone",
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{name},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func node(name string, zone string, address string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"topology.kubernetes.io/zone": zone},
+		},
+		Status: corev1.NodeStatus{
+			Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func prepareClient(objects ...runtime.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = netv1.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+	_ = v1beta1.AddToScheme(scheme)
+
+	return fake.NewFakeClientWithScheme(scheme, objects...)
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// reportedUsages keeps the usages reported as metrics by the last reconciliation of every policy, so the series of
+	// namespaces no longer selected or deleted policies can be removed.
+	reportedUsages     = make(map[string][]v1alpha1.NamespaceUsage)
+	reportedUsagesLock sync.Mutex
+)
+
+// ManageEgressIPPolicy reports the egress IPs assigned to the namespaces selected by the policy against its quotas in
+// the status of the policy and as metrics. Namespaces without egress IPs are not reported.
+func ManageEgressIPPolicy(ctx context.Context, req ctrl.Request, client client.Client, recorder record.EventRecorder, baseLogger logr.Logger) (ctrl.Result, error) {
+	log := baseLogger.WithValues("egressippolicy", req.Name)
+
+	instance := &v1alpha1.EgressIPPolicy{}
+	err := client.Get(ctx, req.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("egressIPPolicy not found - the request will not be re-queued")
+			reportPolicyMetrics(req.Name, nil, nil)
+
+			return ctrl.Result{
+				Requeue: false,
+			}, nil
+		}
+
+		log.Info("egressIPPolicy could not be loaded - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	usages, err := usagesOfPolicy(ctx, client, instance)
+	if err != nil {
+		log.Info("usage of the policy could not be calculated - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	for _, usage := range usages {
+		if len(usage.Violations) > 0 && !equalStrings(usage.Violations, violationsOf(instance.Status.Namespaces, usage.Namespace)) {
+			recorder.Event(instance, corev1.EventTypeWarning, EventReasonPolicyViolated, strings.Join(usage.Violations, "; "))
+		}
+	}
+
+	reportPolicyMetrics(instance.Name, instance, usages)
+
+	if equality.Semantic.DeepEqual(instance.Status.Namespaces, usages) {
+		return ctrl.Result{}, nil
+	}
+
+	instance.Status.Namespaces = usages
+	err = client.Status().Update(ctx, instance)
+	if err != nil {
+		log.Info("status of the policy could not be updated - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// usagesOfPolicy returns the assigned egress IPs of the namespaces selected by the policy sorted by namespace.
+func usagesOfPolicy(ctx context.Context, client client.Client, instance *v1alpha1.EgressIPPolicy) ([]v1alpha1.NamespaceUsage, error) {
+	egressIPs := &v1alpha1.EgressIPList{}
+	err := client.List(ctx, egressIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	err = client.List(ctx, namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]map[string]string)
+	for _, namespace := range namespaces.Items {
+		labels[namespace.Name] = namespace.Labels
+	}
+
+	names := make([]string, 0)
+	listed := make(map[string]bool)
+	for _, egressIP := range egressIPs.Items {
+		if !listed[egressIP.Namespace] {
+			listed[egressIP.Namespace] = true
+			names = append(names, egressIP.Namespace)
+		}
+	}
+	sort.Strings(names)
+
+	result := make([]v1alpha1.NamespaceUsage, 0)
+	for _, name := range names {
+		namespace := &corev1.Namespace{}
+		namespace.Name = name
+		namespace.Labels = labels[name]
+		if !policy.Selects(instance, namespace) {
+			continue
+		}
+
+		usage := policy.AssignedUsage(egressIPs.Items, name)
+		if usage.Total == 0 {
+			continue
+		}
+
+		failureDomains := make([]v1alpha1.FailureDomainUsage, 0, len(usage.PerFailureDomain))
+		for failureDomain, count := range usage.PerFailureDomain {
+			failureDomains = append(failureDomains, v1alpha1.FailureDomainUsage{Name: failureDomain, IPs: count})
+		}
+		sort.Slice(failureDomains, func(i, j int) bool {
+			return failureDomains[i].Name < failureDomains[j].Name
+		})
+
+		current := v1alpha1.NamespaceUsage{
+			Namespace:      name,
+			IPs:            usage.Total,
+			FailureDomains: failureDomains,
+		}
+		violations := policy.Violations([]v1alpha1.EgressIPPolicy{*instance}, name, usage)
+		if len(violations) > 0 {
+			current.Violations = violations
+		}
+
+		result = append(result, current)
+	}
+
+	return result, nil
+}
+
+func violationsOf(usages []v1alpha1.NamespaceUsage, namespace string) []string {
+	for _, usage := range usages {
+		if usage.Namespace == namespace {
+			return usage.Violations
+		}
+	}
+
+	return []string{}
+}
+
+// reportPolicyMetrics replaces the metrics of the policy. A nil instance removes all metrics of the policy.
+func reportPolicyMetrics(name string, instance *v1alpha1.EgressIPPolicy, usages []v1alpha1.NamespaceUsage) {
+	reportedUsagesLock.Lock()
+	defer reportedUsagesLock.Unlock()
+
+	for _, usage := range reportedUsages[name] {
+		metrics.PolicyNamespaceIPs.DeleteLabelValues(name, usage.Namespace)
+		metrics.PolicyNamespaceViolations.DeleteLabelValues(name, usage.Namespace)
+		for _, failureDomain := range usage.FailureDomains {
+			metrics.PolicyNamespaceFailureDomainIPs.DeleteLabelValues(name, usage.Namespace, failureDomain.Name)
+		}
+	}
+	metrics.PolicyMaxIPs.DeleteLabelValues(name)
+	metrics.PolicyMaxIPsPerFailureDomain.DeleteLabelValues(name)
+
+	if instance == nil {
+		delete(reportedUsages, name)
+		return
+	}
+
+	if instance.Spec.MaxIPs != nil {
+		metrics.PolicyMaxIPs.WithLabelValues(name).Set(float64(*instance.Spec.MaxIPs))
+	}
+	if instance.Spec.MaxIPsPerFailureDomain != nil {
+		metrics.PolicyMaxIPsPerFailureDomain.WithLabelValues(name).Set(float64(*instance.Spec.MaxIPsPerFailureDomain))
+	}
+
+	for _, usage := range usages {
+		metrics.PolicyNamespaceIPs.WithLabelValues(name, usage.Namespace).Set(float64(usage.IPs))
+		metrics.PolicyNamespaceViolations.WithLabelValues(name, usage.Namespace).Set(float64(len(usage.Violations)))
+		for _, failureDomain := range usage.FailureDomains {
+			metrics.PolicyNamespaceFailureDomainIPs.WithLabelValues(name, usage.Namespace, failureDomain.Name).Set(float64(failureDomain.IPs))
+		}
+	}
+	reportedUsages[name] = usages
+}
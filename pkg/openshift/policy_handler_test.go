@@ -0,0 +1,130 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	netv1 "github.com/openshift/api/network/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+)
+
+func quotaPolicy(maxIPs int) *v1alpha1.EgressIPPolicy {
+	return &v1alpha1.EgressIPPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota"},
+		Spec: v1alpha1.EgressIPPolicySpec{
+			Namespaces: []string{"tenant"},
+			MaxIPs:     &maxIPs,
+		},
+	}
+}
+
+// prepareQuota prepares the EgressIP 'tenant/egress' in 'lifecycle-a' next to 'tenant/other' already owning an IP in
+// 'lifecycle-a'.
+func prepareQuota(maxIPs int) client.Client {
+	return prepareClient(
+		failureDomain("lifecycle-a", "10.0.1.0/24"),
+		node("node-a", "lifecycle-a", "10.0.1.5", corev1.ConditionTrue),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}},
+		&netv1.NetNamespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}, NetName: "tenant"},
+		quotaPolicy(maxIPs),
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "tenant"},
+			Spec:       v1alpha1.EgressIPSpec{IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.20"}}},
+			Status: v1alpha1.EgressIPStatus{
+				IPs: []v1alpha1.AssignedEgressIP{{FailureDomain: "lifecycle-a", IP: "10.0.1.20", HostName: "node-a"}},
+			},
+		},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: egressIPName.Name, Namespace: egressIPName.Namespace},
+			Spec:       v1alpha1.EgressIPSpec{IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.10"}}},
+		},
+	)
+}
+
+func TestRefusingIPExceedingQuota(t *testing.T) {
+	c := prepareQuota(1)
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+	recorder := record.NewFakeRecorder(10)
+
+	_, _ = openshift.ManageEgressIP(
+		context.Background(),
+		ctrl.Request{NamespacedName: egressIPName},
+		c, provisioner, *metrics.NewAlarmStore(log), recorder, log,
+	)
+
+	if len(provisioner.ips["node-a"]) != 0 {
+		t.Errorf("IP exceeding the quota has been added to the host! current=%v", provisioner.ips)
+	}
+
+	events := receiveEvents(recorder)
+	if len(events) == 0 || !strings.Contains(events[0], openshift.EventReasonQuotaExceeded) {
+		t.Errorf("Exceeded quota should be recorded! current=%v", events)
+	}
+}
+
+func TestAllocatingIPWithinQuota(t *testing.T) {
+	c := prepareQuota(2)
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].IP != "10.0.1.10" {
+		t.Errorf("IP within the quota should be allocated! current=%v", instance.Status.IPs)
+	}
+}
+
+func TestReportingUsageOfPolicy(t *testing.T) {
+	c := prepareQuota(0)
+	recorder := record.NewFakeRecorder(10)
+
+	_, err := openshift.ManageEgressIPPolicy(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "quota"}}, c, recorder, log)
+	if err != nil {
+		t.Fatalf("EgressIPPolicy could not be reconciled: %v", err)
+	}
+
+	instance := &v1alpha1.EgressIPPolicy{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "quota"}, instance)
+
+	if len(instance.Status.Namespaces) != 1 {
+		t.Fatalf("Usage of namespace 'tenant' should be reported! current=%v", instance.Status.Namespaces)
+	}
+	usage := instance.Status.Namespaces[0]
+	if usage.IPs != 1 || len(usage.FailureDomains) != 1 || usage.FailureDomains[0].IPs != 1 || len(usage.Violations) != 1 {
+		t.Errorf("Wrong usage of namespace 'tenant'! expected=1 ip in 'lifecycle-a' with 1 violation, current=%v", usage)
+	}
+
+	current := testutil.ToFloat64(metrics.PolicyNamespaceIPs.WithLabelValues("quota", "tenant"))
+	if current != 1 {
+		t.Errorf("Wrong usage metric! expected=1, current=%v", current)
+	}
+
+	events := receiveEvents(recorder)
+	if len(events) != 1 || !strings.Contains(events[0], openshift.EventReasonPolicyViolated) {
+		t.Errorf("Violation should be recorded! current=%v", events)
+	}
+}
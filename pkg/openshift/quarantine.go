@@ -0,0 +1,198 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"net"
+	"os"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+const DefaultRetainPeriod = 30 * 24 * time.Hour
+
+// RetainPeriod is the cool-down of the IPs released by EgressIPs with the reclaim policy Retain. A zero period keeps
+// them until an admin deletes the quarantine.
+var RetainPeriod time.Duration
+
+func init() {
+	RetainPeriod = DefaultRetainPeriod
+	period, found := os.LookupEnv("RECLAIM_RETAIN_PERIOD")
+	if found {
+		value, err := time.ParseDuration(period)
+		if err == nil {
+			RetainPeriod = value
+		}
+	}
+}
+
+// quarantineName derives the name of the quarantine from the IP. Names must not contain the colons of IPv6 addresses.
+func quarantineName(ip string) string {
+	return strings.ReplaceAll(ip, ":", "-")
+}
+
+// quarantineIP keeps the IP released by the EgressIP for its namespace. An existing quarantine of the IP is replaced.
+func quarantineIP(ctx context.Context, client client.Client, recorder record.EventRecorder, instance *v1alpha1.EgressIP, assigned v1alpha1.AssignedEgressIP, log logr.Logger) error {
+	spec := v1alpha1.EgressIPQuarantineSpec{
+		FailureDomain: assigned.FailureDomain,
+		IP:            assigned.IP,
+		Namespace:     instance.Namespace,
+		EgressIP:      instance.Name,
+	}
+	if RetainPeriod > 0 {
+		releaseAfter := metav1.NewTime(time.Now().Add(RetainPeriod))
+		spec.ReleaseAfter = &releaseAfter
+	}
+
+	quarantine := &v1alpha1.EgressIPQuarantine{}
+	err := client.Get(ctx, types.NamespacedName{Name: quarantineName(assigned.IP)}, quarantine)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if errors.IsNotFound(err) {
+		quarantine = &v1alpha1.EgressIPQuarantine{
+			ObjectMeta: metav1.ObjectMeta{Name: quarantineName(assigned.IP)},
+			Spec:       spec,
+		}
+		err = client.Create(ctx, quarantine)
+	} else {
+		quarantine.Spec = spec
+		err = client.Update(ctx, quarantine)
+	}
+	if err != nil {
+		return err
+	}
+
+	until := "it is released by an admin"
+	if spec.ReleaseAfter != nil {
+		until = spec.ReleaseAfter.UTC().Format(time.RFC3339)
+	}
+	log.Info("quarantined ip", "failure-domain", assigned.FailureDomain, "ip", assigned.IP, "until", until)
+	recordIPEvent(ctx, client, recorder, instance, "", corev1.EventTypeNormal, EventReasonQuarantined,
+		fmt.Sprintf("ip '%v' in failure domain '%v' is kept for namespace '%v' until %v", assigned.IP, assigned.FailureDomain, instance.Namespace, until),
+	)
+
+	return nil
+}
+
+// quarantinedIPs returns the quarantined IPs of the failure domain mapped to the namespace they are kept for.
+func quarantinedIPs(ctx context.Context, client client.Client, failureDomain string) (map[string]string, error) {
+	quarantines := &v1alpha1.EgressIPQuarantineList{}
+	err := client.List(ctx, quarantines)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, quarantine := range quarantines.Items {
+		if quarantine.Spec.FailureDomain != failureDomain {
+			continue
+		}
+
+		if ip := net.ParseIP(quarantine.Spec.IP); ip != nil {
+			result[ip.String()] = quarantine.Spec.Namespace
+		}
+	}
+
+	return result, nil
+}
+
+// checkQuarantine returns why the IP must not be used by the namespace. IPs quarantined for the namespace itself may be
+// used again.
+func checkQuarantine(quarantined map[string]string, ip net.IP, namespace string) error {
+	owner, found := quarantined[ip.String()]
+	if found && owner != namespace {
+		return fmt.Errorf("ip '%v' is quarantined for namespace '%v'", ip.String(), owner)
+	}
+
+	return nil
+}
+
+// reclaimIP ends the quarantine of the IP once the namespace uses it again.
+func reclaimIP(ctx context.Context, client client.Client, ip net.IP, log logr.Logger) {
+	quarantine := &v1alpha1.EgressIPQuarantine{ObjectMeta: metav1.ObjectMeta{Name: quarantineName(ip.String())}}
+	err := client.Delete(ctx, quarantine)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Info("quarantine of the reclaimed ip could not be deleted", "ip", ip.String(), "error", err.Error())
+		return
+	}
+
+	if err == nil {
+		log.Info("reclaimed quarantined ip", "ip", ip.String())
+	}
+}
+
+// ManageEgressIPQuarantine deletes the quarantine once its cool-down is over. Quarantines without end are kept until an
+// admin deletes them.
+func ManageEgressIPQuarantine(ctx context.Context, req ctrl.Request, client client.Client, recorder record.EventRecorder, baseLogger logr.Logger) (ctrl.Result, error) {
+	log := baseLogger.WithValues("egressipquarantine", req.Name)
+
+	instance := &v1alpha1.EgressIPQuarantine{}
+	err := client.Get(ctx, req.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("egressIPQuarantine not found - the request will not be re-queued")
+
+			return ctrl.Result{
+				Requeue: false,
+			}, nil
+		}
+
+		log.Info("egressIPQuarantine could not be loaded - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	if instance.Spec.ReleaseAfter == nil {
+		return ctrl.Result{}, nil
+	}
+
+	remaining := time.Until(instance.Spec.ReleaseAfter.Time)
+	if remaining > 0 {
+		return ctrl.Result{
+			RequeueAfter: remaining,
+		}, nil
+	}
+
+	err = client.Delete(ctx, instance)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Info("egressIPQuarantine could not be deleted - the request will be re-queued in 30 seconds")
+		return ctrl.Result{
+			RequeueAfter: 30 * time.Second,
+		}, err
+	}
+
+	log.Info("released quarantined ip", "failure-domain", instance.Spec.FailureDomain, "ip", instance.Spec.IP)
+	recorder.Event(instance, corev1.EventTypeNormal, EventReasonReleased,
+		fmt.Sprintf("ip '%v' in failure domain '%v' is no longer kept for namespace '%v'", instance.Spec.IP, instance.Spec.FailureDomain, instance.Spec.Namespace),
+	)
+
+	return ctrl.Result{}, nil
+}
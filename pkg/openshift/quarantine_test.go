@@ -0,0 +1,195 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+	"time"
+)
+
+func quarantine(ip string, namespace string, releaseAfter *metav1.Time) *v1alpha1.EgressIPQuarantine {
+	return &v1alpha1.EgressIPQuarantine{
+		ObjectMeta: metav1.ObjectMeta{Name: ip},
+		Spec: v1alpha1.EgressIPQuarantineSpec{
+			FailureDomain: "lifecycle-a",
+			IP:            ip,
+			Namespace:     namespace,
+			EgressIP:      "old",
+			ReleaseAfter:  releaseAfter,
+		},
+	}
+}
+
+func TestQuarantiningReleasedIP(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	instance := &v1alpha1.EgressIP{}
+	_ = c.Get(context.Background(), egressIPName, instance)
+	instance.Spec.ReclaimPolicy = v1alpha1.ReclaimPolicyRetain
+	_ = c.Update(context.Background(), instance)
+
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance = reconcileEgressIP(t, c, provisioner)
+	instance.Spec.IPs = []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.11"}}
+	_ = c.Update(context.Background(), instance)
+	reconcileEgressIP(t, c, provisioner)
+
+	result := &v1alpha1.EgressIPQuarantine{}
+	err := c.Get(context.Background(), types.NamespacedName{Name: "10.0.1.10"}, result)
+	if err != nil {
+		t.Fatalf("Released ip should be quarantined! error=%v", err)
+	}
+	if result.Spec.Namespace != "tenant" || result.Spec.EgressIP != "egress" || result.Spec.FailureDomain != "lifecycle-a" {
+		t.Errorf("Wrong quarantine! expected='tenant/egress' in 'lifecycle-a', current=%v", result.Spec)
+	}
+	if result.Spec.ReleaseAfter == nil || result.Spec.ReleaseAfter.Time.Before(time.Now()) {
+		t.Errorf("Quarantine should end after the retain period! current=%v", result.Spec.ReleaseAfter)
+	}
+}
+
+func TestReleasingIPWithoutQuarantine(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+	instance.Spec.IPs = []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "lifecycle-a", IP: "10.0.1.11"}}
+	_ = c.Update(context.Background(), instance)
+	reconcileEgressIP(t, c, provisioner)
+
+	quarantines := &v1alpha1.EgressIPQuarantineList{}
+	_ = c.List(context.Background(), quarantines)
+	if len(quarantines.Items) != 0 {
+		t.Errorf("IPs with reclaim policy Delete should not be quarantined! current=%v", quarantines.Items)
+	}
+}
+
+func TestRefusingIPQuarantinedForOtherNamespace(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	_ = c.Create(context.Background(), quarantine("10.0.1.10", "other", nil))
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	_, err := openshift.ManageEgressIP(
+		context.Background(),
+		ctrl.Request{NamespacedName: egressIPName},
+		c, provisioner, *metrics.NewAlarmStore(log), record.NewFakeRecorder(10), log,
+	)
+	if err == nil {
+		t.Errorf("Quarantined ip should not be allocated! ips=%v", provisioner.ips)
+	}
+	if len(provisioner.ips["node-a"]) != 0 {
+		t.Errorf("Quarantined ip has been added to the host! current=%v", provisioner.ips)
+	}
+}
+
+func TestReclaimingIPQuarantinedForNamespace(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.10"})
+	_ = c.Create(context.Background(), quarantine("10.0.1.10", "tenant", nil))
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].IP != "10.0.1.10" {
+		t.Errorf("Quarantined ip should be reclaimed by its namespace! current=%v", instance.Status.IPs)
+	}
+
+	err := c.Get(context.Background(), types.NamespacedName{Name: "10.0.1.10"}, &v1alpha1.EgressIPQuarantine{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("Quarantine of the reclaimed ip should be deleted! error=%v", err)
+	}
+}
+
+func TestSkippingQuarantinedRandomIP(t *testing.T) {
+	c := prepareRestrictedEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a"})
+	_ = c.Create(context.Background(), quarantine("10.0.1.55", "other", nil))
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].IP != "10.0.1.56" {
+		t.Errorf("Random ip should skip quarantined ips! expected='10.0.1.56', current=%v", instance.Status.IPs)
+	}
+}
+
+func reconcileQuarantine(t *testing.T, c client.Client) ctrl.Result {
+	result, err := openshift.ManageEgressIPQuarantine(
+		context.Background(),
+		ctrl.Request{NamespacedName: types.NamespacedName{Name: "10.0.1.10"}},
+		c, record.NewFakeRecorder(10), log,
+	)
+	if err != nil {
+		t.Fatalf("EgressIPQuarantine could not be reconciled: %v", err)
+	}
+
+	return result
+}
+
+func TestEndingQuarantine(t *testing.T) {
+	tests := []struct {
+		name         string
+		releaseAfter *metav1.Time
+		kept         bool
+	}{
+		{"expired quarantine", &metav1.Time{Time: time.Now().Add(-time.Minute)}, false},
+		{"running quarantine", &metav1.Time{Time: time.Now().Add(time.Hour)}, true},
+		{"quarantine without end", nil, true},
+	}
+
+	for _, test := range tests {
+		c := prepareClient(quarantine("10.0.1.10", "tenant", test.releaseAfter))
+
+		result := reconcileQuarantine(t, c)
+
+		err := c.Get(context.Background(), types.NamespacedName{Name: "10.0.1.10"}, &v1alpha1.EgressIPQuarantine{})
+		if kept := err == nil; kept != test.kept {
+			t.Errorf("Wrong handling of %v! kept expected=%v, current=%v", test.name, test.kept, kept)
+		}
+		if test.releaseAfter != nil && test.kept && result.RequeueAfter <= 0 {
+			t.Errorf("Running quarantine of %v should be re-queued! current=%v", test.name, result.RequeueAfter)
+		}
+	}
+}
+
+func TestSkippingQuarantinedRandomIPOfProvisioner(t *testing.T) {
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a"})
+	_ = c.Create(context.Background(), quarantine("10.0.1.100", "other", nil))
+	failureDomain := &v1beta1.ClusterEgressIPFailureDomain{}
+	_ = c.Get(context.Background(), types.NamespacedName{Name: "lifecycle-a"}, failureDomain)
+	failureDomain.Spec.Cidr = ""
+	_ = c.Update(context.Background(), failureDomain)
+
+	provisioner := &hostIPs{ips: map[string][]string{}, target: "node-a"}
+
+	instance := reconcileEgressIP(t, c, provisioner)
+
+	if len(instance.Status.IPs) != 1 || instance.Status.IPs[0].IP != "10.0.1.101" {
+		t.Errorf("Another random ip should be picked! expected=10.0.1.101, current=%v", instance.Status.IPs)
+	}
+	if len(provisioner.ips["node-a"]) != 1 || provisioner.ips["node-a"][0] != "10.0.1.101" {
+		t.Errorf("Quarantined ip has not been removed from the host! current=%v", provisioner.ips)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openshift_test
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"testing"
+)
+
+func TestTracingAllocation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	c := prepareEgressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "lifecycle-a", IP: "10.0.1.11"})
+	traced := provisioner.TracedEgressIPProvisioner{
+		Provisioner: &hostIPs{ips: map[string][]string{}, target: "node-a"},
+	}
+
+	ctx, root := tracing.Start(context.Background(), "test")
+	_, err := openshift.ManageEgressIP(ctx, ctrl.Request{NamespacedName: egressIPName}, c, traced, *metrics.NewAlarmStore(log), record.NewFakeRecorder(10), log)
+	root.End()
+	if err != nil {
+		t.Fatalf("EgressIP could not be reconciled: %v", err)
+	}
+
+	spans := make(map[string]*exporttrace.SpanData)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	allocation := spans["EgressIP.AllocateIP"]
+	if allocation == nil {
+		t.Fatalf("Allocation has not been traced! current=%v", spans)
+	}
+	if allocation.ParentSpanID != root.SpanContext().SpanID {
+		t.Errorf("Allocation is no child of the reconciliation!")
+	}
+
+	attributes := make(map[string]string)
+	for _, attribute := range allocation.Attributes {
+		attributes[string(attribute.Key)] = attribute.Value.Emit()
+	}
+	if attributes[string(tracing.FailureDomainKey)] != "lifecycle-a" ||
+		attributes[string(tracing.IPKey)] != "10.0.1.11" ||
+		attributes[string(tracing.HostKey)] != "node-a" {
+		t.Errorf("Wrong attributes of the allocation! current=%v", attributes)
+	}
+
+	for _, name := range []string{"EgressIPProvisioner.FindHostForNewIP", "EgressIPProvisioner.AddSpecifiedIP"} {
+		span := spans[name]
+		if span == nil {
+			t.Errorf("Call of the provisioner has not been traced! expected='%v'", name)
+			continue
+		}
+
+		if span.ParentSpanID != allocation.SpanContext.SpanID {
+			t.Errorf("Call of the provisioner is no child of the allocation! span='%v'", name)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// policy evaluates the EgressIPPolicies. They cap the egress IPs of namespaces in total and per failure domain and
+// restrict the failure domains and pools the namespaces may use.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+// Usage are the egress IPs of a namespace in total and per failure domain.
+type Usage struct {
+	Total            int
+	PerFailureDomain map[string]int
+}
+
+// NewUsage returns an empty usage.
+func NewUsage() *Usage {
+	return &Usage{PerFailureDomain: make(map[string]int)}
+}
+
+// Add counts an IP within the failure domain.
+func (u *Usage) Add(failureDomain string) {
+	u.Total++
+	u.PerFailureDomain[failureDomain]++
+}
+
+// SpecUsage counts the IPs specified by the EgressIPs. The instance replaces the stored EgressIP of the same name and
+// is counted even if it is not stored yet.
+func SpecUsage(egressIPs []v1alpha1.EgressIP, instance *v1alpha1.EgressIP) *Usage {
+	result := NewUsage()
+
+	for _, egressIP := range egressIPs {
+		if egressIP.Namespace != instance.Namespace || egressIP.Name == instance.Name {
+			continue
+		}
+
+		for _, spec := range egressIP.Spec.IPs {
+			result.Add(spec.FailureDomain)
+		}
+	}
+
+	for _, spec := range instance.Spec.IPs {
+		result.Add(spec.FailureDomain)
+	}
+
+	return result
+}
+
+// AssignedUsage counts the IPs assigned to the EgressIPs of the namespace.
+func AssignedUsage(egressIPs []v1alpha1.EgressIP, namespace string) *Usage {
+	result := NewUsage()
+
+	for _, egressIP := range egressIPs {
+		if egressIP.Namespace != namespace {
+			continue
+		}
+
+		for _, ip := range egressIP.Status.IPs {
+			result.Add(ip.FailureDomain)
+		}
+	}
+
+	return result
+}
+
+// PoliciesOf returns the policies selecting the namespace. Unknown namespaces are selected by name only.
+func PoliciesOf(ctx context.Context, c client.Reader, namespace string) ([]v1alpha1.EgressIPPolicy, error) {
+	policies := &v1alpha1.EgressIPPolicyList{}
+	err := c.List(ctx, policies)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies.Items) == 0 {
+		return policies.Items, nil
+	}
+
+	instance := &corev1.Namespace{}
+	err = c.Get(ctx, types.NamespacedName{Name: namespace}, instance)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	instance.Name = namespace
+
+	result := make([]v1alpha1.EgressIPPolicy, 0)
+	for _, policy := range policies.Items {
+		if Selects(&policy, instance) {
+			result = append(result, policy)
+		}
+	}
+
+	return result, nil
+}
+
+// Selects checks if the policy selects the namespace by name or labels. A policy without namespaces and selector
+// selects all namespaces.
+func Selects(policy *v1alpha1.EgressIPPolicy, namespace *corev1.Namespace) bool {
+	if len(policy.Spec.Namespaces) == 0 && policy.Spec.NamespaceSelector == nil {
+		return true
+	}
+
+	for _, name := range policy.Spec.Namespaces {
+		if name == namespace.Name {
+			return true
+		}
+	}
+
+	if policy.Spec.NamespaceSelector == nil {
+		return false
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(namespace.Labels))
+}
+
+// Violations returns why the usage of the namespace exceeds the policies. The result is empty for a usage within all
+// policies.
+func Violations(policies []v1alpha1.EgressIPPolicy, namespace string, usage *Usage) []string {
+	result := make([]string, 0)
+
+	failureDomains := make([]string, 0, len(usage.PerFailureDomain))
+	for failureDomain := range usage.PerFailureDomain {
+		failureDomains = append(failureDomains, failureDomain)
+	}
+	sort.Strings(failureDomains)
+
+	for _, policy := range policies {
+		if policy.Spec.MaxIPs != nil && usage.Total > *policy.Spec.MaxIPs {
+			result = append(result, fmt.Sprintf("namespace '%v' uses %v egress ips but policy '%v' allows %v",
+				namespace, usage.Total, policy.Name, *policy.Spec.MaxIPs,
+			))
+		}
+
+		for _, failureDomain := range failureDomains {
+			count := usage.PerFailureDomain[failureDomain]
+			if count == 0 {
+				continue
+			}
+
+			if !AllowsFailureDomain([]v1alpha1.EgressIPPolicy{policy}, failureDomain) {
+				result = append(result, fmt.Sprintf("policy '%v' does not allow namespace '%v' to use failure domain '%v'",
+					policy.Name, namespace, failureDomain,
+				))
+				continue
+			}
+
+			if policy.Spec.MaxIPsPerFailureDomain != nil && count > *policy.Spec.MaxIPsPerFailureDomain {
+				result = append(result, fmt.Sprintf("namespace '%v' uses %v egress ips in failure domain '%v' but policy '%v' allows %v",
+					namespace, count, failureDomain, policy.Name, *policy.Spec.MaxIPsPerFailureDomain,
+				))
+			}
+		}
+	}
+
+	return result
+}
+
+// AllowsFailureDomain checks if all policies allow the failure domain.
+func AllowsFailureDomain(policies []v1alpha1.EgressIPPolicy, failureDomain string) bool {
+	for _, policy := range policies {
+		if len(policy.Spec.AllowedFailureDomains) > 0 && !containsString(policy.Spec.AllowedFailureDomains, failureDomain) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AllowsPool returns why the pool must not be used by the namespace, or nil if all policies allow it.
+func AllowsPool(policies []v1alpha1.EgressIPPolicy, namespace string, pool string) error {
+	for _, policy := range policies {
+		if len(policy.Spec.AllowedPools) > 0 && !containsString(policy.Spec.AllowedPools, pool) {
+			return fmt.Errorf("policy '%v' does not allow namespace '%v' to use pool '%v'", policy.Name, namespace, pool)
+		}
+	}
+
+	return nil
+}
+
+// Quota decides whether the EgressIPs of a namespace may get another IP. It starts with the IPs already assigned.
+type Quota struct {
+	Namespace string
+	Policies  []v1alpha1.EgressIPPolicy
+	Usage     *Usage
+}
+
+// NewQuota returns the quota of the namespace counting the IPs assigned to all EgressIPs of the namespace except the
+// given one. The IPs of the given EgressIP are added while they are checked.
+func NewQuota(ctx context.Context, c client.Reader, except *v1alpha1.EgressIP) (*Quota, error) {
+	policies, err := PoliciesOf(ctx, c, except.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	egressIPs := &v1alpha1.EgressIPList{}
+	err = c.List(ctx, egressIPs, client.InNamespace(except.Namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	others := make([]v1alpha1.EgressIP, 0, len(egressIPs.Items))
+	for _, egressIP := range egressIPs.Items {
+		if egressIP.Name != except.Name {
+			others = append(others, egressIP)
+		}
+	}
+
+	return &Quota{
+		Namespace: except.Namespace,
+		Policies:  policies,
+		Usage:     AssignedUsage(others, except.Namespace),
+	}, nil
+}
+
+// Allow returns why another IP in the failure domain would exceed the policies, or nil if it is allowed.
+func (q *Quota) Allow(failureDomain string) error {
+	usage := NewUsage()
+	usage.Total = q.Usage.Total
+	for name, count := range q.Usage.PerFailureDomain {
+		usage.PerFailureDomain[name] = count
+	}
+	usage.Add(failureDomain)
+
+	for _, violation := range Violations(q.Policies, q.Namespace, usage) {
+		return fmt.Errorf("%v", violation)
+	}
+
+	return nil
+}
+
+// Add counts an assigned IP within the failure domain.
+func (q *Quota) Add(failureDomain string) {
+	q.Usage.Add(failureDomain)
+}
+
+func containsString(values []string, value string) bool {
+	for _, current := range values {
+		if current == value {
+			return true
+		}
+	}
+
+	return false
+}
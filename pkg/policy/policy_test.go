@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy_test
+
+import (
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func TestSelectingNamespaces(t *testing.T) {
+	tenant := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Labels: map[string]string{"tier": "gold"}}}
+
+	tests := []struct {
+		name     string
+		spec     v1alpha1.EgressIPPolicySpec
+		selected bool
+	}{
+		{"all namespaces", v1alpha1.EgressIPPolicySpec{}, true},
+		{"by name", v1alpha1.EgressIPPolicySpec{Namespaces: []string{"tenant"}}, true},
+		{"by other name", v1alpha1.EgressIPPolicySpec{Namespaces: []string{"other"}}, false},
+		{"by label", v1alpha1.EgressIPPolicySpec{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "gold"}}}, true},
+		{"by other label", v1alpha1.EgressIPPolicySpec{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "silver"}}}, false},
+	}
+
+	for _, test := range tests {
+		current := policy.Selects(&v1alpha1.EgressIPPolicy{Spec: test.spec}, tenant)
+		if current != test.selected {
+			t.Errorf("Wrong selection %v! expected=%v, current=%v", test.name, test.selected, current)
+		}
+	}
+}
+
+func TestViolatingPolicies(t *testing.T) {
+	usage := policy.NewUsage()
+	usage.Add("zone-a")
+	usage.Add("zone-a")
+	usage.Add("zone-b")
+
+	tests := []struct {
+		name       string
+		spec       v1alpha1.EgressIPPolicySpec
+		violations int
+	}{
+		{"without limits", v1alpha1.EgressIPPolicySpec{}, 0},
+		{"within max ips", v1alpha1.EgressIPPolicySpec{MaxIPs: intPtr(3)}, 0},
+		{"exceeding max ips", v1alpha1.EgressIPPolicySpec{MaxIPs: intPtr(2)}, 1},
+		{"exceeding max ips per failure domain", v1alpha1.EgressIPPolicySpec{MaxIPsPerFailureDomain: intPtr(1)}, 1},
+		{"using failure domain not allowed", v1alpha1.EgressIPPolicySpec{AllowedFailureDomains: []string{"zone-a"}}, 1},
+	}
+
+	for _, test := range tests {
+		policies := []v1alpha1.EgressIPPolicy{{ObjectMeta: metav1.ObjectMeta{Name: "quota"}, Spec: test.spec}}
+
+		current := policy.Violations(policies, "tenant", usage)
+		if len(current) != test.violations {
+			t.Errorf("Wrong violations %v! expected=%v, current=%v", test.name, test.violations, current)
+		}
+	}
+}
+
+func TestAllowingIPsWithinQuota(t *testing.T) {
+	sut := &policy.Quota{
+		Namespace: "tenant",
+		Policies: []v1alpha1.EgressIPPolicy{{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota"},
+			Spec:       v1alpha1.EgressIPPolicySpec{MaxIPs: intPtr(2), MaxIPsPerFailureDomain: intPtr(1)},
+		}},
+		Usage: policy.NewUsage(),
+	}
+
+	if err := sut.Allow("zone-a"); err != nil {
+		t.Errorf("First ip should be allowed! error=%v", err)
+	}
+	sut.Add("zone-a")
+
+	if err := sut.Allow("zone-a"); err == nil {
+		t.Errorf("Second ip in 'zone-a' should exceed the quota!")
+	}
+	if err := sut.Allow("zone-b"); err != nil {
+		t.Errorf("Ip in 'zone-b' should be allowed! error=%v", err)
+	}
+	sut.Add("zone-b")
+
+	if err := sut.Allow("zone-c"); err == nil {
+		t.Errorf("Third ip should exceed the quota!")
+	}
+}
+
+func TestAllowingPools(t *testing.T) {
+	policies := []v1alpha1.EgressIPPolicy{{
+		ObjectMeta: metav1.ObjectMeta{Name: "pools"},
+		Spec:       v1alpha1.EgressIPPolicySpec{AllowedPools: []string{"standard"}},
+	}}
+
+	if err := policy.AllowsPool(policies, "tenant", "standard"); err != nil {
+		t.Errorf("Pool 'standard' should be allowed! error=%v", err)
+	}
+	if err := policy.AllowsPool(policies, "tenant", "premium"); err == nil {
+		t.Errorf("Pool 'premium' should not be allowed!")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/klenkes74/egress-ip-operator/pkg/cloudprovider"
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner/ocp_static_provisioner"
+	corev1 "k8s.io/api/core/v1"
 	"net"
 )
 
@@ -34,14 +35,14 @@ type CloudManagedEgressIPProvisioner struct {
 }
 
 func (a CloudManagedEgressIPProvisioner) AddRandomIP(ctx context.Context, hostName string) (*net.IP, error) {
-	ip, err := a.Cloud.AddRandomIP(hostName)
+	ip, err := a.Cloud.AddRandomIP(ctx, hostName)
 	if err != nil {
 		return nil, err
 	}
 
 	err = a.OpenShift.AddSpecifiedIP(ctx, ip, hostName)
 	if err != nil {
-		redoErr := a.Cloud.RemoveIP(ip, hostName)
+		redoErr := a.Cloud.RemoveIP(ctx, ip, hostName)
 		if redoErr != nil {
 			return nil, fmt.Errorf(
 				"error while rolling back adding random ip to host '%v': %v",
@@ -57,14 +58,14 @@ func (a CloudManagedEgressIPProvisioner) AddRandomIP(ctx context.Context, hostNa
 }
 
 func (a CloudManagedEgressIPProvisioner) AddSpecifiedIP(ctx context.Context, ip *net.IP, hostName string) error {
-	err := a.Cloud.AddSpecifiedIP(ip, hostName)
+	err := a.Cloud.AddSpecifiedIP(ctx, ip, hostName)
 	if err != nil {
 		return err
 	}
 
 	err = a.OpenShift.AddSpecifiedIP(ctx, ip, hostName)
 	if err != nil {
-		redoErr := a.Cloud.RemoveIP(ip, hostName)
+		redoErr := a.Cloud.RemoveIP(ctx, ip, hostName)
 		if redoErr != nil {
 			return fmt.Errorf(
 				"error while rolling back adding ip '%v' to host '%v': %v",
@@ -83,7 +84,7 @@ func (a CloudManagedEgressIPProvisioner) AssignCIDR(_ context.Context, _ string)
 }
 
 func (a CloudManagedEgressIPProvisioner) CheckIP(ctx context.Context, ip *net.IP, hostName string) error {
-	err := a.Cloud.CheckIP(ip, hostName)
+	err := a.Cloud.CheckIP(ctx, ip, hostName)
 	if err != nil {
 		return err
 	}
@@ -91,19 +92,45 @@ func (a CloudManagedEgressIPProvisioner) CheckIP(ctx context.Context, ip *net.IP
 	return a.OpenShift.CheckIP(ctx, ip, hostName)
 }
 
-func (a CloudManagedEgressIPProvisioner) FindHostForNewIP(ctx context.Context, failureDomain string) (string, error) {
-	return a.OpenShift.FindHostForNewIP(ctx, failureDomain)
+func (a CloudManagedEgressIPProvisioner) CheckHost(ctx context.Context, hostName string) error {
+	return a.Cloud.CheckHost(ctx, hostName)
+}
+
+// CheckBackend checks the cloud and the kubernetes api.
+func (a CloudManagedEgressIPProvisioner) CheckBackend(ctx context.Context) error {
+	err := a.Cloud.CheckBackend(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.OpenShift.CheckBackend(ctx)
+}
+
+func (a CloudManagedEgressIPProvisioner) FindIP(ctx context.Context, ip *net.IP) (string, error) {
+	return a.Cloud.FindIP(ctx, ip)
+}
+
+func (a CloudManagedEgressIPProvisioner) ListIPs(ctx context.Context, hostName string) ([]*net.IP, error) {
+	return a.Cloud.ListIPs(ctx, hostName)
+}
+
+func (a CloudManagedEgressIPProvisioner) IPLimit(ctx context.Context, hostName string) (int, error) {
+	return a.Cloud.IPLimit(ctx, hostName)
+}
+
+func (a CloudManagedEgressIPProvisioner) FindHostForNewIP(ctx context.Context, failureDomain string, affinity *corev1.NodeAffinity, pending map[string]int) (string, error) {
+	return a.OpenShift.FindHostForNewIP(ctx, failureDomain, affinity, pending)
 }
 
 func (a CloudManagedEgressIPProvisioner) MoveIP(ctx context.Context, ip *net.IP, oldHostName string, newHostName string) error {
-	err := a.Cloud.MoveIP(ip, oldHostName, newHostName)
+	err := a.Cloud.MoveIP(ctx, ip, oldHostName, newHostName)
 	if err != nil {
 		return err
 	}
 
 	err = a.OpenShift.MoveIP(ctx, ip, oldHostName, newHostName)
 	if err != nil {
-		redoErr := a.Cloud.MoveIP(ip, newHostName, oldHostName)
+		redoErr := a.Cloud.MoveIP(ctx, ip, newHostName, oldHostName)
 		if redoErr != nil {
 			return fmt.Errorf("error while moving IP '%v' from '%v' to '%v': %v", ip.String(), oldHostName, newHostName, redoErr.Error())
 		}
@@ -115,14 +142,14 @@ func (a CloudManagedEgressIPProvisioner) MoveIP(ctx context.Context, ip *net.IP,
 }
 
 func (a CloudManagedEgressIPProvisioner) RemoveIP(ctx context.Context, ip *net.IP, hostName string) error {
-	err := a.Cloud.RemoveIP(ip, hostName)
+	err := a.Cloud.RemoveIP(ctx, ip, hostName)
 	if err != nil {
 		return err
 	}
 
 	err = a.OpenShift.RemoveIP(ctx, ip, hostName)
 	if err != nil {
-		redoErr := a.Cloud.AddSpecifiedIP(ip, hostName)
+		redoErr := a.Cloud.AddSpecifiedIP(ctx, ip, hostName)
 		if redoErr != nil {
 			return fmt.Errorf("error while removing IP '%v' from OpenShift. Re-adding it to the cloudprovider failed: %v", ip.String(), redoErr.Error())
 		}
@@ -132,3 +159,134 @@ func (a CloudManagedEgressIPProvisioner) RemoveIP(ctx context.Context, ip *net.I
 
 	return nil
 }
+
+func (a CloudManagedEgressIPProvisioner) AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error) {
+	ips, err := a.Cloud.AddRandomIPs(ctx, count, hostName)
+	if err != nil {
+		if len(ips) > 0 {
+			a.rollbackCloud(a.Cloud.RemoveIPs(ctx, ips, hostName), hostName)
+		}
+
+		return nil, err
+	}
+
+	result := make([]*net.IP, 0)
+	failed := make([]*net.IP, 0)
+	for _, ip := range ips {
+		err = a.OpenShift.AddSpecifiedIP(ctx, ip, hostName)
+		if err != nil {
+			failed = append(failed, ip)
+			continue
+		}
+
+		result = append(result, ip)
+	}
+
+	if len(failed) > 0 {
+		a.rollbackCloud(a.Cloud.RemoveIPs(ctx, failed, hostName), hostName)
+
+		return result, fmt.Errorf("%v of %v random ips could not be added to host '%v'", len(failed), count, hostName)
+	}
+
+	return result, nil
+}
+
+func (a CloudManagedEgressIPProvisioner) AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	failures := a.Cloud.AddSpecifiedIPs(ctx, ips, hostName)
+
+	failed := make([]*net.IP, 0)
+	for _, ip := range succeeded(ips, failures) {
+		err := a.OpenShift.AddSpecifiedIP(ctx, ip, hostName)
+		if err != nil {
+			failures[ip.String()] = err
+			failed = append(failed, ip)
+		}
+	}
+
+	if len(failed) > 0 {
+		a.rollbackCloud(a.Cloud.RemoveIPs(ctx, failed, hostName), hostName)
+	}
+
+	return failures
+}
+
+func (a CloudManagedEgressIPProvisioner) MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error {
+	failures := a.Cloud.MoveIPs(ctx, ips, oldHostName, newHostName)
+
+	failed := make([]*net.IP, 0)
+	errs := make(map[string]error)
+	for _, ip := range succeeded(ips, failures) {
+		err := a.OpenShift.MoveIP(ctx, ip, oldHostName, newHostName)
+		if err != nil {
+			errs[ip.String()] = err
+			failed = append(failed, ip)
+		}
+	}
+
+	if len(failed) > 0 {
+		redoFailures := a.Cloud.MoveIPs(ctx, failed, newHostName, oldHostName)
+		a.rollbackCloud(redoFailures, oldHostName)
+
+		for _, ip := range failed {
+			redoErr, found := redoFailures[ip.String()]
+			if found {
+				failures[ip.String()] = fmt.Errorf("error while moving IP '%v' from '%v' to '%v': %v. Reverting the change failed: %v", ip.String(), oldHostName, newHostName, errs[ip.String()].Error(), redoErr.Error())
+				continue
+			}
+
+			failures[ip.String()] = fmt.Errorf("error while moving IP '%v' from '%v' to '%v'. Change reverted: %v", ip.String(), oldHostName, newHostName, errs[ip.String()].Error())
+		}
+	}
+
+	return failures
+}
+
+func (a CloudManagedEgressIPProvisioner) RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	failures := a.Cloud.RemoveIPs(ctx, ips, hostName)
+
+	failed := make([]*net.IP, 0)
+	errs := make(map[string]error)
+	for _, ip := range succeeded(ips, failures) {
+		err := a.OpenShift.RemoveIP(ctx, ip, hostName)
+		if err != nil {
+			errs[ip.String()] = err
+			failed = append(failed, ip)
+		}
+	}
+
+	if len(failed) > 0 {
+		redoFailures := a.Cloud.AddSpecifiedIPs(ctx, failed, hostName)
+		a.rollbackCloud(redoFailures, hostName)
+
+		for _, ip := range failed {
+			redoErr, found := redoFailures[ip.String()]
+			if found {
+				failures[ip.String()] = fmt.Errorf("error while removing IP '%v' from OpenShift: %v. Re-adding it to the cloudprovider failed: %v", ip.String(), errs[ip.String()].Error(), redoErr.Error())
+				continue
+			}
+
+			failures[ip.String()] = fmt.Errorf("error while removing IP '%v' from host. IP is still valid: %v", ip.String(), errs[ip.String()].Error())
+		}
+	}
+
+	return failures
+}
+
+// rollbackCloud logs all IPs that could not be rolled back in the cloud.
+func (a CloudManagedEgressIPProvisioner) rollbackCloud(failures map[string]error, hostName string) {
+	for ip, err := range failures {
+		a.Log.Error(err, "error while rolling back ip in the cloud", "ip", ip, "host", hostName)
+	}
+}
+
+// succeeded returns all IPs without failure.
+func succeeded(ips []*net.IP, failures map[string]error) []*net.IP {
+	result := make([]*net.IP, 0)
+	for _, ip := range ips {
+		if _, failed := failures[ip.String()]; !failed {
+			result = append(result, ip)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cloudmanaged_provisioner_test
+
+import (
+	"context"
+	"errors"
+	"github.com/klenkes74/egress-ip-operator/pkg/cloudprovider"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner/cloudmanaged_provisioner"
+	"github.com/klenkes74/egress-ip-operator/pkg/provisioner/ocp_static_provisioner"
+	netv1 "github.com/openshift/api/network/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"strings"
+	"testing"
+)
+
+var log = zap.New(zap.UseDevMode(true)).WithName("cloudmanaged_provisioner_test")
+
+// brokenCloud succeeds every move and removal but fails to roll them back.
+type brokenCloud struct {
+	cloudprovider.CloudProvider
+	moves int
+}
+
+func (b *brokenCloud) MoveIPs(_ context.Context, ips []*net.IP, _ string, _ string) map[string]error {
+	b.moves++
+	if b.moves == 1 {
+		return map[string]error{}
+	}
+
+	return failAll(ips, errors.New("cloud unavailable"))
+}
+func (b *brokenCloud) RemoveIPs(_ context.Context, _ []*net.IP, _ string) map[string]error {
+	return map[string]error{}
+}
+func (b *brokenCloud) AddSpecifiedIPs(_ context.Context, ips []*net.IP, _ string) map[string]error {
+	return failAll(ips, errors.New("cloud unavailable"))
+}
+
+func failAll(ips []*net.IP, err error) map[string]error {
+	result := make(map[string]error)
+	for _, ip := range ips {
+		result[ip.String()] = err
+	}
+
+	return result
+}
+
+// prepareProvisioner returns a provisioner whose HostSubnets can't be updated since they don't exist.
+func prepareProvisioner() cloudmanaged_provisioner.CloudManagedEgressIPProvisioner {
+	scheme := runtime.NewScheme()
+	_ = netv1.AddToScheme(scheme)
+
+	return cloudmanaged_provisioner.CloudManagedEgressIPProvisioner{
+		Log:   log,
+		Cloud: &brokenCloud{},
+		OpenShift: ocp_static_provisioner.OcpStaticEgressIPProvisioner{
+			Client: fake.NewFakeClientWithScheme(scheme, &netv1.HostSubnet{}),
+			Log:    log,
+		},
+	}
+}
+
+func TestReportingFailedRollbackOfMoves(t *testing.T) {
+	ip := net.ParseIP("10.0.1.10")
+
+	failures := prepareProvisioner().MoveIPs(context.Background(), []*net.IP{&ip}, "node-a", "node-b")
+
+	err := failures[ip.String()]
+	if err == nil || !strings.Contains(err.Error(), "Reverting the change failed") {
+		t.Errorf("Failed rollback should be reported! current='%v'", err)
+	}
+}
+
+func TestReportingFailedRollbackOfRemovals(t *testing.T) {
+	ip := net.ParseIP("10.0.1.10")
+	sut := prepareProvisioner()
+	sut.OpenShift.Client = fake.NewFakeClientWithScheme(runtime.NewScheme())
+
+	failures := sut.RemoveIPs(context.Background(), []*net.IP{&ip}, "node-a")
+
+	err := failures[ip.String()]
+	if err == nil || !strings.Contains(err.Error(), "Re-adding it to the cloudprovider failed") {
+		t.Errorf("Failed rollback should be reported! current='%v'", err)
+	}
+}
@@ -19,6 +19,8 @@ package ocp_dynamic_provisioner
 import (
 	"context"
 	"github.com/go-logr/logr"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
 	"net"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -26,6 +28,8 @@ import (
 // OcpDynamicEgressIPProvisioner is basically a no-op provisioner since it only has to handle
 type OcpDynamicEgressIPProvisioner struct {
 	client.Client
+	// APIReader reads directly from the kubernetes api. Without it the client is used.
+	APIReader client.Reader
 
 	Log logr.Logger
 }
@@ -48,7 +52,37 @@ func (o OcpDynamicEgressIPProvisioner) CheckIP(_ context.Context, _ *net.IP, _ s
 	return nil
 }
 
-func (o OcpDynamicEgressIPProvisioner) FindHostForNewIP(_ context.Context, _ string) (string, error) {
+// CheckHost has nothing to check since OpenShift manages the host networking.
+func (o OcpDynamicEgressIPProvisioner) CheckHost(_ context.Context, _ string) error {
+	return nil
+}
+
+// CheckBackend reads a HostSubnet from the kubernetes api.
+func (o OcpDynamicEgressIPProvisioner) CheckBackend(ctx context.Context) error {
+	var reader client.Reader = o.Client
+	if o.APIReader != nil {
+		reader = o.APIReader
+	}
+
+	return reader.List(ctx, &netv1.HostSubnetList{}, client.Limit(1))
+}
+
+// FindIP returns no host since OpenShift decides which host of the CIDR range serves the IP.
+func (o OcpDynamicEgressIPProvisioner) FindIP(_ context.Context, _ *net.IP) (string, error) {
+	return "", nil
+}
+
+// ListIPs returns no IPs since the hosts only get the CIDR range assigned.
+func (o OcpDynamicEgressIPProvisioner) ListIPs(_ context.Context, _ string) ([]*net.IP, error) {
+	return []*net.IP{}, nil
+}
+
+// IPLimit returns no limit since OpenShift manages the host networking.
+func (o OcpDynamicEgressIPProvisioner) IPLimit(_ context.Context, _ string) (int, error) {
+	return 0, nil
+}
+
+func (o OcpDynamicEgressIPProvisioner) FindHostForNewIP(_ context.Context, _ string, _ *corev1.NodeAffinity, _ map[string]int) (string, error) {
 	return "-no host needed-", nil
 }
 
@@ -59,3 +93,53 @@ func (o OcpDynamicEgressIPProvisioner) MoveIP(_ context.Context, ip *net.IP, _ s
 func (o OcpDynamicEgressIPProvisioner) RemoveIP(_ context.Context, _ *net.IP, _ string) error {
 	return nil
 }
+
+func (o OcpDynamicEgressIPProvisioner) AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	failures := make(map[string]error)
+	for _, ip := range ips {
+		err := o.AddSpecifiedIP(ctx, ip, hostName)
+		if err != nil {
+			failures[ip.String()] = err
+		}
+	}
+
+	return failures
+}
+
+func (o OcpDynamicEgressIPProvisioner) AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error) {
+	result := make([]*net.IP, 0)
+	for i := 0; i < count; i++ {
+		ip, err := o.AddRandomIP(ctx, hostName)
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, ip)
+	}
+
+	return result, nil
+}
+
+func (o OcpDynamicEgressIPProvisioner) MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error {
+	failures := make(map[string]error)
+	for _, ip := range ips {
+		err := o.MoveIP(ctx, ip, oldHostName, newHostName)
+		if err != nil {
+			failures[ip.String()] = err
+		}
+	}
+
+	return failures
+}
+
+func (o OcpDynamicEgressIPProvisioner) RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	failures := make(map[string]error)
+	for _, ip := range ips {
+		err := o.RemoveIP(ctx, ip, hostName)
+		if err != nil {
+			failures[ip.String()] = err
+		}
+	}
+
+	return failures
+}
@@ -18,7 +18,13 @@ package ocp_static_provisioner
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	netv1 "github.com/openshift/api/network/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"net"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -26,40 +32,330 @@ import (
 // The OcpStaticEgressIPProvisioner will manage the IP on the hosts by assigning free IPs from the failure-domain.
 type OcpStaticEgressIPProvisioner struct {
 	client.Client
+	// APIReader reads directly from the kubernetes api. Without it the client is used.
+	APIReader client.Reader
 
 	Log logr.Logger
 }
 
+// AddSpecifiedIP adds the IP to the egress IPs of the HostSubnet of the specified host.
 func (o OcpStaticEgressIPProvisioner) AddSpecifiedIP(ctx context.Context, ip *net.IP, hostName string) error {
-	// TODO 2020-09-19 rlichti implement AddSpecifiedIP in OcpStaticEgressIPProvisioner
-	panic("implement me")
+	hostSubnet := &netv1.HostSubnet{}
+	err := o.Client.Get(ctx, types.NamespacedName{Name: hostName}, hostSubnet)
+	if err != nil {
+		return err
+	}
+
+	if containsIP(hostSubnet.EgressIPs, ip) {
+		return nil
+	}
+
+	hostSubnet.EgressIPs = append(hostSubnet.EgressIPs, ip.String())
+	err = o.Client.Update(ctx, hostSubnet)
+	if err != nil {
+		return err
+	}
+
+	o.Log.Info("added egress ip to hostsubnet", "host", hostName, "ip", ip.String())
+	return nil
 }
 
+// AddRandomIP takes the first free IP of the address space of the failure domain of the specified host. An IP is free
+// if it is neither an egress IP of any HostSubnet nor the address of any node.
 func (o OcpStaticEgressIPProvisioner) AddRandomIP(ctx context.Context, hostName string) (*net.IP, error) {
-	// TODO 2020-09-19 rlichti implement AddRandomIP in OcpStaticEgressIPProvisioner
-	panic("implement me")
+	node := &corev1.Node{}
+	err := o.Client.Get(ctx, types.NamespacedName{Name: hostName}, node)
+	if err != nil {
+		return nil, err
+	}
+
+	failureDomains, err := failuredomain.FailureDomainsOfNode(ctx, o.Client, node)
+	if err != nil {
+		return nil, err
+	}
+	if len(failureDomains) != 1 {
+		return nil, fmt.Errorf("host '%v' has to be in exactly one failure domain but is in %v", hostName, len(failureDomains))
+	}
+
+	space, err := failuredomain.NewAddressSpace(&failureDomains[0])
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := o.usedIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := space.NextFreeIP(used)
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.AddSpecifiedIP(ctx, ip, hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	return ip, nil
 }
 
 func (o OcpStaticEgressIPProvisioner) AssignCIDR(_ context.Context, _ string) error {
 	return nil
 }
 
+// CheckIP checks the egress IPs of the HostSubnet of the specified host for the IP.
 func (o OcpStaticEgressIPProvisioner) CheckIP(ctx context.Context, ip *net.IP, hostName string) error {
-	// TODO 2020-09-19 rlichti implement CheckIP in OcpStaticEgressIPProvisioner
-	panic("implement me")
+	hostSubnet := &netv1.HostSubnet{}
+	err := o.Client.Get(ctx, types.NamespacedName{Name: hostName}, hostSubnet)
+	if err != nil {
+		return err
+	}
+
+	if !containsIP(hostSubnet.EgressIPs, ip) {
+		return fmt.Errorf("ip '%v' is not an egress ip of hostsubnet '%v'", ip.String(), hostName)
+	}
+
+	return nil
 }
 
-func (o OcpStaticEgressIPProvisioner) FindHostForNewIP(ctx context.Context, failureDomain string) (string, error) {
-	// TODO 2020-09-19 rlichti implement FindHostForNewIP in OcpStaticEgressIPProvisioner
-	panic("implement me")
+// CheckHost has nothing to check since OpenShift manages the host networking.
+func (o OcpStaticEgressIPProvisioner) CheckHost(_ context.Context, _ string) error {
+	return nil
+}
+
+// CheckBackend reads a HostSubnet from the kubernetes api.
+func (o OcpStaticEgressIPProvisioner) CheckBackend(ctx context.Context) error {
+	var reader client.Reader = o.Client
+	if o.APIReader != nil {
+		reader = o.APIReader
+	}
+
+	return reader.List(ctx, &netv1.HostSubnetList{}, client.Limit(1))
 }
 
+// FindIP searches all HostSubnets for the specified IP.
+func (o OcpStaticEgressIPProvisioner) FindIP(ctx context.Context, ip *net.IP) (string, error) {
+	hostSubnets := &netv1.HostSubnetList{}
+	err := o.Client.List(ctx, hostSubnets)
+	if err != nil {
+		return "", err
+	}
+
+	for _, hostSubnet := range hostSubnets.Items {
+		for _, egressIP := range hostSubnet.EgressIPs {
+			if egressIP == ip.String() {
+				return hostSubnet.Host, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// ListIPs returns the egress IPs of the HostSubnet of the specified host.
+func (o OcpStaticEgressIPProvisioner) ListIPs(ctx context.Context, hostName string) ([]*net.IP, error) {
+	hostSubnet := &netv1.HostSubnet{}
+	err := o.Client.Get(ctx, types.NamespacedName{Name: hostName}, hostSubnet)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*net.IP, 0)
+	for _, egressIP := range hostSubnet.EgressIPs {
+		ip := net.ParseIP(egressIP)
+		if ip == nil {
+			continue
+		}
+
+		result = append(result, &ip)
+	}
+
+	return result, nil
+}
+
+// IPLimit returns no limit since OpenShift manages the host networking.
+func (o OcpStaticEgressIPProvisioner) IPLimit(_ context.Context, _ string) (int, error) {
+	return 0, nil
+}
+
+// FindHostForNewIP returns the ready host of the failure domain serving the least egress IPs among the hosts preferred
+// most by the node affinity. The pending IPs of a host are added to the egress IPs of its HostSubnet. Hosts without
+// HostSubnet or not matching the required terms of the affinity are not eligible.
+func (o OcpStaticEgressIPProvisioner) FindHostForNewIP(ctx context.Context, failureDomainName string, affinity *corev1.NodeAffinity, pending map[string]int) (string, error) {
+	failureDomain, err := failuredomain.FindFailureDomain(ctx, o.Client, failureDomainName)
+	if err != nil {
+		return "", err
+	}
+
+	nodes, err := failuredomain.ListNodesOfFailureDomain(ctx, o.Client, failureDomain)
+	if err != nil {
+		return "", err
+	}
+
+	result := ""
+	least := 0
+	var preferred int32
+	for _, node := range nodes {
+		if !failuredomain.IsNodeReady(&node) || !failuredomain.MatchesRequiredAffinity(&node, affinity) {
+			continue
+		}
+
+		hostSubnet := &netv1.HostSubnet{}
+		err = o.Client.Get(ctx, types.NamespacedName{Name: node.Name}, hostSubnet)
+		if err != nil {
+			continue
+		}
+
+		preference := failuredomain.PreferenceOf(&node, affinity)
+		if result != "" && preference < preferred {
+			continue
+		}
+
+		served := len(hostSubnet.EgressIPs) + pending[node.Name]
+		if result == "" || preference > preferred || served < least || (served == least && node.Name < result) {
+			result = node.Name
+			least = served
+			preferred = preference
+		}
+	}
+
+	if result == "" {
+		return "", fmt.Errorf("no ready host matching the node affinity found in failure domain '%v'", failureDomainName)
+	}
+
+	return result, nil
+}
+
+// MoveIP removes the IP from the HostSubnet of the old host and adds it to the HostSubnet of the new host.
 func (o OcpStaticEgressIPProvisioner) MoveIP(ctx context.Context, ip *net.IP, oldHostName string, newHostName string) error {
-	// TODO 2020-09-19 rlichti implement MoveIP in OcpStaticEgressIPProvisioner
-	panic("implement me")
+	err := o.RemoveIP(ctx, ip, oldHostName)
+	if err != nil {
+		return err
+	}
+
+	return o.AddSpecifiedIP(ctx, ip, newHostName)
 }
 
+// RemoveIP removes the IP from the egress IPs of the HostSubnet of the specified host. A missing HostSubnet has no IPs
+// to remove.
 func (o OcpStaticEgressIPProvisioner) RemoveIP(ctx context.Context, ip *net.IP, hostName string) error {
-	// TODO 2020-09-19 rlichti implement RemoveIP in OcpStaticEgressIPProvisioner
-	panic("implement me")
+	hostSubnet := &netv1.HostSubnet{}
+	err := o.Client.Get(ctx, types.NamespacedName{Name: hostName}, hostSubnet)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if !containsIP(hostSubnet.EgressIPs, ip) {
+		return nil
+	}
+
+	remaining := make([]string, 0)
+	for _, egressIP := range hostSubnet.EgressIPs {
+		if !ip.Equal(net.ParseIP(egressIP)) {
+			remaining = append(remaining, egressIP)
+		}
+	}
+	hostSubnet.EgressIPs = remaining
+
+	err = o.Client.Update(ctx, hostSubnet)
+	if err != nil {
+		return err
+	}
+
+	o.Log.Info("removed egress ip from hostsubnet", "host", hostName, "ip", ip.String())
+	return nil
+}
+
+func (o OcpStaticEgressIPProvisioner) AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	failures := make(map[string]error)
+	for _, ip := range ips {
+		err := o.AddSpecifiedIP(ctx, ip, hostName)
+		if err != nil {
+			failures[ip.String()] = err
+		}
+	}
+
+	return failures
+}
+
+func (o OcpStaticEgressIPProvisioner) AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error) {
+	result := make([]*net.IP, 0)
+	for i := 0; i < count; i++ {
+		ip, err := o.AddRandomIP(ctx, hostName)
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, ip)
+	}
+
+	return result, nil
+}
+
+func (o OcpStaticEgressIPProvisioner) MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error {
+	failures := make(map[string]error)
+	for _, ip := range ips {
+		err := o.MoveIP(ctx, ip, oldHostName, newHostName)
+		if err != nil {
+			failures[ip.String()] = err
+		}
+	}
+
+	return failures
+}
+
+func (o OcpStaticEgressIPProvisioner) RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	failures := make(map[string]error)
+	for _, ip := range ips {
+		err := o.RemoveIP(ctx, ip, hostName)
+		if err != nil {
+			failures[ip.String()] = err
+		}
+	}
+
+	return failures
+}
+
+// usedIPs collects the egress IPs of all HostSubnets and the addresses of all nodes.
+func (o OcpStaticEgressIPProvisioner) usedIPs(ctx context.Context) (map[string]bool, error) {
+	result := make(map[string]bool)
+
+	hostSubnets := &netv1.HostSubnetList{}
+	err := o.Client.List(ctx, hostSubnets)
+	if err != nil {
+		return nil, err
+	}
+	for _, hostSubnet := range hostSubnets.Items {
+		for _, egressIP := range hostSubnet.EgressIPs {
+			result[egressIP] = true
+		}
+	}
+
+	nodes := &corev1.NodeList{}
+	err = o.Client.List(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			result[address.Address] = true
+		}
+	}
+
+	return result, nil
+}
+
+func containsIP(ips []string, ip *net.IP) bool {
+	for _, candidate := range ips {
+		if ip.Equal(net.ParseIP(candidate)) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -34,30 +34,61 @@ import (
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner/cloudmanaged_provisioner"
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner/ocp_dynamic_provisioner"
 	"github.com/klenkes74/egress-ip-operator/pkg/provisioner/ocp_static_provisioner"
+	corev1 "k8s.io/api/core/v1"
 	"net"
 	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // EgressIPProvisioner is the low level IP manager for
 type EgressIPProvisioner interface {
-	// FindHostForNewIP searches for a host in the failure domain to add an IP to.
+	// FindHostForNewIP searches for a host in the failure domain matching the required terms of the node affinity to
+	// add an IP to. Hosts preferred by the affinity are chosen first. The pending IPs by host are already chosen for the
+	// hosts but not assigned yet and count like their IPs, pending may be nil.
 	// Will return the hostname or an error.
-	FindHostForNewIP(ctx context.Context, failureDomain string) (string, error)
+	FindHostForNewIP(ctx context.Context, failureDomain string, affinity *corev1.NodeAffinity, pending map[string]int) (string, error)
 	// AddSpecifiedIP adds a predefined IP to the specified host.
 	// It will return an error or nil.
 	AddSpecifiedIP(ctx context.Context, ip *net.IP, hostName string) error
+	// AddSpecifiedIPs adds the predefined IPs to the specified host.
+	// It will return the errors of the failed IPs keyed by the IP. An empty map means all IPs have been added.
+	AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error
 	// AddRandomIP adds a random IP to the specified host.
 	// It will return the IP or the error.
 	AddRandomIP(ctx context.Context, hostName string) (*net.IP, error)
+	// AddRandomIPs adds the given number of random IPs to the specified host.
+	// It will return the added IPs and an error if not all IPs could be added.
+	AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error)
 	// RemoveIP will remove the given IP from the specified host.
 	// It will return an error or nil.
 	RemoveIP(ctx context.Context, ip *net.IP, hostName string) error
+	// RemoveIPs will remove the given IPs from the specified host.
+	// It will return the errors of the failed IPs keyed by the IP. An empty map means all IPs have been removed.
+	RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error
 	// MoveIP will move the specified IP from oldHost to newHost.
 	// It will return an error or nil.
 	MoveIP(ctx context.Context, ip *net.IP, oldHostName string, newHostName string) error
+	// MoveIPs will move the specified IPs from oldHost to newHost.
+	// It will return the errors of the failed IPs keyed by the IP. An empty map means all IPs have been moved.
+	MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error
 	// CheckIP will check if the specified IP is assigned on the specified host.
 	// it will return an error or nil.
 	CheckIP(ctx context.Context, ip *net.IP, hostName string) error
+	// CheckHost will check if the specified host is configured to serve egress IPs.
+	// It will return an error or nil.
+	CheckHost(ctx context.Context, hostName string) error
+	// FindIP searches for the host the specified IP is currently assigned to.
+	// It will return the hostname, an empty hostname if the IP is not assigned at all or the error.
+	FindIP(ctx context.Context, ip *net.IP) (string, error)
+	// ListIPs lists all egress IPs assigned to the specified host.
+	// It will return the IPs or the error.
+	ListIPs(ctx context.Context, hostName string) ([]*net.IP, error)
+	// IPLimit returns the maximum number of egress IPs the specified host can serve. A limit of 0 means unlimited.
+	// It will return the limit or the error.
+	IPLimit(ctx context.Context, hostName string) (int, error)
+	// CheckBackend will check if the backend of the provisioner can be reached.
+	// It will return an error or nil.
+	CheckBackend(ctx context.Context) error
 	// AssignCIDR will assign the cidr range to a host.
 	// Basically it is only needed by the provisioner 'ocp-dynamic'. The other provisioners will be no-ops.
 	AssignCIDR(ctx context.Context, hostName string) error
@@ -67,7 +98,9 @@ var _ EgressIPProvisioner = &cloudmanaged_provisioner.CloudManagedEgressIPProvis
 var _ EgressIPProvisioner = &ocp_dynamic_provisioner.OcpDynamicEgressIPProvisioner{}
 var _ EgressIPProvisioner = &ocp_static_provisioner.OcpStaticEgressIPProvisioner{}
 
-func NewEgressIPProvisioner(logger logr.Logger) (*EgressIPProvisioner, error) {
+// NewEgressIPProvisioner creates the provisioner configured by the environment. The reader is used for reading directly
+// from the kubernetes api instead of the cache.
+func NewEgressIPProvisioner(c client.Client, reader client.Reader, logger logr.Logger) (*EgressIPProvisioner, error) {
 	var result EgressIPProvisioner
 
 	provisionerType, found := os.LookupEnv("EGRESSIP_PROVISIONER")
@@ -82,28 +115,38 @@ func NewEgressIPProvisioner(logger logr.Logger) (*EgressIPProvisioner, error) {
 			return nil, errors.New("no cloud provider type defined - please set environment 'CLOUD_PROVIDER")
 		}
 
-		cloud, err := cloudprovider.NewCloudProvider(cloudProviderType, logger.WithName("cloud"))
+		cloud, err := cloudprovider.NewCloudProvider(cloudProviderType, c, logger.WithName("cloud"))
 		if err != nil {
 			return nil, err
 		}
 		provider := &cloudmanaged_provisioner.CloudManagedEgressIPProvisioner{
 			Cloud: *cloud,
-			Log:   logger,
+			OpenShift: ocp_static_provisioner.OcpStaticEgressIPProvisioner{
+				Client:    c,
+				APIReader: reader,
+				Log:       logger.WithName("ocp-static"),
+			},
+			Log: logger,
 		}
 		result = EgressIPProvisioner(provider)
 	case "ocp-dynamic":
 		provider := &ocp_dynamic_provisioner.OcpDynamicEgressIPProvisioner{
-			Log: logger.WithName("ocp-dynamic"),
+			Client:    c,
+			APIReader: reader,
+			Log:       logger.WithName("ocp-dynamic"),
 		}
 		result = EgressIPProvisioner(provider)
 	case "ocp-static":
 		provider := &ocp_static_provisioner.OcpStaticEgressIPProvisioner{
-			Log: logger.WithName("ocp-static"),
+			Client:    c,
+			APIReader: reader,
+			Log:       logger.WithName("ocp-static"),
 		}
 		result = EgressIPProvisioner(provider)
 	default:
 		return nil, fmt.Errorf("cloudprovider type '%v' is not defined - please use one of: 'cloud', 'ocp-dynamic', or 'ocp-static", provisionerType)
 	}
 
+	result = EgressIPProvisioner(&TracedEgressIPProvisioner{Provisioner: result})
 	return &result, nil
 }
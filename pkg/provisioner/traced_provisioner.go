@@ -0,0 +1,165 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioner
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"net"
+)
+
+var _ EgressIPProvisioner = &TracedEgressIPProvisioner{}
+
+// TracedEgressIPProvisioner creates a span for every call and delegates it to the wrapped EgressIPProvisioner.
+type TracedEgressIPProvisioner struct {
+	Provisioner EgressIPProvisioner
+}
+
+func (t TracedEgressIPProvisioner) FindHostForNewIP(ctx context.Context, failureDomain string, affinity *corev1.NodeAffinity, pending map[string]int) (string, error) {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.FindHostForNewIP", tracing.FailureDomainKey.String(failureDomain))
+	result, err := t.Provisioner.FindHostForNewIP(ctx, failureDomain, affinity, pending)
+	span.SetAttributes(tracing.HostKey.String(result))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedEgressIPProvisioner) AddSpecifiedIP(ctx context.Context, ip *net.IP, hostName string) error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.AddSpecifiedIP", tracing.IP(ip), tracing.HostKey.String(hostName))
+	err := t.Provisioner.AddSpecifiedIP(ctx, ip, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedEgressIPProvisioner) AddSpecifiedIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.AddSpecifiedIPs", tracing.IPs(ips), tracing.HostKey.String(hostName))
+	result := t.Provisioner.AddSpecifiedIPs(ctx, ips, hostName)
+	tracing.EndWithFailures(span, result)
+
+	return result
+}
+
+func (t TracedEgressIPProvisioner) AddRandomIP(ctx context.Context, hostName string) (*net.IP, error) {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.AddRandomIP", tracing.HostKey.String(hostName))
+	result, err := t.Provisioner.AddRandomIP(ctx, hostName)
+	span.SetAttributes(tracing.IP(result))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedEgressIPProvisioner) AddRandomIPs(ctx context.Context, count int, hostName string) ([]*net.IP, error) {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.AddRandomIPs", tracing.HostKey.String(hostName))
+	result, err := t.Provisioner.AddRandomIPs(ctx, count, hostName)
+	span.SetAttributes(tracing.IPs(result))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedEgressIPProvisioner) RemoveIP(ctx context.Context, ip *net.IP, hostName string) error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.RemoveIP", tracing.IP(ip), tracing.HostKey.String(hostName))
+	err := t.Provisioner.RemoveIP(ctx, ip, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedEgressIPProvisioner) RemoveIPs(ctx context.Context, ips []*net.IP, hostName string) map[string]error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.RemoveIPs", tracing.IPs(ips), tracing.HostKey.String(hostName))
+	result := t.Provisioner.RemoveIPs(ctx, ips, hostName)
+	tracing.EndWithFailures(span, result)
+
+	return result
+}
+
+func (t TracedEgressIPProvisioner) MoveIP(ctx context.Context, ip *net.IP, oldHostName string, newHostName string) error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.MoveIP",
+		tracing.IP(ip), tracing.HostKey.String(oldHostName), tracing.TargetHostKey.String(newHostName),
+	)
+	err := t.Provisioner.MoveIP(ctx, ip, oldHostName, newHostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedEgressIPProvisioner) MoveIPs(ctx context.Context, ips []*net.IP, oldHostName string, newHostName string) map[string]error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.MoveIPs",
+		tracing.IPs(ips), tracing.HostKey.String(oldHostName), tracing.TargetHostKey.String(newHostName),
+	)
+	result := t.Provisioner.MoveIPs(ctx, ips, oldHostName, newHostName)
+	tracing.EndWithFailures(span, result)
+
+	return result
+}
+
+func (t TracedEgressIPProvisioner) CheckIP(ctx context.Context, ip *net.IP, hostName string) error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.CheckIP", tracing.IP(ip), tracing.HostKey.String(hostName))
+	err := t.Provisioner.CheckIP(ctx, ip, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (t TracedEgressIPProvisioner) CheckHost(ctx context.Context, hostName string) error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.CheckHost", tracing.HostKey.String(hostName))
+	err := t.Provisioner.CheckHost(ctx, hostName)
+	tracing.End(span, err)
+
+	return err
+}
+
+// CheckBackend is not traced since the health probes would flood the traces.
+func (t TracedEgressIPProvisioner) CheckBackend(ctx context.Context) error {
+	return t.Provisioner.CheckBackend(ctx)
+}
+
+func (t TracedEgressIPProvisioner) FindIP(ctx context.Context, ip *net.IP) (string, error) {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.FindIP", tracing.IP(ip))
+	result, err := t.Provisioner.FindIP(ctx, ip)
+	span.SetAttributes(tracing.HostKey.String(result))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedEgressIPProvisioner) ListIPs(ctx context.Context, hostName string) ([]*net.IP, error) {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.ListIPs", tracing.HostKey.String(hostName))
+	result, err := t.Provisioner.ListIPs(ctx, hostName)
+	span.SetAttributes(tracing.IPs(result))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedEgressIPProvisioner) IPLimit(ctx context.Context, hostName string) (int, error) {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.IPLimit", tracing.HostKey.String(hostName))
+	result, err := t.Provisioner.IPLimit(ctx, hostName)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (t TracedEgressIPProvisioner) AssignCIDR(ctx context.Context, hostName string) error {
+	ctx, span := tracing.Start(ctx, "EgressIPProvisioner.AssignCIDR", tracing.HostKey.String(hostName))
+	err := t.Provisioner.AssignCIDR(ctx, hostName)
+	tracing.End(span, err)
+
+	return err
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package statusapi
+
+import (
+	"context"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Authorizer checks the callers of the API.
+type Authorizer interface {
+	// Authenticate returns the user of the token or nil if the token is not valid.
+	Authenticate(ctx context.Context, token string) (*authenticationv1.UserInfo, error)
+	// Authorize checks if the user may list the EgressIPs of the namespace. An empty namespace means all namespaces.
+	Authorize(ctx context.Context, user *authenticationv1.UserInfo, namespace string) (bool, error)
+}
+
+var _ Authorizer = &KubernetesAuthorizer{}
+
+// KubernetesAuthorizer reviews the token with a TokenReview and the access with a SubjectAccessReview.
+type KubernetesAuthorizer struct {
+	Client client.Client
+}
+
+func (k *KubernetesAuthorizer) Authenticate(ctx context.Context, token string) (*authenticationv1.UserInfo, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+
+	err := k.Client.Create(ctx, review)
+	if err != nil {
+		return nil, err
+	}
+
+	if !review.Status.Authenticated {
+		return nil, nil
+	}
+
+	return &review.Status.User, nil
+}
+
+func (k *KubernetesAuthorizer) Authorize(ctx context.Context, user *authenticationv1.UserInfo, namespace string) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "list",
+				Group:     v1alpha1.GroupVersion.Group,
+				Resource:  "egressips",
+			},
+		},
+	}
+
+	err := k.Client.Create(ctx, review)
+	if err != nil {
+		return false, err
+	}
+
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,359 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// statusapi serves a read-only JSON view of the EgressIPs, the capacity of their failure domains and the current alarms
+// next to the metrics. Every request has to carry a bearer token of a user allowed to list the EgressIPs of the
+// requested namespace.
+package statusapi
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/openshift"
+	"net/http"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+const (
+	// Path is the path the API is served on by the metrics server.
+	Path = "/api/egressips"
+	// HistoryPath is the path the history of the IPs is exported on as JSON lines.
+	HistoryPath = Path + "/history"
+)
+
+// Enabled enables the API.
+var Enabled bool
+
+func init() {
+	Enabled = true
+	enabled, found := os.LookupEnv("STATUS_API_ENABLED")
+	if found {
+		Enabled, _ = strconv.ParseBool(enabled)
+	}
+}
+
+// Report is the answer of the API.
+type Report struct {
+	EgressIPs      []EgressIPReport      `json:"egressIPs"`
+	FailureDomains []FailureDomainReport `json:"failureDomains"`
+	Alarms         []AlarmReport         `json:"alarms"`
+}
+
+// EgressIPReport is the state of a single EgressIP with the hosts serving its IPs.
+type EgressIPReport struct {
+	Namespace string                      `json:"namespace"`
+	Name      string                      `json:"name"`
+	Phase     string                      `json:"phase,omitempty"`
+	Message   string                      `json:"message,omitempty"`
+	IPs       []v1alpha1.AssignedEgressIP `json:"ips"`
+}
+
+// FailureDomainReport is the capacity of a failure domain. The capacity is missing until the failure domain has been
+// reconciled.
+type FailureDomainReport struct {
+	Name     string                           `json:"name"`
+	Cidr     string                           `json:"cidr,omitempty"`
+	Phase    string                           `json:"phase,omitempty"`
+	Capacity *openshift.FailureDomainCapacity `json:"capacity,omitempty"`
+}
+
+// AlarmReport is a current alarm of an EgressIP.
+type AlarmReport struct {
+	Namespace       string           `json:"namespace"`
+	EgressIP        string           `json:"egressIP,omitempty"`
+	Failures        []FailedIPReport `json:"failures"`
+	FirstOccurrence time.Time        `json:"firstOccurrence"`
+	LastOccurrence  time.Time        `json:"lastOccurrence"`
+	Counter         float64          `json:"counter"`
+	State           string           `json:"state"`
+	Flapping        bool             `json:"flapping"`
+}
+
+// HistoryReport is a single change of an IP of an EgressIP. It is written as a line of the history export.
+type HistoryReport struct {
+	Namespace string `json:"namespace"`
+	EgressIP  string `json:"egressip"`
+
+	v1alpha1.EgressIPHistoryEntry `json:",inline"`
+}
+
+// FailedIPReport is a single failed IP of an alarm. The IP is empty for a random IP not assigned yet.
+type FailedIPReport struct {
+	FailureDomain string `json:"failureDomain,omitempty"`
+	IP            string `json:"ip,omitempty"`
+}
+
+// Handler serves the report and the history export. The namespace is given by the query parameter 'namespace'. Without
+// it the report covers the whole cluster.
+type Handler struct {
+	Client     client.Client
+	Authorizer Authorizer
+	Alarms     metrics.AlarmStore
+
+	Log logr.Logger
+}
+
+var _ http.Handler = &Handler{}
+
+// NewHandler creates the handler authorizing the requests against the kubernetes api.
+func NewHandler(c client.Client, alarms metrics.AlarmStore, logger logr.Logger) *Handler {
+	return &Handler{
+		Client:     c,
+		Authorizer: &KubernetesAuthorizer{Client: c},
+		Alarms:     alarms,
+		Log:        logger,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	namespace := r.URL.Query().Get("namespace")
+	log := h.Log.WithValues("namespace", namespace)
+
+	token := bearerToken(r)
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.Authorizer.Authenticate(ctx, token)
+	if err != nil {
+		log.Error(err, "token could not be reviewed")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	allowed, err := h.Authorizer.Authorize(ctx, user, namespace)
+	if err != nil {
+		log.Error(err, "access could not be reviewed", "user", user.Username)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		log.Info("access denied", "user", user.Username)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path == HistoryPath {
+		h.serveHistory(w, r, namespace, log)
+		return
+	}
+
+	report, err := h.Report(ctx, namespace)
+	if err != nil {
+		log.Error(err, "report could not be created")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Error(err, "report could not be written")
+	}
+}
+
+// Report collects the EgressIPs, their failure domains and alarms of the namespace. An empty namespace covers the
+// whole cluster.
+func (h *Handler) Report(ctx context.Context, namespace string) (*Report, error) {
+	egressIPs := &v1alpha1.EgressIPList{}
+	err := h.Client.List(ctx, egressIPs, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err = h.Client.List(ctx, failureDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Report{
+		EgressIPs:      make([]EgressIPReport, 0),
+		FailureDomains: make([]FailureDomainReport, 0),
+		Alarms:         make([]AlarmReport, 0),
+	}
+
+	referenced := make(map[string]bool)
+	for _, egressIP := range egressIPs.Items {
+		result.EgressIPs = append(result.EgressIPs, EgressIPReport{
+			Namespace: egressIP.Namespace,
+			Name:      egressIP.Name,
+			Phase:     egressIP.Status.Phase,
+			Message:   egressIP.Status.Message,
+			IPs:       append([]v1alpha1.AssignedEgressIP{}, egressIP.Status.IPs...),
+		})
+
+		for _, ip := range egressIP.Spec.IPs {
+			referenced[ip.FailureDomain] = true
+		}
+	}
+
+	for _, failureDomain := range failureDomains.Items {
+		if namespace != "" && !referenced[failureDomain.Name] {
+			continue
+		}
+
+		report := FailureDomainReport{
+			Name:  failureDomain.Name,
+			Cidr:  failureDomain.Spec.Cidr,
+			Phase: failureDomain.Status.Phase,
+		}
+
+		capacity, found := openshift.CapacityOfFailureDomain(client.ObjectKey{Name: failureDomain.Name})
+		if found {
+			report.Capacity = &capacity
+		}
+
+		result.FailureDomains = append(result.FailureDomains, report)
+	}
+
+	for _, alarm := range h.Alarms.GetFailed() {
+		if namespace != "" && alarm.Namespace != namespace {
+			continue
+		}
+
+		result.Alarms = append(result.Alarms, alarmReport(alarm, h.Alarms.IsFlapping(alarm.Namespace, alarm.EgressIP)))
+	}
+	sort.Slice(result.Alarms, func(i, j int) bool {
+		if result.Alarms[i].Namespace != result.Alarms[j].Namespace {
+			return result.Alarms[i].Namespace < result.Alarms[j].Namespace
+		}
+
+		return result.Alarms[i].EgressIP < result.Alarms[j].EgressIP
+	})
+
+	return result, nil
+}
+
+// serveHistory writes the history of the IPs as JSON lines, the oldest change first.
+func (h *Handler) serveHistory(w http.ResponseWriter, r *http.Request, namespace string, log logr.Logger) {
+	history, err := h.History(r.Context(), namespace)
+	if err != nil {
+		log.Error(err, "history could not be collected")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for _, entry := range history {
+		err = encoder.Encode(entry)
+		if err != nil {
+			log.Error(err, "history could not be written")
+			return
+		}
+	}
+}
+
+// History collects the changes of the IPs of the namespace, the oldest first. An empty namespace covers the whole
+// cluster. With history resources enabled the EgressIPHistory resources are read, covering deleted EgressIPs too.
+// Otherwise the bounded history in the status of the EgressIPs is read.
+func (h *Handler) History(ctx context.Context, namespace string) ([]HistoryReport, error) {
+	result := make([]HistoryReport, 0)
+
+	if openshift.HistoryResourcesEnabled {
+		histories := &v1alpha1.EgressIPHistoryList{}
+		err := h.Client.List(ctx, histories, client.InNamespace(namespace))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, history := range histories.Items {
+			result = append(result, HistoryReport{
+				Namespace:            history.Namespace,
+				EgressIP:             history.Spec.EgressIP,
+				EgressIPHistoryEntry: history.Spec.EgressIPHistoryEntry,
+			})
+		}
+	} else {
+		egressIPs := &v1alpha1.EgressIPList{}
+		err := h.Client.List(ctx, egressIPs, client.InNamespace(namespace))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, egressIP := range egressIPs.Items {
+			for _, entry := range egressIP.Status.History {
+				result = append(result, HistoryReport{
+					Namespace:            egressIP.Namespace,
+					EgressIP:             egressIP.Name,
+					EgressIPHistoryEntry: entry,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(&result[j].Timestamp)
+	})
+
+	return result, nil
+}
+
+func alarmReport(alarm *metrics.FailedEgressIP, flapping bool) AlarmReport {
+	result := AlarmReport{
+		Namespace:       alarm.Namespace,
+		EgressIP:        alarm.EgressIP,
+		Failures:        make([]FailedIPReport, len(alarm.Failures)),
+		FirstOccurrence: alarm.FirstOccurrence,
+		LastOccurrence:  alarm.LastOccurrence,
+		Counter:         alarm.Counter,
+		State:           alarm.State,
+		Flapping:        flapping,
+	}
+
+	for i, failure := range alarm.Failures {
+		result.Failures[i].FailureDomain = failure.FailureDomain
+		if failure.IP != nil {
+			result.Failures[i].IP = failure.IP.String()
+		}
+	}
+
+	return result
+}
+
+// bearerToken returns the token of the authorization header or an empty string.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package statusapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/metrics"
+	"github.com/klenkes74/egress-ip-operator/pkg/statusapi"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+var log = zap.New(zap.UseDevMode(true)).WithName("statusapi_test")
+
+// namespaceAuthorizer accepts the token 'tenant' allowed to read the namespace 'tenant-a' and the token 'admin' allowed
+// to read everything.
+type namespaceAuthorizer struct{}
+
+func (n namespaceAuthorizer) Authenticate(_ context.Context, token string) (*authenticationv1.UserInfo, error) {
+	if token != "tenant" && token != "admin" {
+		return nil, nil
+	}
+
+	return &authenticationv1.UserInfo{Username: token}, nil
+}
+
+func (n namespaceAuthorizer) Authorize(_ context.Context, user *authenticationv1.UserInfo, namespace string) (bool, error) {
+	return user.Username == "admin" || namespace == "tenant-a", nil
+}
+
+func prepareHandler() *statusapi.Handler {
+	scheme := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(scheme)
+	_ = v1beta1.AddToScheme(scheme)
+
+	c := fake.NewFakeClientWithScheme(scheme,
+		&v1beta1.ClusterEgressIPFailureDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "zone-a"},
+			Spec:       v1beta1.ClusterEgressIPFailureDomainSpec{Cidr: "10.0.1.0/24"},
+		},
+		&v1beta1.ClusterEgressIPFailureDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "zone-b"},
+			Spec:       v1beta1.ClusterEgressIPFailureDomainSpec{Cidr: "10.0.2.0/24"},
+		},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant-a"},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.10"}},
+			},
+			Status: v1alpha1.EgressIPStatus{
+				Phase: "provisioned",
+				IPs:   []v1alpha1.AssignedEgressIP{{FailureDomain: "zone-a", IP: "10.0.1.10", HostName: "node-a"}},
+				History: []v1alpha1.EgressIPHistoryEntry{
+					{
+						Timestamp:     metav1.NewTime(time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)),
+						Action:        v1alpha1.HistoryActionAssigned,
+						FailureDomain: "zone-a",
+						IP:            "10.0.1.10",
+						NewHostName:   "node-b",
+						Reason:        "Specified",
+						Actor:         "kubectl",
+					},
+					{
+						Timestamp:     metav1.NewTime(time.Date(2020, 10, 2, 12, 0, 0, 0, time.UTC)),
+						Action:        v1alpha1.HistoryActionMoved,
+						FailureDomain: "zone-a",
+						IP:            "10.0.1.10",
+						OldHostName:   "node-b",
+						NewHostName:   "node-a",
+						Reason:        "NodeFailure",
+						Actor:         "egress-ip-operator",
+					},
+				},
+			},
+		},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant-b"},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-b", IP: "10.0.2.10"}},
+			},
+		},
+	)
+
+	return &statusapi.Handler{
+		Client:     c,
+		Authorizer: namespaceAuthorizer{},
+		Alarms:     *metrics.NewAlarmStore(log),
+		Log:        log,
+	}
+}
+
+func request(sut *statusapi.Handler, token string, namespace string) *httptest.ResponseRecorder {
+	return requestPath(sut, statusapi.Path, token, namespace)
+}
+
+func requestPath(sut *statusapi.Handler, path string, token string, namespace string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path+"?namespace="+namespace, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	sut.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestRejectingRequestsWithoutValidToken(t *testing.T) {
+	sut := prepareHandler()
+
+	for _, token := range []string{"", "invalid"} {
+		if code := request(sut, token, "tenant-a").Code; code != http.StatusUnauthorized {
+			t.Errorf("Request with token '%v' should be unauthorized! expected=%v, current=%v", token, http.StatusUnauthorized, code)
+		}
+	}
+}
+
+func TestRejectingRequestsForOtherNamespaces(t *testing.T) {
+	sut := prepareHandler()
+
+	for _, namespace := range []string{"tenant-b", ""} {
+		if code := request(sut, "tenant", namespace).Code; code != http.StatusForbidden {
+			t.Errorf("Request for namespace '%v' should be forbidden! expected=%v, current=%v", namespace, http.StatusForbidden, code)
+		}
+	}
+}
+
+func TestReportingNamespace(t *testing.T) {
+	sut := prepareHandler()
+
+	ip := net.ParseIP("10.0.1.10")
+	sut.Alarms.AddEgressIPAlarm("tenant-a", "egress", []metrics.FailedIP{{FailureDomain: "zone-a", IP: &ip}})
+	sut.Alarms.AddEgressIPAlarm("tenant-b", "egress", []metrics.FailedIP{{FailureDomain: "zone-b"}})
+	defer sut.Alarms.RemoveAlarm("tenant-a")
+	defer sut.Alarms.RemoveAlarm("tenant-b")
+
+	w := request(sut, "tenant", "tenant-a")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Request should succeed! expected=%v, current=%v", http.StatusOK, w.Code)
+	}
+
+	report := &statusapi.Report{}
+	err := json.Unmarshal(w.Body.Bytes(), report)
+	if err != nil {
+		t.Fatalf("Report is no valid json: %v", err)
+	}
+
+	if len(report.EgressIPs) != 1 || len(report.EgressIPs[0].IPs) != 1 || report.EgressIPs[0].IPs[0].HostName != "node-a" {
+		t.Errorf("Only the egressip of namespace 'tenant-a' served by 'node-a' should be reported! current=%v", report.EgressIPs)
+	}
+
+	if len(report.FailureDomains) != 1 || report.FailureDomains[0].Name != "zone-a" {
+		t.Errorf("Only the failure domain 'zone-a' should be reported! current=%v", report.FailureDomains)
+	}
+
+	if len(report.Alarms) != 1 || report.Alarms[0].Namespace != "tenant-a" || report.Alarms[0].Failures[0].IP != "10.0.1.10" {
+		t.Errorf("Only the alarm of namespace 'tenant-a' should be reported! current=%v", report.Alarms)
+	}
+}
+
+func TestReportingCluster(t *testing.T) {
+	sut := prepareHandler()
+
+	w := request(sut, "admin", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Request should succeed! expected=%v, current=%v", http.StatusOK, w.Code)
+	}
+
+	report := &statusapi.Report{}
+	_ = json.Unmarshal(w.Body.Bytes(), report)
+
+	if len(report.EgressIPs) != 2 || len(report.FailureDomains) != 2 {
+		t.Errorf("All egressips and failure domains should be reported! expected=2/2, current=%v/%v",
+			len(report.EgressIPs),
+			len(report.FailureDomains),
+		)
+	}
+}
+
+func TestExportingHistoryAsJSONLines(t *testing.T) {
+	sut := prepareHandler()
+
+	if code := requestPath(sut, statusapi.HistoryPath, "tenant", "tenant-b").Code; code != http.StatusForbidden {
+		t.Errorf("Export for namespace 'tenant-b' should be forbidden! expected=%v, current=%v", http.StatusForbidden, code)
+	}
+
+	w := requestPath(sut, statusapi.HistoryPath, "tenant", "tenant-a")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Request should succeed! expected=%v, current=%v", http.StatusOK, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Wrong content type! expected='application/x-ndjson', current='%v'", contentType)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Every change should be a line! expected=2, current=%v", lines)
+	}
+
+	entries := make([]statusapi.HistoryReport, len(lines))
+	for i, line := range lines {
+		err := json.Unmarshal([]byte(line), &entries[i])
+		if err != nil {
+			t.Fatalf("Line is no valid json: %v", err)
+		}
+	}
+
+	if entries[0].Namespace != "tenant-a" || entries[0].EgressIP != "egress" || entries[0].Action != v1alpha1.HistoryActionAssigned {
+		t.Errorf("Assignment should be the first line! current=%v", entries[0])
+	}
+	if entries[1].Action != v1alpha1.HistoryActionMoved || entries[1].OldHostName != "node-b" || entries[1].NewHostName != "node-a" {
+		t.Errorf("Move should be the second line! current=%v", entries[1])
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// tracing creates the spans of the operator from the reconcilers through the provisioners down to the calls of the
+// cloud API. The spans are exported via OTLP. Tracing is disabled by default.
+package tracing
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"os"
+	"strconv"
+)
+
+const (
+	DefaultEndpoint    = "localhost:55680"
+	DefaultSampleRatio = 1.0
+	DefaultServiceName = "egress-ip-operator"
+
+	// TracerName is the name of the instrumentation library.
+	TracerName = "github.com/klenkes74/egress-ip-operator"
+
+	NamespaceKey     = label.Key("egressip.namespace")
+	NameKey          = label.Key("egressip.name")
+	FailureDomainKey = label.Key("egressip.failure_domain")
+	IPKey            = label.Key("egressip.ip")
+	HostKey          = label.Key("egressip.host")
+	TargetHostKey    = label.Key("egressip.target_host")
+)
+
+var (
+	// Enabled enables the export of the spans.
+	Enabled bool
+	// Endpoint is the address of the OTLP collector.
+	Endpoint string
+	// Insecure disables TLS for the connection to the OTLP collector.
+	Insecure bool
+	// SampleRatio is the ratio of traces sampled.
+	SampleRatio float64
+)
+
+func init() {
+	enabled, found := os.LookupEnv("TRACING_ENABLED")
+	if found {
+		Enabled, _ = strconv.ParseBool(enabled)
+	}
+
+	Endpoint, found = os.LookupEnv("TRACING_OTLP_ENDPOINT")
+	if !found {
+		Endpoint = DefaultEndpoint
+	}
+
+	insecure, found := os.LookupEnv("TRACING_OTLP_INSECURE")
+	if found {
+		Insecure, _ = strconv.ParseBool(insecure)
+	}
+
+	SampleRatio = DefaultSampleRatio
+	ratio, found := os.LookupEnv("TRACING_SAMPLE_RATIO")
+	if found {
+		value, err := strconv.ParseFloat(ratio, 64)
+		if err == nil {
+			SampleRatio = value
+		}
+	}
+}
+
+// Setup installs the global tracer provider exporting the spans to the OTLP collector. Without tracing enabled the
+// spans are dropped. The returned function flushes and stops the export.
+func Setup(ctx context.Context, logger logr.Logger) (func(context.Context) error, error) {
+	if !Enabled {
+		logger.Info("tracing is disabled")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlp.ExporterOption{otlp.WithAddress(Endpoint)}
+	if Insecure {
+		options = append(options, otlp.WithInsecure())
+	}
+
+	exporter, err := otlp.NewExporter(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{
+			DefaultSampler: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(SampleRatio)),
+		}),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(DefaultServiceName))),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(provider)
+
+	logger.Info("tracing is enabled", "endpoint", Endpoint, "sample-ratio", SampleRatio)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			return err
+		}
+
+		return exporter.Shutdown(ctx)
+	}, nil
+}
+
+// Start starts a span of the operator as child of the span within the context.
+func Start(ctx context.Context, name string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error within the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// EndWithFailures records the number of failed IPs of a batch operation and ends the span.
+func EndWithFailures(span trace.Span, failures map[string]error) {
+	if len(failures) > 0 {
+		failed := make([]string, 0, len(failures))
+		for ip := range failures {
+			failed = append(failed, ip)
+		}
+
+		span.SetAttributes(label.Key("egressip.failed_ips").Array(failed))
+		span.SetStatus(codes.Error, strconv.Itoa(len(failures))+" ips failed")
+	}
+
+	span.End()
+}
+
+// IP returns the attribute of the IP. A nil IP is an empty attribute.
+func IP(ip *net.IP) label.KeyValue {
+	if ip == nil {
+		return IPKey.String("")
+	}
+
+	return IPKey.String(ip.String())
+}
+
+// IPs returns the attribute of all IPs.
+func IPs(ips []*net.IP) label.KeyValue {
+	result := make([]string, len(ips))
+	for i, ip := range ips {
+		result[i] = ip.String()
+	}
+
+	return IPKey.Array(result)
+}
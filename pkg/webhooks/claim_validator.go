@@ -0,0 +1,117 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/pkg/policy"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ClaimValidator rejects EgressIPClaims using a pool not allowed by the policies of their namespace. Updates keeping
+// the spec are always allowed.
+type ClaimValidator struct {
+	Client client.Reader
+	Log    logr.Logger
+
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &ClaimValidator{}
+var _ admission.DecoderInjector = &ClaimValidator{}
+
+func (v *ClaimValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+func (v *ClaimValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	instance := &v1alpha1.EgressIPClaim{}
+	err := v.decoder.DecodeRaw(req.Object, instance)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if req.Operation == admissionv1beta1.Update {
+		old := &v1alpha1.EgressIPClaim{}
+		err = v.decoder.DecodeRaw(req.OldObject, old)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if equality.Semantic.DeepEqual(old.Spec, instance.Spec) {
+			return admission.Allowed("spec is unchanged")
+		}
+	}
+
+	errs, err := ValidateClaim(ctx, v.Client, instance)
+	if err != nil {
+		v.Log.Error(err, "egressipclaim could not be validated", "egressipclaim", req.Namespace+"/"+req.Name)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if len(errs) > 0 {
+		v.Log.Info("rejected egressipclaim", "egressipclaim", req.Namespace+"/"+req.Name, "errors", errs.ToAggregate().Error())
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// ValidateClaim checks the pool of the claim against the policies of its namespace. Claims without class name are
+// checked against the default pool. The error is returned if the policies or pools could not be read.
+func ValidateClaim(ctx context.Context, c client.Reader, instance *v1alpha1.EgressIPClaim) (field.ErrorList, error) {
+	errs := field.ErrorList{}
+
+	policies, err := policy.PoliciesOf(ctx, c, instance.Namespace)
+	if err != nil || len(policies) == 0 {
+		return errs, err
+	}
+
+	className := instance.Spec.ClassName
+	if className == "" {
+		pools := &v1alpha1.EgressIPPoolList{}
+		err = c.List(ctx, pools)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pool := range pools.Items {
+			if pool.Annotations[v1alpha1.DefaultPoolAnnotation] == "true" {
+				className = pool.Name
+				break
+			}
+		}
+		if className == "" {
+			return errs, nil
+		}
+	}
+
+	err = policy.AllowsPool(policies, instance.Namespace, className)
+	if err != nil {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "className"), err.Error()))
+	}
+
+	return errs, nil
+}
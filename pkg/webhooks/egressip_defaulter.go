@@ -0,0 +1,66 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// EgressIPDefaulter expands EgressIPs without IPs or using all failure domains to a random IP in every failure domain.
+type EgressIPDefaulter struct {
+	Client client.Reader
+	Log    logr.Logger
+
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &EgressIPDefaulter{}
+var _ admission.DecoderInjector = &EgressIPDefaulter{}
+
+func (d *EgressIPDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+func (d *EgressIPDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	instance, err := decodeEgressIP(d.decoder, req.Object, req.Kind.Version)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	changed, err := failuredomain.ExpandToAllFailureDomains(ctx, d.Client, instance)
+	if err != nil {
+		d.Log.Error(err, "egressip could not be expanded to all failure domains", "egressip", req.Namespace+"/"+req.Name)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !changed {
+		return admission.Allowed("")
+	}
+
+	marshaled, err := encodeEgressIP(instance, req.Kind.Version)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	d.Log.Info("expanded egressip to all failure domains", "egressip", req.Namespace+"/"+req.Name, "ips", instance.Spec.IPs)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	"github.com/klenkes74/egress-ip-operator/pkg/policy"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// EgressIPValidator rejects EgressIPs referencing unknown failure domains, listing a failure domain twice or
+// specifying IPs outside the CIDR of their failure domain, excluded or reserved there, claimed by another EgressIP or
+// quarantined for another namespace.
+// EgressIPs exceeding the policies of their namespace are rejected, too. Updates keeping the spec are always allowed,
+// so finalizers can be removed even after the failure domain is gone.
+type EgressIPValidator struct {
+	Client client.Reader
+	Log    logr.Logger
+
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &EgressIPValidator{}
+var _ admission.DecoderInjector = &EgressIPValidator{}
+
+func (v *EgressIPValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+func (v *EgressIPValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	instance, err := decodeEgressIP(v.decoder, req.Object, req.Kind.Version)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if req.Operation == admissionv1beta1.Update {
+		old, err := decodeEgressIP(v.decoder, req.OldObject, req.Kind.Version)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if equality.Semantic.DeepEqual(old.Spec, instance.Spec) {
+			return admission.Allowed("spec is unchanged")
+		}
+	}
+
+	errs, err := ValidateEgressIP(ctx, v.Client, instance)
+	if err != nil {
+		v.Log.Error(err, "egressip could not be validated", "egressip", req.Namespace+"/"+req.Name)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if len(errs) > 0 {
+		v.Log.Info("rejected egressip", "egressip", req.Namespace+"/"+req.Name, "errors", errs.ToAggregate().Error())
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// ValidateEgressIP checks the spec of the EgressIP against the failure domains and the other EgressIPs. The error is
+// returned if they could not be read.
+func ValidateEgressIP(ctx context.Context, c client.Reader, instance *v1alpha1.EgressIP) (field.ErrorList, error) {
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err := c.List(ctx, failureDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	egressIPs := &v1alpha1.EgressIPList{}
+	err = c.List(ctx, egressIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	quarantines := &v1alpha1.EgressIPQuarantineList{}
+	err = c.List(ctx, quarantines)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := claimedIPs(egressIPs.Items, instance)
+
+	errs := field.ErrorList{}
+	listed := make(map[string]bool)
+	for i, spec := range instance.Spec.IPs {
+		path := field.NewPath("spec", "ips").Index(i)
+
+		if listed[spec.FailureDomain] {
+			errs = append(errs, field.Duplicate(path.Child("failure-domain"), spec.FailureDomain))
+			continue
+		}
+		listed[spec.FailureDomain] = true
+
+		failureDomain := findFailureDomain(failureDomains.Items, spec.FailureDomain)
+		if failureDomain == nil {
+			errs = append(errs, field.NotFound(path.Child("failure-domain"), spec.FailureDomain))
+			continue
+		}
+
+		if spec.IP == "" {
+			continue
+		}
+
+		ip := net.ParseIP(spec.IP)
+		if ip == nil {
+			errs = append(errs, field.Invalid(path.Child("ip"), spec.IP, "not a valid ip"))
+			continue
+		}
+
+		if failureDomain.Spec.Cidr != "" {
+			_, cidr, err := net.ParseCIDR(failureDomain.Spec.Cidr)
+			if err == nil && !cidr.Contains(ip) {
+				errs = append(errs, field.Invalid(path.Child("ip"), spec.IP,
+					fmt.Sprintf("not within cidr '%v' of failure domain '%v'", failureDomain.Spec.Cidr, failureDomain.Name),
+				))
+				continue
+			}
+
+			space, err := failuredomain.NewAddressSpace(failureDomain)
+			if err == nil && space.Excluded(ip) != "" {
+				errs = append(errs, field.Forbidden(path.Child("ip"), fmt.Sprintf("ip '%v' is %v", spec.IP, space.Excluded(ip))))
+				continue
+			}
+		}
+
+		if owner, found := claimed[ip.String()]; found {
+			errs = append(errs, field.Invalid(path.Child("ip"), spec.IP, fmt.Sprintf("already claimed by egressip '%v'", owner)))
+			continue
+		}
+
+		if owner := quarantinedFor(quarantines.Items, spec.FailureDomain, ip); owner != "" && owner != instance.Namespace {
+			errs = append(errs, field.Forbidden(path.Child("ip"), fmt.Sprintf("ip '%v' is quarantined for namespace '%v'", spec.IP, owner)))
+		}
+	}
+
+	policies, err := policy.PoliciesOf(ctx, c, instance.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, violation := range policy.Violations(policies, instance.Namespace, policy.SpecUsage(egressIPs.Items, instance)) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "ips"), violation))
+	}
+
+	return errs, nil
+}
+
+// claimedIPs returns the IPs specified or assigned by all EgressIPs except the instance, mapped to the name of the
+// claiming EgressIP.
+func claimedIPs(egressIPs []v1alpha1.EgressIP, instance *v1alpha1.EgressIP) map[string]string {
+	result := make(map[string]string)
+
+	for _, egressIP := range egressIPs {
+		if egressIP.Namespace == instance.Namespace && egressIP.Name == instance.Name {
+			continue
+		}
+
+		owner := egressIP.Namespace + "/" + egressIP.Name
+		for _, spec := range egressIP.Spec.IPs {
+			if ip := net.ParseIP(spec.IP); ip != nil {
+				result[ip.String()] = owner
+			}
+		}
+		for _, assigned := range egressIP.Status.IPs {
+			if ip := net.ParseIP(assigned.IP); ip != nil {
+				result[ip.String()] = owner
+			}
+		}
+	}
+
+	return result
+}
+
+// quarantinedFor returns the namespace the IP of the failure domain is quarantined for or an empty string.
+func quarantinedFor(quarantines []v1alpha1.EgressIPQuarantine, failureDomain string, ip net.IP) string {
+	for _, quarantine := range quarantines {
+		if quarantine.Spec.FailureDomain == failureDomain && ip.Equal(net.ParseIP(quarantine.Spec.IP)) {
+			return quarantine.Spec.Namespace
+		}
+	}
+
+	return ""
+}
+
+func findFailureDomain(failureDomains []v1beta1.ClusterEgressIPFailureDomain, name string) *v1beta1.ClusterEgressIPFailureDomain {
+	for i := range failureDomains {
+		if failureDomains[i].Name == name {
+			return &failureDomains[i]
+		}
+	}
+
+	return nil
+}
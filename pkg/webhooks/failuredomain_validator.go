@@ -0,0 +1,204 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/failuredomain"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// FailureDomainValidator rejects failure domains with CIDRs overlapping other failure domains and changes of the CIDR
+// leaving IPs in use outside of it. Namespaced v1alpha1 failure domains are validated as the cluster failure domains
+// they are migrated to.
+type FailureDomainValidator struct {
+	Client client.Reader
+	Log    logr.Logger
+
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &FailureDomainValidator{}
+var _ admission.DecoderInjector = &FailureDomainValidator{}
+
+func (v *FailureDomainValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+func (v *FailureDomainValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	instance, err := decodeFailureDomain(v.decoder, req.Object, req.Kind.Kind)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var old *v1beta1.ClusterEgressIPFailureDomain
+	if req.Operation == admissionv1beta1.Update {
+		old, err = decodeFailureDomain(v.decoder, req.OldObject, req.Kind.Kind)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if equality.Semantic.DeepEqual(old.Spec, instance.Spec) {
+			return admission.Allowed("spec is unchanged")
+		}
+	}
+
+	errs, err := ValidateFailureDomain(ctx, v.Client, instance, old)
+	if err != nil {
+		v.Log.Error(err, "failure domain could not be validated", "failuredomain", req.Name)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if len(errs) > 0 {
+		v.Log.Info("rejected failure domain", "failuredomain", req.Name, "errors", errs.ToAggregate().Error())
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// ValidateFailureDomain checks the CIDR of the failure domain against the other failure domains and its ranges and
+// reserved IPs against the CIDR. Failure domains migrated from another object must not take over a cluster failure
+// domain of the same name migrated from elsewhere. For changed CIDRs the IPs of the EgressIPs in the failure domain
+// have to stay within the new CIDR. The old failure domain is nil on create. The error is returned if the failure
+// domains or EgressIPs could not be read.
+func ValidateFailureDomain(ctx context.Context, c client.Reader, instance *v1beta1.ClusterEgressIPFailureDomain, old *v1beta1.ClusterEgressIPFailureDomain) (field.ErrorList, error) {
+	errs := field.ErrorList{}
+	path := field.NewPath("spec", "cidr")
+
+	failureDomains := &v1beta1.ClusterEgressIPFailureDomainList{}
+	err := c.List(ctx, failureDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	if migratedFrom := instance.Annotations[v1alpha1.MigratedFromAnnotation]; migratedFrom != "" {
+		for _, other := range failureDomains.Items {
+			if other.Name == instance.Name && other.Annotations[v1alpha1.MigratedFromAnnotation] != migratedFrom {
+				errs = append(errs, field.Duplicate(field.NewPath("metadata", "name"), instance.Name))
+			}
+		}
+	}
+
+	if instance.Spec.Cidr == "" {
+		if instance.Spec.AllocationRange != nil || len(instance.Spec.ExcludeRanges) > 0 || len(instance.Spec.Reserved) > 0 {
+			errs = append(errs, field.Required(path, "needed for allocationRange, excludeRanges and reserved"))
+		}
+		return errs, nil
+	}
+
+	_, cidr, err := net.ParseCIDR(instance.Spec.Cidr)
+	if err != nil {
+		return append(errs, field.Invalid(path, instance.Spec.Cidr, "not a valid cidr")), nil
+	}
+
+	if instance.Spec.AllocationRange != nil {
+		err = failuredomain.ValidateRange(cidr, *instance.Spec.AllocationRange)
+		if err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "allocationRange"), *instance.Spec.AllocationRange, err.Error()))
+		}
+	}
+	for i, excluded := range instance.Spec.ExcludeRanges {
+		err = failuredomain.ValidateRange(cidr, excluded)
+		if err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "excludeRanges").Index(i), excluded, err.Error()))
+		}
+	}
+	for i, reserved := range instance.Spec.Reserved {
+		err = failuredomain.ValidateRange(cidr, v1beta1.IPRange{Start: reserved, End: reserved})
+		if err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "reserved").Index(i), reserved, err.Error()))
+		}
+	}
+
+	for _, other := range failureDomains.Items {
+		if other.Name == instance.Name {
+			continue
+		}
+
+		_, otherCidr, err := net.ParseCIDR(other.Spec.Cidr)
+		if err != nil {
+			continue
+		}
+
+		if cidr.Contains(otherCidr.IP) || otherCidr.Contains(cidr.IP) {
+			errs = append(errs, field.Invalid(path, instance.Spec.Cidr,
+				fmt.Sprintf("overlaps cidr '%v' of failure domain '%v'", other.Spec.Cidr, other.Name),
+			))
+		}
+	}
+
+	if old == nil || old.Spec.Cidr == instance.Spec.Cidr {
+		return errs, nil
+	}
+
+	egressIPs := &v1alpha1.EgressIPList{}
+	err = c.List(ctx, egressIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range usedIPs(egressIPs.Items, instance.Name) {
+		if !cidr.Contains(net.ParseIP(ip)) {
+			errs = append(errs, field.Invalid(path, instance.Spec.Cidr, fmt.Sprintf("ip '%v' in use is not within the cidr", ip)))
+		}
+	}
+
+	return errs, nil
+}
+
+// usedIPs returns the IPs specified or assigned by the EgressIPs within the failure domain.
+func usedIPs(egressIPs []v1alpha1.EgressIP, failureDomain string) []string {
+	result := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add := func(value string) {
+		ip := net.ParseIP(value)
+		if ip == nil || seen[ip.String()] {
+			return
+		}
+
+		seen[ip.String()] = true
+		result = append(result, ip.String())
+	}
+
+	for _, egressIP := range egressIPs {
+		for _, spec := range egressIP.Spec.IPs {
+			if spec.FailureDomain == failureDomain {
+				add(spec.IP)
+			}
+		}
+		for _, assigned := range egressIP.Status.IPs {
+			if assigned.FailureDomain == failureDomain {
+				add(assigned.IP)
+			}
+		}
+	}
+
+	return result
+}
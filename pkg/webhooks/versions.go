@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks
+
+import (
+	"encoding/json"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// The webhooks are called with the version of the request. They work on v1alpha1 EgressIPs and v1beta1
+// ClusterEgressIPFailureDomains like the rest of the operator and convert the other versions.
+
+// ClusterFailureDomainKind is the kind of the cluster-scoped failure domains.
+const ClusterFailureDomainKind = "ClusterEgressIPFailureDomain"
+
+// decodeEgressIP decodes the raw EgressIP of the given version to v1alpha1.
+func decodeEgressIP(decoder *admission.Decoder, raw runtime.RawExtension, version string) (*v1alpha1.EgressIP, error) {
+	result := &v1alpha1.EgressIP{}
+
+	if version != v1beta1.GroupVersion.Version {
+		err := decoder.DecodeRaw(raw, result)
+		return result, err
+	}
+
+	hub := &v1beta1.EgressIP{}
+	err := decoder.DecodeRaw(raw, hub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = result.ConvertFrom(hub)
+	return result, err
+}
+
+// encodeEgressIP marshals the v1alpha1 EgressIP in the given version.
+func encodeEgressIP(instance *v1alpha1.EgressIP, version string) ([]byte, error) {
+	if version != v1beta1.GroupVersion.Version {
+		return json.Marshal(instance)
+	}
+
+	hub := &v1beta1.EgressIP{}
+	err := instance.ConvertTo(hub)
+	if err != nil {
+		return nil, err
+	}
+	hub.TypeMeta = instance.TypeMeta
+	hub.APIVersion = v1beta1.GroupVersion.String()
+
+	return json.Marshal(hub)
+}
+
+// decodeFailureDomain decodes the raw failure domain of the given kind to a ClusterEgressIPFailureDomain. Namespaced
+// v1alpha1 EgressIPFailureDomains are decoded to the ClusterEgressIPFailureDomain they are migrated to.
+func decodeFailureDomain(decoder *admission.Decoder, raw runtime.RawExtension, kind string) (*v1beta1.ClusterEgressIPFailureDomain, error) {
+	result := &v1beta1.ClusterEgressIPFailureDomain{}
+
+	if kind == ClusterFailureDomainKind {
+		err := decoder.DecodeRaw(raw, result)
+		return result, err
+	}
+
+	legacy := &v1alpha1.EgressIPFailureDomain{}
+	err := decoder.DecodeRaw(raw, legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	legacy.ConvertToCluster(result)
+	return result, nil
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// webhooks contains the admission webhooks of the EgressIPs, failure domains and EgressIPClaims. They expand EgressIPs to all
+// failure domains and reject invalid objects before they are stored instead of failing at reconciliation. The conversion
+// webhook converts the EgressIPs between v1alpha1 and the stored v1beta1.
+package webhooks
+
+import (
+	"github.com/go-logr/logr"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+	"strconv"
+)
+
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-egressip-kaiserpfalz-edv-de-v1alpha1-egressip,mutating=true,failurePolicy=fail,groups=egressip.kaiserpfalz-edv.de,resources=egressips,versions=v1alpha1;v1beta1,name=megressip.kaiserpfalz-edv.de
+// +kubebuilder:webhook:verbs=create;update,path=/validate-egressip-kaiserpfalz-edv-de-v1alpha1-egressip,mutating=false,failurePolicy=fail,groups=egressip.kaiserpfalz-edv.de,resources=egressips,versions=v1alpha1;v1beta1,name=vegressip.kaiserpfalz-edv.de
+// +kubebuilder:webhook:verbs=create;update,path=/validate-egressip-kaiserpfalz-edv-de-v1alpha1-egressipfailuredomain,mutating=false,failurePolicy=fail,groups=egressip.kaiserpfalz-edv.de,resources=egressipfailuredomains,versions=v1alpha1,name=vegressipfailuredomain.kaiserpfalz-edv.de
+// +kubebuilder:webhook:verbs=create;update,path=/validate-egressip-kaiserpfalz-edv-de-v1beta1-clusteregressipfailuredomain,mutating=false,failurePolicy=fail,groups=egressip.kaiserpfalz-edv.de,resources=clusteregressipfailuredomains,versions=v1beta1,name=vclusteregressipfailuredomain.kaiserpfalz-edv.de
+// +kubebuilder:webhook:verbs=create;update,path=/validate-egressip-kaiserpfalz-edv-de-v1alpha1-egressipclaim,mutating=false,failurePolicy=fail,groups=egressip.kaiserpfalz-edv.de,resources=egressipclaims,versions=v1alpha1,name=vegressipclaim.kaiserpfalz-edv.de
+
+const (
+	// DefaultEgressIPPath is the path of the mutating webhook of the EgressIPs.
+	DefaultEgressIPPath = "/mutate-egressip-kaiserpfalz-edv-de-v1alpha1-egressip"
+	// ValidateEgressIPPath is the path of the validating webhook of the EgressIPs.
+	ValidateEgressIPPath = "/validate-egressip-kaiserpfalz-edv-de-v1alpha1-egressip"
+	// ValidateFailureDomainPath is the path of the validating webhook of the namespaced EgressIPFailureDomains.
+	ValidateFailureDomainPath = "/validate-egressip-kaiserpfalz-edv-de-v1alpha1-egressipfailuredomain"
+	// ValidateClusterFailureDomainPath is the path of the validating webhook of the ClusterEgressIPFailureDomains.
+	ValidateClusterFailureDomainPath = "/validate-egressip-kaiserpfalz-edv-de-v1beta1-clusteregressipfailuredomain"
+	// ValidateClaimPath is the path of the validating webhook of the EgressIPClaims.
+	ValidateClaimPath = "/validate-egressip-kaiserpfalz-edv-de-v1alpha1-egressipclaim"
+	// ConvertPath is the path of the conversion webhook between v1alpha1 and v1beta1 of the EgressIPs.
+	ConvertPath = "/convert"
+)
+
+// Enabled enables the webhooks. Running the manager outside the cluster without serving certificates needs them
+// disabled.
+var Enabled bool
+
+func init() {
+	Enabled = true
+	enabled, found := os.LookupEnv("WEBHOOKS_ENABLED")
+	if found {
+		Enabled, _ = strconv.ParseBool(enabled)
+	}
+}
+
+// Register adds the webhooks to the webhook server of the manager. The webhooks read directly from the kubernetes api
+// to see the objects stored just before.
+func Register(mgr manager.Manager, logger logr.Logger) {
+	server := mgr.GetWebhookServer()
+
+	server.Register(DefaultEgressIPPath, &webhook.Admission{Handler: &EgressIPDefaulter{
+		Client: mgr.GetAPIReader(),
+		Log:    logger.WithName("egressip-defaulter"),
+	}})
+
+	server.Register(ValidateEgressIPPath, &webhook.Admission{Handler: &EgressIPValidator{
+		Client: mgr.GetAPIReader(),
+		Log:    logger.WithName("egressip-validator"),
+	}})
+	server.Register(ValidateFailureDomainPath, &webhook.Admission{Handler: &FailureDomainValidator{
+		Client: mgr.GetAPIReader(),
+		Log:    logger.WithName("failuredomain-validator"),
+	}})
+	server.Register(ValidateClusterFailureDomainPath, &webhook.Admission{Handler: &FailureDomainValidator{
+		Client: mgr.GetAPIReader(),
+		Log:    logger.WithName("clusterfailuredomain-validator"),
+	}})
+	server.Register(ValidateClaimPath, &webhook.Admission{Handler: &ClaimValidator{
+		Client: mgr.GetAPIReader(),
+		Log:    logger.WithName("egressipclaim-validator"),
+	}})
+
+	server.Register(ConvertPath, &conversion.Webhook{})
+}
@@ -0,0 +1,376 @@
+/*
+ * Copyright 2020 Kaiserpfalz EDV-Service, Roland T. Lichti.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/klenkes74/egress-ip-operator/api/v1alpha1"
+	"github.com/klenkes74/egress-ip-operator/api/v1beta1"
+	"github.com/klenkes74/egress-ip-operator/pkg/webhooks"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"testing"
+)
+
+var log = zap.New(zap.UseDevMode(true)).WithName("webhooks_test")
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+	_ = v1beta1.AddToScheme(scheme)
+	_ = v1beta1.AddToScheme(scheme)
+}
+
+func prepareClient(objects ...runtime.Object) client.Client {
+	return fake.NewFakeClientWithScheme(scheme, append([]runtime.Object{
+		&v1beta1.ClusterEgressIPFailureDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "zone-a"},
+			Spec: v1beta1.ClusterEgressIPFailureDomainSpec{
+				Cidr:          "10.0.1.0/24",
+				ExcludeRanges: []v1beta1.IPRange{{Start: "10.0.1.200", End: "10.0.1.250"}},
+				Reserved:      []string{"10.0.1.5"},
+			},
+		},
+		&v1beta1.ClusterEgressIPFailureDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "zone-b"},
+			Spec:       v1beta1.ClusterEgressIPFailureDomainSpec{Cidr: "10.0.2.0/24"},
+		},
+		&v1alpha1.EgressIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant-b"},
+			Spec: v1alpha1.EgressIPSpec{
+				IPs: []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.10"}, {FailureDomain: "zone-b"}},
+			},
+			Status: v1alpha1.EgressIPStatus{
+				IPs: []v1alpha1.AssignedEgressIP{{FailureDomain: "zone-b", IP: "10.0.2.100", HostName: "node-b"}},
+			},
+		},
+		&v1alpha1.EgressIPQuarantine{
+			ObjectMeta: metav1.ObjectMeta{Name: "10.0.1.20"},
+			Spec:       v1alpha1.EgressIPQuarantineSpec{FailureDomain: "zone-a", IP: "10.0.1.20", Namespace: "tenant-b", EgressIP: "old"},
+		},
+		&v1alpha1.EgressIPQuarantine{
+			ObjectMeta: metav1.ObjectMeta{Name: "10.0.1.21"},
+			Spec:       v1alpha1.EgressIPQuarantineSpec{FailureDomain: "zone-a", IP: "10.0.1.21", Namespace: "tenant-a", EgressIP: "old"},
+		},
+	}, objects...)...)
+}
+
+func admissionRequest(t *testing.T, operation admissionv1beta1.Operation, object runtime.Object, old runtime.Object) admission.Request {
+	result := admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{Operation: operation}}
+
+	raw, err := json.Marshal(object)
+	if err != nil {
+		t.Fatalf("Object could not be encoded: %v", err)
+	}
+	result.Object.Raw = raw
+
+	if old != nil {
+		raw, err = json.Marshal(old)
+		if err != nil {
+			t.Fatalf("Old object could not be encoded: %v", err)
+		}
+		result.OldObject.Raw = raw
+	}
+
+	return result
+}
+
+func decoder(t *testing.T) *admission.Decoder {
+	result, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatalf("Decoder could not be created: %v", err)
+	}
+
+	return result
+}
+
+func egressIP(ips ...v1alpha1.FailureDomainEgressIPSpec) *v1alpha1.EgressIP {
+	return &v1alpha1.EgressIP{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "EgressIP"},
+		ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant-a"},
+		Spec:       v1alpha1.EgressIPSpec{IPs: ips},
+	}
+}
+
+func failureDomain(name string, cidr string) *v1beta1.ClusterEgressIPFailureDomain {
+	return &v1beta1.ClusterEgressIPFailureDomain{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.GroupVersion.String(), Kind: "ClusterEgressIPFailureDomain"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1beta1.ClusterEgressIPFailureDomainSpec{Cidr: cidr},
+	}
+}
+
+func legacyFailureDomain(name string, cidr string) *v1alpha1.EgressIPFailureDomain {
+	return &v1alpha1.EgressIPFailureDomain{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "EgressIPFailureDomain"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "egress-ip-operator"},
+		Spec:       v1alpha1.EgressIPFailureDomainSpec{Cidr: cidr},
+	}
+}
+
+func TestValidatingEgressIP(t *testing.T) {
+	sut := &webhooks.EgressIPValidator{Client: prepareClient(), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	tests := []struct {
+		name    string
+		ips     []v1alpha1.FailureDomainEgressIPSpec
+		allowed bool
+	}{
+		{"valid ips", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.11"}, {FailureDomain: "zone-b"}}, true},
+		{"unknown failure domain", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-c"}}, false},
+		{"ip outside of cidr", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.2.11"}}, false},
+		{"duplicate failure domain", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a"}, {FailureDomain: "zone-a"}}, false},
+		{"ip specified by other egressip", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.10"}}, false},
+		{"ip assigned to other egressip", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-b", IP: "10.0.2.100"}}, false},
+		{"reserved ip", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.5"}}, false},
+		{"ip in excluded range", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.210"}}, false},
+		{"ip quarantined for other namespace", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.20"}}, false},
+		{"ip quarantined for own namespace", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a", IP: "10.0.1.21"}}, true},
+	}
+
+	for _, test := range tests {
+		response := sut.Handle(context.Background(), admissionRequest(t, admissionv1beta1.Create, egressIP(test.ips...), nil))
+		if response.Allowed != test.allowed {
+			t.Errorf("Wrong admission of %v! expected=%v, current=%v (%v)", test.name, test.allowed, response.Allowed, response.Result)
+		}
+	}
+}
+
+func TestAllowingUpdateWithUnchangedSpec(t *testing.T) {
+	sut := &webhooks.EgressIPValidator{Client: prepareClient(), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	old := egressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "removed-zone"})
+	instance := old.DeepCopy()
+	instance.Finalizers = []string{}
+
+	response := sut.Handle(context.Background(), admissionRequest(t, admissionv1beta1.Update, instance, old))
+	if !response.Allowed {
+		t.Errorf("Update keeping the spec should be allowed! result=%v", response.Result)
+	}
+}
+
+func TestValidatingFailureDomain(t *testing.T) {
+	sut := &webhooks.FailureDomainValidator{Client: prepareClient(), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	withRanges := func(instance *v1beta1.ClusterEgressIPFailureDomain, excluded v1beta1.IPRange, reserved string) *v1beta1.ClusterEgressIPFailureDomain {
+		instance.Spec.ExcludeRanges = []v1beta1.IPRange{excluded}
+		instance.Spec.Reserved = []string{reserved}
+		return instance
+	}
+
+	tests := []struct {
+		name      string
+		operation admissionv1beta1.Operation
+		instance  runtime.Object
+		old       runtime.Object
+		allowed   bool
+	}{
+		{"new cidr", admissionv1beta1.Create, failureDomain("zone-c", "10.0.3.0/24"), nil, true},
+		{"overlapping cidr", admissionv1beta1.Create, failureDomain("zone-c", "10.0.0.0/16"), nil, false},
+		{"invalid cidr", admissionv1beta1.Create, failureDomain("zone-c", "10.0.3.0"), nil, false},
+		{"cidr keeping used ips", admissionv1beta1.Update, failureDomain("zone-a", "10.0.1.0/25"), failureDomain("zone-a", "10.0.1.0/24"), true},
+		{"cidr leaving specified ip", admissionv1beta1.Update, failureDomain("zone-a", "10.0.1.128/25"), failureDomain("zone-a", "10.0.1.0/24"), false},
+		{"cidr leaving assigned ip", admissionv1beta1.Update, failureDomain("zone-b", "10.0.2.0/26"), failureDomain("zone-b", "10.0.2.0/24"), false},
+		{"valid ranges", admissionv1beta1.Create, withRanges(failureDomain("zone-c", "10.0.3.0/24"), v1beta1.IPRange{Start: "10.0.3.1", End: "10.0.3.9"}, "10.0.3.10"), nil, true},
+		{"range outside of cidr", admissionv1beta1.Create, withRanges(failureDomain("zone-c", "10.0.3.0/24"), v1beta1.IPRange{Start: "10.0.3.1", End: "10.0.4.9"}, "10.0.3.10"), nil, false},
+		{"reversed range", admissionv1beta1.Create, withRanges(failureDomain("zone-c", "10.0.3.0/24"), v1beta1.IPRange{Start: "10.0.3.9", End: "10.0.3.1"}, "10.0.3.10"), nil, false},
+		{"reserved ip outside of cidr", admissionv1beta1.Create, withRanges(failureDomain("zone-c", "10.0.3.0/24"), v1beta1.IPRange{Start: "10.0.3.1", End: "10.0.3.9"}, "10.0.4.10"), nil, false},
+		{"ranges without cidr", admissionv1beta1.Create, withRanges(failureDomain("zone-c", ""), v1beta1.IPRange{Start: "10.0.3.1", End: "10.0.3.9"}, "10.0.3.10"), nil, false},
+		{"new namespaced failure domain", admissionv1beta1.Create, legacyFailureDomain("zone-c", "10.0.3.0/24"), nil, true},
+		{"namespaced failure domain using name of cluster failure domain", admissionv1beta1.Create, legacyFailureDomain("zone-a", "10.0.1.0/24"), nil, false},
+	}
+
+	for _, test := range tests {
+		request := admissionRequest(t, test.operation, test.instance, test.old)
+		gvk := test.instance.GetObjectKind().GroupVersionKind()
+		request.Kind = metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}
+
+		response := sut.Handle(context.Background(), request)
+		if response.Allowed != test.allowed {
+			t.Errorf("Wrong admission of %v! expected=%v, current=%v (%v)", test.name, test.allowed, response.Allowed, response.Result)
+		}
+	}
+}
+
+func TestDefaultingEgressIPWithoutIPs(t *testing.T) {
+	sut := &webhooks.EgressIPDefaulter{Client: prepareClient(), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	response := sut.Handle(context.Background(), admissionRequest(t, admissionv1beta1.Create, egressIP(), nil))
+	if !response.Allowed {
+		t.Fatalf("EgressIP without ips should be allowed! result=%v", response.Result)
+	}
+
+	patches := make(map[string]interface{})
+	for _, patch := range response.Patches {
+		patches[patch.Path] = patch.Value
+	}
+
+	ips, ok := patches["/spec/ips"].([]interface{})
+	if patches["/spec/allFailureDomains"] != true || !ok || len(ips) != 2 {
+		t.Errorf("EgressIP should be expanded to 'zone-a' and 'zone-b'! patches=%v", response.Patches)
+	}
+}
+
+func TestNotDefaultingEgressIPWithIPs(t *testing.T) {
+	sut := &webhooks.EgressIPDefaulter{Client: prepareClient(), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	response := sut.Handle(context.Background(), admissionRequest(t, admissionv1beta1.Create,
+		egressIP(v1alpha1.FailureDomainEgressIPSpec{FailureDomain: "zone-a"}), nil,
+	))
+	if !response.Allowed || len(response.Patches) != 0 {
+		t.Errorf("EgressIP listing its failure domains should not be patched! patches=%v", response.Patches)
+	}
+}
+
+func v1beta1EgressIP(ips ...v1beta1.EgressIPAddress) *v1beta1.EgressIP {
+	return &v1beta1.EgressIP{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.GroupVersion.String(), Kind: "EgressIP"},
+		ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "tenant-a"},
+		Spec:       v1beta1.EgressIPSpec{IPs: ips},
+	}
+}
+
+func v1beta1Request(t *testing.T, object runtime.Object) admission.Request {
+	result := admissionRequest(t, admissionv1beta1.Create, object, nil)
+	result.Kind = metav1.GroupVersionKind{Group: v1beta1.GroupVersion.Group, Version: v1beta1.GroupVersion.Version, Kind: "EgressIP"}
+
+	return result
+}
+
+func TestValidatingV1beta1EgressIP(t *testing.T) {
+	sut := &webhooks.EgressIPValidator{Client: prepareClient(), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	tests := []struct {
+		name    string
+		ip      v1beta1.EgressIPAddress
+		allowed bool
+	}{
+		{"valid ip", v1beta1.EgressIPAddress{FailureDomainRef: v1beta1.FailureDomainReference{Name: "zone-a"}, IP: "10.0.1.11"}, true},
+		{"unknown failure domain", v1beta1.EgressIPAddress{FailureDomainRef: v1beta1.FailureDomainReference{Name: "zone-c"}}, false},
+		{"ip outside of cidr", v1beta1.EgressIPAddress{FailureDomainRef: v1beta1.FailureDomainReference{Name: "zone-a"}, IP: "10.0.2.11"}, false},
+	}
+
+	for _, test := range tests {
+		response := sut.Handle(context.Background(), v1beta1Request(t, v1beta1EgressIP(test.ip)))
+		if response.Allowed != test.allowed {
+			t.Errorf("Wrong admission of %v! expected=%v, current=%v (%v)", test.name, test.allowed, response.Allowed, response.Result)
+		}
+	}
+}
+
+func TestDefaultingV1beta1EgressIPWithoutIPs(t *testing.T) {
+	sut := &webhooks.EgressIPDefaulter{Client: prepareClient(), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	response := sut.Handle(context.Background(), v1beta1Request(t, v1beta1EgressIP()))
+	if !response.Allowed {
+		t.Fatalf("EgressIP without ips should be allowed! result=%v", response.Result)
+	}
+
+	var ips []interface{}
+	for _, patch := range response.Patches {
+		if patch.Path == "/spec/ips" {
+			ips, _ = patch.Value.([]interface{})
+		}
+	}
+
+	if len(ips) != 2 {
+		t.Fatalf("EgressIP should be expanded to 'zone-a' and 'zone-b'! patches=%v", response.Patches)
+	}
+
+	ref, ok := ips[0].(map[string]interface{})["failureDomainRef"].(map[string]interface{})
+	if !ok || ref["name"] != "zone-a" {
+		t.Errorf("Patch should use the failure domain references of v1beta1! expected=%v, current=%v", "zone-a", ips[0])
+	}
+}
+
+func TestRejectingEgressIPExceedingPolicy(t *testing.T) {
+	maxIPs := 1
+	sut := &webhooks.EgressIPValidator{Client: prepareClient(&v1alpha1.EgressIPPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota"},
+		Spec:       v1alpha1.EgressIPPolicySpec{Namespaces: []string{"tenant-a"}, MaxIPs: &maxIPs, AllowedFailureDomains: []string{"zone-a"}},
+	}), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	tests := []struct {
+		name    string
+		ips     []v1alpha1.FailureDomainEgressIPSpec
+		allowed bool
+	}{
+		{"within quota", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a"}}, true},
+		{"exceeding max ips", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-a"}, {FailureDomain: "zone-b"}}, false},
+		{"using failure domain not allowed", []v1alpha1.FailureDomainEgressIPSpec{{FailureDomain: "zone-b"}}, false},
+	}
+
+	for _, test := range tests {
+		response := sut.Handle(context.Background(), admissionRequest(t, admissionv1beta1.Create, egressIP(test.ips...), nil))
+		if response.Allowed != test.allowed {
+			t.Errorf("Wrong admission of %v! expected=%v, current=%v (%v)", test.name, test.allowed, response.Allowed, response.Result)
+		}
+	}
+}
+
+func TestValidatingClaim(t *testing.T) {
+	sut := &webhooks.ClaimValidator{Client: prepareClient(
+		&v1alpha1.EgressIPPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "pools"},
+			Spec:       v1alpha1.EgressIPPolicySpec{AllowedPools: []string{"standard"}},
+		},
+		&v1alpha1.EgressIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "premium", Annotations: map[string]string{v1alpha1.DefaultPoolAnnotation: "true"}},
+		},
+	), Log: log}
+	_ = sut.InjectDecoder(decoder(t))
+
+	tests := []struct {
+		name      string
+		className string
+		allowed   bool
+	}{
+		{"allowed pool", "standard", true},
+		{"pool not allowed", "premium", false},
+		{"default pool not allowed", "", false},
+	}
+
+	for _, test := range tests {
+		claim := &v1alpha1.EgressIPClaim{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "EgressIPClaim"},
+			ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "tenant-a"},
+			Spec:       v1alpha1.EgressIPClaimSpec{ClassName: test.className, Count: 1},
+		}
+
+		response := sut.Handle(context.Background(), admissionRequest(t, admissionv1beta1.Create, claim, nil))
+		if response.Allowed != test.allowed {
+			t.Errorf("Wrong admission of %v! expected=%v, current=%v (%v)", test.name, test.allowed, response.Allowed, response.Result)
+		}
+	}
+}